@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
 	"github.com/moseskang00/custom_search_component_service/internal/app/handlers"
 	"github.com/moseskang00/custom_search_component_service/internal/cache"
 	redisClient "github.com/moseskang00/custom_search_component_service/internal/redis"
@@ -20,7 +24,15 @@ import (
 
 var logger *zap.Logger
 
+// processEnv records which variables were set before .env was loaded. Real environment
+// variables take precedence over .env, on startup and on reload alike.
+var processEnv = map[string]bool{}
+
 func main() {
+	for _, kv := range os.Environ() {
+		processEnv[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+
 	// Load environment variables from .env file (if it exists)
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
@@ -41,35 +53,81 @@ func main() {
 	// Set logger for handlers
 	handlers.SetLogger(logger)
 
+	cfg := app.LoadConfig()
+	handlers.SetConfig(cfg)
+
 	// Initialize Redis and Cache (optional)
-	redisEnabled := os.Getenv("REDIS_ENABLED")
-	if redisEnabled == "true" {
-		redisConfig := redisClient.Config{
-			Host:         getEnv("REDIS_HOST", "localhost"),
-			Port:         getEnv("REDIS_PORT", "6379"),
-			Password:     getEnv("REDIS_PASSWORD", ""),
-			DB:           0,
-			PoolSize:     10,
-			MinIdleConns: 5,
-			MaxRetries:   3,
-			DialTimeout:  5 * time.Second,
-			ReadTimeout:  3 * time.Second,
-			WriteTimeout: 3 * time.Second,
-		}
+	var statsCounter *cache.Counter
+	// Background work is cancelled through rootCtx on shutdown
+	rootCtx, cancelRoot := context.WithCancel(context.Background())
+	defer cancelRoot()
+	handlers.SetRootContext(rootCtx)
 
-		client, err := redisClient.NewClient(redisConfig)
+	if cfg.RedisEnabled {
+		client, err := redisClient.NewClient(redisClientConfig(cfg))
 		if err != nil {
 			logger.Warn("Failed to connect to Redis, running without cache", zap.Error(err))
 		} else {
 			logger.Info("Redis connected successfully")
-			searchCache := cache.NewCache(client.GetClient(), "openlibrary")
+			searchCache := cache.NewCache(client.GetClient(), handlers.CachePrefix("openlibrary", cfg))
+			searchCache.SetSafeMode(cfg.RedisSafeMode)
+			searchCache.SetEnvelopes(cfg.CacheEnvelope)
+			searchCache.SetMaxKeyLength(cfg.CacheMaxKeyLength)
+			searchCache.SetGenerationalPrefixes(handlers.ResultKeyPrefixes()...)
+			searchCache.SetLongKeyHandler(func(key string) {
+				logger.Debug("Cache key too long, using its hash", zap.Int("length", len(key)))
+			})
+			searchCache.SetReadOnlyHandler(func(readOnly bool, err error) {
+				if readOnly {
+					logger.Error("Redis is read-only (replica?), cache writes are paused until it accepts them again", zap.Error(err))
+					return
+				}
+				logger.Info("Redis accepts writes again, cache writes resumed")
+			})
 			handlers.SetCache(searchCache)
+			// Check the commands and settings the cache depends on; problems are logged, not fatal
+			warnings := searchCache.Probe(context.Background())
+			for _, warning := range warnings {
+				logger.Warn("Redis capability warning", zap.String("warning", warning))
+			}
+			handlers.SetCacheWarnings(warnings)
+			handlers.StartGenerationSync(constants.CACHE_GENERATION_SYNC_SECONDS * time.Second)
 			defer client.Close()
+
+			statsCounter = cache.NewCounter(searchCache)
+			statsCounter.Start(cfg.StatsFlushInterval, func(err error) {
+				if errors.Is(err, cache.ErrReadOnly) {
+					return
+				}
+				logger.Warn("Failed to flush cache stats", zap.Error(err))
+			})
+			handlers.SetStats(statsCounter)
+
+			if cfg.AnalyticsSweepInterval > 0 {
+				handlers.StartAnalyticsSweeper(cfg.AnalyticsSweepInterval)
+			}
+
+			if cfg.FuzzyIndexRefreshInterval > 0 {
+				handlers.StartFuzzyIndexRefresher(cfg.FuzzyIndexRefreshInterval)
+			}
 		}
 	} else {
 		logger.Info("Redis disabled, running without cache")
 	}
 
+	// Initialize the on-disk stale fallback (optional)
+	if cfg.FallbackEnabled {
+		fallback, err := cache.NewDiskStore(cfg.FallbackDir, cfg.FallbackMaxEntries)
+		if err != nil {
+			logger.Warn("Failed to initialize stale fallback, running without it", zap.Error(err))
+		} else {
+			logger.Info("Stale fallback enabled",
+				zap.String("dir", cfg.FallbackDir),
+				zap.Int("max_entries", cfg.FallbackMaxEntries))
+			handlers.SetFallback(fallback)
+		}
+	}
+
 	// Get port from environment or use default
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -81,7 +139,17 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := setupRouter()
+	switch {
+	case len(cfg.AdminAPIKeys) > 0:
+	case cfg.Environment == app.EnvironmentProduction:
+		logger.Error("ADMIN_API_KEYS is not set, admin endpoints are disabled")
+	default:
+		logger.Warn("ADMIN_API_KEYS is not set, admin endpoints are unauthenticated")
+	}
+
+	handlers.SetHTTPClient(handlers.NewUpstreamClient(cfg.UpstreamDialTimeout))
+
+	router := setupRouter(cfg)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -100,6 +168,15 @@ func main() {
 		}
 	}()
 
+	// Reload tunables on SIGHUP. Signals are handled one at a time, so reloads never overlap.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig()
+		}
+	}()
+
 	// Wait for interrupt signal to gracefully shut down the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -115,25 +192,154 @@ func main() {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
+	// Stop background work, then flush what it left behind
+	cancelRoot()
+	if !handlers.WaitBackground(5 * time.Second) {
+		logger.Warn("Background work did not stop in time")
+	}
+
+	// Flush any stat increments still held in memory
+	if statsCounter != nil {
+		if err := statsCounter.Stop(); err != nil {
+			logger.Warn("Failed to flush cache stats on shutdown", zap.Error(err))
+		}
+	}
+
 	logger.Info("Server exited")
 }
 
+// redisClientConfig is the Redis connection and pool settings from cfg
+func redisClientConfig(cfg app.Config) redisClient.Config {
+	return redisClient.Config{
+		Host:         cfg.RedisHost,
+		Port:         cfg.RedisPort,
+		Password:     cfg.RedisPassword,
+		DB:           cfg.RedisDB,
+		PoolSize:     cfg.RedisPoolSize,
+		MinIdleConns: cfg.RedisMinIdleConns,
+		MaxRetries:   cfg.RedisMaxRetries,
+		DialTimeout:  cfg.RedisDialTimeout,
+		ReadTimeout:  cfg.RedisReadTimeout,
+		WriteTimeout: cfg.RedisWriteTimeout,
+	}
+}
+
+// reloadConfig re-reads .env and the environment and atomically swaps the tunables used by
+// handlers. Every other setting keeps its startup value until the next restart, including
+// those handlers read per request.
+func reloadConfig() app.Config {
+	values, err := godotenv.Read()
+	if err != nil {
+		logger.Info("No .env file found on reload, using environment variables")
+	}
+	for key, value := range values {
+		if !processEnv[key] {
+			os.Setenv(key, value)
+		}
+	}
+
+	cfg := withTunables(handlers.CurrentConfig(), app.LoadConfig())
+	handlers.SetConfig(cfg)
+
+	logger.Info("Configuration reloaded",
+		zap.Duration("cache_ttl", cfg.CacheTTL),
+		zap.Int("fuzzy_max_distance", cfg.FuzzyMaxDistance),
+		zap.Int("fuzzy_word_distance", cfg.FuzzyWordDistance),
+		zap.Float64("fuzzy_word_match_ratio", cfg.FuzzyWordMatchRatio),
+		zap.Strings("cors_allowed_origins", cfg.CORSAllowedOrigins))
+	logger.Info("Kept the startup port, ENV, Redis, cache key settings (strategy, URL hashing, envelopes, query folding, phrase search, upstream fields), " +
+		"assembled results, variation reads, response time header, stale fallback, load shedding, analytics, trusted proxies, upstream dial timeout and background intervals; restart to change them")
+
+	return cfg
+}
+
+// withTunables returns current with the tunables the README lists as reloaded on SIGHUP
+// taken from loaded. The rest stays as it is: some settings are consumed at startup, and
+// others, though read per request, would move cache keys without a flush (CacheKeyStrategy,
+// FoldAccents) or open admin endpoints (Environment) if they changed live.
+func withTunables(current, loaded app.Config) app.Config {
+	current.CacheTTL = loaded.CacheTTL
+	current.FuzzyMaxDistance = loaded.FuzzyMaxDistance
+	current.FuzzyWordDistance = loaded.FuzzyWordDistance
+	current.FuzzyWordMatchRatio = loaded.FuzzyWordMatchRatio
+	current.FuzzyDisableThreshold = loaded.FuzzyDisableThreshold
+	current.FuzzyMaxAge = loaded.FuzzyMaxAge
+	current.FuzzyMatchDetails = loaded.FuzzyMatchDetails
+	current.FuzzyWriteBack = loaded.FuzzyWriteBack
+	current.FuzzyWriteBackTTL = loaded.FuzzyWriteBackTTL
+	current.CORSAllowedOrigins = loaded.CORSAllowedOrigins
+	current.LongWordMinLength = loaded.LongWordMinLength
+	current.StrictQueryParams = loaded.StrictQueryParams
+	current.LogMode = loaded.LogMode
+	current.AdminAPIKeys = loaded.AdminAPIKeys
+	current.APIKeySchemes = loaded.APIKeySchemes
+	current.DebugSampleRate = loaded.DebugSampleRate
+	current.DebugResponseFields = loaded.DebugResponseFields
+	current.IncludeMetrics = loaded.IncludeMetrics
+	current.ResultFormat = loaded.ResultFormat
+	current.BookKeyStyle = loaded.BookKeyStyle
+	current.RequiredResultFields = loaded.RequiredResultFields
+	current.SchemaRequiredFields = loaded.SchemaRequiredFields
+	current.SchemaMaxMissingRatio = loaded.SchemaMaxMissingRatio
+	current.SchemaRefuseCache = loaded.SchemaRefuseCache
+	current.ResultFieldNames = loaded.ResultFieldNames
+	current.UpstreamTimeout = loaded.UpstreamTimeout
+	current.UpstreamExtendedTimeout = loaded.UpstreamExtendedTimeout
+	current.RetryBudgetAttempts = loaded.RetryBudgetAttempts
+	current.RetryBudgetTime = loaded.RetryBudgetTime
+	current.DefaultQueryLimit = loaded.DefaultQueryLimit
+	return current
+}
+
 // make changes for endpoints here
-func setupRouter() *gin.Engine {
+func setupRouter(cfg app.Config) *gin.Engine {
 	router := gin.Default()
 
+	// Only trust X-Forwarded-For from configured proxies, so c.ClientIP() is the real client
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Warn("Invalid TRUSTED_PROXIES, trusting no proxies", zap.Error(err))
+		router.SetTrustedProxies(nil)
+	}
+
 	// Add middleware
+	if cfg.ResponseTimeHeader {
+		router.Use(handlers.ResponseTimeHeader())
+	}
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 	router.Use(corsMiddleware())
+	// Warm job event streams stay open for the whole job, so they don't count as in flight
+	// and aren't captured
+	router.Use(handlers.InFlightLimiter(int64(cfg.MaxInFlightRequests), "/health", "/api/v1/cache/warm/:id/events"))
+	router.Use(handlers.DebugSampler("/api/v1/cache/warm/:id/events"))
 
 	// Health check endpoint
 	router.GET("/health", handlers.HealthCheck)
+	router.GET("/metrics", handlers.Metrics)
 
 	// API routes
 	api := router.Group("/api/v1")
 	{
 		api.GET("/search", handlers.Search)
+		api.HEAD("/search", handlers.Search)
+		api.GET("/isbn/:isbn", handlers.ISBNLookup)
+		api.GET("/stats", handlers.CacheStats)
+	}
+
+	// Admin routes, guarded by ADMIN_API_KEYS (and disabled in production without them)
+	admin := api.Group("", handlers.RequireAPIKey())
+	{
+		admin.GET("/cache/diff", handlers.CacheDiff)
+		admin.DELETE("/cache", handlers.DeleteCacheByPattern)
+		admin.POST("/cache/flush", handlers.FlushCache)
+		admin.POST("/cache/warm", handlers.WarmCache)
+		admin.GET("/cache/warm/:id", handlers.WarmJobStatus)
+		admin.GET("/cache/warm/:id/events", handlers.WarmJobEvents)
+		admin.DELETE("/cache/warm/:id", handlers.CancelWarmJob)
+		admin.GET("/analytics/queries", handlers.AnalyticsQueries)
+		admin.GET("/selftest", handlers.SelfTest)
+		admin.GET("/debug/captures", handlers.DebugCaptures)
+		admin.GET("/debug/captures/:id", handlers.DebugCaptureByID)
 	}
 
 	return router
@@ -142,9 +348,21 @@ func setupRouter() *gin.Engine {
 // CORS middleware
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		// Origins are read per request so they can be changed by a config reload
+		origin := c.GetHeader("Origin")
+		for _, allowed := range handlers.CurrentConfig().CORSAllowedOrigins {
+			if allowed == "*" {
+				c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+				break
+			}
+			if origin != "" && allowed == origin {
+				c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+				c.Writer.Header().Add("Vary", "Origin")
+				break
+			}
+		}
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -155,12 +373,3 @@ func corsMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-// getEnv gets an environment variable with a default fallback
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	return value
-}
\ No newline at end of file
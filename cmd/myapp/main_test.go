@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/app/handlers"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	redisClient "github.com/moseskang00/custom_search_component_service/internal/redis"
+	"go.uber.org/zap"
+)
+
+func TestHealthRouteServesDependencyChecks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := setupRouter(app.DefaultConfig())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200", rec.Code)
+	}
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	for _, field := range []string{"status", "service", "time", "cache", "fallback"} {
+		if _, ok := body[field]; !ok {
+			t.Errorf("health response is missing %q: %s", field, rec.Body.String())
+		}
+	}
+}
+
+func TestReloadConfigAppliesToLaterRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger = zap.NewNop()
+	previous := handlers.CurrentConfig()
+	t.Cleanup(func() { handlers.SetConfig(previous) })
+	handlers.SetConfig(app.DefaultConfig())
+	router := setupRouter(app.DefaultConfig())
+
+	allowedOrigin := func(origin string) string {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Header().Get("Access-Control-Allow-Origin")
+	}
+	if got := allowedOrigin("https://a.example"); got != "*" {
+		t.Fatalf("Access-Control-Allow-Origin before reload = %q, want *", got)
+	}
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example")
+	t.Setenv("CACHE_TTL", "5m")
+	t.Setenv("FUZZY_MAX_DISTANCE", "1")
+	t.Setenv("FALLBACK_MIN_REQUESTS", "1")
+	t.Setenv("SHED_RETRY_AFTER", "30s")
+	reloadConfig()
+
+	cfg := handlers.CurrentConfig()
+	if cfg.CacheTTL != 5*time.Minute || cfg.FuzzyMaxDistance != 1 {
+		t.Errorf("reloaded CacheTTL = %v and FuzzyMaxDistance = %d, want 5m and 1", cfg.CacheTTL, cfg.FuzzyMaxDistance)
+	}
+	// Read per request but startup-only, so the reload must leave them alone
+	startup := app.DefaultConfig()
+	if cfg.FallbackMinRequests != startup.FallbackMinRequests || cfg.ShedRetryAfter != startup.ShedRetryAfter {
+		t.Errorf("FallbackMinRequests = %d and ShedRetryAfter = %v after reload, want the startup %d and %v",
+			cfg.FallbackMinRequests, cfg.ShedRetryAfter, startup.FallbackMinRequests, startup.ShedRetryAfter)
+	}
+	if got := allowedOrigin("https://a.example"); got != "https://a.example" {
+		t.Errorf("Access-Control-Allow-Origin for an allowed origin = %q, want it echoed", got)
+	}
+	if got := allowedOrigin("https://b.example"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin for another origin = %q, want none", got)
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    []string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{name: "no proxies trusted by default", remoteAddr: "10.0.0.5:4000", forwarded: "203.0.113.7", want: "10.0.0.5"},
+		{name: "trusted proxy CIDR", proxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.5:4000", forwarded: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted proxy IP", proxies: []string{"10.0.0.5"}, remoteAddr: "10.0.0.5:4000", forwarded: "203.0.113.7", want: "203.0.113.7"},
+		{name: "proxy chain", proxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.5:4000", forwarded: "203.0.113.7, 10.0.0.9", want: "203.0.113.7"},
+		{name: "untrusted connection", proxies: []string{"10.0.0.0/8"}, remoteAddr: "198.51.100.2:4000", forwarded: "203.0.113.7", want: "198.51.100.2"},
+		{name: "invalid config trusts none", proxies: []string{"not-an-ip"}, remoteAddr: "10.0.0.5:4000", forwarded: "203.0.113.7", want: "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			logger = zap.NewNop()
+			cfg := app.DefaultConfig()
+			if tt.proxies != nil {
+				cfg.TrustedProxies = tt.proxies
+			}
+			previous := handlers.CurrentConfig()
+			t.Cleanup(func() { handlers.SetConfig(previous) })
+			handlers.SetConfig(cfg)
+			router := setupRouter(cfg)
+			router.GET("/client-ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			req := httptest.NewRequest(http.MethodGet, "/client-ip", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchRouteAcceptsHead(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger = zap.NewNop()
+	previous := handlers.CurrentConfig()
+	t.Cleanup(func() { handlers.SetConfig(previous) })
+	handlers.SetConfig(app.DefaultConfig())
+	router := setupRouter(app.DefaultConfig())
+
+	// Without a query the handler rejects the request before any lookup, which shows the
+	// route reached it rather than falling through to 404
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/api/v1/search", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("HEAD /api/v1/search status code = %d, want 400 from the search handler", rec.Code)
+	}
+}
+
+func TestReloadConfigKeepsUpstreamFields(t *testing.T) {
+	logger = zap.NewNop()
+	previous := handlers.CurrentConfig()
+	t.Cleanup(func() { handlers.SetConfig(previous) })
+	startup := app.DefaultConfig()
+	startup.SearchUpstreamFields = []string{"key", "title"}
+	// Startup values the reloaded environment doesn't set must not fall back to defaults either
+	startup.CacheKeyStrategy = app.CacheKeyBoth
+	startup.FoldAccents = true
+	startup.Environment = app.EnvironmentProduction
+	handlers.SetConfig(startup)
+
+	t.Setenv("SEARCH_UPSTREAM_FIELDS", "key")
+	t.Setenv("ISBN_UPSTREAM_FIELDS", "key")
+	t.Setenv("CACHE_TTL", "5m")
+	cfg := reloadConfig()
+
+	if cfg.CacheTTL != 5*time.Minute {
+		t.Errorf("reloaded CacheTTL = %v, want 5m", cfg.CacheTTL)
+	}
+	if got := handlers.CurrentConfig(); len(got.SearchUpstreamFields) != 2 || got.ISBNUpstreamFields != nil {
+		t.Errorf("upstream fields after reload = %v and %v, want the startup ones kept", got.SearchUpstreamFields, got.ISBNUpstreamFields)
+	}
+	if cfg.CacheKeyStrategy != app.CacheKeyBoth || !cfg.FoldAccents || cfg.Environment != app.EnvironmentProduction {
+		t.Errorf("CacheKeyStrategy = %q, FoldAccents = %v and Environment = %q after reload, want the startup ones kept",
+			cfg.CacheKeyStrategy, cfg.FoldAccents, cfg.Environment)
+	}
+}
+
+func TestReloadConfigKeepsStartupSettings(t *testing.T) {
+	logger = zap.NewNop()
+	previous := handlers.CurrentConfig()
+	t.Cleanup(func() { handlers.SetConfig(previous) })
+	handlers.SetConfig(app.DefaultConfig())
+
+	t.Setenv("CACHE_KEY_STRATEGY", app.CacheKeyRaw)
+	t.Setenv("FOLD_ACCENTS", "true")
+	t.Setenv("ENV", app.EnvironmentProduction)
+	t.Setenv("CACHE_TTL", "5m")
+	reloadConfig()
+
+	cfg := handlers.CurrentConfig()
+	if cfg.CacheTTL != 5*time.Minute {
+		t.Errorf("reloaded CacheTTL = %v, want 5m", cfg.CacheTTL)
+	}
+	if cfg.CacheKeyStrategy != app.CacheKeyNormalized {
+		t.Errorf("CacheKeyStrategy after reload = %q, want the startup %q", cfg.CacheKeyStrategy, app.CacheKeyNormalized)
+	}
+	if cfg.FoldAccents {
+		t.Error("FoldAccents turned on by a reload, want the startup value kept")
+	}
+	if cfg.Environment != "development" {
+		t.Errorf("Environment after reload = %q, want the startup development", cfg.Environment)
+	}
+}
+
+func TestRedisClientConfig(t *testing.T) {
+	cfg := app.DefaultConfig()
+	cfg.RedisHost, cfg.RedisPort, cfg.RedisPassword, cfg.RedisDB = "cache.internal", "6380", "secret", 2
+	cfg.RedisPoolSize, cfg.RedisMinIdleConns, cfg.RedisMaxRetries = 20, 4, 1
+	cfg.RedisDialTimeout, cfg.RedisReadTimeout, cfg.RedisWriteTimeout = time.Second, 2*time.Second, 3*time.Second
+
+	want := redisClient.Config{
+		Host:         "cache.internal",
+		Port:         "6380",
+		Password:     "secret",
+		DB:           2,
+		PoolSize:     20,
+		MinIdleConns: 4,
+		MaxRetries:   1,
+		DialTimeout:  time.Second,
+		ReadTimeout:  2 * time.Second,
+		WriteTimeout: 3 * time.Second,
+	}
+	if got := redisClientConfig(cfg); got != want {
+		t.Errorf("redisClientConfig = %+v, want %+v", got, want)
+	}
+}
+
+// countingUpstream answers every OpenLibrary request with one doc and counts the requests
+type countingUpstream struct {
+	requests atomic.Int32
+}
+
+func (u *countingUpstream) Do(req *http.Request) (*http.Response, error) {
+	u.requests.Add(1)
+	body := `{"numFound": 1, "start": 0, "docs": [{"key": "/works/OL1W", "title": "Dune"}]}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestSearchRouteGoesThroughCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger = zap.NewNop()
+	server := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := app.DefaultConfig()
+	cfg.RedisHost, cfg.RedisPort = host, port
+	previous := handlers.CurrentConfig()
+	t.Cleanup(func() { handlers.SetConfig(previous) })
+	handlers.SetConfig(cfg)
+
+	client, err := redisClient.NewClient(redisClientConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	searchCache := cache.NewCache(client.GetClient(), handlers.CachePrefix("openlibrary", cfg))
+	searchCache.SetGenerationalPrefixes(handlers.ResultKeyPrefixes()...)
+	handlers.SetCache(searchCache)
+	t.Cleanup(func() { handlers.SetCache(nil) })
+	upstream := &countingUpstream{}
+	previousClient := handlers.HTTPClient
+	handlers.SetHTTPClient(upstream)
+	t.Cleanup(func() { handlers.SetHTTPClient(previousClient) })
+	router := setupRouter(cfg)
+	t.Cleanup(func() { handlers.WaitBackground(time.Second) })
+
+	for i, wantCached := range []bool{false, true} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=Dune", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("search %d status code = %d, want 200: %s", i+1, rec.Code, rec.Body.String())
+		}
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("response is not JSON: %v", err)
+		}
+		if body["cached"] != wantCached {
+			t.Errorf("search %d cached = %v, want %v", i+1, body["cached"], wantCached)
+		}
+	}
+	if got := upstream.requests.Load(); got != 1 {
+		t.Errorf("%d upstream requests, want the second search served from Redis", got)
+	}
+	if len(server.Keys()) == 0 {
+		t.Error("nothing was written to Redis")
+	}
+}
@@ -4,10 +4,79 @@ const (
 	OpenLibraryAPIURL = "https://openlibrary.org/"
 	OpenLibrarySearchEndpoint = "search.json?q="
 	QueryLimit = "&limit="
+	FieldsParam = "&fields="
+	OpenLibraryISBNEndpoint = "isbn/"
+	OpenLibraryAuthorsPath = "authors/"
+)
+
+const (
+	UPSTREAM_TIMEOUT_SECONDS=5
+	UPSTREAM_DIAL_TIMEOUT_SECONDS=2 // connecting to OpenLibrary, separate from waiting for its response
+	UPSTREAM_EXTENDED_TIMEOUT_SECONDS=15
+	UPSTREAM_MAX_TIMEOUT_SECONDS=30 // hard cap regardless of configuration
+	RETRY_BUDGET_ATTEMPTS=2 // retries per request, shared by cache reads and upstream calls
+	RETRY_BUDGET_MILLISECONDS=1000
+	CACHE_READ_RETRY_WINDOW_MILLISECONDS=200 // most time retries of a single cache read may take
+	UPSTREAM_MAX_CONCURRENCY=16 // OpenLibrary calls in flight at once, across live searches and warming
+	DEFAULT_QUERY_LIMIT=20 // results requested from OpenLibrary when the client doesn't pass limit
+	MAX_QUERY_LIMIT=100
+	MAX_FACET_VALUES=20 // most values returned per facet
+	UPSTREAM_MIN_REMAINING_MILLISECONDS=100 // searches with less budget left fail fast instead of calling upstream
 )	
 
 const (
 	CACHE_TTL_MINUTES=30
 	CACHE_MAX_SIZE=1000
+	CACHE_MAX_KEY_LENGTH=512 // longer keys are replaced by a hash
+	CACHE_BULK_DELETE_MAX=1000 // most keys one delete-by-pattern request may remove
+	WARM_MAX_QUERIES=100 // most queries one warm request may list
+	WARM_CONCURRENCY=2 // upstream slots warming may hold at once, leaving the rest for live traffic
+	WARM_MAX_RUNNING_JOBS=2 // asynchronous warm jobs running at once
+	WARM_JOB_RETENTION_SECONDS=600 // how long a finished warm job's progress stays available
+	FLUSH_REFILL_MAX_QUERIES=500 // most recent queries per match mode refetched by a flush
+	CACHE_GENERATION_SYNC_SECONDS=10 // how often instances pick up flushes started elsewhere
 	MAX_LEVENSHTEIN_DISTANCE=3
-)
\ No newline at end of file
+	MAX_WORD_LEVENSHTEIN_DISTANCE=2
+	FUZZY_WORD_MATCH_RATIO=0.6
+	FUZZY_RECENT_WINDOW=200 // only the newest N cached queries are fuzzy matched
+	FUZZY_INDEX_REFRESH_SECONDS=30
+	FUZZY_DISABLE_THRESHOLD=900 // cached queries per namespace at which fuzzy matching is suspended
+	LONG_WORD_MIN_LENGTH=4 // shorter words are left out of the long-words key variation
+	FUZZY_MAX_CANDIDATES=5 // fuzzy matches collected per lookup
+	FUZZY_CLIENT_MAX_CANDIDATES=3 // of those, how many are listed in responses
+	FUZZY_MAX_WORD_COMPARISONS=5000 // word-pair Levenshtein computations allowed per request
+	FUZZY_WRITE_BACK_TTL_MINUTES=5 // how long a fuzzy hit stays cached under the query that found it
+	VARIATION_READ_CONCURRENCY=4 // parallel cache reads per request when ConcurrentVariationReads is on
+)
+
+const (
+	STATS_FLUSH_INTERVAL_SECONDS=10
+	ANALYTICS_QUERY_TTL_HOURS=168
+	ANALYTICS_MAX_QUERIES=1000
+	ANALYTICS_SWEEP_INTERVAL_MINUTES=5
+	MAX_IN_FLIGHT_REQUESTS=200
+	SHED_RETRY_AFTER_SECONDS=1 // Retry-After on shed requests
+	HEALTH_PING_TIMEOUT_MILLISECONDS=500 // Redis ping in the health check
+	REDIS_POOL_SIZE=10
+	REDIS_MIN_IDLE_CONNS=5
+	REDIS_MAX_RETRIES=3
+	REDIS_DIAL_TIMEOUT_SECONDS=5
+	REDIS_READ_TIMEOUT_SECONDS=3
+	REDIS_WRITE_TIMEOUT_SECONDS=3
+)
+
+const (
+	FALLBACK_DIR="data/fallback"
+	FALLBACK_MAX_ENTRIES=500
+	FALLBACK_MIN_REQUESTS=3 // requests a query needs before its results are persisted
+)
+
+const (
+	SELFTEST_QUERY="the hobbit" // canned query for /api/v1/selftest
+	SELFTEST_TTL_SECONDS=60
+)
+
+const (
+	DEBUG_CAPTURE_TTL_MINUTES=15
+	DEBUG_CAPTURE_MAX_BODY_BYTES=64*1024 // request and upstream bodies beyond this are truncated in captures
+)
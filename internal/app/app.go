@@ -0,0 +1,411 @@
+package app
+
+import (
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/pkg/utils"
+)
+
+// Ways per-query analytics counters can be keyed
+const (
+	AnalyticsModeRaw    = "raw"    // the normalized query itself
+	AnalyticsModeHashed = "hashed" // a salted hash, so raw user input is never stored
+)
+
+// What search results are cached under
+const (
+	CacheKeyNormalized = "normalized" // the normalized query, shared by every spelling that normalizes the same
+	CacheKeyRaw        = "raw"        // the query exactly as sent (URL-decoded)
+	CacheKeyBoth       = "both"       // both; the raw key is checked first
+)
+
+// Ways an admin API key can be presented
+const (
+	APIKeySchemeHeader = "header" // X-API-Key: <key>
+	APIKeySchemeBearer = "bearer" // Authorization: Bearer <key>
+)
+
+// Shapes search results can be returned in
+const (
+	ResultFormatRaw        = "raw"        // OpenLibrary docs as cached
+	ResultFormatNormalized = "normalized" // mapped to Book
+)
+
+// How Book keys are reported
+const (
+	BookKeyPath = "path" // as OpenLibrary returns it, e.g. /works/OL45804W
+	BookKeyID   = "id"   // the bare id, e.g. OL45804W
+)
+
+// EnvironmentProduction is the ENV value of production deployments
+const EnvironmentProduction = "production"
+
+// How much a search request logs
+const (
+	LogModeVerbose = "verbose" // every lookup step at info level
+	LogModeSummary = "summary" // one line per request; the steps drop to debug
+)
+
+// Config holds the service settings read from the environment
+type Config struct {
+	// Last known good results persisted to disk, served when both Redis and OpenLibrary fail
+	FallbackEnabled    bool
+	FallbackDir        string
+	FallbackMaxEntries int
+	// Only queries requested at least this many times (per the stats counters) are persisted
+	FallbackMinRequests int
+
+	// How often batched cache stat increments are flushed to Redis
+	StatsFlushInterval time.Duration
+
+	// Per-query analytics counters expire after AnalyticsQueryTTL without requests, and a sweeper
+	// running every AnalyticsSweepInterval keeps only the AnalyticsMaxQueries most requested
+	AnalyticsQueryTTL      time.Duration
+	AnalyticsMaxQueries    int
+	AnalyticsSweepInterval time.Duration
+
+	// How per-query counters are keyed (AnalyticsModeRaw or AnalyticsModeHashed). In hashed mode
+	// AnalyticsHashMapping additionally keeps hash -> query lookups, expiring with the counters.
+	AnalyticsMode        string
+	AnalyticsHashSalt    string
+	AnalyticsHashMapping bool
+
+	// Requests served concurrently before new ones are shed with a 503 (0 disables)
+	MaxInFlightRequests int
+
+	// Retry-After sent with every shed request, rounded up to whole seconds
+	ShedRetryAfter time.Duration
+
+	// How often the in-memory snapshot of recent queries used for fuzzy matching is rebuilt (0 reads Redis per request)
+	FuzzyIndexRefreshInterval time.Duration
+
+	// Whether results are keyed on the normalized query, the raw query, or both (CacheKey*)
+	CacheKeyStrategy string
+
+	// Key results for requests whose parameters change the upstream call on a hash of the
+	// upstream URL instead, so every parameter combination gets its own entry
+	CacheKeyURLHash bool
+
+	// Proxies (IPs or CIDRs) whose X-Forwarded-For is believed when resolving the client IP.
+	// None are trusted by default, so the client IP is the connecting address.
+	TrustedProxies []string
+
+	// Keys accepted on admin endpoints (none disables the check) and how they may be sent
+	AdminAPIKeys  []string
+	APIKeySchemes []string
+
+	// Redis connection, read at startup. Without RedisEnabled the service runs uncached.
+	RedisEnabled      bool
+	RedisHost         string
+	RedisPort         string
+	RedisPassword     string
+	RedisDB           int
+	RedisPoolSize     int
+	RedisMinIdleConns int
+	RedisMaxRetries   int
+	RedisDialTimeout  time.Duration
+	RedisReadTimeout  time.Duration
+	RedisWriteTimeout time.Duration
+
+	// Deployment environment (ENV). In production admin endpoints are disabled until
+	// AdminAPIKeys is set, and the cache flush endpoint is refused unless
+	// AllowProductionFlush is set.
+	Environment          string
+	AllowProductionFlush bool
+
+	// Refuse FLUSHALL and KEYS in the cache layer, for Redis servers shared with other services
+	RedisSafeMode bool
+
+	// Store search results in envelopes (Redis hashes) recording createdAt, hitCount and the
+	// original query. Read at startup only: flip it on an empty cache or after a flush.
+	CacheEnvelope bool
+
+	// Cache keys longer than this (including the prefix) are stored under a hash (0 no limit)
+	CacheMaxKeyLength int
+
+	// On a miss, answer multi-word queries from the cached results of their individual words
+	// (flagged assembled) while the full query is fetched in the background
+	AssembledResults bool
+
+	// Read all cache key variations in parallel instead of one at a time
+	ConcurrentVariationReads bool
+
+	// Add an X-Response-Time header (milliseconds) to every response
+	ResponseTimeHeader bool
+
+	// Fold look-alike characters from other scripts (e.g. Cyrillic 'а') to Latin before normalizing queries
+	FoldHomoglyphs bool
+
+	// Treat a query wrapped in quotes as an exact phrase: searched as a phrase upstream and
+	// never served from fuzzy matches
+	QuotedPhraseSearch bool
+
+	// Strip accents (e.g. "café" -> "cafe") when normalizing queries, so accented and plain
+	// spellings share a cache entry. Changes the keys of accented queries: flip it with a flush.
+	FoldAccents bool
+
+	// Reloadable on SIGHUP
+	CacheTTL              time.Duration
+	FuzzyMaxDistance      int           // max edit distance between whole queries
+	FuzzyWordDistance     int           // max edit distance for two words to count as matching
+	FuzzyWordMatchRatio   float64       // fraction of words that must match for a word-level fuzzy hit
+	FuzzyDisableThreshold int           // cached queries per namespace at which fuzzy matching is suspended (0 never)
+	FuzzyMaxAge           time.Duration // fuzzy matches to entries written longer ago are skipped (0 no limit)
+	FuzzyMatchDetails     bool          // report matchMethod and editDistance on fuzzy hits
+	FuzzyWriteBack        bool          // also cache fuzzy hits under the client's query, so repeats hit exactly
+	FuzzyWriteBackTTL     time.Duration // TTL of those entries
+	CORSAllowedOrigins    []string
+	LongWordMinLength     int     // words shorter than this are dropped from the long-words key variation
+	StrictQueryParams     bool    // reject unknown query parameters on /api/v1/search instead of ignoring them
+	DebugSampleRate       float64 // fraction of requests (0-1) captured in full for troubleshooting
+	DebugResponseFields   bool    // honour debug=true on searches, adding internal details to the response
+	IncludeMetrics        bool    // report responseTime and upstream timings in responses unless includeMetrics=false
+	LogMode               string  // LogModeVerbose or LogModeSummary
+
+	// Shape of search results when the request doesn't pass format
+	ResultFormat string
+
+	// Whether Book.Key keeps the OpenLibrary path or just the id
+	BookKeyStyle string
+
+	// OpenLibrary doc fields a result must have to survive filterIncomplete=true
+	RequiredResultFields []string
+
+	// OpenLibrary fields fetched per endpoint (all when empty). Search sends them as the
+	// fields parameter; the ISBN endpoint has none, so editions are trimmed before caching.
+	// They version the cache prefix, so they are read at startup only.
+	SearchUpstreamFields []string
+	ISBNUpstreamFields   []string
+
+	// Fields every upstream doc should have. When more than SchemaMaxMissingRatio of a
+	// response's docs lack one, the response is flagged as a likely OpenLibrary schema change,
+	// and not cached if SchemaRefuseCache is set.
+	SchemaRequiredFields  []string
+	SchemaMaxMissingRatio float64
+	SchemaRefuseCache     bool
+
+	// Output field renames (e.g. title -> name) applied to search results and books, keyed
+	// by the name they'd otherwise be returned under
+	ResultFieldNames map[string]string
+
+	// Upstream budget for plain lookups, and for broad queries that legitimately take longer
+	UpstreamTimeout         time.Duration
+	UpstreamExtendedTimeout time.Duration
+
+	// Time allowed to connect to OpenLibrary, within the budgets above. A connect failure is
+	// reported as a 502, a response that doesn't arrive in time as a 504.
+	UpstreamDialTimeout time.Duration
+
+	// Retries one search request may spend across cache reads and upstream calls, and the
+	// time they may take in total
+	RetryBudgetAttempts int
+	RetryBudgetTime     time.Duration
+
+	// Results requested from OpenLibrary per search when the client doesn't pass limit
+	DefaultQueryLimit int
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		FallbackEnabled:           false,
+		FallbackDir:               constants.FALLBACK_DIR,
+		FallbackMaxEntries:        constants.FALLBACK_MAX_ENTRIES,
+		FallbackMinRequests:       constants.FALLBACK_MIN_REQUESTS,
+		StatsFlushInterval:        constants.STATS_FLUSH_INTERVAL_SECONDS * time.Second,
+		AnalyticsQueryTTL:         constants.ANALYTICS_QUERY_TTL_HOURS * time.Hour,
+		AnalyticsMaxQueries:       constants.ANALYTICS_MAX_QUERIES,
+		AnalyticsSweepInterval:    constants.ANALYTICS_SWEEP_INTERVAL_MINUTES * time.Minute,
+		AnalyticsMode:             AnalyticsModeRaw,
+		AnalyticsHashSalt:         "",
+		AnalyticsHashMapping:      false,
+		MaxInFlightRequests:       constants.MAX_IN_FLIGHT_REQUESTS,
+		ShedRetryAfter:            constants.SHED_RETRY_AFTER_SECONDS * time.Second,
+		FuzzyIndexRefreshInterval: constants.FUZZY_INDEX_REFRESH_SECONDS * time.Second,
+		CacheKeyStrategy:          CacheKeyNormalized,
+		CacheKeyURLHash:           false,
+		TrustedProxies:            []string{},
+		AdminAPIKeys:              []string{},
+		APIKeySchemes:             []string{APIKeySchemeHeader, APIKeySchemeBearer},
+		RedisEnabled:              false,
+		RedisHost:                 "localhost",
+		RedisPort:                 "6379",
+		RedisDB:                   0,
+		RedisPoolSize:             constants.REDIS_POOL_SIZE,
+		RedisMinIdleConns:         constants.REDIS_MIN_IDLE_CONNS,
+		RedisMaxRetries:           constants.REDIS_MAX_RETRIES,
+		RedisDialTimeout:          constants.REDIS_DIAL_TIMEOUT_SECONDS * time.Second,
+		RedisReadTimeout:          constants.REDIS_READ_TIMEOUT_SECONDS * time.Second,
+		RedisWriteTimeout:         constants.REDIS_WRITE_TIMEOUT_SECONDS * time.Second,
+		Environment:               "development",
+		AllowProductionFlush:      false,
+		RedisSafeMode:             false,
+		CacheEnvelope:             false,
+		CacheMaxKeyLength:         constants.CACHE_MAX_KEY_LENGTH,
+		AssembledResults:          false,
+		ConcurrentVariationReads:  false,
+		ResponseTimeHeader:        true,
+		FoldHomoglyphs:            false,
+		FoldAccents:               false,
+		QuotedPhraseSearch:        false,
+		CacheTTL:                  constants.CACHE_TTL_MINUTES * time.Minute,
+		FuzzyMaxDistance:          constants.MAX_LEVENSHTEIN_DISTANCE,
+		FuzzyWordDistance:         constants.MAX_WORD_LEVENSHTEIN_DISTANCE,
+		FuzzyWordMatchRatio:       constants.FUZZY_WORD_MATCH_RATIO,
+		FuzzyDisableThreshold:     constants.FUZZY_DISABLE_THRESHOLD,
+		FuzzyMaxAge:               0,
+		FuzzyMatchDetails:         true,
+		FuzzyWriteBack:            false,
+		FuzzyWriteBackTTL:         constants.FUZZY_WRITE_BACK_TTL_MINUTES * time.Minute,
+		CORSAllowedOrigins:        []string{"*"},
+		LongWordMinLength:         constants.LONG_WORD_MIN_LENGTH,
+		StrictQueryParams:         false,
+		DebugSampleRate:           0,
+		DebugResponseFields:       false,
+		IncludeMetrics:            true,
+		LogMode:                   LogModeVerbose,
+		ResultFormat:              ResultFormatRaw,
+		BookKeyStyle:              BookKeyPath,
+		RequiredResultFields:      []string{"title", "author_name"},
+		SearchUpstreamFields:      nil,
+		ISBNUpstreamFields:        nil,
+		SchemaRequiredFields:      []string{"key", "title"},
+		SchemaMaxMissingRatio:     0.5,
+		SchemaRefuseCache:         false,
+		ResultFieldNames:          map[string]string{},
+		UpstreamTimeout:           constants.UPSTREAM_TIMEOUT_SECONDS * time.Second,
+		UpstreamExtendedTimeout:   constants.UPSTREAM_EXTENDED_TIMEOUT_SECONDS * time.Second,
+		UpstreamDialTimeout:       constants.UPSTREAM_DIAL_TIMEOUT_SECONDS * time.Second,
+		RetryBudgetAttempts:       constants.RETRY_BUDGET_ATTEMPTS,
+		RetryBudgetTime:           constants.RETRY_BUDGET_MILLISECONDS * time.Millisecond,
+		DefaultQueryLimit:         constants.DEFAULT_QUERY_LIMIT,
+	}
+}
+
+// LoadConfig reads the service settings from the environment, applying defaults for anything unset
+func LoadConfig() Config {
+	defaults := DefaultConfig()
+	return Config{
+		FallbackEnabled:           utils.GetEnvBool("FALLBACK_ENABLED", defaults.FallbackEnabled),
+		FallbackDir:               utils.GetEnv("FALLBACK_DIR", defaults.FallbackDir),
+		FallbackMaxEntries:        utils.GetEnvInt("FALLBACK_MAX_ENTRIES", defaults.FallbackMaxEntries),
+		FallbackMinRequests:       utils.GetEnvInt("FALLBACK_MIN_REQUESTS", defaults.FallbackMinRequests),
+		StatsFlushInterval:        utils.GetEnvDuration("STATS_FLUSH_INTERVAL", defaults.StatsFlushInterval),
+		AnalyticsQueryTTL:         utils.GetEnvDuration("ANALYTICS_QUERY_TTL", defaults.AnalyticsQueryTTL),
+		AnalyticsMaxQueries:       utils.GetEnvInt("ANALYTICS_MAX_QUERIES", defaults.AnalyticsMaxQueries),
+		AnalyticsSweepInterval:    utils.GetEnvDuration("ANALYTICS_SWEEP_INTERVAL", defaults.AnalyticsSweepInterval),
+		AnalyticsMode:             analyticsMode(utils.GetEnv("ANALYTICS_MODE", defaults.AnalyticsMode)),
+		AnalyticsHashSalt:         utils.GetEnv("ANALYTICS_HASH_SALT", defaults.AnalyticsHashSalt),
+		AnalyticsHashMapping:      utils.GetEnvBool("ANALYTICS_HASH_MAPPING", defaults.AnalyticsHashMapping),
+		MaxInFlightRequests:       utils.GetEnvInt("MAX_IN_FLIGHT_REQUESTS", defaults.MaxInFlightRequests),
+		ShedRetryAfter:            utils.GetEnvDuration("SHED_RETRY_AFTER", defaults.ShedRetryAfter),
+		FuzzyIndexRefreshInterval: utils.GetEnvDuration("FUZZY_INDEX_REFRESH_INTERVAL", defaults.FuzzyIndexRefreshInterval),
+		CacheKeyStrategy:          cacheKeyStrategy(utils.GetEnv("CACHE_KEY_STRATEGY", defaults.CacheKeyStrategy)),
+		CacheKeyURLHash:           utils.GetEnvBool("CACHE_KEY_URL_HASH", defaults.CacheKeyURLHash),
+		TrustedProxies:            utils.GetEnvList("TRUSTED_PROXIES", defaults.TrustedProxies),
+		AdminAPIKeys:              utils.GetEnvList("ADMIN_API_KEYS", defaults.AdminAPIKeys),
+		APIKeySchemes:             utils.GetEnvList("API_KEY_SCHEMES", defaults.APIKeySchemes),
+		RedisEnabled:              utils.GetEnvBool("REDIS_ENABLED", defaults.RedisEnabled),
+		RedisHost:                 utils.GetEnv("REDIS_HOST", defaults.RedisHost),
+		RedisPort:                 utils.GetEnv("REDIS_PORT", defaults.RedisPort),
+		RedisPassword:             utils.GetEnv("REDIS_PASSWORD", defaults.RedisPassword),
+		RedisDB:                   utils.GetEnvInt("REDIS_DB", defaults.RedisDB),
+		RedisPoolSize:             utils.GetEnvInt("REDIS_POOL_SIZE", defaults.RedisPoolSize),
+		RedisMinIdleConns:         utils.GetEnvInt("REDIS_MIN_IDLE_CONNS", defaults.RedisMinIdleConns),
+		RedisMaxRetries:           utils.GetEnvInt("REDIS_MAX_RETRIES", defaults.RedisMaxRetries),
+		RedisDialTimeout:          utils.GetEnvDuration("REDIS_DIAL_TIMEOUT", defaults.RedisDialTimeout),
+		RedisReadTimeout:          utils.GetEnvDuration("REDIS_READ_TIMEOUT", defaults.RedisReadTimeout),
+		RedisWriteTimeout:         utils.GetEnvDuration("REDIS_WRITE_TIMEOUT", defaults.RedisWriteTimeout),
+		Environment:               utils.GetEnv("ENV", defaults.Environment),
+		AllowProductionFlush:      utils.GetEnvBool("ALLOW_PRODUCTION_FLUSH", defaults.AllowProductionFlush),
+		RedisSafeMode:             utils.GetEnvBool("REDIS_SAFE_MODE", defaults.RedisSafeMode),
+		CacheEnvelope:             utils.GetEnvBool("CACHE_ENVELOPE", defaults.CacheEnvelope),
+		CacheMaxKeyLength:         utils.GetEnvInt("CACHE_MAX_KEY_LENGTH", defaults.CacheMaxKeyLength),
+		AssembledResults:          utils.GetEnvBool("ASSEMBLED_RESULTS", defaults.AssembledResults),
+		ConcurrentVariationReads:  utils.GetEnvBool("CONCURRENT_VARIATION_READS", defaults.ConcurrentVariationReads),
+		ResponseTimeHeader:        utils.GetEnvBool("RESPONSE_TIME_HEADER", defaults.ResponseTimeHeader),
+		FoldHomoglyphs:            utils.GetEnvBool("FOLD_HOMOGLYPHS", defaults.FoldHomoglyphs),
+		FoldAccents:               utils.GetEnvBool("FOLD_ACCENTS", defaults.FoldAccents),
+		QuotedPhraseSearch:        utils.GetEnvBool("QUOTED_PHRASE_SEARCH", defaults.QuotedPhraseSearch),
+		CacheTTL:                  utils.GetEnvDuration("CACHE_TTL", defaults.CacheTTL),
+		FuzzyMaxDistance:          utils.GetEnvInt("FUZZY_MAX_DISTANCE", defaults.FuzzyMaxDistance),
+		FuzzyWordDistance:         utils.GetEnvInt("FUZZY_WORD_DISTANCE", defaults.FuzzyWordDistance),
+		FuzzyWordMatchRatio:       utils.GetEnvFloat("FUZZY_WORD_MATCH_RATIO", defaults.FuzzyWordMatchRatio),
+		FuzzyDisableThreshold:     utils.GetEnvInt("FUZZY_DISABLE_THRESHOLD", defaults.FuzzyDisableThreshold),
+		FuzzyMaxAge:               utils.GetEnvDuration("FUZZY_MAX_AGE", defaults.FuzzyMaxAge),
+		FuzzyMatchDetails:         utils.GetEnvBool("FUZZY_MATCH_DETAILS", defaults.FuzzyMatchDetails),
+		FuzzyWriteBack:            utils.GetEnvBool("FUZZY_WRITE_BACK", defaults.FuzzyWriteBack),
+		FuzzyWriteBackTTL:         utils.GetEnvDuration("FUZZY_WRITE_BACK_TTL", defaults.FuzzyWriteBackTTL),
+		CORSAllowedOrigins:        utils.GetEnvList("CORS_ALLOWED_ORIGINS", defaults.CORSAllowedOrigins),
+		LongWordMinLength:         utils.GetEnvInt("LONG_WORD_MIN_LENGTH", defaults.LongWordMinLength),
+		StrictQueryParams:         utils.GetEnvBool("STRICT_QUERY_PARAMS", defaults.StrictQueryParams),
+		DebugSampleRate:           utils.GetEnvFloat("DEBUG_SAMPLE_RATE", defaults.DebugSampleRate),
+		DebugResponseFields:       utils.GetEnvBool("DEBUG_RESPONSE_FIELDS", defaults.DebugResponseFields),
+		IncludeMetrics:            utils.GetEnvBool("INCLUDE_METRICS", defaults.IncludeMetrics),
+		LogMode:                   logMode(utils.GetEnv("LOG_MODE", defaults.LogMode)),
+		ResultFormat:              resultFormat(utils.GetEnv("RESULT_FORMAT", defaults.ResultFormat)),
+		BookKeyStyle:              bookKeyStyle(utils.GetEnv("BOOK_KEY_STYLE", defaults.BookKeyStyle)),
+		RequiredResultFields:      utils.GetEnvList("REQUIRED_RESULT_FIELDS", defaults.RequiredResultFields),
+		SearchUpstreamFields:      utils.GetEnvList("SEARCH_UPSTREAM_FIELDS", defaults.SearchUpstreamFields),
+		ISBNUpstreamFields:        utils.GetEnvList("ISBN_UPSTREAM_FIELDS", defaults.ISBNUpstreamFields),
+		SchemaRequiredFields:      utils.GetEnvList("SCHEMA_REQUIRED_FIELDS", defaults.SchemaRequiredFields),
+		SchemaMaxMissingRatio:     utils.GetEnvFloat("SCHEMA_MAX_MISSING_RATIO", defaults.SchemaMaxMissingRatio),
+		SchemaRefuseCache:         utils.GetEnvBool("SCHEMA_REFUSE_CACHE", defaults.SchemaRefuseCache),
+		ResultFieldNames:          utils.GetEnvMap("RESULT_FIELD_NAMES", defaults.ResultFieldNames),
+		UpstreamTimeout:           utils.GetEnvDuration("UPSTREAM_TIMEOUT", defaults.UpstreamTimeout),
+		UpstreamExtendedTimeout:   utils.GetEnvDuration("UPSTREAM_EXTENDED_TIMEOUT", defaults.UpstreamExtendedTimeout),
+		UpstreamDialTimeout:       utils.GetEnvDuration("UPSTREAM_DIAL_TIMEOUT", defaults.UpstreamDialTimeout),
+		RetryBudgetAttempts:       utils.GetEnvInt("RETRY_BUDGET_ATTEMPTS", defaults.RetryBudgetAttempts),
+		RetryBudgetTime:           utils.GetEnvDuration("RETRY_BUDGET_TIME", defaults.RetryBudgetTime),
+		DefaultQueryLimit:         queryLimit(utils.GetEnvInt("DEFAULT_QUERY_LIMIT", defaults.DefaultQueryLimit)),
+	}
+}
+
+// queryLimit keeps the default limit within 1 and MAX_QUERY_LIMIT
+func queryLimit(limit int) int {
+	if limit <= 0 {
+		return constants.DEFAULT_QUERY_LIMIT
+	}
+	return min(limit, constants.MAX_QUERY_LIMIT)
+}
+
+// analyticsMode accepts "hashed" and treats anything else as raw
+func analyticsMode(mode string) string {
+	if mode == AnalyticsModeHashed {
+		return AnalyticsModeHashed
+	}
+	return AnalyticsModeRaw
+}
+
+// resultFormat accepts "normalized" and treats anything else as raw
+func resultFormat(format string) string {
+	if format == ResultFormatNormalized {
+		return ResultFormatNormalized
+	}
+	return ResultFormatRaw
+}
+
+// bookKeyStyle accepts "id" and treats anything else as path
+func bookKeyStyle(style string) string {
+	if style == BookKeyID {
+		return BookKeyID
+	}
+	return BookKeyPath
+}
+
+// logMode accepts "summary" and treats anything else as verbose
+func logMode(mode string) string {
+	if mode == LogModeSummary {
+		return LogModeSummary
+	}
+	return LogModeVerbose
+}
+
+// cacheKeyStrategy accepts "raw" and "both" and treats anything else as normalized
+func cacheKeyStrategy(strategy string) string {
+	if strategy == CacheKeyRaw || strategy == CacheKeyBoth {
+		return strategy
+	}
+	return CacheKeyNormalized
+}
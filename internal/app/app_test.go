@@ -0,0 +1,280 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+)
+
+func TestLoadConfigCacheKeyStrategy(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "", want: CacheKeyNormalized},
+		{value: "normalized", want: CacheKeyNormalized},
+		{value: "raw", want: CacheKeyRaw},
+		{value: "both", want: CacheKeyBoth},
+		{value: "RAW", want: CacheKeyNormalized},
+		{value: "exact", want: CacheKeyNormalized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("CACHE_KEY_STRATEGY", tt.value)
+			if got := LoadConfig().CacheKeyStrategy; got != tt.want {
+				t.Errorf("CacheKeyStrategy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRedisSafeMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "", want: false},
+		{value: "true", want: true},
+		{value: "false", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("REDIS_SAFE_MODE", tt.value)
+			if got := LoadConfig().RedisSafeMode; got != tt.want {
+				t.Errorf("RedisSafeMode = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLogMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", LogModeVerbose},
+		{"verbose", LogModeVerbose},
+		{"summary", LogModeSummary},
+		{"quiet", LogModeVerbose},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("LOG_MODE", tt.value)
+			if got := LoadConfig().LogMode; got != tt.want {
+				t.Errorf("LogMode = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDefaultQueryLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", constants.DEFAULT_QUERY_LIMIT},
+		{"50", 50},
+		{"0", constants.DEFAULT_QUERY_LIMIT},
+		{"-5", constants.DEFAULT_QUERY_LIMIT},
+		{"1000", constants.MAX_QUERY_LIMIT},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("DEFAULT_QUERY_LIMIT", tt.value)
+			if got := LoadConfig().DefaultQueryLimit; got != tt.want {
+				t.Errorf("DefaultQueryLimit = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigResultFormat(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ResultFormatRaw},
+		{"raw", ResultFormatRaw},
+		{"normalized", ResultFormatNormalized},
+		{"books", ResultFormatRaw},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("RESULT_FORMAT", tt.value)
+			if got := LoadConfig().ResultFormat; got != tt.want {
+				t.Errorf("ResultFormat = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigBookKeyStyle(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", BookKeyPath},
+		{"path", BookKeyPath},
+		{"id", BookKeyID},
+		{"ID", BookKeyPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("BOOK_KEY_STYLE", tt.value)
+			if got := LoadConfig().BookKeyStyle; got != tt.want {
+				t.Errorf("BookKeyStyle = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigSchemaChecks(t *testing.T) {
+	t.Setenv("SCHEMA_REQUIRED_FIELDS", "key,title,author_name")
+	t.Setenv("SCHEMA_MAX_MISSING_RATIO", "0.25")
+	t.Setenv("SCHEMA_REFUSE_CACHE", "true")
+
+	cfg := LoadConfig()
+	if want := []string{"key", "title", "author_name"}; !reflect.DeepEqual(cfg.SchemaRequiredFields, want) {
+		t.Errorf("SchemaRequiredFields = %v, want %v", cfg.SchemaRequiredFields, want)
+	}
+	if cfg.SchemaMaxMissingRatio != 0.25 || !cfg.SchemaRefuseCache {
+		t.Errorf("SchemaMaxMissingRatio = %v, SchemaRefuseCache = %v; want 0.25 and true", cfg.SchemaMaxMissingRatio, cfg.SchemaRefuseCache)
+	}
+}
+
+func TestLoadConfigUpstreamFields(t *testing.T) {
+	if cfg := LoadConfig(); cfg.SearchUpstreamFields != nil || cfg.ISBNUpstreamFields != nil {
+		t.Errorf("default upstream fields = %v and %v, want every field", cfg.SearchUpstreamFields, cfg.ISBNUpstreamFields)
+	}
+
+	t.Setenv("SEARCH_UPSTREAM_FIELDS", "key,title,author_name")
+	t.Setenv("ISBN_UPSTREAM_FIELDS", "key,title")
+	cfg := LoadConfig()
+	if want := []string{"key", "title", "author_name"}; !reflect.DeepEqual(cfg.SearchUpstreamFields, want) {
+		t.Errorf("SearchUpstreamFields = %v, want %v", cfg.SearchUpstreamFields, want)
+	}
+	if want := []string{"key", "title"}; !reflect.DeepEqual(cfg.ISBNUpstreamFields, want) {
+		t.Errorf("ISBNUpstreamFields = %v, want %v", cfg.ISBNUpstreamFields, want)
+	}
+}
+
+func TestLoadConfigRedisConnection(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(cfg Config) bool
+	}{
+		{name: "disabled by default", check: func(cfg Config) bool { return !cfg.RedisEnabled }},
+		{name: "enabled", env: map[string]string{"REDIS_ENABLED": "true"}, check: func(cfg Config) bool { return cfg.RedisEnabled }},
+		{name: "default address", check: func(cfg Config) bool { return cfg.RedisHost == "localhost" && cfg.RedisPort == "6379" }},
+		{name: "address", env: map[string]string{"REDIS_HOST": "cache.internal", "REDIS_PORT": "6380"}, check: func(cfg Config) bool { return cfg.RedisHost == "cache.internal" && cfg.RedisPort == "6380" }},
+		{name: "password", env: map[string]string{"REDIS_PASSWORD": "secret"}, check: func(cfg Config) bool { return cfg.RedisPassword == "secret" }},
+		{name: "db", env: map[string]string{"REDIS_DB": "3"}, check: func(cfg Config) bool { return cfg.RedisDB == 3 }},
+		{name: "default pool", check: func(cfg Config) bool {
+			return cfg.RedisPoolSize == constants.REDIS_POOL_SIZE && cfg.RedisMinIdleConns == constants.REDIS_MIN_IDLE_CONNS && cfg.RedisMaxRetries == constants.REDIS_MAX_RETRIES
+		}},
+		{name: "pool", env: map[string]string{"REDIS_POOL_SIZE": "50", "REDIS_MIN_IDLE_CONNS": "8", "REDIS_MAX_RETRIES": "0"}, check: func(cfg Config) bool {
+			return cfg.RedisPoolSize == 50 && cfg.RedisMinIdleConns == 8 && cfg.RedisMaxRetries == 0
+		}},
+		{name: "default timeouts", check: func(cfg Config) bool {
+			return cfg.RedisDialTimeout == constants.REDIS_DIAL_TIMEOUT_SECONDS*time.Second &&
+				cfg.RedisReadTimeout == constants.REDIS_READ_TIMEOUT_SECONDS*time.Second &&
+				cfg.RedisWriteTimeout == constants.REDIS_WRITE_TIMEOUT_SECONDS*time.Second
+		}},
+		{name: "timeouts", env: map[string]string{"REDIS_DIAL_TIMEOUT": "2s", "REDIS_READ_TIMEOUT": "500ms", "REDIS_WRITE_TIMEOUT": "1s"}, check: func(cfg Config) bool {
+			return cfg.RedisDialTimeout == 2*time.Second && cfg.RedisReadTimeout == 500*time.Millisecond && cfg.RedisWriteTimeout == time.Second
+		}},
+		{name: "invalid values keep the defaults", env: map[string]string{"REDIS_DB": "one", "REDIS_READ_TIMEOUT": "soon"}, check: func(cfg Config) bool {
+			return cfg.RedisDB == 0 && cfg.RedisReadTimeout == constants.REDIS_READ_TIMEOUT_SECONDS*time.Second
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if cfg := LoadConfig(); !tt.check(cfg) {
+				t.Errorf("config from %v = %+v", tt.env, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadConfigProductionFlush(t *testing.T) {
+	tests := []struct {
+		env             map[string]string
+		wantEnvironment string
+		wantAllow       bool
+	}{
+		{wantEnvironment: "development"},
+		{env: map[string]string{"ENV": "production"}, wantEnvironment: EnvironmentProduction},
+		{env: map[string]string{"ENV": "production", "ALLOW_PRODUCTION_FLUSH": "true"}, wantEnvironment: EnvironmentProduction, wantAllow: true},
+		{env: map[string]string{"ENV": "staging", "ALLOW_PRODUCTION_FLUSH": "yes"}, wantEnvironment: "staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.env), func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg := LoadConfig()
+			if cfg.Environment != tt.wantEnvironment || cfg.AllowProductionFlush != tt.wantAllow {
+				t.Errorf("Environment = %q and AllowProductionFlush = %v, want %q and %v", cfg.Environment, cfg.AllowProductionFlush, tt.wantEnvironment, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFuzzyWriteBack(t *testing.T) {
+	tests := []struct {
+		env         map[string]string
+		wantEnabled bool
+		wantTTL     time.Duration
+	}{
+		{wantTTL: constants.FUZZY_WRITE_BACK_TTL_MINUTES * time.Minute},
+		{env: map[string]string{"FUZZY_WRITE_BACK": "true"}, wantEnabled: true, wantTTL: constants.FUZZY_WRITE_BACK_TTL_MINUTES * time.Minute},
+		{env: map[string]string{"FUZZY_WRITE_BACK": "true", "FUZZY_WRITE_BACK_TTL": "30s"}, wantEnabled: true, wantTTL: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.env), func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg := LoadConfig()
+			if cfg.FuzzyWriteBack != tt.wantEnabled || cfg.FuzzyWriteBackTTL != tt.wantTTL {
+				t.Errorf("FuzzyWriteBack = %v and FuzzyWriteBackTTL = %v, want %v and %v", cfg.FuzzyWriteBack, cfg.FuzzyWriteBackTTL, tt.wantEnabled, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestLoadConfigIncludeMetrics(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "", want: true},
+		{value: "true", want: true},
+		{value: "false", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("INCLUDE_METRICS", tt.value)
+			if got := LoadConfig().IncludeMetrics; got != tt.want {
+				t.Errorf("IncludeMetrics = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAnalyticsLimit = 10
+	maxAnalyticsLimit     = 100
+)
+
+// QueryCount is one entry of the query popularity report. In hashed mode Query is only set
+// when the hash -> query mapping is kept.
+type QueryCount struct {
+	Query string `json:"query,omitempty"`
+	Hash  string `json:"hash,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// hashQuery returns the salted hash analytics store in place of a query in hashed mode
+func hashQuery(normalizedQuery string, salt string) string {
+	sum := sha256.Sum256([]byte(salt + normalizedQuery))
+	return hex.EncodeToString(sum[:16])
+}
+
+// analyticsKey is the per-query counter suffix for a normalized query under the configured
+// mode. When the mapping is kept, it is queued on the batched Stats counter with the
+// counter's increment, so it is refreshed (once per flush) to expire with the counter
+// without a Redis round trip per search.
+func analyticsKey(normalizedQuery string) string {
+	cfg := CurrentConfig()
+	if cfg.AnalyticsMode != app.AnalyticsModeHashed {
+		return normalizedQuery
+	}
+
+	hash := hashQuery(normalizedQuery, cfg.AnalyticsHashSalt)
+	if cfg.AnalyticsHashMapping && Stats != nil {
+		Stats.Put(statsQueryMapPrefix+hash, normalizedQuery)
+	}
+	return hash
+}
+
+// topQueries returns the limit most requested queries, most requested first. Counts include
+// increments still queued in memory for queries that have already been flushed once.
+func topQueries(limit int) ([]QueryCount, error) {
+	keys, err := Cache.Scan(statsQueryPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	values, _, err := Cache.GetMany(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := CurrentConfig()
+	hashed := cfg.AnalyticsMode == app.AnalyticsModeHashed
+	counts := make([]QueryCount, 0, len(keys))
+	for i, key := range keys {
+		count, _ := strconv.ParseInt(values[i], 10, 64)
+		if Stats != nil {
+			count += Stats.Pending(key)
+		}
+		name := strings.TrimPrefix(key, statsQueryPrefix)
+		if hashed {
+			counts = append(counts, QueryCount{Hash: name, Count: count})
+		} else {
+			counts = append(counts, QueryCount{Query: name, Count: count})
+		}
+	}
+
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].Count > counts[j].Count
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+
+	if hashed && cfg.AnalyticsHashMapping && len(counts) > 0 {
+		mapKeys := make([]string, len(counts))
+		for i, entry := range counts {
+			mapKeys[i] = statsQueryMapPrefix + entry.Hash
+		}
+		originals, found, err := Cache.GetMany(mapKeys)
+		if err != nil {
+			return nil, err
+		}
+		for i := range counts {
+			if found[i] {
+				counts[i].Query = originals[i]
+			}
+		}
+	}
+	return counts, nil
+}
+
+// AnalyticsQueries reports the most requested queries. In hashed mode entries carry hashes,
+// plus the original query when the mapping is enabled.
+func AnalyticsQueries(c *gin.Context) {
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+
+	limit := defaultAnalyticsLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxAnalyticsLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Parameter 'limit' must be between 1 and " + strconv.Itoa(maxAnalyticsLimit),
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	queries, err := topQueries(limit)
+	if err != nil {
+		Logger.Warn("Failed to read query analytics", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read query analytics",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mode":    CurrentConfig().AnalyticsMode,
+		"queries": queries,
+	})
+}
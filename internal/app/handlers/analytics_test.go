@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestHashQuery(t *testing.T) {
+	hash := hashQuery("dune", "pepper")
+	if len(hash) != 32 {
+		t.Errorf("hash %q has %d characters, want 32", hash, len(hash))
+	}
+	if again := hashQuery("dune", "pepper"); again != hash {
+		t.Errorf("hashQuery is not deterministic: %q then %q", hash, again)
+	}
+	if other := hashQuery("dune", "salt"); other == hash {
+		t.Error("hashQuery ignores the salt")
+	}
+	if other := hashQuery("emma", "pepper"); other == hash {
+		t.Error("different queries hash alike")
+	}
+}
+
+func TestAnalyticsQueries(t *testing.T) {
+	searches := []string{"dune", "dune", "dune", "emma", "emma", "ulysses"}
+	tests := []struct {
+		name        string
+		mode        string
+		mapping     bool
+		target      string
+		wantStatus  int
+		wantQueries []QueryCount
+	}{
+		{
+			name:       "raw mode",
+			mode:       app.AnalyticsModeRaw,
+			target:     "/analytics/queries",
+			wantStatus: http.StatusOK,
+			wantQueries: []QueryCount{
+				{Query: "dune", Count: 3}, {Query: "emma", Count: 2}, {Query: "ulysses", Count: 1},
+			},
+		},
+		{
+			name:       "limited",
+			mode:       app.AnalyticsModeRaw,
+			target:     "/analytics/queries?limit=2",
+			wantStatus: http.StatusOK,
+			wantQueries: []QueryCount{
+				{Query: "dune", Count: 3}, {Query: "emma", Count: 2},
+			},
+		},
+		{
+			name:       "hashed mode",
+			mode:       app.AnalyticsModeHashed,
+			target:     "/analytics/queries",
+			wantStatus: http.StatusOK,
+			wantQueries: []QueryCount{
+				{Hash: hashQuery("dune", "pepper"), Count: 3},
+				{Hash: hashQuery("emma", "pepper"), Count: 2},
+				{Hash: hashQuery("ulysses", "pepper"), Count: 1},
+			},
+		},
+		{
+			name:       "hashed mode with mapping",
+			mode:       app.AnalyticsModeHashed,
+			mapping:    true,
+			target:     "/analytics/queries",
+			wantStatus: http.StatusOK,
+			wantQueries: []QueryCount{
+				{Query: "dune", Hash: hashQuery("dune", "pepper"), Count: 3},
+				{Query: "emma", Hash: hashQuery("emma", "pepper"), Count: 2},
+				{Query: "ulysses", Hash: hashQuery("ulysses", "pepper"), Count: 1},
+			},
+		},
+		{name: "invalid limit", mode: app.AnalyticsModeRaw, target: "/analytics/queries?limit=0", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.AnalyticsMode = tt.mode
+				cfg.AnalyticsHashSalt = "pepper"
+				cfg.AnalyticsHashMapping = tt.mapping
+			})
+			_, server := useCache(t)
+			stats := useStats(t)
+			for _, query := range searches {
+				recordSearchStats(false, query)
+			}
+			if err := stats.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := serve(AnalyticsQueries, http.MethodGet, "/analytics/queries", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Mode    string       `json:"mode"`
+				Queries []QueryCount `json:"queries"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Mode != tt.mode {
+				t.Errorf("mode = %q, want %q", body.Mode, tt.mode)
+			}
+			if !reflect.DeepEqual(body.Queries, tt.wantQueries) {
+				t.Errorf("queries = %+v, want %+v", body.Queries, tt.wantQueries)
+			}
+			if tt.mode == app.AnalyticsModeHashed && !tt.mapping {
+				for _, key := range server.Keys() {
+					if strings.Contains(key, "dune") {
+						t.Errorf("raw query stored in hashed mode under %q", key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyticsMappingExpiresWithCounter(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) {
+		cfg.AnalyticsMode = app.AnalyticsModeHashed
+		cfg.AnalyticsHashSalt = "pepper"
+		cfg.AnalyticsHashMapping = true
+		cfg.AnalyticsQueryTTL = 2 * time.Hour
+	})
+	_, server := useCache(t)
+	stats := useStats(t)
+	recordSearchStats(false, "dune")
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := hashQuery("dune", "pepper")
+	if got, _ := server.Get("test:" + statsQueryMapPrefix + hash); got != "dune" {
+		t.Errorf("mapping = %q, want dune", got)
+	}
+	for _, key := range []string{statsQueryPrefix + hash, statsQueryMapPrefix + hash} {
+		if got := server.TTL("test:" + key); got != 2*time.Hour {
+			t.Errorf("TTL of %s = %v, want 2h", key, got)
+		}
+	}
+}
+
+func TestTrimQueryCountersRemovesHashedMappings(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) {
+		cfg.AnalyticsMode = app.AnalyticsModeHashed
+		cfg.AnalyticsHashMapping = true
+	})
+	_, server := useCache(t)
+	server.Set("test:"+statsQueryPrefix+"aaaa", "5")
+	server.Set("test:"+statsQueryMapPrefix+"aaaa", "dune")
+	server.Set("test:"+statsQueryPrefix+"bbbb", "1")
+	server.Set("test:"+statsQueryMapPrefix+"bbbb", "emma")
+
+	if _, err := trimQueryCounters(1); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists("test:"+statsQueryMapPrefix+"bbbb") || server.Exists("test:"+statsQueryPrefix+"bbbb") {
+		t.Error("the trimmed counter or its mapping is still stored")
+	}
+	if !server.Exists("test:" + statsQueryMapPrefix + "aaaa") {
+		t.Error("the kept counter's mapping was removed")
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	"go.uber.org/zap"
+)
+
+// assembleFromWords builds a partial result for a multi-word query from the cached results
+// of its significant words on their own, deduplicated by work key and in word order.
+// Returns the words that contributed and false when fewer than two words are significant or
+// none of them is cached. numFound is the number of assembled docs, since the full query's
+// total is unknown.
+func assembleFromWords(params SearchParams) (OpenLibraryResponse, []string, bool) {
+	words := significantWords(strings.Split(params.NormalizedQuery, " "))
+	if len(words) < 2 {
+		return OpenLibraryResponse{}, nil, false
+	}
+
+	keys := make([]string, len(words))
+	for i, word := range words {
+		keys[i] = fmt.Sprintf("%s:%s", params.Namespace(), word)
+	}
+	values, found, err := Cache.GetMany(keys)
+	if err != nil {
+		Logger.Warn("Failed to read word caches for assembly", zap.Error(err))
+		return OpenLibraryResponse{}, nil, false
+	}
+
+	assembled := OpenLibraryResponse{Docs: []map[string]interface{}{}}
+	seen := map[string]bool{}
+	used := []string{}
+	for i, word := range words {
+		if !found[i] {
+			continue
+		}
+		var part OpenLibraryResponse
+		if err := json.Unmarshal([]byte(values[i]), &part); err != nil {
+			Logger.Warn("Failed to decode word cache for assembly", zap.String("key", keys[i]), zap.Error(err))
+			continue
+		}
+		used = append(used, word)
+		for _, doc := range part.Docs {
+			key := docString(doc, "key")
+			if key != "" && seen[key] {
+				continue
+			}
+			seen[key] = true
+			assembled.Docs = append(assembled.Docs, doc)
+		}
+	}
+	if len(used) == 0 {
+		return OpenLibraryResponse{}, nil, false
+	}
+	assembled.NumFound = len(assembled.Docs)
+	return assembled, used, true
+}
+
+// fetchInBackground fetches and caches the full query after an assembled answer, so the
+// next request for it is an exact hit. Concurrent refreshes of one query share a fetch.
+// Run it with goBackground so it is cancelled on shutdown.
+func fetchInBackground(ctx context.Context, params SearchParams) {
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout(params.Query, params.Match, params.ExtendedTimeout))
+	defer cancel()
+
+	hit, err := fillCache(ctx, params)
+	if err != nil && !errors.Is(err, cache.ErrSetFailed) {
+		Logger.Warn("Background fetch after assembled result failed", zap.String("query", params.NormalizedQuery), zap.Error(err))
+		return
+	}
+	if !hit && err == nil {
+		Logger.Info("Background fetch cached full query", zap.String("query", params.NormalizedQuery))
+	}
+}
+
+// fillCache makes sure the entry Search serves for params (its StoreKey) is cached, fetching
+// it from upstream on a miss. It goes through GetOrSet, so a live search or another fill for
+// the same key in this process shares one upstream call. hit reports whether it was already
+// cached.
+// ctx only bounds how long the caller waits: the fetch runs detached from it under its own
+// upstream timeout, so a caller giving up never fails live searches sharing the fetch.
+func fillCache(ctx context.Context, params SearchParams) (bool, error) {
+	cacheKey := params.StoreKey()
+	var response OpenLibraryResponse
+	loaded, err := getOrSetResults(ctx, cacheKey, params.Query, &response, func(ctx context.Context) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, upstreamTimeout(params.Query, params.Match, params.ExtendedTimeout))
+		defer cancel()
+		result, err := fetchOpenLibrary(ctx, params.UpstreamURL())
+		if err == nil && !cacheableResponse(params.UpstreamURL(), result.Response) {
+			return cache.DontStore(result.Response), nil
+		}
+		return result.Response, err
+	})
+	if err != nil {
+		return loaded.Hit, err
+	}
+	// Fuzzy matching works on normalized query keys only, as in Search
+	if !loaded.Hit && CurrentConfig().CacheKeyStrategy != app.CacheKeyRaw && !params.changesUpstreamCall() {
+		recordRecentQuery(params.Namespace(), params.NormalizedQuery)
+	}
+	return loaded.Hit, nil
+}
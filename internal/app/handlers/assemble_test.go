@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestAssembleFromWords(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		cached     map[string]string // word -> cached body
+		wantOK     bool
+		wantWords  []string
+		wantTitles []string
+	}{
+		{
+			name:       "every word cached",
+			query:      "tolkien dragons",
+			cached:     map[string]string{"tolkien": `{"numFound":2,"docs":[{"key":"/works/OL1W","title":"The Hobbit"},{"key":"/works/OL2W","title":"The Silmarillion"}]}`, "dragons": `{"numFound":1,"docs":[{"key":"/works/OL3W","title":"Dragonflight"}]}`},
+			wantOK:     true,
+			wantWords:  []string{"tolkien", "dragons"},
+			wantTitles: []string{"The Hobbit", "The Silmarillion", "Dragonflight"},
+		},
+		{
+			name:       "some words cached",
+			query:      "tolkien dragons",
+			cached:     map[string]string{"dragons": upstreamBody("Dragonflight")},
+			wantOK:     true,
+			wantWords:  []string{"dragons"},
+			wantTitles: []string{"Dragonflight"},
+		},
+		{
+			name:       "duplicate works kept once in word order",
+			query:      "tolkien hobbit",
+			cached:     map[string]string{"tolkien": `{"numFound":2,"docs":[{"key":"/works/OL1W","title":"The Hobbit"},{"key":"/works/OL2W","title":"The Silmarillion"}]}`, "hobbit": `{"numFound":1,"docs":[{"key":"/works/OL1W","title":"The Hobbit"}]}`},
+			wantOK:     true,
+			wantWords:  []string{"tolkien", "hobbit"},
+			wantTitles: []string{"The Hobbit", "The Silmarillion"},
+		},
+		{
+			name:   "short words are not significant",
+			query:  "the hobbit",
+			cached: map[string]string{"the": upstreamBody("The Road"), "hobbit": upstreamBody("The Hobbit")},
+			wantOK: false,
+		},
+		{
+			name:   "no word cached",
+			query:  "tolkien dragons",
+			wantOK: false,
+		},
+		{
+			name:   "single word",
+			query:  "tolkien",
+			cached: map[string]string{"tolkien": upstreamBody("The Hobbit")},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			for word, body := range tt.cached {
+				cacheResults(t, "search:"+word, body)
+			}
+
+			params := SearchParams{Query: tt.query, NormalizedQuery: tt.query}
+			assembled, words, ok := assembleFromWords(params)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(words, tt.wantWords) {
+				t.Errorf("words = %q, want %q", words, tt.wantWords)
+			}
+			var titles []string
+			for _, doc := range assembled.Docs {
+				titles = append(titles, docString(doc, "title"))
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Errorf("titles = %q, want %q", titles, tt.wantTitles)
+			}
+			if assembled.NumFound != len(tt.wantTitles) {
+				t.Errorf("numFound = %d, want the assembled count %d", assembled.NumFound, len(tt.wantTitles))
+			}
+		})
+	}
+}
+
+func TestSearchAssembledResults(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		wantAssembled bool
+	}{
+		{name: "off by default", enabled: false, wantAssembled: false},
+		{name: "enabled", enabled: true, wantAssembled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.AssembledResults = tt.enabled })
+			c, _ := useCache(t)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Tolkien's Dragons"))
+			cacheResults(t, "search:tolkien", upstreamBody("The Hobbit"))
+			cacheResults(t, "search:dragons", upstreamBody("Dragonflight"))
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q=tolkien+dragons", "")
+			if !WaitBackground(2 * time.Second) {
+				t.Fatal("background fetch never finished")
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if got := body["assembled"] == true; got != tt.wantAssembled {
+				t.Fatalf("assembled = %v, want %v: %s", body["assembled"], tt.wantAssembled, rec.Body.String())
+			}
+			// Either way the full query ends up fetched once and cached
+			if upstream.calls() != 1 {
+				t.Errorf("upstream called %d times, want 1", upstream.calls())
+			}
+			if exists, _ := c.Exists("search:tolkien dragons"); !exists {
+				t.Error("the full query was not cached")
+			}
+			if !tt.wantAssembled {
+				return
+			}
+			if body["source"] != sourceL2Assembled {
+				t.Errorf("source = %v, want %s", body["source"], sourceL2Assembled)
+			}
+			if want := []interface{}{"tolkien", "dragons"}; !reflect.DeepEqual(body["assembledFrom"], want) {
+				t.Errorf("assembledFrom = %v, want %v", body["assembledFrom"], want)
+			}
+		})
+	}
+}
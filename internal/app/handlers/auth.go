@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// apiKeyHeader is the header carrying an admin API key under app.APIKeySchemeHeader.
+// Header names are matched case-insensitively.
+const apiKeyHeader = "X-API-Key"
+
+// presentedAPIKeys collects the keys a request presents under the accepted schemes
+func presentedAPIKeys(c *gin.Context, schemes []string) []string {
+	keys := []string{}
+	for _, scheme := range schemes {
+		switch strings.ToLower(scheme) {
+		case app.APIKeySchemeHeader:
+			for _, value := range c.Request.Header.Values(apiKeyHeader) {
+				keys = append(keys, strings.TrimSpace(value))
+			}
+		case app.APIKeySchemeBearer:
+			for _, value := range c.Request.Header.Values("Authorization") {
+				scheme, token, ok := strings.Cut(strings.TrimSpace(value), " ")
+				if ok && strings.EqualFold(scheme, "Bearer") {
+					keys = append(keys, strings.TrimSpace(token))
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// validAPIKey compares key against every configured key in constant time
+func validAPIKey(key string, allowed []string) bool {
+	valid := false
+	for _, candidate := range allowed {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// RequireAPIKey guards admin endpoints with one of AdminAPIKeys, presented as an X-API-Key
+// header and/or an Authorization: Bearer token depending on APIKeySchemes. A request
+// presenting more than one key (repeated headers, or both schemes) is rejected, so it's
+// never ambiguous which one was checked. With no keys configured the guard is off, except
+// in production, where admin endpoints answer 503 until keys are set.
+func RequireAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := CurrentConfig()
+		if len(cfg.AdminAPIKeys) == 0 {
+			if cfg.Environment == app.EnvironmentProduction {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+					"error": "Admin endpoints are disabled until ADMIN_API_KEYS is set",
+				})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		keys := presentedAPIKeys(c, cfg.APIKeySchemes)
+		switch {
+		case len(keys) == 0:
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "API key required",
+			})
+			return
+		case len(keys) > 1:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Multiple API keys presented, send exactly one",
+			})
+			return
+		case !validAPIKey(keys[0], cfg.AdminAPIKeys):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Invalid API key",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	type header struct {
+		name  string
+		value string
+	}
+	tests := []struct {
+		name       string
+		keys       []string
+		schemes    []string
+		headers    []header
+		production bool
+		wantStatus int
+	}{
+		{name: "no keys configured", headers: nil, wantStatus: http.StatusOK},
+		{name: "no keys configured in production", production: true, headers: []header{{"X-API-Key", "secret"}}, wantStatus: http.StatusServiceUnavailable},
+		{name: "keys configured in production", production: true, keys: []string{"secret"}, headers: []header{{"X-API-Key", "secret"}}, wantStatus: http.StatusOK},
+		{name: "missing key", keys: []string{"secret"}, wantStatus: http.StatusUnauthorized},
+		{name: "header key", keys: []string{"secret"}, headers: []header{{"X-API-Key", "secret"}}, wantStatus: http.StatusOK},
+		{name: "header name casing", keys: []string{"secret"}, headers: []header{{"x-api-KEY", "secret"}}, wantStatus: http.StatusOK},
+		{name: "surrounding whitespace", keys: []string{"secret"}, headers: []header{{"X-API-Key", " secret "}}, wantStatus: http.StatusOK},
+		{name: "any configured key", keys: []string{"old", "secret"}, headers: []header{{"X-API-Key", "secret"}}, wantStatus: http.StatusOK},
+		{name: "wrong key", keys: []string{"secret"}, headers: []header{{"X-API-Key", "guess"}}, wantStatus: http.StatusForbidden},
+		{name: "key values are case sensitive", keys: []string{"secret"}, headers: []header{{"X-API-Key", "SECRET"}}, wantStatus: http.StatusForbidden},
+		{name: "bearer token", keys: []string{"secret"}, headers: []header{{"Authorization", "Bearer secret"}}, wantStatus: http.StatusOK},
+		{name: "bearer scheme casing", keys: []string{"secret"}, headers: []header{{"authorization", "bearer secret"}}, wantStatus: http.StatusOK},
+		{name: "other authorization scheme", keys: []string{"secret"}, headers: []header{{"Authorization", "Basic secret"}}, wantStatus: http.StatusUnauthorized},
+		{name: "duplicate headers", keys: []string{"secret"}, headers: []header{{"X-API-Key", "secret"}, {"x-api-key", "secret"}}, wantStatus: http.StatusBadRequest},
+		{name: "both schemes", keys: []string{"secret"}, headers: []header{{"X-API-Key", "secret"}, {"Authorization", "Bearer secret"}}, wantStatus: http.StatusBadRequest},
+		{name: "bearer scheme not accepted", keys: []string{"secret"}, schemes: []string{app.APIKeySchemeHeader}, headers: []header{{"Authorization", "Bearer secret"}}, wantStatus: http.StatusUnauthorized},
+		{name: "header scheme not accepted", keys: []string{"secret"}, schemes: []string{app.APIKeySchemeBearer}, headers: []header{{"X-API-Key", "secret"}}, wantStatus: http.StatusUnauthorized},
+		{name: "unaccepted scheme doesn't count as a duplicate", keys: []string{"secret"}, schemes: []string{app.APIKeySchemeHeader}, headers: []header{{"X-API-Key", "secret"}, {"Authorization", "Bearer other"}}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.AdminAPIKeys = tt.keys
+				if tt.production {
+					cfg.Environment = app.EnvironmentProduction
+				}
+				if tt.schemes != nil {
+					cfg.APIKeySchemes = tt.schemes
+				}
+			})
+			router := gin.New()
+			router.Use(RequireAPIKey())
+			router.GET("/admin", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			for _, h := range tt.headers {
+				req.Header.Add(h.name, h.value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
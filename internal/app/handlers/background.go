@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Background work (periodic loops, refreshes after assembled answers) derives from rootCtx
+// and is tracked in background, so shutdown can cancel it and wait for it to finish.
+var (
+	rootCtx    = context.Background()
+	background backgroundWork
+)
+
+// backgroundWork counts running background goroutines. Unlike a sync.WaitGroup, waiting on
+// it can time out and new work can start afterwards without racing the abandoned wait.
+type backgroundWork struct {
+	mu      sync.Mutex
+	running int
+	idle    chan struct{} // closed when running drops to 0
+}
+
+func (b *backgroundWork) add() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running == 0 {
+		b.idle = make(chan struct{})
+	}
+	b.running++
+}
+
+func (b *backgroundWork) done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running--
+	if b.running == 0 {
+		close(b.idle)
+	}
+}
+
+// wait returns a channel closed once no background work is running
+func (b *backgroundWork) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	return b.idle
+}
+
+// SetRootContext sets the context background work runs under. Call it before starting any
+// background work; cancel ctx on shutdown and then call WaitBackground.
+func SetRootContext(ctx context.Context) {
+	rootCtx = ctx
+}
+
+// goBackground runs fn in a tracked goroutine with the root context
+func goBackground(fn func(ctx context.Context)) {
+	ctx := rootCtx
+	background.add()
+	go func() {
+		defer background.done()
+		fn(ctx)
+	}()
+}
+
+// WaitBackground waits up to timeout for background work to stop after the root context is
+// cancelled. It reports whether everything stopped in time.
+func WaitBackground(timeout time.Duration) bool {
+	select {
+	case <-background.wait():
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// runEvery calls fn every interval in a background goroutine until the root context is
+// cancelled. The returned function stops it earlier and waits for any call in progress to finish.
+func runEvery(interval time.Duration, fn func()) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	goBackground(func(ctx context.Context) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stop) })
+		<-done
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useRootContext installs a cancellable root context for background work, restoring the
+// previous one once the test's background work has stopped
+func useRootContext(t *testing.T) context.CancelFunc {
+	t.Helper()
+	if !WaitBackground(time.Second) {
+		t.Fatal("background work from an earlier test is still running")
+	}
+	previous := rootCtx
+	ctx, cancel := context.WithCancel(context.Background())
+	SetRootContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		WaitBackground(time.Second)
+		SetRootContext(previous)
+	})
+	return cancel
+}
+
+func TestGoBackgroundStopsOnRootCancel(t *testing.T) {
+	cancel := useRootContext(t)
+	started := make(chan struct{})
+	var stopped atomic.Bool
+	goBackground(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		stopped.Store(true)
+	})
+	<-started
+
+	if WaitBackground(20 * time.Millisecond) {
+		t.Fatal("WaitBackground returned true while a worker was still running")
+	}
+	cancel()
+	if !WaitBackground(time.Second) {
+		t.Fatal("worker did not stop after the root context was cancelled")
+	}
+	if !stopped.Load() {
+		t.Error("worker returned without seeing the cancellation")
+	}
+}
+
+func TestRunEvery(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(cancelRoot context.CancelFunc, stop func())
+	}{
+		{name: "stopped by its stop function", stop: func(_ context.CancelFunc, stop func()) { stop() }},
+		{name: "stopped by the root context", stop: func(cancelRoot context.CancelFunc, _ func()) { cancelRoot() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancel := useRootContext(t)
+			var calls atomic.Int32
+			stop := runEvery(5*time.Millisecond, func() { calls.Add(1) })
+
+			deadline := time.Now().Add(2 * time.Second)
+			for calls.Load() < 2 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if calls.Load() < 2 {
+				t.Fatal("fn was not called on the interval")
+			}
+
+			tt.stop(cancel, stop)
+			if !WaitBackground(time.Second) {
+				t.Fatal("loop did not stop")
+			}
+			after := calls.Load()
+			time.Sleep(20 * time.Millisecond)
+			if got := calls.Load(); got != after {
+				t.Errorf("fn called %d more times after stopping", got-after)
+			}
+			// Stopping again, or after the root context stopped it, must not block or panic
+			stop()
+		})
+	}
+}
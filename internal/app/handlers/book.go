@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// Book is the normalized shape of an OpenLibrary search doc. Raw docs are loosely
+// typed and vary between responses, so comparisons and client-facing fields go through this.
+type Book struct {
+	Key              string       `json:"key"`
+	Title            string       `json:"title"`
+	AuthorNames      []string     `json:"authorNames,omitempty"`
+	AuthorKeys       []string     `json:"authorKeys,omitempty"`
+	AuthorLinks      []AuthorLink `json:"authorLinks,omitempty"`
+	FirstPublishYear int          `json:"firstPublishYear,omitempty"`
+	CoverID          int          `json:"coverId,omitempty"`
+	EditionCount     int          `json:"editionCount"` // 0 when the doc doesn't report it
+	Publishers       []string     `json:"publishers,omitempty"`
+	PublishPlaces    []string     `json:"publishPlaces,omitempty"`
+	Languages        []string     `json:"languages,omitempty"`
+	Subjects         []string     `json:"subjects,omitempty"`
+
+	// Online availability. Docs without these fields leave them empty rather than failing.
+	EbookAccess  string        `json:"ebookAccess,omitempty"`
+	HasFulltext  bool          `json:"hasFulltext"`
+	Availability *Availability `json:"availability,omitempty"`
+
+	// Edition-level details, only present for ISBN lookups
+	ISBN          []string `json:"isbn,omitempty"`
+	PublishDate   string   `json:"publishDate,omitempty"`
+	NumberOfPages int      `json:"numberOfPages,omitempty"`
+}
+
+// Availability is the subset of OpenLibrary's nested availability object clients filter on
+type Availability struct {
+	Status            string `json:"status,omitempty"`
+	IsReadable        bool   `json:"isReadable"`
+	IsLendable        bool   `json:"isLendable"`
+	IsPreviewable     bool   `json:"isPreviewable"`
+	AvailableToBorrow bool   `json:"availableToBorrow"`
+}
+
+// AvailableOnline reports whether the book can be read or borrowed online by anyone.
+// "printdisabled" ebooks are excluded since they are restricted to qualifying patrons.
+func (b Book) AvailableOnline() bool {
+	switch b.EbookAccess {
+	case "public", "borrowable":
+		return true
+	case "":
+		if b.HasFulltext {
+			return true
+		}
+	}
+	if a := b.Availability; a != nil {
+		return a.IsReadable || a.IsLendable || a.AvailableToBorrow ||
+			a.Status == "open" || a.Status == "borrow_available"
+	}
+	return false
+}
+
+// AuthorLink pairs an author's name with their OpenLibrary key, for drill-down links
+type AuthorLink struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// authorLinks pairs author_name with author_key by position. OpenLibrary keeps the two
+// arrays parallel, but if their lengths disagree, extra names get a link without a key
+// and extra keys are dropped rather than being attached to the wrong author.
+func authorLinks(names []string, keys []string) []AuthorLink {
+	if len(names) == 0 {
+		return nil
+	}
+	links := make([]AuthorLink, len(names))
+	for i, name := range names {
+		links[i] = AuthorLink{Name: name}
+		if i < len(keys) && keys[i] != "" {
+			links[i].Key = keys[i]
+			links[i].URL = constants.OpenLibraryAPIURL + constants.OpenLibraryAuthorsPath + keys[i]
+		}
+	}
+	return links
+}
+
+// mapAvailability maps the nested availability object, returning nil when it is absent
+func mapAvailability(doc map[string]interface{}) *Availability {
+	raw, ok := doc["availability"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return &Availability{
+		Status:            docString(raw, "status"),
+		IsReadable:        docBool(raw, "is_readable"),
+		IsLendable:        docBool(raw, "is_lendable"),
+		IsPreviewable:     docBool(raw, "is_previewable"),
+		AvailableToBorrow: docBool(raw, "available_to_borrow"),
+	}
+}
+
+// mapDocToBook extracts the fields we care about from a raw OpenLibrary doc,
+// leaving zero values for anything missing or of an unexpected type
+func mapDocToBook(doc map[string]interface{}) Book {
+	authorNames := docStrings(doc, "author_name")
+	authorKeys := docStrings(doc, "author_key")
+	return Book{
+		Key:              bookKey(docString(doc, "key")),
+		Title:            docString(doc, "title"),
+		AuthorNames:      authorNames,
+		AuthorKeys:       authorKeys,
+		AuthorLinks:      authorLinks(authorNames, authorKeys),
+		FirstPublishYear: docInt(doc, "first_publish_year"),
+		CoverID:          docInt(doc, "cover_i"),
+		EditionCount:     docInt(doc, "edition_count"),
+		Publishers:       docStrings(doc, "publisher"),
+		PublishPlaces:    docStrings(doc, "publish_place"),
+		Languages:        docStrings(doc, "language"),
+		Subjects:         docStrings(doc, "subject"),
+		EbookAccess:      docString(doc, "ebook_access"),
+		HasFulltext:      docBool(doc, "has_fulltext"),
+		Availability:     mapAvailability(doc),
+	}
+}
+
+// bookKey reports an OpenLibrary key in the configured BookKeyStyle: the path as returned
+// (/works/OL45804W) or just its id (OL45804W)
+func bookKey(path string) string {
+	if CurrentConfig().BookKeyStyle != app.BookKeyID {
+		return path
+	}
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// mapEditionToBook maps an OpenLibrary edition record (as returned by /isbn/{isbn}.json)
+func mapEditionToBook(edition map[string]interface{}) Book {
+	covers := docInts(edition, "covers")
+	coverID := 0
+	if len(covers) > 0 {
+		coverID = covers[0]
+	}
+
+	return Book{
+		Key:           bookKey(docString(edition, "key")),
+		Title:         docString(edition, "title"),
+		CoverID:       coverID,
+		ISBN:          append(docStrings(edition, "isbn_13"), docStrings(edition, "isbn_10")...),
+		PublishDate:   docString(edition, "publish_date"),
+		NumberOfPages: docInt(edition, "number_of_pages"),
+	}
+}
+
+// mapDocsToBooks maps every raw doc to a Book, preserving order
+func mapDocsToBooks(docs []map[string]interface{}) []Book {
+	books := make([]Book, 0, len(docs))
+	for _, doc := range docs {
+		books = append(books, mapDocToBook(doc))
+	}
+	return books
+}
+
+func docString(doc map[string]interface{}, field string) string {
+	value, _ := doc[field].(string)
+	return value
+}
+
+// hasFields reports whether doc has a non-empty value for every field
+func hasFields(doc map[string]interface{}, fields []string) bool {
+	for _, field := range fields {
+		switch value := doc[field].(type) {
+		case nil:
+			return false
+		case string:
+			if value == "" {
+				return false
+			}
+		case []interface{}:
+			if len(value) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func docBool(doc map[string]interface{}, field string) bool {
+	value, _ := doc[field].(bool)
+	return value
+}
+
+func docStrings(doc map[string]interface{}, field string) []string {
+	raw, ok := doc[field].([]interface{})
+	if !ok {
+		return nil
+	}
+	values := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// docInt reads a numeric field; encoding/json decodes numbers into float64
+func docInt(doc map[string]interface{}, field string) int {
+	value, ok := doc[field].(float64)
+	if !ok {
+		return 0
+	}
+	return int(value)
+}
+
+func docInts(doc map[string]interface{}, field string) []int {
+	raw, ok := doc[field].([]interface{})
+	if !ok {
+		return nil
+	}
+	values := make([]int, 0, len(raw))
+	for _, item := range raw {
+		if value, ok := item.(float64); ok {
+			values = append(values, int(value))
+		}
+	}
+	return values
+}
@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// decodeDoc decodes a raw OpenLibrary doc the way responses are decoded
+func decodeDoc(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestMapDocToBookAvailability(t *testing.T) {
+	tests := []struct {
+		name             string
+		doc              string
+		wantEbookAccess  string
+		wantHasFulltext  bool
+		wantAvailability *Availability
+	}{
+		{
+			name:            "flat fields",
+			doc:             `{"key":"/works/OL1W","title":"Dune","ebook_access":"borrowable","has_fulltext":true}`,
+			wantEbookAccess: "borrowable",
+			wantHasFulltext: true,
+		},
+		{
+			name:             "nested availability",
+			doc:              `{"key":"/works/OL1W","title":"Dune","availability":{"status":"borrow_available","is_lendable":true,"available_to_borrow":true}}`,
+			wantAvailability: &Availability{Status: "borrow_available", IsLendable: true, AvailableToBorrow: true},
+		},
+		{
+			name: "no availability data",
+			doc:  `{"key":"/works/OL1W","title":"Dune"}`,
+		},
+		{
+			name: "mistyped fields ignored",
+			doc:  `{"key":"/works/OL1W","title":"Dune","ebook_access":3,"has_fulltext":"yes","availability":"open"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			book := mapDocToBook(decodeDoc(t, tt.doc))
+			if book.EbookAccess != tt.wantEbookAccess || book.HasFulltext != tt.wantHasFulltext {
+				t.Errorf("EbookAccess = %q, HasFulltext = %v; want %q, %v", book.EbookAccess, book.HasFulltext, tt.wantEbookAccess, tt.wantHasFulltext)
+			}
+			if !reflect.DeepEqual(book.Availability, tt.wantAvailability) {
+				t.Errorf("Availability = %+v, want %+v", book.Availability, tt.wantAvailability)
+			}
+		})
+	}
+}
+
+func TestBookAvailableOnline(t *testing.T) {
+	tests := []struct {
+		name string
+		book Book
+		want bool
+	}{
+		{name: "public ebook", book: Book{EbookAccess: "public"}, want: true},
+		{name: "borrowable ebook", book: Book{EbookAccess: "borrowable"}, want: true},
+		{name: "print disabled only", book: Book{EbookAccess: "printdisabled", HasFulltext: true}, want: false},
+		{name: "no ebook", book: Book{EbookAccess: "no_ebook"}, want: false},
+		{name: "full text without ebook access", book: Book{HasFulltext: true}, want: true},
+		{name: "readable availability", book: Book{Availability: &Availability{IsReadable: true}}, want: true},
+		{name: "open status", book: Book{Availability: &Availability{Status: "open"}}, want: true},
+		{name: "checked out", book: Book{Availability: &Availability{Status: "borrow_unavailable"}}, want: false},
+		{name: "no availability data", book: Book{}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.book.AvailableOnline(); got != tt.want {
+				t.Errorf("AvailableOnline = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchAvailableOnlineFilter(t *testing.T) {
+	body := `{"numFound":3,"docs":[
+		{"key":"/works/OL1W","title":"Dune","ebook_access":"public"},
+		{"key":"/works/OL2W","title":"Dune Messiah"},
+		{"key":"/works/OL3W","title":"Children of Dune","availability":{"is_lendable":true}}
+	]}`
+	tests := []struct {
+		name         string
+		target       string
+		wantStatus   int
+		wantTitles   []string
+		wantFiltered interface{}
+	}{
+		{name: "unfiltered", target: "/search?q=dune", wantStatus: http.StatusOK, wantTitles: []string{"Dune", "Dune Messiah", "Children of Dune"}},
+		{name: "available online", target: "/search?q=dune&availableOnline=true", wantStatus: http.StatusOK, wantTitles: []string{"Dune", "Children of Dune"}, wantFiltered: float64(1)},
+		{name: "explicitly off", target: "/search?q=dune&availableOnline=false", wantStatus: http.StatusOK, wantTitles: []string{"Dune", "Dune Messiah", "Children of Dune"}},
+		{name: "invalid", target: "/search?q=dune&availableOnline=yes", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			useUpstream(t, http.StatusOK, body)
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			response := decodeBody(t, rec)
+			var titles []string
+			for _, result := range response["results"].([]interface{}) {
+				titles = append(titles, result.(map[string]interface{})["title"].(string))
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Errorf("titles = %v, want %v", titles, tt.wantTitles)
+			}
+			if response["numFiltered"] != tt.wantFiltered {
+				t.Errorf("numFiltered = %v, want %v", response["numFiltered"], tt.wantFiltered)
+			}
+			if response["numFound"] != float64(3) {
+				t.Errorf("numFound = %v, want the upstream total 3", response["numFound"])
+			}
+		})
+	}
+}
+
+func TestHasFields(t *testing.T) {
+	required := []string{"title", "author_name"}
+	tests := []struct {
+		name string
+		doc  string
+		want bool
+	}{
+		{name: "complete", doc: `{"title":"Dune","author_name":["Frank Herbert"]}`, want: true},
+		{name: "missing author", doc: `{"title":"Dune"}`, want: false},
+		{name: "empty title", doc: `{"title":"","author_name":["Frank Herbert"]}`, want: false},
+		{name: "empty author list", doc: `{"title":"Dune","author_name":[]}`, want: false},
+		{name: "null title", doc: `{"title":null,"author_name":["Frank Herbert"]}`, want: false},
+		{name: "non-string values count as present", doc: `{"title":1965,"author_name":["Frank Herbert"]}`, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasFields(decodeDoc(t, tt.doc), required); got != tt.want {
+				t.Errorf("hasFields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchFilterIncomplete(t *testing.T) {
+	body := `{"numFound":4,"docs":[
+		{"key":"/works/OL1W","title":"Dune","author_name":["Frank Herbert"],"first_publish_year":1965},
+		{"key":"/works/OL2W","title":"Dune Messiah"},
+		{"key":"/works/OL3W","author_name":["Frank Herbert"],"first_publish_year":1976},
+		{"key":"/works/OL4W","title":"Children of Dune","author_name":["Frank Herbert"]}
+	]}`
+	tests := []struct {
+		name         string
+		required     []string
+		target       string
+		wantStatus   int
+		wantTitles   []string
+		wantFiltered interface{}
+	}{
+		{name: "unfiltered", target: "/search?q=dune", wantStatus: http.StatusOK, wantTitles: []string{"Dune", "Dune Messiah", "", "Children of Dune"}},
+		{name: "default required fields", target: "/search?q=dune&filterIncomplete=true", wantStatus: http.StatusOK, wantTitles: []string{"Dune", "Children of Dune"}, wantFiltered: float64(2)},
+		{name: "configured required fields", required: []string{"first_publish_year"}, target: "/search?q=dune&filterIncomplete=true", wantStatus: http.StatusOK, wantTitles: []string{"Dune", ""}, wantFiltered: float64(2)},
+		{name: "combined with availableOnline", target: "/search?q=dune&filterIncomplete=true&availableOnline=true", wantStatus: http.StatusOK, wantTitles: nil, wantFiltered: float64(4)},
+		{name: "invalid", target: "/search?q=dune&filterIncomplete=1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				if tt.required != nil {
+					cfg.RequiredResultFields = tt.required
+				}
+			})
+			useCache(t)
+			useUpstream(t, http.StatusOK, body)
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			response := decodeBody(t, rec)
+			var titles []string
+			for _, result := range response["results"].([]interface{}) {
+				title, _ := result.(map[string]interface{})["title"].(string)
+				titles = append(titles, title)
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Errorf("titles = %q, want %q", titles, tt.wantTitles)
+			}
+			if response["numFiltered"] != tt.wantFiltered {
+				t.Errorf("numFiltered = %v, want %v", response["numFiltered"], tt.wantFiltered)
+			}
+			if response["numFound"] != float64(4) {
+				t.Errorf("numFound = %v, want the upstream total 4", response["numFound"])
+			}
+		})
+	}
+}
+
+func TestMapDocToBookEditionCount(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want int
+	}{
+		{name: "reported", doc: `{"title":"Dune","edition_count":42}`, want: 42},
+		{name: "absent", doc: `{"title":"Dune"}`, want: 0},
+		{name: "null", doc: `{"title":"Dune","edition_count":null}`, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mapDocToBook(decodeDoc(t, tt.doc)).EditionCount; got != tt.want {
+				t.Errorf("EditionCount = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSortByEditions(t *testing.T) {
+	body := `{"numFound":4,"docs":[
+		{"key":"/works/OL1W","title":"Dune Messiah","edition_count":3},
+		{"key":"/works/OL2W","title":"Dune Unreported"},
+		{"key":"/works/OL3W","title":"Dune","edition_count":42},
+		{"key":"/works/OL4W","title":"Children of Dune","edition_count":3}
+	]}`
+	tests := []struct {
+		name       string
+		target     string
+		wantTitles []string
+	}{
+		{name: "relevance order", target: "/search?q=dune", wantTitles: []string{"Dune Messiah", "Dune Unreported", "Dune", "Children of Dune"}},
+		{name: "most editions first", target: "/search?q=dune&sort=editions", wantTitles: []string{"Dune", "Dune Messiah", "Children of Dune", "Dune Unreported"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			useUpstream(t, http.StatusOK, body)
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			var titles []string
+			for _, result := range decodeBody(t, rec)["results"].([]interface{}) {
+				titles = append(titles, result.(map[string]interface{})["title"].(string))
+			}
+			if !reflect.DeepEqual(titles, tt.wantTitles) {
+				t.Errorf("titles = %q, want %q", titles, tt.wantTitles)
+			}
+		})
+	}
+}
+
+func TestMapDocToBookAuthorLinks(t *testing.T) {
+	link := func(name string, key string) AuthorLink {
+		if key == "" {
+			return AuthorLink{Name: name}
+		}
+		return AuthorLink{Name: name, Key: key, URL: "https://openlibrary.org/authors/" + key}
+	}
+	tests := []struct {
+		name     string
+		doc      string
+		wantKeys []string
+		want     []AuthorLink
+	}{
+		{
+			name:     "single author",
+			doc:      `{"author_name":["Frank Herbert"],"author_key":["OL79034A"]}`,
+			wantKeys: []string{"OL79034A"},
+			want:     []AuthorLink{link("Frank Herbert", "OL79034A")},
+		},
+		{
+			name:     "multiple authors paired by position",
+			doc:      `{"author_name":["Terry Pratchett","Neil Gaiman"],"author_key":["OL25712A","OL53305A"]}`,
+			wantKeys: []string{"OL25712A", "OL53305A"},
+			want:     []AuthorLink{link("Terry Pratchett", "OL25712A"), link("Neil Gaiman", "OL53305A")},
+		},
+		{
+			name:     "more names than keys",
+			doc:      `{"author_name":["Terry Pratchett","Neil Gaiman"],"author_key":["OL25712A"]}`,
+			wantKeys: []string{"OL25712A"},
+			want:     []AuthorLink{link("Terry Pratchett", "OL25712A"), link("Neil Gaiman", "")},
+		},
+		{
+			name:     "more keys than names",
+			doc:      `{"author_name":["Terry Pratchett"],"author_key":["OL25712A","OL53305A"]}`,
+			wantKeys: []string{"OL25712A", "OL53305A"},
+			want:     []AuthorLink{link("Terry Pratchett", "OL25712A")},
+		},
+		{
+			name: "names without keys",
+			doc:  `{"author_name":["Frank Herbert"]}`,
+			want: []AuthorLink{link("Frank Herbert", "")},
+		},
+		{
+			name:     "empty key",
+			doc:      `{"author_name":["Frank Herbert"],"author_key":[""]}`,
+			wantKeys: []string{""},
+			want:     []AuthorLink{link("Frank Herbert", "")},
+		},
+		{
+			name:     "keys without names",
+			doc:      `{"author_key":["OL79034A"]}`,
+			wantKeys: []string{"OL79034A"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := mapDocToBook(decodeDoc(t, tt.doc))
+			if !reflect.DeepEqual(book.AuthorKeys, tt.wantKeys) {
+				t.Errorf("AuthorKeys = %q, want %q", book.AuthorKeys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(book.AuthorLinks, tt.want) {
+				t.Errorf("AuthorLinks = %+v, want %+v", book.AuthorLinks, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapDocToBookPublishers(t *testing.T) {
+	tests := []struct {
+		name           string
+		doc            string
+		wantPublishers []string
+		wantPlaces     []string
+	}{
+		{
+			name:           "both reported",
+			doc:            `{"title":"Dune","publisher":["Chilton Books","Ace"],"publish_place":["Philadelphia","New York"]}`,
+			wantPublishers: []string{"Chilton Books", "Ace"},
+			wantPlaces:     []string{"Philadelphia", "New York"},
+		},
+		{
+			name:           "places absent",
+			doc:            `{"title":"Dune","publisher":["Ace"]}`,
+			wantPublishers: []string{"Ace"},
+		},
+		{
+			name: "both absent",
+			doc:  `{"title":"Dune"}`,
+		},
+		{
+			name: "unexpected type",
+			doc:  `{"title":"Dune","publisher":"Ace"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := mapDocToBook(decodeDoc(t, tt.doc))
+			if !reflect.DeepEqual(book.Publishers, tt.wantPublishers) {
+				t.Errorf("Publishers = %q, want %q", book.Publishers, tt.wantPublishers)
+			}
+			if !reflect.DeepEqual(book.PublishPlaces, tt.wantPlaces) {
+				t.Errorf("PublishPlaces = %q, want %q", book.PublishPlaces, tt.wantPlaces)
+			}
+		})
+	}
+}
+
+func TestSearchFieldsProjection(t *testing.T) {
+	body := `{"numFound":2,"docs":[
+		{"key":"/works/OL1W","title":"Dune","author_name":["Frank Herbert"],"publisher":["Ace"],"publish_place":["New York"]},
+		{"key":"/works/OL2W","title":"Dune Messiah","author_name":["Frank Herbert"]}
+	]}`
+	tests := []struct {
+		name   string
+		target string
+		want   []map[string]interface{}
+	}{
+		{
+			name:   "publishers and places",
+			target: "/search?q=dune&fields=publisher,publish_place",
+			want: []map[string]interface{}{
+				{"key": "/works/OL1W", "publisher": []interface{}{"Ace"}, "publish_place": []interface{}{"New York"}},
+				{"key": "/works/OL2W"},
+			},
+		},
+		{
+			name:   "title only",
+			target: "/search?q=dune&fields=title",
+			want: []map[string]interface{}{
+				{"key": "/works/OL1W", "title": "Dune"},
+				{"key": "/works/OL2W", "title": "Dune Messiah"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			useUpstream(t, http.StatusOK, body)
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			var response struct {
+				Results []map[string]interface{} `json:"results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(response.Results, tt.want) {
+				t.Errorf("results = %v, want %v", response.Results, tt.want)
+			}
+		})
+	}
+}
+
+func TestBookKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		style string
+		path  string
+		want  string
+	}{
+		{name: "work path kept", style: app.BookKeyPath, path: "/works/OL45804W", want: "/works/OL45804W"},
+		{name: "work id", style: app.BookKeyID, path: "/works/OL45804W", want: "OL45804W"},
+		{name: "edition id", style: app.BookKeyID, path: "/books/OL1M", want: "OL1M"},
+		{name: "already an id", style: app.BookKeyID, path: "OL45804W", want: "OL45804W"},
+		{name: "missing key", style: app.BookKeyID, path: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.BookKeyStyle = tt.style })
+			if got := bookKey(tt.path); got != tt.want {
+				t.Errorf("bookKey(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchKeepsBookKeys(t *testing.T) {
+	body := `{"numFound":1,"docs":[{"key":"/works/OL45804W","title":"Fantastic Mr Fox","author_name":["Roald Dahl"]}]}`
+	tests := []struct {
+		name   string
+		style  string
+		target string
+		want   string
+	}{
+		{name: "raw", style: app.BookKeyID, target: "/search?q=fantastic+mr+fox", want: "/works/OL45804W"},
+		{name: "raw projected", style: app.BookKeyID, target: "/search?q=fantastic+mr+fox&fields=author_name", want: "/works/OL45804W"},
+		{name: "normalized path", style: app.BookKeyPath, target: "/search?q=fantastic+mr+fox&format=normalized", want: "/works/OL45804W"},
+		{name: "normalized id", style: app.BookKeyID, target: "/search?q=fantastic+mr+fox&format=normalized", want: "OL45804W"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.BookKeyStyle = tt.style })
+			useCache(t)
+			useUpstream(t, http.StatusOK, body)
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			result := decodeBody(t, rec)["results"].([]interface{})[0].(map[string]interface{})
+			if result["key"] != tt.want {
+				t.Errorf("key = %v, want %q", result["key"], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"go.uber.org/zap"
+)
+
+// stageTimingsKey is the gin context key holding the request's stage timings
+const stageTimingsKey = "stageTimings"
+
+// codeDeadlineExceeded is the error code returned to clients when a search runs out of time
+const codeDeadlineExceeded = "DEADLINE_EXCEEDED"
+
+// stageTimings records how much of a search's time budget each stage used, so a timeout
+// can tell the client where the time went. It lives in the gin context, shared by the
+// stages of one request.
+type stageTimings struct {
+	cache    time.Duration // exact key and variation lookups, excluding fuzzy matching
+	fuzzy    time.Duration
+	upstream time.Duration
+}
+
+// timingsFor returns the stage timings for the request, creating them on first use
+func timingsFor(c *gin.Context) *stageTimings {
+	if existing, ok := c.Get(stageTimingsKey); ok {
+		return existing.(*stageTimings)
+	}
+	timings := &stageTimings{}
+	c.Set(stageTimingsKey, timings)
+	return timings
+}
+
+// body reports each stage in milliseconds
+func (t *stageTimings) body() gin.H {
+	return gin.H{
+		"cacheMs":    durationMs(t.cache),
+		"fuzzyMs":    durationMs(t.fuzzy),
+		"upstreamMs": durationMs(t.upstream),
+	}
+}
+
+// upstreamTooLate reports whether so little of the budget is left that calling OpenLibrary
+// would almost certainly time out anyway
+func upstreamTooLate(deadline time.Time) bool {
+	return time.Until(deadline) < constants.UPSTREAM_MIN_REMAINING_MILLISECONDS*time.Millisecond
+}
+
+// respondDeadlineExceeded writes a 504 with the per-stage breakdown of the spent budget
+func respondDeadlineExceeded(c *gin.Context, budget time.Duration, startTime time.Time) {
+	timings := timingsFor(c)
+	elapsed := time.Since(startTime)
+	Logger.Warn("Search deadline exceeded",
+		zap.Duration("budget", budget),
+		zap.Duration("elapsed", elapsed),
+		zap.Duration("cache", timings.cache),
+		zap.Duration("fuzzy", timings.fuzzy),
+		zap.Duration("upstream", timings.upstream))
+
+	c.JSON(http.StatusGatewayTimeout, gin.H{
+		"error":     "Search did not finish within its time budget",
+		"code":      codeDeadlineExceeded,
+		"budgetMs":  durationMs(budget),
+		"elapsedMs": durationMs(elapsed),
+		"stages":    timings.body(),
+	})
+}
+
+// durationMs converts d to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// hangingUpstream never answers, failing each request once its context is done
+type hangingUpstream struct {
+	requests atomic.Int32
+}
+
+func (h *hangingUpstream) Do(req *http.Request) (*http.Response, error) {
+	h.requests.Add(1)
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestSearchDeadlineExceeded(t *testing.T) {
+	tests := []struct {
+		name          string
+		budget        time.Duration
+		wantUpstreams int32
+		minUpstreamMs float64
+	}{
+		{name: "upstream runs out the budget", budget: 300 * time.Millisecond, wantUpstreams: 1, minUpstreamMs: 200},
+		{name: "too little budget to call upstream", budget: 50 * time.Millisecond, wantUpstreams: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.UpstreamTimeout = tt.budget
+				cfg.RetryBudgetAttempts = 0
+			})
+			useCache(t)
+			upstream := &hangingUpstream{}
+			previous := HTTPClient
+			SetHTTPClient(upstream)
+			t.Cleanup(func() { SetHTTPClient(previous) })
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+			if rec.Code != http.StatusGatewayTimeout {
+				t.Fatalf("status code = %d, want 504: %s", rec.Code, rec.Body.String())
+			}
+			if got := upstream.requests.Load(); got != tt.wantUpstreams {
+				t.Errorf("%d upstream requests, want %d", got, tt.wantUpstreams)
+			}
+
+			body := decodeBody(t, rec)
+			if body["code"] != codeDeadlineExceeded {
+				t.Errorf("code = %v, want %s", body["code"], codeDeadlineExceeded)
+			}
+			if body["budgetMs"] != durationMs(tt.budget) {
+				t.Errorf("budgetMs = %v, want %v", body["budgetMs"], durationMs(tt.budget))
+			}
+			stages, ok := body["stages"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("stages = %v, want a per-stage breakdown", body["stages"])
+			}
+			for _, stage := range []string{"cacheMs", "fuzzyMs", "upstreamMs"} {
+				if _, ok := stages[stage].(float64); !ok {
+					t.Errorf("stages[%s] = %v, want milliseconds", stage, stages[stage])
+				}
+			}
+			if upstreamMs := stages["upstreamMs"].(float64); upstreamMs < tt.minUpstreamMs {
+				t.Errorf("upstreamMs = %v, want at least %v", upstreamMs, tt.minUpstreamMs)
+			}
+			if elapsed := body["elapsedMs"].(float64); elapsed < stages["cacheMs"].(float64)+stages["upstreamMs"].(float64) {
+				t.Errorf("elapsedMs = %v, less than the stages it covers: %v", elapsed, stages)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// debugCapturePrefix is where sampled captures are stored, under debug:capture:<id>
+const debugCapturePrefix = "debug:capture:"
+
+// Request headers never written to a capture
+var redactedHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"X-Api-Key":     true,
+}
+
+// DebugCapture is the full record of one sampled request
+type DebugCapture struct {
+	ID             string            `json:"id"`
+	CapturedAt     time.Time         `json:"capturedAt"`
+	Method         string            `json:"method"`
+	Path           string            `json:"path"`
+	Query          string            `json:"query"`
+	Headers        map[string]string `json:"headers"`
+	Status         int               `json:"status"`
+	ResponseBody   string            `json:"responseBody"`
+	CacheTrace     []string          `json:"cacheTrace"`
+	UpstreamURL    string            `json:"upstreamUrl,omitempty"`
+	UpstreamStatus int               `json:"upstreamStatus,omitempty"`
+	UpstreamBody   string            `json:"upstreamBody,omitempty"`
+	DurationMs     float64           `json:"durationMs"`
+
+	mu sync.Mutex
+}
+
+type debugCaptureCtxKey struct{}
+
+// captureFrom returns the capture attached to ctx, or nil when the request wasn't sampled
+func captureFrom(ctx context.Context) *DebugCapture {
+	capture, _ := ctx.Value(debugCaptureCtxKey{}).(*DebugCapture)
+	return capture
+}
+
+// addDebugFields adds the fields returned for debug=true: cacheVariations lists which of
+// the query's key variations are currently cached, to show why a reworded query did or
+// didn't hit. Only simple queries are looked up by variation, so requests changing the
+// upstream call get none.
+// upstreamUrl is the exact OpenLibrary URL the search is sent with, even when the response
+// came from the cache. It only carries the query and public parameters, so nothing needs
+// redacting.
+func addDebugFields(params SearchParams, body gin.H) {
+	body["upstreamUrl"] = params.UpstreamURL()
+
+	existing := []string{}
+	if Cache != nil && !params.changesUpstreamCall() {
+		for _, variation := range generateCacheKeyVariations(params.Query) {
+			found, err := Cache.Exists(fmt.Sprintf("%s:%s", params.Namespace(), variation))
+			if err != nil {
+				Logger.Debug("Failed to check cache variation", zap.String("variation", variation), zap.Error(err))
+				continue
+			}
+			if found {
+				existing = append(existing, variation)
+			}
+		}
+	}
+	body["cacheVariations"] = existing
+}
+
+// debugTrace records a cache event on the request's capture, if it is being captured
+func debugTrace(ctx context.Context, format string, args ...interface{}) {
+	capture := captureFrom(ctx)
+	if capture == nil {
+		return
+	}
+	capture.mu.Lock()
+	capture.CacheTrace = append(capture.CacheTrace, fmt.Sprintf(format, args...))
+	capture.mu.Unlock()
+}
+
+// captureUpstream records the upstream call on the request's capture, if it is being captured
+func captureUpstream(ctx context.Context, url string, status int, body []byte) {
+	capture := captureFrom(ctx)
+	if capture == nil {
+		return
+	}
+	capture.mu.Lock()
+	capture.UpstreamURL = url
+	capture.UpstreamStatus = status
+	capture.UpstreamBody = truncateCapture(body)
+	capture.mu.Unlock()
+}
+
+func truncateCapture(body []byte) string {
+	if len(body) > constants.DEBUG_CAPTURE_MAX_BODY_BYTES {
+		return string(body[:constants.DEBUG_CAPTURE_MAX_BODY_BYTES]) + "...(truncated)"
+	}
+	return string(body)
+}
+
+// capturingWriter keeps a copy of the response body as it is written, up to one byte past
+// what truncateCapture keeps
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data[:min(len(data), w.room())])
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s[:min(len(s), w.room())])
+	return w.ResponseWriter.WriteString(s)
+}
+
+// room is how many more body bytes are worth keeping
+func (w *capturingWriter) room() int {
+	return max(constants.DEBUG_CAPTURE_MAX_BODY_BYTES+1-w.body.Len(), 0)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *capturingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DebugSampler captures the full request, response, cache trace and upstream body for a
+// random DebugSampleRate fraction of requests. Captures are stored in Redis for
+// DEBUG_CAPTURE_TTL_MINUTES (or logged when Redis is disabled) and their id is returned in
+// the X-Debug-Capture-Id header. Unsampled requests pay only for the coin flip. Routes in
+// exempt (route patterns, e.g. "/items/:id/events") are never captured, so long-lived
+// streams aren't held in memory for as long as they stay open.
+func DebugSampler(exempt ...string) gin.HandlerFunc {
+	exempted := make(map[string]bool, len(exempt))
+	for _, route := range exempt {
+		exempted[route] = true
+	}
+	return func(c *gin.Context) {
+		rate := CurrentConfig().DebugSampleRate
+		if rate <= 0 || exempted[c.FullPath()] || Rand.Float64() >= rate {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		capture := &DebugCapture{
+			ID:         fmt.Sprintf("%x%04x", start.UnixNano(), Rand.IntN(1<<16)),
+			CapturedAt: start.UTC(),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Query:      c.Request.URL.RawQuery,
+			Headers:    map[string]string{},
+			CacheTrace: []string{},
+		}
+		for name, values := range c.Request.Header {
+			if !redactedHeaders[name] {
+				capture.Headers[name] = strings.Join(values, ", ")
+			}
+		}
+
+		c.Header("X-Debug-Capture-Id", capture.ID)
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), debugCaptureCtxKey{}, capture))
+
+		c.Next()
+
+		capture.mu.Lock()
+		capture.Status = writer.Status()
+		capture.ResponseBody = truncateCapture(writer.body.Bytes())
+		capture.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		capture.mu.Unlock()
+
+		storeCapture(capture)
+	}
+}
+
+func storeCapture(capture *DebugCapture) {
+	if Cache == nil {
+		Logger.Info("Debug capture", zap.Any("capture", capture))
+		return
+	}
+	ttl := constants.DEBUG_CAPTURE_TTL_MINUTES * time.Minute
+	if err := Cache.Set(debugCapturePrefix+capture.ID, capture, ttl); err != nil {
+		Logger.Warn("Failed to store debug capture", zap.String("id", capture.ID), zap.Error(err))
+	}
+}
+
+// DebugCaptures lists the ids of stored debug captures, newest first
+func DebugCaptures(c *gin.Context) {
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+
+	keys, err := Cache.Scan(debugCapturePrefix + "*")
+	if err != nil {
+		Logger.Warn("Failed to list debug captures", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list debug captures",
+		})
+		return
+	}
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = strings.TrimPrefix(key, debugCapturePrefix)
+	}
+	// Ids start with the hex capture time, so they sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"captures": ids,
+	})
+}
+
+// DebugCaptureByID returns one stored debug capture
+func DebugCaptureByID(c *gin.Context) {
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+
+	var capture DebugCapture
+	err := Cache.GetJSON(debugCapturePrefix+c.Param("id"), &capture)
+	if errors.Is(err, redis.Nil) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Debug capture not found or expired",
+		})
+		return
+	}
+	if err != nil {
+		Logger.Warn("Failed to read debug capture", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read debug capture",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, &capture)
+}
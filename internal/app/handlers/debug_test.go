@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// useRand installs a seeded source of randomness and restores the global one when the test ends
+func useRand(t *testing.T) {
+	t.Helper()
+	SetRand(rand.New(rand.NewPCG(1, 2)))
+	t.Cleanup(func() { SetRand(nil) })
+}
+
+// debugRouter serves search and the debug capture endpoints behind DebugSampler
+func debugRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(DebugSampler())
+	router.GET("/search", Search)
+	router.GET("/debug/captures", DebugCaptures)
+	router.GET("/debug/captures/:id", DebugCaptureByID)
+	return router
+}
+
+func TestDebugSamplerRate(t *testing.T) {
+	const requests = 1000
+	tests := []struct {
+		name    string
+		rate    float64
+		wantMin int
+		wantMax int
+	}{
+		{name: "off", rate: 0, wantMin: 0, wantMax: 0},
+		{name: "every request", rate: 1, wantMin: requests, wantMax: requests},
+		{name: "a quarter", rate: 0.25, wantMin: 200, wantMax: 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.DebugSampleRate = tt.rate })
+			useCache(t)
+			useRand(t)
+			router := gin.New()
+			router.Use(DebugSampler())
+			router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			sampled := 0
+			for i := 0; i < requests; i++ {
+				if get(router, "/ping").Header().Get("X-Debug-Capture-Id") != "" {
+					sampled++
+				}
+			}
+			if sampled < tt.wantMin || sampled > tt.wantMax {
+				t.Errorf("%d of %d requests sampled, want %d to %d", sampled, requests, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestDebugCaptureStoredAndRetrievable(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.DebugSampleRate = 1 })
+	_, server := useCache(t)
+	useRand(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+	router := debugRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=Dune", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("User-Agent", "smoke-test")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	id := rec.Header().Get("X-Debug-Capture-Id")
+	if rec.Code != http.StatusOK || id == "" {
+		t.Fatalf("status code = %d, capture id %q; want 200 with an id", rec.Code, id)
+	}
+	if ttl := server.TTL("test:" + debugCapturePrefix + id); ttl != constants.DEBUG_CAPTURE_TTL_MINUTES*time.Minute {
+		t.Errorf("capture TTL = %v, want %d minutes", ttl, constants.DEBUG_CAPTURE_TTL_MINUTES)
+	}
+
+	listing := decodeBody(t, get(router, "/debug/captures"))
+	if ids, _ := listing["captures"].([]interface{}); len(ids) != 1 || ids[0] != id {
+		t.Errorf("captures = %v, want [%s]", listing["captures"], id)
+	}
+
+	rec = get(router, "/debug/captures/"+id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+	}
+	var capture DebugCapture
+	if err := json.Unmarshal(rec.Body.Bytes(), &capture); err != nil {
+		t.Fatal(err)
+	}
+	if capture.ID != id || capture.Path != "/search" || capture.Query != "q=Dune" || capture.Status != http.StatusOK {
+		t.Errorf("capture = %s, want the search request", rec.Body.String())
+	}
+	if _, ok := capture.Headers["Authorization"]; ok {
+		t.Error("Authorization header was captured")
+	}
+	if capture.Headers["User-Agent"] != "smoke-test" {
+		t.Errorf("User-Agent = %q, want smoke-test", capture.Headers["User-Agent"])
+	}
+	if !strings.Contains(capture.ResponseBody, "Dune") || !strings.Contains(capture.UpstreamBody, "Dune") {
+		t.Errorf("response body %q and upstream body %q should both hold the result", capture.ResponseBody, capture.UpstreamBody)
+	}
+	if capture.UpstreamStatus != http.StatusOK || capture.UpstreamURL == "" {
+		t.Errorf("upstream call = %d %q, want the OpenLibrary request", capture.UpstreamStatus, capture.UpstreamURL)
+	}
+	if len(capture.CacheTrace) == 0 {
+		t.Error("cache trace is empty")
+	}
+}
+
+func TestDebugCaptureByIDNotFound(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+
+	if rec := get(debugRouter(), "/debug/captures/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("status code = %d, want 404", rec.Code)
+	}
+}
+
+// sequenceRand returns the given Float64 draws in order and 0 for IntN
+type sequenceRand struct {
+	draws []float64
+}
+
+func (s *sequenceRand) Float64() float64 {
+	draw := s.draws[0]
+	s.draws = s.draws[1:]
+	return draw
+}
+
+func (s *sequenceRand) IntN(n int) int { return 0 }
+
+func TestDebugSamplerUsesRand(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        float64
+		draws       []float64
+		wantSampled []bool
+	}{
+		{name: "draws below the rate are sampled", rate: 0.5, draws: []float64{0.1, 0.7, 0.49, 0.5}, wantSampled: []bool{true, false, true, false}},
+		{name: "every draw is below a rate of 1", rate: 1, draws: []float64{0, 0.99}, wantSampled: []bool{true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.DebugSampleRate = tt.rate })
+			useCache(t)
+			SetRand(&sequenceRand{draws: tt.draws})
+			t.Cleanup(func() { SetRand(nil) })
+			router := gin.New()
+			router.Use(DebugSampler())
+			router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, want := range tt.wantSampled {
+				if got := get(router, "/ping").Header().Get("X-Debug-Capture-Id") != ""; got != want {
+					t.Errorf("request %d sampled = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugSamplerDeterministicWithSeed(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.DebugSampleRate = 0.5 })
+	useCache(t)
+	router := gin.New()
+	router.Use(DebugSampler())
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	run := func() []bool {
+		useRand(t)
+		sampled := make([]bool, 50)
+		for i := range sampled {
+			sampled[i] = get(router, "/ping").Header().Get("X-Debug-Capture-Id") != ""
+		}
+		return sampled
+	}
+	first, second := run(), run()
+	if !slices.Equal(first, second) {
+		t.Errorf("sampling differs between runs with the same seed:\n%v\n%v", first, second)
+	}
+}
+
+func TestDebugSamplerExemptRoutes(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.DebugSampleRate = 1 })
+	c, _ := useCache(t)
+	useRand(t)
+	router := gin.New()
+	router.Use(DebugSampler("/jobs/:id/events"))
+	router.GET("/jobs/:id/events", func(c *gin.Context) { c.String(http.StatusOK, "data: {}\n\n") })
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	if id := get(router, "/jobs/1/events").Header().Get("X-Debug-Capture-Id"); id != "" {
+		t.Errorf("exempt route captured as %s", id)
+	}
+	if id := get(router, "/ping").Header().Get("X-Debug-Capture-Id"); id == "" {
+		t.Error("other route not captured")
+	}
+	if keys, _ := c.Scan(debugCapturePrefix + "*"); len(keys) != 1 {
+		t.Errorf("captures = %v, want only the other route's", keys)
+	}
+}
+
+func TestDebugSamplerKeepsWriteDeadlineControl(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.DebugSampleRate = 1 })
+	useCache(t)
+	useRand(t)
+	router := gin.New()
+	router.Use(DebugSampler())
+	router.GET("/stream", func(c *gin.Context) {
+		if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("X-Debug-Capture-Id") == "" {
+		t.Fatal("request not captured")
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Errorf("SetWriteDeadline on a captured request failed: %s", body)
+	}
+}
+
+func TestDebugCaptureBodyBounded(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.DebugSampleRate = 1 })
+	useCache(t)
+	useRand(t)
+	router := gin.New()
+	router.Use(DebugSampler())
+	body := strings.Repeat("a", constants.DEBUG_CAPTURE_MAX_BODY_BYTES)
+	router.GET("/big", func(c *gin.Context) {
+		for i := 0; i < 3; i++ {
+			c.Writer.WriteString(body)
+		}
+	})
+	router.GET("/debug/captures/:id", DebugCaptureByID)
+
+	rec := get(router, "/big")
+	if rec.Body.Len() != 3*len(body) {
+		t.Fatalf("response is %d bytes, want all %d", rec.Body.Len(), 3*len(body))
+	}
+	capture := decodeBody(t, get(router, "/debug/captures/"+rec.Header().Get("X-Debug-Capture-Id")))
+	if got := capture["responseBody"]; got != body+"...(truncated)" {
+		t.Errorf("responseBody is %d bytes, want the first %d and a truncation marker", len(fmt.Sprint(got)), len(body))
+	}
+}
+
+func TestSetRandNilRestoresGlobal(t *testing.T) {
+	useRand(t)
+	SetRand(nil)
+	if _, ok := Rand.(globalRand); !ok {
+		t.Errorf("Rand = %T after SetRand(nil), want the global source", Rand)
+	}
+}
+
+func TestSearchCacheVariationsField(t *testing.T) {
+	variations := generateCacheKeyVariations("the lord of the rings")
+	tests := []struct {
+		name    string
+		enabled bool
+		target  string
+		cached  []string // variations cached before the request
+		want    interface{}
+	}{
+		{name: "miss lists the key it just cached", enabled: true, target: "/search?q=the+lord+of+the+rings&debug=true", want: []interface{}{variations[0]}},
+		{name: "reordered variations cached", enabled: true, target: "/search?q=the+lord+of+the+rings&debug=true", cached: variations[1:3], want: []interface{}{variations[1], variations[2]}},
+		{name: "not requested", enabled: true, target: "/search?q=the+lord+of+the+rings", cached: variations[1:3]},
+		{name: "disabled", target: "/search?q=the+lord+of+the+rings&debug=true", cached: variations[1:3]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.DebugResponseFields = tt.enabled })
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("The Lord of the Rings"))
+			for _, variation := range tt.cached {
+				cacheResults(t, "search:"+variation, upstreamBody("The Lord of the Rings"))
+			}
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			got, present := decodeBody(t, rec)["cacheVariations"]
+			if tt.want == nil {
+				if present {
+					t.Errorf("cacheVariations = %v, want it absent", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cacheVariations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchUpstreamURLField(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		target  string
+		want    bool
+	}{
+		{name: "debug fields off", target: "/search?q=dune&debug=true"},
+		{name: "not asked for", enabled: true, target: "/search?q=dune"},
+		{name: "simple query", enabled: true, target: "/search?q=dune&debug=true", want: true},
+		{name: "match all", enabled: true, target: "/search?q=dune+messiah&match=all&debug=true", want: true},
+		{name: "phrase with a limit", enabled: true, target: "/search?q=%22dune+messiah%22&limit=5&debug=true", want: true},
+		{name: "characters needing encoding", enabled: true, target: "/search?q=caf%C3%A9+%26+co&debug=true", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.DebugResponseFields = tt.enabled })
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			got, present := decodeBody(t, rec)["upstreamUrl"]
+			if !tt.want {
+				if present {
+					t.Errorf("upstreamUrl = %v, want it absent", got)
+				}
+				return
+			}
+			if len(upstream.urls) != 1 || got != upstream.urls[0] {
+				t.Errorf("upstreamUrl = %v, want the URL sent to OpenLibrary: %v", got, upstream.urls)
+			}
+		})
+	}
+}
+
+func TestSearchUpstreamURLFieldOnCacheHit(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.DebugResponseFields = true })
+	useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+	if rec := serve(Search, http.MethodGet, "/search", "/search?q=Dune", ""); rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	body := decodeBody(t, serve(Search, http.MethodGet, "/search", "/search?q=Dune&debug=true", ""))
+	if body["cached"] != true {
+		t.Fatalf("cached = %v, want the second search served from the cache", body["cached"])
+	}
+	if upstream.calls() != 1 || body["upstreamUrl"] != upstream.urls[0] {
+		t.Errorf("upstreamUrl = %v, want the URL the cached result was fetched with: %v", body["upstreamUrl"], upstream.urls)
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// BookChange is a work present in both the cached and fresh results whose fields differ
+type BookChange struct {
+	Key    string `json:"key"`
+	Cached Book   `json:"cached"`
+	Fresh  Book   `json:"fresh"`
+}
+
+// BookDiff is the difference between a cached result set and a fresh one
+type BookDiff struct {
+	Added     []Book       `json:"added"`
+	Removed   []Book       `json:"removed"`
+	Changed   []BookChange `json:"changed"`
+	Unchanged int          `json:"unchanged"`
+}
+
+// diffBooks compares two result sets by work key. Books without a key fall back to their title.
+func diffBooks(cached []Book, fresh []Book) BookDiff {
+	diff := BookDiff{
+		Added:   []Book{},
+		Removed: []Book{},
+		Changed: []BookChange{},
+	}
+
+	cachedByKey := make(map[string]Book, len(cached))
+	for _, book := range cached {
+		cachedByKey[bookIdentity(book)] = book
+	}
+
+	seen := make(map[string]bool, len(fresh))
+	for _, book := range fresh {
+		id := bookIdentity(book)
+		seen[id] = true
+
+		old, ok := cachedByKey[id]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, book)
+		case !reflect.DeepEqual(old, book):
+			diff.Changed = append(diff.Changed, BookChange{Key: id, Cached: old, Fresh: book})
+		default:
+			diff.Unchanged++
+		}
+	}
+
+	for _, book := range cached {
+		if !seen[bookIdentity(book)] {
+			diff.Removed = append(diff.Removed, book)
+		}
+	}
+
+	return diff
+}
+
+func bookIdentity(book Book) string {
+	if book.Key != "" {
+		return book.Key
+	}
+	return book.Title
+}
+
+// CacheDiff fetches fresh results for a query and diffs them against the cached copy,
+// to monitor upstream catalog drift and cache staleness
+func CacheDiff(c *gin.Context) {
+	params, err := parseSearchParams(c)
+	if err != nil {
+		var paramErr *paramError
+		if errors.As(err, &paramErr) {
+			c.JSON(http.StatusBadRequest, paramErr.body())
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+
+	query := params.Query
+	match := params.Match
+	normalizedQuery := params.NormalizedQuery
+	// Compare the entry Search serves for these parameters, however they are keyed
+	cacheKey := params.StoreKey()
+
+	var cachedResponse OpenLibraryResponse
+	if err := Cache.GetJSON(cacheKey, &cachedResponse); err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "No cached results for query",
+				"query": query,
+			})
+			return
+		}
+		Logger.Warn("Cache error", zap.String("key", cacheKey), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read cached results",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), upstreamTimeout(query, match, false))
+	defer cancel()
+
+	result, err := fetchOpenLibrary(ctx, params.UpstreamURL())
+	if err != nil {
+		respondUpstreamError(c, err, http.StatusBadGateway)
+		return
+	}
+
+	diff := diffBooks(mapDocsToBooks(cachedResponse.Docs), mapDocsToBooks(result.Response.Docs))
+
+	Logger.Info("Cache diff computed",
+		zap.String("query", normalizedQuery),
+		zap.Int("added", len(diff.Added)),
+		zap.Int("removed", len(diff.Removed)),
+		zap.Int("changed", len(diff.Changed)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":          query,
+		"cacheKey":       strings.TrimPrefix(cacheKey, params.Namespace()+":"),
+		"cachedNumFound": cachedResponse.NumFound,
+		"freshNumFound":  result.Response.NumFound,
+		"diff":           diff,
+	})
+}
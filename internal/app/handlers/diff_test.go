@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestDiffBooks(t *testing.T) {
+	dune := Book{Key: "/works/OL1W", Title: "Dune"}
+	emma := Book{Key: "/works/OL2W", Title: "Emma"}
+	ulysses := Book{Key: "/works/OL3W", Title: "Ulysses"}
+	duneRevised := Book{Key: "/works/OL1W", Title: "Dune", FirstPublishYear: 1965}
+
+	tests := []struct {
+		name          string
+		cached        []Book
+		fresh         []Book
+		wantAdded     []string
+		wantRemoved   []string
+		wantChanged   []string
+		wantUnchanged int
+	}{
+		{
+			name:          "identical",
+			cached:        []Book{dune, emma},
+			fresh:         []Book{dune, emma},
+			wantUnchanged: 2,
+		},
+		{
+			name:          "added and removed",
+			cached:        []Book{dune, emma},
+			fresh:         []Book{dune, ulysses},
+			wantAdded:     []string{"/works/OL3W"},
+			wantRemoved:   []string{"/works/OL2W"},
+			wantUnchanged: 1,
+		},
+		{
+			name:          "changed fields",
+			cached:        []Book{dune, emma},
+			fresh:         []Book{duneRevised, emma},
+			wantChanged:   []string{"/works/OL1W"},
+			wantUnchanged: 1,
+		},
+		{
+			name:          "order doesn't matter",
+			cached:        []Book{dune, emma},
+			fresh:         []Book{emma, dune},
+			wantUnchanged: 2,
+		},
+		{
+			name:          "books without a key matched by title",
+			cached:        []Book{{Title: "Untitled draft"}},
+			fresh:         []Book{{Title: "Untitled draft"}},
+			wantUnchanged: 1,
+		},
+		{
+			name:        "empty cache",
+			cached:      nil,
+			fresh:       []Book{dune},
+			wantAdded:   []string{"/works/OL1W"},
+			wantRemoved: nil,
+		},
+	}
+
+	identities := func(books []Book) []string {
+		ids := []string{}
+		for _, book := range books {
+			ids = append(ids, bookIdentity(book))
+		}
+		return ids
+	}
+	equal := func(got []string, want []string) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffBooks(tt.cached, tt.fresh)
+			if got := identities(diff.Added); !equal(got, tt.wantAdded) {
+				t.Errorf("added = %v, want %v", got, tt.wantAdded)
+			}
+			if got := identities(diff.Removed); !equal(got, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", got, tt.wantRemoved)
+			}
+			changed := []string{}
+			for _, change := range diff.Changed {
+				changed = append(changed, change.Key)
+			}
+			if !equal(changed, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if diff.Unchanged != tt.wantUnchanged {
+				t.Errorf("unchanged = %d, want %d", diff.Unchanged, tt.wantUnchanged)
+			}
+		})
+	}
+}
+
+func TestCacheDiff(t *testing.T) {
+	tests := []struct {
+		name         string
+		strategy     string
+		target       string
+		cachedKey    string // key the cached results are stored under, if any
+		withCache    bool
+		wantStatus   int
+		wantCacheKey string
+		wantLimit    int // limit the fresh results are fetched with, when checked
+	}{
+		{name: "missing query", target: "/diff", withCache: true, wantStatus: http.StatusBadRequest},
+		{name: "cache disabled", target: "/diff?q=dune", wantStatus: http.StatusServiceUnavailable},
+		{name: "invalid match mode", target: "/diff?q=dune&match=some", withCache: true, wantStatus: http.StatusBadRequest},
+		{name: "nothing cached", target: "/diff?q=dune", withCache: true, wantStatus: http.StatusNotFound},
+		{name: "diffed against upstream", target: "/diff?q=dune", withCache: true, cachedKey: "search:dune", wantStatus: http.StatusOK, wantCacheKey: "dune"},
+		{name: "raw strategy reads the raw key", strategy: app.CacheKeyRaw, target: "/diff?q=Dune", withCache: true, cachedKey: "search:raw:Dune", wantStatus: http.StatusOK, wantCacheKey: "raw:Dune"},
+		{name: "raw strategy ignores the normalized key", strategy: app.CacheKeyRaw, target: "/diff?q=Dune", withCache: true, cachedKey: "search:dune", wantStatus: http.StatusNotFound},
+		{name: "both strategy reads the normalized key", strategy: app.CacheKeyBoth, target: "/diff?q=Dune", withCache: true, cachedKey: "search:dune", wantStatus: http.StatusOK, wantCacheKey: "dune"},
+		{name: "limit reads its own key", target: "/diff?q=dune&limit=5", withCache: true, cachedKey: "search:limit=5:dune", wantStatus: http.StatusOK, wantCacheKey: "limit=5:dune", wantLimit: 5},
+		{name: "limit ignores the default limit's key", target: "/diff?q=dune&limit=5", withCache: true, cachedKey: "search:dune", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				if tt.strategy != "" {
+					cfg.CacheKeyStrategy = tt.strategy
+				}
+			})
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune", "Dune Messiah"))
+			if tt.withCache {
+				useCache(t)
+				if tt.cachedKey != "" {
+					cacheResults(t, tt.cachedKey, upstreamBody("Dune"))
+				}
+			}
+
+			rec := serve(CacheDiff, http.MethodGet, "/diff", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, rec)
+			if body["cacheKey"] != tt.wantCacheKey {
+				t.Errorf("cacheKey = %v, want %q", body["cacheKey"], tt.wantCacheKey)
+			}
+			if want := fmt.Sprintf("&limit=%d", tt.wantLimit); tt.wantLimit > 0 && !strings.HasSuffix(upstream.urls[0], want) {
+				t.Errorf("upstream URL = %s, want it to end in %s", upstream.urls[0], want)
+			}
+			diff := body["diff"].(map[string]interface{})
+			if added := diff["added"].([]interface{}); len(added) != 1 {
+				t.Errorf("added = %v, want Dune Messiah only", added)
+			}
+			if diff["unchanged"] != float64(1) {
+				t.Errorf("unchanged = %v, want 1", diff["unchanged"])
+			}
+		})
+	}
+}
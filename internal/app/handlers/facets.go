@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"sort"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+)
+
+// facetFields are the values accepted by the facets parameter, each reading the values a
+// book contributes to that facet
+var facetFields = map[string]func(b Book) []string{
+	"language": func(b Book) []string { return b.Languages },
+	"subject":  func(b Book) []string { return b.Subjects },
+}
+
+// facetNames lists the facets values, sorted
+func facetNames() []string {
+	names := make([]string, 0, len(facetFields))
+	for name := range facetFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FacetValue is how many of the returned books have one facet value
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// computeFacets counts the values of each requested facet across docs, most common first
+// (ties alphabetically), keeping at most MAX_FACET_VALUES per facet. A book listing a value
+// twice counts once.
+func computeFacets(names []string, docs []map[string]interface{}) map[string][]FacetValue {
+	books := mapDocsToBooks(docs)
+	facets := make(map[string][]FacetValue, len(names))
+	for _, name := range names {
+		values := facetFields[name]
+		counts := map[string]int{}
+		for _, book := range books {
+			seen := map[string]bool{}
+			for _, value := range values(book) {
+				if value != "" && !seen[value] {
+					seen[value] = true
+					counts[value]++
+				}
+			}
+		}
+
+		facet := make([]FacetValue, 0, len(counts))
+		for value, count := range counts {
+			facet = append(facet, FacetValue{Value: value, Count: count})
+		}
+		sort.Slice(facet, func(i, j int) bool {
+			if facet[i].Count != facet[j].Count {
+				return facet[i].Count > facet[j].Count
+			}
+			return facet[i].Value < facet[j].Value
+		})
+		if len(facet) > constants.MAX_FACET_VALUES {
+			facet = facet[:constants.MAX_FACET_VALUES]
+		}
+		facets[name] = facet
+	}
+	return facets
+}
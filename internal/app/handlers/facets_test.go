@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+)
+
+// facetDoc is an OpenLibrary doc with the given languages and subjects
+func facetDoc(title string, languages []string, subjects []string) map[string]interface{} {
+	doc := map[string]interface{}{"key": "/works/" + title, "title": title}
+	if languages != nil {
+		doc["language"] = toInterfaces(languages)
+	}
+	if subjects != nil {
+		doc["subject"] = toInterfaces(subjects)
+	}
+	return doc
+}
+
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+func TestComputeFacets(t *testing.T) {
+	many := make([]map[string]interface{}, constants.MAX_FACET_VALUES+5)
+	for i := range many {
+		many[i] = facetDoc(fmt.Sprint(i), nil, []string{fmt.Sprintf("subject %02d", i)})
+	}
+	tests := []struct {
+		name  string
+		names []string
+		docs  []map[string]interface{}
+		want  map[string][]FacetValue
+	}{
+		{
+			name:  "counts across languages and subjects",
+			names: []string{"language", "subject"},
+			docs: []map[string]interface{}{
+				facetDoc("a", []string{"eng", "fre"}, []string{"Fiction", "Space"}),
+				facetDoc("b", []string{"eng"}, []string{"Fiction"}),
+				facetDoc("c", []string{"ger", "eng"}, []string{"History"}),
+			},
+			want: map[string][]FacetValue{
+				"language": {{"eng", 3}, {"fre", 1}, {"ger", 1}},
+				"subject":  {{"Fiction", 2}, {"History", 1}, {"Space", 1}},
+			},
+		},
+		{
+			name:  "only the requested facets",
+			names: []string{"language"},
+			docs:  []map[string]interface{}{facetDoc("a", []string{"eng"}, []string{"Fiction"})},
+			want:  map[string][]FacetValue{"language": {{"eng", 1}}},
+		},
+		{
+			name:  "a value listed twice by one book counts once",
+			names: []string{"subject"},
+			docs:  []map[string]interface{}{facetDoc("a", nil, []string{"Fiction", "Fiction"}), facetDoc("b", nil, []string{"Fiction"})},
+			want:  map[string][]FacetValue{"subject": {{"Fiction", 2}}},
+		},
+		{
+			name:  "books without the field",
+			names: []string{"language", "subject"},
+			docs:  []map[string]interface{}{facetDoc("a", nil, nil), facetDoc("b", []string{"eng"}, nil)},
+			want:  map[string][]FacetValue{"language": {{"eng", 1}}, "subject": {}},
+		},
+		{
+			name:  "no results",
+			names: []string{"language"},
+			want:  map[string][]FacetValue{"language": {}},
+		},
+		{
+			name:  "capped",
+			names: []string{"subject"},
+			docs:  many,
+			want: func() map[string][]FacetValue {
+				values := make([]FacetValue, constants.MAX_FACET_VALUES)
+				for i := range values {
+					values[i] = FacetValue{Value: fmt.Sprintf("subject %02d", i), Count: 1}
+				}
+				return map[string][]FacetValue{"subject": values}
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := computeFacets(tt.names, tt.docs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeFacets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchFacets(t *testing.T) {
+	docs := []map[string]interface{}{
+		facetDoc("Dune", []string{"eng"}, []string{"Science fiction", "Ecology"}),
+		facetDoc("Dune Messiah", []string{"eng", "spa"}, []string{"Science fiction"}),
+		facetDoc("Der W\u00fcstenplanet", []string{"ger"}, []string{"Science fiction"}),
+	}
+	encoded, err := json.Marshal(map[string]interface{}{"numFound": len(docs), "docs": docs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(encoded)
+	tests := []struct {
+		name   string
+		target string
+		cached bool // served from the cache rather than upstream
+		want   map[string]interface{}
+	}{
+		{name: "not requested", target: "/search?q=Dune"},
+		{
+			name:   "from upstream",
+			target: "/search?q=Dune&facets=language,subject",
+			want: map[string]interface{}{
+				"language": []interface{}{facetJSON("eng", 2), facetJSON("ger", 1), facetJSON("spa", 1)},
+				"subject":  []interface{}{facetJSON("Science fiction", 3), facetJSON("Ecology", 1)},
+			},
+		},
+		{
+			name:   "from the cache",
+			target: "/search?q=Dune&facets=language",
+			cached: true,
+			want: map[string]interface{}{
+				"language": []interface{}{facetJSON("eng", 2), facetJSON("ger", 1), facetJSON("spa", 1)},
+			},
+		},
+		{
+			name:   "normalized format",
+			target: "/search?q=Dune&facets=subject&format=normalized",
+			want: map[string]interface{}{
+				"subject": []interface{}{facetJSON("Science fiction", 3), facetJSON("Ecology", 1)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			if tt.cached {
+				useCache(t)
+				cacheResults(t, "search:dune", body)
+			}
+			upstream := useUpstream(t, http.StatusOK, body)
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			if tt.cached && upstream.calls() != 0 {
+				t.Fatalf("%d upstream calls, want the cached result", upstream.calls())
+			}
+			got, ok := decodeBody(t, rec)["facets"]
+			if tt.want == nil {
+				if ok {
+					t.Errorf("facets = %v, want none", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("facets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchInvalidFacets(t *testing.T) {
+	useConfig(t, nil)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=Dune&facets=author", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if upstream.calls() != 0 {
+		t.Errorf("%d upstream calls for an invalid request, want none", upstream.calls())
+	}
+}
+
+// facetJSON is a FacetValue as it decodes from a response body
+func facetJSON(value string, count int) map[string]interface{} {
+	return map[string]interface{}{"value": value, "count": float64(count)}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	"go.uber.org/zap"
+)
+
+// refillMatchModes are the namespaces a flush refills. Phrase results aren't refilled; they
+// are fetched again on their next request.
+var refillMatchModes = []string{matchDefault, matchAll, matchAny}
+
+// FlushCache empties the cache in two phases so it never goes cold. A new cache generation
+// takes all writes while reads that miss it still fall back to the old one; the most recent
+// queries are refetched into the new generation in the background, and only then is the old
+// generation deleted. Only cached results are flushed; recency indexes, stats and analytics
+// outlive it. Requires confirm=true.
+func FlushCache(c *gin.Context) {
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+	if cfg := CurrentConfig(); cfg.Environment == app.EnvironmentProduction && !cfg.AllowProductionFlush {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Cache flush is disabled in production; set ALLOW_PRODUCTION_FLUSH=true to allow it",
+		})
+		return
+	}
+	if c.Query("confirm") != "true" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Add confirm=true to flush the cache",
+		})
+		return
+	}
+
+	// The queries to refill are read before switching generations, while they are all cached
+	queries := map[string][]string{}
+	refilling := 0
+	for _, match := range refillMatchModes {
+		recent, err := Cache.RecentFromIndex(recentIndexKey(cacheNamespace(match)), constants.FLUSH_REFILL_MAX_QUERIES)
+		if err != nil {
+			Logger.Warn("Failed to read recent queries to refill", zap.String("match", match), zap.Error(err))
+			continue
+		}
+		queries[match] = recent
+		refilling += len(recent)
+	}
+
+	generation, err := Cache.BeginGeneration()
+	if errors.Is(err, cache.ErrFlushInProgress) {
+		current, _ := Cache.Generation()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "A flush is already in progress",
+			"generation": current,
+		})
+		return
+	}
+	if err != nil {
+		Logger.Warn("Failed to start cache flush", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start cache flush",
+		})
+		return
+	}
+
+	Logger.Info("Cache flush started", zap.Int64("generation", generation), zap.Int("refilling", refilling))
+	goBackground(func(ctx context.Context) {
+		finishFlush(ctx, queries)
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"generation": generation,
+		"refilling":  refilling,
+	})
+}
+
+// finishFlush refills the new generation and then deletes the old one. Refilling stops at the
+// flush timeout or on shutdown; the old generation is deleted anyway, and the queries not
+// refilled are fetched again on their next request. If deleting fails, SyncGeneration
+// deletes it once the flush timeout has passed.
+func finishFlush(ctx context.Context, queries map[string][]string) {
+	ctx, cancel := context.WithTimeout(ctx, Cache.FlushTimeout())
+	defer cancel()
+
+	refilled := 0
+	for match, recent := range queries {
+		refilled += refillQueries(ctx, recent, match)
+	}
+	if ctx.Err() != nil {
+		Logger.Warn("Cache flush refill interrupted", zap.Int("refilled", refilled), zap.Error(ctx.Err()))
+	}
+
+	deleted, err := Cache.EndGeneration()
+	if err != nil {
+		Logger.Warn("Failed to delete old cache generation", zap.Int64("deleted", deleted), zap.Error(err))
+		return
+	}
+	Logger.Info("Cache flush finished", zap.Int("refilled", refilled), zap.Int64("deleted", deleted))
+}
+
+// refillQueries fetches each normalized query into the current generation with up to
+// WARM_CONCURRENCY workers, returning how many were stored
+func refillQueries(ctx context.Context, queries []string, match string) int {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		refilled int
+	)
+	slots := make(chan struct{}, constants.WARM_CONCURRENCY)
+	for _, query := range queries {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(query string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if refillQuery(ctx, query, match) {
+				mu.Lock()
+				refilled++
+				mu.Unlock()
+			}
+		}(query)
+	}
+	wg.Wait()
+	return refilled
+}
+
+// refillQuery fetches one query and stores it in the current generation. Unlike fillCache it
+// doesn't check the cache first, since reads still find the old generation's copy.
+func refillQuery(ctx context.Context, query string, match string) bool {
+	params := SearchParams{Query: query, NormalizedQuery: query, Match: match}
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout(query, match, false))
+	defer cancel()
+
+	result, err := fetchOpenLibrary(ctx, params.UpstreamURL())
+	if err != nil {
+		Logger.Warn("Failed to refill query", zap.String("query", query), zap.Error(err))
+		return false
+	}
+	if !cacheableResponse(params.UpstreamURL(), result.Response) {
+		return false
+	}
+	cacheKey := fmt.Sprintf("%s:%s", params.Namespace(), params.NormalizedQuery)
+	if err := setResults(cacheKey, query, result.Response); err != nil {
+		warnCacheWrite("Failed to store refilled query", err, zap.String("query", query))
+		return false
+	}
+	recordRecentQuery(params.Namespace(), params.NormalizedQuery)
+	return true
+}
+
+// StartGenerationSync reloads the cache generation now and then every interval, so
+// flushes started by another instance are picked up. Call the returned function to stop it.
+func StartGenerationSync(interval time.Duration) func() {
+	load := func() {
+		if err := Cache.SyncGeneration(); err != nil {
+			Logger.Warn("Failed to sync cache generation", zap.Error(err))
+		}
+	}
+	load()
+
+	return runEvery(interval, load)
+}
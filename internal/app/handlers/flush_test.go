@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestFlushCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		noCache    bool
+		production bool
+		inProgress bool
+		target     string
+		wantStatus int
+	}{
+		{name: "no cache", noCache: true, target: "/flush?confirm=true", wantStatus: http.StatusServiceUnavailable},
+		{name: "production", production: true, target: "/flush?confirm=true", wantStatus: http.StatusForbidden},
+		{name: "not confirmed", target: "/flush", wantStatus: http.StatusBadRequest},
+		{name: "already in progress", inProgress: true, target: "/flush?confirm=true", wantStatus: http.StatusConflict},
+		{name: "started", target: "/flush?confirm=true", wantStatus: http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				if tt.production {
+					cfg.Environment = app.EnvironmentProduction
+				}
+			})
+			useRootContext(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			if !tt.noCache {
+				c, _ := useCache(t)
+				if tt.inProgress {
+					if _, err := c.BeginGeneration(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			rec := serve(FlushCache, http.MethodPost, "/flush", tt.target, "")
+			WaitBackground(time.Second)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code == http.StatusAccepted {
+				if body := decodeBody(t, rec); body["generation"] != float64(1) {
+					t.Errorf("generation = %v, want 1", body["generation"])
+				}
+			}
+		})
+	}
+}
+
+func TestFlushCacheServesWarmResultsThroughout(t *testing.T) {
+	useConfig(t, nil)
+	useRootContext(t)
+	c, _ := useCache(t)
+	upstream := useSlowUpstream(t, 100*time.Millisecond, upstreamBody("Dune"))
+	for _, query := range []string{"dune", "emma"} {
+		cacheResults(t, "search:"+query, upstreamBody(query))
+	}
+	indexQueries(t, "search", "dune", "emma")
+	if err := Cache.Set("stats:hits", "7", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(FlushCache, http.MethodPost, "/flush", "/flush?confirm=true", "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+	}
+	if body := decodeBody(t, rec); body["refilling"] != float64(2) {
+		t.Errorf("refilling = %v, want both indexed queries", body["refilling"])
+	}
+
+	search := func(phase string) {
+		t.Helper()
+		for _, query := range []string{"dune", "emma"} {
+			rec := serve(Search, http.MethodGet, "/search", "/search?q="+query, "")
+			if rec.Code != http.StatusOK || decodeBody(t, rec)["cached"] != true {
+				t.Errorf("%s: %s served %d uncached, want a warm hit: %s", phase, query, rec.Code, rec.Body.String())
+			}
+		}
+	}
+
+	// The refill is still waiting on the slow upstream, so these read the old generation
+	search("during the flush")
+	if _, previous := c.Generation(); previous == -1 {
+		t.Fatal("flush finished before the reads during it")
+	}
+
+	if !WaitBackground(2 * time.Second) {
+		t.Fatal("flush never finished")
+	}
+	if _, previous := c.Generation(); previous != -1 {
+		t.Errorf("previous generation %d still readable after the flush", previous)
+	}
+	search("after the switch")
+
+	if got := upstream.inFlight.Load(); got != 0 {
+		t.Errorf("%d upstream calls still in flight", got)
+	}
+	upstream.mu.Lock()
+	refetched := len(upstream.requests)
+	upstream.mu.Unlock()
+	if refetched != 2 {
+		t.Errorf("%d queries refetched, want both indexed queries", refetched)
+	}
+	if got := upstream.duplicates(); got != 0 {
+		t.Errorf("%d duplicate upstream calls, want only the refill of each query", got)
+	}
+	if value, err := Cache.Get("stats:hits"); err != nil || value != "7" {
+		t.Errorf("stats:hits = %q, %v after the flush; want it kept", value, err)
+	}
+}
+
+func TestFlushCacheEnvironmentGuard(t *testing.T) {
+	tests := []struct {
+		environment string
+		allow       bool
+		wantStatus  int
+	}{
+		{environment: "development", wantStatus: http.StatusAccepted},
+		{environment: "staging", wantStatus: http.StatusAccepted},
+		{environment: app.EnvironmentProduction, wantStatus: http.StatusForbidden},
+		{environment: app.EnvironmentProduction, allow: true, wantStatus: http.StatusAccepted},
+		{environment: "development", allow: true, wantStatus: http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s allow=%v", tt.environment, tt.allow), func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.Environment = tt.environment
+				cfg.AllowProductionFlush = tt.allow
+			})
+			useRootContext(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			c, _ := useCache(t)
+			cacheResults(t, "search:dune", upstreamBody("Dune"))
+
+			rec := serve(FlushCache, http.MethodPost, "/flush", "/flush?confirm=true", "")
+			WaitBackground(time.Second)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code == http.StatusForbidden {
+				if current, _ := c.Generation(); current != 0 {
+					t.Errorf("generation = %d after a refused flush, want 0", current)
+				}
+				if found, _ := c.Exists("search:dune"); !found {
+					t.Error("cached result removed by a refused flush")
+				}
+			}
+		})
+	}
+}
+
+func TestFlushCacheInterrupted(t *testing.T) {
+	useConfig(t, nil)
+	cancel := useRootContext(t)
+	c, _ := useCache(t)
+	useSlowUpstream(t, time.Second, upstreamBody("Dune"))
+	cacheResults(t, "search:dune", upstreamBody("Dune"))
+	indexQueries(t, "search", "dune")
+
+	if rec := serve(FlushCache, http.MethodPost, "/flush", "/flush?confirm=true", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+	}
+	// Shut down while the refill is waiting on the slow upstream
+	cancel()
+	if !WaitBackground(2 * time.Second) {
+		t.Fatal("interrupted flush never stopped")
+	}
+	if _, previous := c.Generation(); previous != -1 {
+		t.Errorf("previous generation %d left behind by the interrupted flush", previous)
+	}
+
+	useRootContext(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+	rec := serve(FlushCache, http.MethodPost, "/flush", "/flush?confirm=true", "")
+	WaitBackground(time.Second)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("next flush status code = %d, want 202: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFlushCacheAfterAbandonedFlush(t *testing.T) {
+	useConfig(t, nil)
+	useRootContext(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+	c, server := useCache(t)
+	cacheResults(t, "search:dune", upstreamBody("Dune"))
+
+	// A flush whose instance died before deleting the old generation
+	if _, err := c.BeginGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(FlushCache, http.MethodPost, "/flush", "/flush?confirm=true", "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status code = %d during the flush, want 409", rec.Code)
+	}
+
+	c.SetFlushTimeout(time.Minute)
+	server.HSet("test@generation", "started", fmt.Sprint(time.Now().Add(-2*time.Minute).Unix()))
+	rec = serve(FlushCache, http.MethodPost, "/flush", "/flush?confirm=true", "")
+	WaitBackground(time.Second)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status code = %d after the flush timeout, want 202: %s", rec.Code, rec.Body.String())
+	}
+}
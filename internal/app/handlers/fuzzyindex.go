@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"go.uber.org/zap"
+)
+
+// queryIndexSnapshot is an in-process copy of the recent-query index for each namespace.
+// Fuzzy matching reads from it instead of Redis, accepting staleness of up to one refresh interval.
+type queryIndexSnapshot struct {
+	mu        sync.RWMutex
+	queries   map[string][]string
+	sizes     map[string]int64 // full index size, beyond the fuzzy window
+	refreshed time.Time
+}
+
+var fuzzySnapshot = &queryIndexSnapshot{}
+
+// get returns the snapshot for a namespace, or false if no snapshot has been taken yet
+func (s *queryIndexSnapshot) get(namespace string) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.queries == nil {
+		return nil, false
+	}
+	return s.queries[namespace], true
+}
+
+// refresh reloads every namespace from Redis and swaps the snapshot in one step.
+// On error the previous snapshot is kept.
+func (s *queryIndexSnapshot) refresh() error {
+	queries := make(map[string][]string)
+	sizes := make(map[string]int64)
+	for _, namespace := range searchNamespaces() {
+		recent, err := Cache.RecentFromIndex(recentIndexKey(namespace), constants.FUZZY_RECENT_WINDOW)
+		if err != nil {
+			return err
+		}
+		queries[namespace] = recent
+		size, err := Cache.IndexSize(recentIndexKey(namespace))
+		if err != nil {
+			return err
+		}
+		sizes[namespace] = size
+	}
+
+	s.mu.Lock()
+	s.queries = queries
+	s.sizes = sizes
+	s.refreshed = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// size returns the snapshotted index size for a namespace, or false if no snapshot has been taken yet
+func (s *queryIndexSnapshot) size(namespace string) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.sizes == nil {
+		return 0, false
+	}
+	return s.sizes[namespace], true
+}
+
+// knownQueryCount is how many queries are cached in a namespace, from the snapshot when available
+func knownQueryCount(namespace string) (int64, error) {
+	if size, ok := fuzzySnapshot.size(namespace); ok {
+		return size, nil
+	}
+	return Cache.IndexSize(recentIndexKey(namespace))
+}
+
+// fuzzySuspended holds, per namespace, an *atomic.Bool set while fuzzy matching there is
+// off because its keyspace is too large
+var fuzzySuspended sync.Map
+
+// fuzzySuspendedFlag returns the suspension flag of a namespace
+func fuzzySuspendedFlag(namespace string) *atomic.Bool {
+	flag, _ := fuzzySuspended.LoadOrStore(namespace, new(atomic.Bool))
+	return flag.(*atomic.Bool)
+}
+
+// fuzzyAllowed reports whether fuzzy matching should run for a namespace. Once the number of
+// cached queries reaches FuzzyDisableThreshold it is suspended to protect latency, and it
+// resumes when the keyspace shrinks back below it. Namespaces are suspended independently;
+// transitions are logged once per namespace.
+func fuzzyAllowed(namespace string) bool {
+	threshold := CurrentConfig().FuzzyDisableThreshold
+	if threshold <= 0 {
+		return true
+	}
+	count, err := knownQueryCount(namespace)
+	if err != nil {
+		Logger.Warn("Failed to count cached queries for fuzzy matching", zap.Error(err))
+		return true
+	}
+
+	suspended := fuzzySuspendedFlag(namespace)
+	if count >= int64(threshold) {
+		if !suspended.Swap(true) {
+			Logger.Warn("Keyspace too large, suspending fuzzy matching",
+				zap.String("namespace", namespace),
+				zap.Int64("cached_queries", count),
+				zap.Int("threshold", threshold))
+		}
+		return false
+	}
+	if suspended.Swap(false) {
+		Logger.Info("Keyspace shrank, resuming fuzzy matching",
+			zap.String("namespace", namespace),
+			zap.Int64("cached_queries", count))
+	}
+	return true
+}
+
+// recentQueriesFor returns the fuzzy candidates for a namespace, preferring the snapshot
+// and reading Redis directly when the refresher isn't running
+func recentQueriesFor(namespace string) ([]string, error) {
+	if queries, ok := fuzzySnapshot.get(namespace); ok {
+		return queries, nil
+	}
+	return Cache.RecentFromIndex(recentIndexKey(namespace), constants.FUZZY_RECENT_WINDOW)
+}
+
+// StartFuzzyIndexRefresher takes a snapshot of the recent-query index now and then every
+// interval in the background. Call the returned function to stop it.
+func StartFuzzyIndexRefresher(interval time.Duration) func() {
+	refresh := func() {
+		if err := fuzzySnapshot.refresh(); err != nil {
+			Logger.Warn("Failed to refresh fuzzy index snapshot", zap.Error(err))
+		}
+	}
+	refresh()
+
+	return runEvery(interval, refresh)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestFuzzySnapshot(t *testing.T) {
+	tests := []struct {
+		name        string
+		refresh     bool     // take a snapshot after indexing before
+		before      []string // indexed before the snapshot
+		after       []string // indexed after it
+		wantQueries []string
+	}{
+		{
+			name:        "no snapshot reads Redis",
+			before:      []string{"dune", "emma"},
+			after:       []string{"ulysses"},
+			wantQueries: []string{"ulysses", "emma", "dune"},
+		},
+		{
+			name:        "snapshot serves what was indexed when it was taken",
+			refresh:     true,
+			before:      []string{"dune", "emma"},
+			after:       []string{"ulysses"},
+			wantQueries: []string{"emma", "dune"},
+		},
+		{
+			name:        "empty snapshot",
+			refresh:     true,
+			after:       []string{"ulysses"},
+			wantQueries: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			indexQueries(t, "search", tt.before...)
+			if tt.refresh {
+				if err := fuzzySnapshot.refresh(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, query := range tt.after {
+				if err := Cache.AddToIndex(recentIndexKey("search"), query, time.Now().Add(time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := recentQueriesFor("search")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) == 0 && len(tt.wantQueries) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.wantQueries) {
+				t.Errorf("recentQueriesFor = %v, want %v", got, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestFuzzySnapshotSizes(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	indexQueries(t, "search", "dune", "emma")
+	indexQueries(t, "search:all", "ulysses")
+
+	if _, ok := fuzzySnapshot.size("search"); ok {
+		t.Fatal("size reported before any snapshot was taken")
+	}
+	if err := fuzzySnapshot.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for namespace, want := range map[string]int64{"search": 2, "search:all": 1, "search:any": 0} {
+		if got, ok := fuzzySnapshot.size(namespace); !ok || got != want {
+			t.Errorf("size(%s) = %d, %v; want %d", namespace, got, ok, want)
+		}
+	}
+}
+
+func TestFuzzyIndexRefresherKeepsSnapshotCurrent(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	indexQueries(t, "search", "dune")
+
+	stop := StartFuzzyIndexRefresher(10 * time.Millisecond)
+	defer stop()
+	if got, ok := fuzzySnapshot.get("search"); !ok || !reflect.DeepEqual(got, []string{"dune"}) {
+		t.Fatalf("snapshot right after starting = %v, %v; want [dune]", got, ok)
+	}
+
+	if err := Cache.AddToIndex(recentIndexKey("search"), "emma", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if got, _ := fuzzySnapshot.get("search"); len(got) == 2 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("snapshot never picked up the new query")
+}
+
+func TestFuzzyAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		sizes     map[string]int // cached queries per namespace
+		want      map[string]bool
+	}{
+		{name: "below the threshold", threshold: 5, sizes: map[string]int{"search": 4}, want: map[string]bool{"search": true}},
+		{name: "at the threshold", threshold: 5, sizes: map[string]int{"search": 5}, want: map[string]bool{"search": false}},
+		{name: "no threshold", threshold: 0, sizes: map[string]int{"search": 50}, want: map[string]bool{"search": true}},
+		{name: "namespaces are independent", threshold: 5, sizes: map[string]int{"search": 6, "search:all": 2}, want: map[string]bool{"search": false, "search:all": true, "search:any": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.FuzzyDisableThreshold = tt.threshold })
+			useCache(t)
+			t.Cleanup(fuzzySuspended.Clear)
+			for namespace, size := range tt.sizes {
+				indexQueries(t, namespace, fillerQueries(size)...)
+			}
+
+			for namespace, want := range tt.want {
+				if got := fuzzyAllowed(namespace); got != want {
+					t.Errorf("fuzzyAllowed(%s) = %v, want %v", namespace, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFuzzyMatchingSuspendedForLargeKeyspace(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.FuzzyDisableThreshold = 10 })
+	useCache(t)
+	t.Cleanup(fuzzySuspended.Clear)
+	indexQueries(t, "search", "harry poter")
+	if matches := findSimilarCachedQueries("harry potter", "search", 5); len(matches) != 1 {
+		t.Fatalf("%d matches in a small keyspace, want 1", len(matches))
+	}
+
+	indexQueries(t, "search", fillerQueries(10)...)
+	if matches := findSimilarCachedQueries("harry potter", "search", 5); len(matches) != 0 {
+		t.Errorf("%d matches in a large keyspace, want fuzzy matching skipped", len(matches))
+	}
+	if !fuzzySuspendedFlag("search").Load() {
+		t.Error("namespace not marked suspended")
+	}
+
+	if err := Cache.RemoveFromIndex(recentIndexKey("search"), fillerQueries(10)...); err != nil {
+		t.Fatal(err)
+	}
+	if matches := findSimilarCachedQueries("harry potter", "search", 5); len(matches) != 1 {
+		t.Errorf("%d matches after the keyspace shrank, want fuzzy matching resumed", len(matches))
+	}
+	if fuzzySuspendedFlag("search").Load() {
+		t.Error("namespace still marked suspended")
+	}
+}
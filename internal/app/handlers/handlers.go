@@ -1,27 +1,114 @@
 package handlers
 
 import (
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+
 	"go.uber.org/zap"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
 	"github.com/moseskang00/custom_search_component_service/internal/cache"
 )
 
 var (
-	Logger *zap.Logger
-	Cache  *cache.Cache
+	// Logger discards everything until SetLogger is called, so handlers are safe to use
+	// without one
+	Logger = zap.NewNop()
+
+	Cache    *cache.Cache
+	Fallback *cache.DiskStore
+	Stats    *cache.Counter
+
+	HTTPClient Doer = http.DefaultClient
+
+	// Rand is the randomness behind sampling decisions and random ids. Tests can install a
+	// seeded source with SetRand for deterministic outcomes.
+	Rand Randomizer = globalRand{}
 )
 
+// Randomizer is the subset of *rand.Rand (math/rand/v2) handlers use, so a seeded
+// rand.New(rand.NewPCG(seed1, seed2)) can be installed with SetRand
+type Randomizer interface {
+	Float64() float64
+	IntN(n int) int
+}
+
+// globalRand draws from math/rand/v2's auto-seeded global source, which is safe for
+// concurrent use. A *rand.Rand is not, so SetRand callers must not share it across goroutines
+// they don't control.
+type globalRand struct{}
+
+func (globalRand) Float64() float64 { return rand.Float64() }
+func (globalRand) IntN(n int) int   { return rand.IntN(n) }
+
+// config holds the tunables handlers read per request. It can be swapped at runtime
+// (e.g. on SIGHUP), so always read it through CurrentConfig. A stored Config is never
+// modified afterwards: a reload stores a new one, so readers never see a half-applied update.
+var config atomic.Pointer[app.Config]
+
+func init() {
+	defaults := app.DefaultConfig()
+	config.Store(&defaults)
+}
+
+// SetLogger installs the logger used by handlers; nil restores the no-op logger
 func SetLogger(l *zap.Logger) {
+	if l == nil {
+		l = zap.NewNop()
+	}
 	Logger = l
+	quietLogger = l.WithOptions(zap.IncreaseLevel(zap.WarnLevel))
 }
 
 func SetCache(c *cache.Cache) {
 	Cache = c
 }
 
+func SetFallback(f *cache.DiskStore) {
+	Fallback = f
+}
+
+// SetStats installs the batched stats counter. Per-query counters and their hashed query
+// mappings are given the configured analytics TTL so queries nobody asks for anymore age out.
+func SetStats(s *cache.Counter) {
+	Stats = s
+	if s != nil {
+		s.SetTTL(statsQueryPrefix, CurrentConfig().AnalyticsQueryTTL)
+		s.SetTTL(statsQueryMapPrefix, CurrentConfig().AnalyticsQueryTTL)
+	}
+}
+
+func SetHTTPClient(d Doer) {
+	HTTPClient = d
+}
+
+// SetRand replaces the source of randomness; nil restores the global source
+func SetRand(r Randomizer) {
+	if r == nil {
+		r = globalRand{}
+	}
+	Rand = r
+}
+
+// SetConfig swaps in c as a whole. Its slices and maps must not be modified afterwards.
+func SetConfig(c app.Config) {
+	config.Store(&c)
+}
+
+// CurrentConfig returns a consistent snapshot of the current tunables. Treat its slices
+// and maps as read-only; they are shared with every other reader.
+func CurrentConfig() app.Config {
+	return *config.Load()
+}
+
 // OpenLibraryResponse represents the response from OpenLibrary search API
 type OpenLibraryResponse struct {
 	NumFound      int                      `json:"numFound"`
 	Start         int                      `json:"start"`
 	NumFoundExact bool                     `json:"numFoundExact"`
 	Docs          []map[string]interface{} `json:"docs"`
+
+	// Echoed back by OpenLibrary; checked against the request by checkEcho
+	Query  string `json:"q,omitempty"`
+	Offset *int   `json:"offset,omitempty"`
 }
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// numberedConfig is a config whose tunables all encode n, so a reader can tell whether the
+// fields it sees came from the same reload
+func numberedConfig(n int) app.Config {
+	cfg := app.DefaultConfig()
+	cfg.CacheTTL = time.Duration(n) * time.Minute
+	cfg.FuzzyMaxDistance = n
+	cfg.Environment = fmt.Sprintf("env-%d", n)
+	cfg.TrustedProxies = []string{fmt.Sprintf("10.0.0.%d", n%256)}
+	return cfg
+}
+
+// consistent reports whether every field of cfg encodes the same number
+func consistent(cfg app.Config) bool {
+	n := cfg.FuzzyMaxDistance
+	return cfg.CacheTTL == time.Duration(n)*time.Minute &&
+		cfg.Environment == fmt.Sprintf("env-%d", n) &&
+		len(cfg.TrustedProxies) == 1 && cfg.TrustedProxies[0] == fmt.Sprintf("10.0.0.%d", n%256)
+}
+
+func TestSetConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		set  []app.Config
+		want app.Config
+	}{
+		{name: "one reload", set: []app.Config{numberedConfig(1)}, want: numberedConfig(1)},
+		{name: "last reload wins", set: []app.Config{numberedConfig(1), numberedConfig(2), numberedConfig(3)}, want: numberedConfig(3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			for _, cfg := range tt.set {
+				SetConfig(cfg)
+			}
+			got := CurrentConfig()
+			if got.FuzzyMaxDistance != tt.want.FuzzyMaxDistance || !consistent(got) {
+				t.Errorf("CurrentConfig = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrentConfigIsACopy(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { *cfg = numberedConfig(1) })
+
+	cfg := CurrentConfig()
+	cfg.FuzzyMaxDistance = 9
+	cfg.CacheTTL = time.Hour
+	if got := CurrentConfig(); !consistent(got) || got.FuzzyMaxDistance != 1 {
+		t.Errorf("CurrentConfig after editing a returned copy = %+v, want the stored config untouched", got)
+	}
+}
+
+// Run with -race: reloads and reads must never share a Config being written
+func TestConfigReloadDuringReads(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { *cfg = numberedConfig(0) })
+
+	const (
+		readers = 8
+		reloads = 2000
+	)
+	var (
+		wg   sync.WaitGroup
+		done atomic.Bool
+		torn atomic.Int64
+		seen atomic.Int64
+	)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				if !consistent(CurrentConfig()) {
+					torn.Add(1)
+				}
+				seen.Add(1)
+			}
+		}()
+	}
+	for n := 1; n <= reloads; n++ {
+		SetConfig(numberedConfig(n))
+	}
+	done.Store(true)
+	wg.Wait()
+
+	if got := torn.Load(); got != 0 {
+		t.Errorf("%d of %d reads saw fields from different reloads", got, seen.Load())
+	}
+	if got := CurrentConfig().FuzzyMaxDistance; got != reloads {
+		t.Errorf("FuzzyMaxDistance after every reload = %d, want %d", got, reloads)
+	}
+}
+
+func TestConfigReloadDuringSearches(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+	var reloader, searches sync.WaitGroup
+	stop := make(chan struct{})
+	reloader.Add(1)
+	go func() {
+		defer reloader.Done()
+		for n := 1; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			cfg := app.DefaultConfig()
+			cfg.CacheTTL = time.Duration(n%60+1) * time.Minute
+			cfg.FuzzyMaxDistance = n % 4
+			SetConfig(cfg)
+		}
+	}()
+
+	for i := 0; i < 8; i++ {
+		searches.Add(1)
+		go func(i int) {
+			defer searches.Done()
+			for j := 0; j < 10; j++ {
+				rec := serve(Search, http.MethodGet, "/search", fmt.Sprintf("/search?q=dune+%d", i), "")
+				if rec.Code != http.StatusOK {
+					t.Errorf("status code = %d during reloads: %s", rec.Code, rec.Body.String())
+					return
+				}
+			}
+		}(i)
+	}
+	searches.Wait()
+	close(stop)
+	reloader.Wait()
+	WaitBackground(time.Second)
+}
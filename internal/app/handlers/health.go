@@ -1,18 +1,65 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
 )
 
-// HealthCheck handles the health check endpoint
+// cacheWarnings are the problems the Redis capability probe found at connect time
+var cacheWarnings []string
+
+// SetCacheWarnings records the Redis capability probe's findings for the health check
+func SetCacheWarnings(warnings []string) {
+	cacheWarnings = warnings
+}
+
+// HealthCheck handles the health check endpoint. It checks each dependency, but always
+// answers 200: without Redis the service still serves searches from OpenLibrary, so a
+// cache problem is reported as degraded rather than taking the instance out of rotation.
 func HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "healthy",
-		"service": "custom-search-service",
-		"time":    time.Now().Format(time.RFC3339),
-	})
+	body := gin.H{
+		"status":   "healthy",
+		"service":  "custom-search-service",
+		"time":     time.Now().Format(time.RFC3339),
+		"cache":    cacheHealth(c.Request.Context()),
+		"fallback": "disabled",
+	}
+	// An unreachable Redis means no caching at all; a read-only one still serves cached
+	// results, but nothing new is being cached
+	if body["cache"] == "unreachable" || body["cache"] == "read-only" {
+		body["status"] = "degraded"
+	}
+	if Fallback != nil {
+		body["fallback"] = "enabled"
+	}
+	if len(cacheWarnings) > 0 {
+		body["cacheWarnings"] = cacheWarnings
+	}
+	if Cache != nil {
+		if _, previous := Cache.Generation(); previous >= 0 {
+			body["cacheFlush"] = "in-progress"
+		}
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// cacheHealth pings Redis within HEALTH_PING_TIMEOUT_MILLISECONDS
+func cacheHealth(ctx context.Context) string {
+	if Cache == nil {
+		return "disabled"
+	}
+	ctx, cancel := context.WithTimeout(ctx, constants.HEALTH_PING_TIMEOUT_MILLISECONDS*time.Millisecond)
+	defer cancel()
+	if err := Cache.Ping(ctx); err != nil {
+		return "unreachable"
+	}
+	if Cache.ReadOnly() {
+		return "read-only"
+	}
+	return "ok"
 }
 
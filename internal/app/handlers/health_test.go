@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// readOnlyHook makes Redis reject SET the way a read-only replica does
+type readOnlyHook struct{}
+
+func (readOnlyHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (readOnlyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "set" {
+			err := errors.New("READONLY You can't write against a read only replica.")
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (readOnlyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestHealthCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T)
+		wantCache  string
+		wantStatus string
+		want       map[string]interface{}
+		absent     []string
+	}{
+		{
+			name:       "cache disabled",
+			setup:      func(t *testing.T) {},
+			wantCache:  "disabled",
+			wantStatus: "healthy",
+			want:       map[string]interface{}{"fallback": "disabled"},
+			absent:     []string{"cacheWarnings", "cacheFlush"},
+		},
+		{
+			name: "cache reachable",
+			setup: func(t *testing.T) {
+				useCache(t)
+			},
+			wantCache:  "ok",
+			wantStatus: "healthy",
+		},
+		{
+			name: "cache unreachable",
+			setup: func(t *testing.T) {
+				_, server := useCache(t)
+				server.Close()
+			},
+			wantCache:  "unreachable",
+			wantStatus: "degraded",
+		},
+		{
+			name: "cache read-only",
+			setup: func(t *testing.T) {
+				_, server := useCache(t)
+				client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+				t.Cleanup(func() { client.Close() })
+				client.AddHook(readOnlyHook{})
+				c := cache.NewCache(client, "test")
+				SetCache(c)
+				if err := c.Set("search:dune", "x", time.Minute); !errors.Is(err, cache.ErrReadOnly) {
+					t.Fatalf("Set error = %v, want ErrReadOnly", err)
+				}
+			},
+			wantCache:  "read-only",
+			wantStatus: "degraded",
+		},
+		{
+			name: "fallback enabled",
+			setup: func(t *testing.T) {
+				store, err := cache.NewDiskStore(t.TempDir(), 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				SetFallback(store)
+				t.Cleanup(func() { SetFallback(nil) })
+			},
+			wantCache:  "disabled",
+			wantStatus: "healthy",
+			want:       map[string]interface{}{"fallback": "enabled"},
+		},
+		{
+			name: "probe warnings",
+			setup: func(t *testing.T) {
+				useCache(t)
+				SetCacheWarnings([]string{"maxmemory-policy is noeviction"})
+				t.Cleanup(func() { SetCacheWarnings(nil) })
+			},
+			wantCache:  "ok",
+			wantStatus: "healthy",
+			want: map[string]interface{}{
+				"cacheWarnings": []interface{}{"maxmemory-policy is noeviction"},
+			},
+		},
+		{
+			name: "flush in progress",
+			setup: func(t *testing.T) {
+				c, _ := useCache(t)
+				if _, err := c.BeginGeneration(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCache:  "ok",
+			wantStatus: "healthy",
+			want:       map[string]interface{}{"cacheFlush": "in-progress"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+
+			rec := serve(HealthCheck, http.MethodGet, "/health", "/health", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d, want 200", rec.Code)
+			}
+			body := decodeBody(t, rec)
+			if body["cache"] != tt.wantCache {
+				t.Errorf("cache = %v, want %q", body["cache"], tt.wantCache)
+			}
+			if body["status"] != tt.wantStatus {
+				t.Errorf("status = %v, want %q", body["status"], tt.wantStatus)
+			}
+			for field, want := range tt.want {
+				if got := body[field]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", field, got, want)
+				}
+			}
+			for _, field := range tt.absent {
+				if _, ok := body[field]; ok {
+					t.Errorf("%s = %v, want it absent", field, body[field])
+				}
+			}
+		})
+	}
+}
+
+func TestSearchWithReadOnlyCache(t *testing.T) {
+	useConfig(t, nil)
+	_, server := useCache(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	client.AddHook(readOnlyHook{})
+	c := cache.NewCache(client, "test")
+	SetCache(c)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+	for i := 1; i <= 2; i++ {
+		rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status code = %d, want 200: %s", i, rec.Code, rec.Body.String())
+		}
+		if got := upstream.calls(); got != i {
+			t.Errorf("request %d: %d upstream calls, want %d since nothing could be cached", i, got, i)
+		}
+	}
+	WaitBackground(time.Second)
+	if !c.ReadOnly() {
+		t.Error("cache not marked read-only after its writes were rejected")
+	}
+}
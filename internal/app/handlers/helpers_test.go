@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// useConfig installs the default config changed by edit (which may be nil) and restores the
+// previous one when the test ends
+func useConfig(t testing.TB, edit func(cfg *app.Config)) app.Config {
+	t.Helper()
+	previous := CurrentConfig()
+	t.Cleanup(func() { SetConfig(previous) })
+
+	cfg := app.DefaultConfig()
+	if edit != nil {
+		edit(&cfg)
+	}
+	SetConfig(cfg)
+	return cfg
+}
+
+// useCache installs a cache backed by a fresh in-memory Redis, laid out the way main sets
+// it up, and removes it when the test ends
+func useCache(t testing.TB) (*cache.Cache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { client.Close() })
+
+	c := cache.NewCache(client, "test")
+	c.SetGenerationalPrefixes(ResultKeyPrefixes()...)
+	SetCache(c)
+	fuzzySnapshot = &queryIndexSnapshot{}
+	t.Cleanup(func() {
+		SetCache(nil)
+		fuzzySnapshot = &queryIndexSnapshot{}
+	})
+	return c, server
+}
+
+// fakeUpstream answers OpenLibrary requests with a canned status and body, recording the
+// URLs it was asked for
+type fakeUpstream struct {
+	mu     sync.Mutex
+	status int
+	body   string
+	urls   []string
+}
+
+// useUpstream installs a fake OpenLibrary answering every request with status and body
+func useUpstream(t *testing.T, status int, body string) *fakeUpstream {
+	t.Helper()
+	upstream := &fakeUpstream{status: status, body: body}
+	previous := HTTPClient
+	SetHTTPClient(upstream)
+	t.Cleanup(func() { SetHTTPClient(previous) })
+	return upstream
+}
+
+func (f *fakeUpstream) Do(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.urls = append(f.urls, req.URL.String())
+	f.mu.Unlock()
+	return &http.Response{
+		StatusCode: f.status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(f.body)),
+		Request:    req,
+	}, nil
+}
+
+// calls is how many requests reached the fake upstream
+func (f *fakeUpstream) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.urls)
+}
+
+// serve routes one request to handler, registered for method at route
+func serve(handler gin.HandlerFunc, method string, route string, target string, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, handler)
+	rec := httptest.NewRecorder()
+	var reader io.Reader
+	if body != "" {
+		reader = bytes.NewBufferString(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeBody decodes a JSON response body
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, rec.Body.String())
+	}
+	return body
+}
+
+// upstreamBody is an OpenLibrary search response with one doc per title
+func upstreamBody(titles ...string) string {
+	docs := make([]map[string]interface{}, len(titles))
+	for i, title := range titles {
+		docs[i] = map[string]interface{}{
+			"key":         fmt.Sprintf("/works/OL%dW", i+1),
+			"title":       title,
+			"author_name": []string{"Author " + title},
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"numFound":      len(titles),
+		"start":         0,
+		"numFoundExact": true,
+		"docs":          docs,
+	})
+	return string(body)
+}
+
+// cacheResults caches an OpenLibrary response body under key
+func cacheResults(t *testing.T, key string, body string) {
+	t.Helper()
+	var response OpenLibraryResponse
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatal(err)
+	}
+	if err := Cache.Set(key, response, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowUpstream answers every request with body after delay, counting requests per URL and
+// the most it had in flight at once
+type slowUpstream struct {
+	delay    time.Duration
+	body     string
+	mu       sync.Mutex
+	requests map[string]int
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func useSlowUpstream(t *testing.T, delay time.Duration, body string) *slowUpstream {
+	t.Helper()
+	upstream := &slowUpstream{delay: delay, body: body, requests: map[string]int{}}
+	previous := HTTPClient
+	SetHTTPClient(upstream)
+	t.Cleanup(func() { SetHTTPClient(previous) })
+	return upstream
+}
+
+func (s *slowUpstream) Do(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	s.requests[req.URL.String()]++
+	s.mu.Unlock()
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for peak := s.peak.Load(); n > peak && !s.peak.CompareAndSwap(peak, n); peak = s.peak.Load() {
+	}
+
+	select {
+	case <-time.After(s.delay):
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(s.body)),
+		Request:    req,
+	}, nil
+}
+
+// duplicates is how many requests repeated a URL already fetched
+func (s *slowUpstream) duplicates() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	extra := 0
+	for _, n := range s.requests {
+		extra += n - 1
+	}
+	return extra
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"go.uber.org/zap"
+)
+
+// matchingSearchKeys returns the cached search result keys whose query matches the glob
+// pattern, across every match-mode namespace
+func matchingSearchKeys(pattern string) ([]string, error) {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, namespace := range searchNamespaces() {
+		found, err := Cache.Scan(namespace + ":" + pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range found {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// forgetRecentQueries drops deleted keys from the recency indexes so fuzzy matching and
+// stats stop pointing at them
+func forgetRecentQueries(keys []string) {
+	byNamespace := map[string][]string{}
+	for _, key := range keys {
+		// Keys of the default namespace also match the longer ones' prefix, so try the
+		// most specific namespace first
+		for _, namespace := range []string{phraseNamespace, cacheNamespace(matchAll), cacheNamespace(matchAny), cacheNamespace(matchDefault)} {
+			if query, ok := strings.CutPrefix(key, namespace+":"); ok {
+				byNamespace[namespace] = append(byNamespace[namespace], query)
+				break
+			}
+		}
+	}
+	for namespace, queries := range byNamespace {
+		if err := Cache.RemoveFromIndex(recentIndexKey(namespace), queries...); err != nil {
+			Logger.Warn("Failed to update recent query index", zap.Error(err))
+		}
+	}
+}
+
+// DeleteCacheByPattern invalidates every cached search whose query matches a glob pattern
+// (e.g. pattern=*tolkien*). Deletion needs confirm=true; without it the matches are only
+// counted. More than CACHE_BULK_DELETE_MAX matches is refused as a likely mistake.
+func DeleteCacheByPattern(c *gin.Context) {
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+
+	pattern := c.Query("pattern")
+	if pattern == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Parameter 'pattern' is required",
+		})
+		return
+	}
+
+	keys, err := matchingSearchKeys(pattern)
+	if err != nil {
+		Logger.Warn("Failed to scan cache keys", zap.String("pattern", pattern), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to scan cache keys",
+		})
+		return
+	}
+
+	if len(keys) > constants.CACHE_BULK_DELETE_MAX {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Pattern matches too many keys, narrow it down",
+			"pattern": pattern,
+			"matched": len(keys),
+			"max":     constants.CACHE_BULK_DELETE_MAX,
+		})
+		return
+	}
+
+	if c.Query("confirm") != "true" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Add confirm=true to delete the matched keys",
+			"pattern": pattern,
+			"matched": len(keys),
+		})
+		return
+	}
+
+	if err := Cache.DeleteMany(keys); err != nil {
+		Logger.Warn("Failed to delete cache keys", zap.String("pattern", pattern), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete cache keys",
+		})
+		return
+	}
+	forgetRecentQueries(keys)
+
+	Logger.Info("Deleted cache keys by pattern", zap.String("pattern", pattern), zap.Int("deleted", len(keys)))
+	c.JSON(http.StatusOK, gin.H{
+		"pattern": pattern,
+		"deleted": len(keys),
+	})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+)
+
+func TestDeleteCacheByPattern(t *testing.T) {
+	seeded := []string{"search:the hobbit", "search:all:tolkien hobbit", "search:phrase:the hobbit", "search:dune", "stats:hits"}
+	tests := []struct {
+		name        string
+		target      string
+		extra       int // filler keys seeded on top
+		wantStatus  int
+		wantMatched interface{}
+		wantDeleted interface{}
+		wantLeft    []string
+		wantRecent  []string // recent default-mode queries left
+	}{
+		{
+			name:       "pattern required",
+			target:     "/cache?confirm=true",
+			wantStatus: http.StatusBadRequest,
+			wantLeft:   seeded,
+		},
+		{
+			name:        "unconfirmed only counts",
+			target:      "/cache?pattern=*hobbit*",
+			wantStatus:  http.StatusBadRequest,
+			wantMatched: float64(3),
+			wantLeft:    seeded,
+		},
+		{
+			name:        "confirmed deletes matches in every namespace",
+			target:      "/cache?pattern=*hobbit*&confirm=true",
+			wantStatus:  http.StatusOK,
+			wantDeleted: float64(3),
+			wantLeft:    []string{"search:dune", "stats:hits"},
+			wantRecent:  []string{"dune"},
+		},
+		{
+			name:        "no matches",
+			target:      "/cache?pattern=*emma*&confirm=true",
+			wantStatus:  http.StatusOK,
+			wantDeleted: float64(0),
+			wantLeft:    seeded,
+			wantRecent:  []string{"dune", "the hobbit"},
+		},
+		{
+			name:        "over the cap",
+			target:      "/cache?pattern=*&confirm=true",
+			extra:       constants.CACHE_BULK_DELETE_MAX,
+			wantStatus:  http.StatusBadRequest,
+			wantMatched: float64(constants.CACHE_BULK_DELETE_MAX + 4),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			c, _ := useCache(t)
+			for _, key := range seeded {
+				if err := c.Set(key, "{}", time.Hour); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < tt.extra; i++ {
+				if err := c.Set(fmt.Sprintf("search:filler %d", i), "{}", time.Hour); err != nil {
+					t.Fatal(err)
+				}
+			}
+			indexQueries(t, "search", "the hobbit", "dune")
+			indexQueries(t, "search:all", "tolkien hobbit")
+
+			rec := serve(DeleteCacheByPattern, http.MethodDelete, "/cache", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if body["matched"] != tt.wantMatched || body["deleted"] != tt.wantDeleted {
+				t.Errorf("matched %v, deleted %v; want %v, %v", body["matched"], body["deleted"], tt.wantMatched, tt.wantDeleted)
+			}
+			if tt.wantLeft == nil {
+				return
+			}
+
+			var left []string
+			for _, key := range seeded {
+				if exists, err := c.Exists(key); err != nil {
+					t.Fatal(err)
+				} else if exists {
+					left = append(left, key)
+				}
+			}
+			sort.Strings(left)
+			want := append([]string(nil), tt.wantLeft...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(left, want) {
+				t.Errorf("keys left = %q, want %q", left, want)
+			}
+			if tt.wantRecent == nil {
+				return
+			}
+			if recent, _ := recentQueriesFor("search"); !reflect.DeepEqual(recent, tt.wantRecent) {
+				t.Errorf("recent queries = %q, want %q", recent, tt.wantRecent)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/pkg/utils"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ISBNLookup returns normalized details for a single edition by ISBN-10 or ISBN-13
+func ISBNLookup(c *gin.Context) {
+	startTime := time.Now()
+
+	isbn, ok := utils.NormalizeISBN(c.Param("isbn"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ISBN: expected a valid ISBN-10 or ISBN-13",
+			"isbn":  c.Param("isbn"),
+		})
+		return
+	}
+
+	includeMetrics, err := parseIncludeMetrics(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.(*paramError).body())
+		return
+	}
+
+	cacheKey := fmt.Sprintf("isbn:%s", isbn)
+	var edition map[string]interface{}
+
+	if Cache != nil {
+		err := Cache.GetJSON(cacheKey, &edition)
+		if err == nil {
+			totalDuration := time.Since(startTime)
+			Logger.Info("ISBN cache HIT", zap.String("isbn", isbn), zap.Duration("total_ms", totalDuration))
+			c.JSON(http.StatusOK, isbnResponse(isbn, edition, true, includeMetrics, totalDuration))
+			return
+		} else if !errors.Is(err, redis.Nil) {
+			Logger.Warn("Cache error", zap.String("key", cacheKey), zap.Error(err))
+		}
+	}
+
+	isbnURL := fmt.Sprintf("%s%s%s.json", constants.OpenLibraryAPIURL, constants.OpenLibraryISBNEndpoint, isbn)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), CurrentConfig().UpstreamTimeout)
+	defer cancel()
+
+	if _, err := fetchUpstreamJSON(ctx, isbnURL, &edition); err != nil {
+		if errors.Is(err, errUpstreamNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "No book found for ISBN",
+				"isbn":  isbn,
+			})
+			return
+		}
+		respondUpstreamError(c, err, http.StatusInternalServerError)
+		return
+	}
+	edition = trimFields(edition, CurrentConfig().ISBNUpstreamFields)
+
+	if Cache != nil {
+		if err := Cache.Set(cacheKey, edition, CurrentConfig().CacheTTL); err != nil {
+			warnCacheWrite("Failed to cache ISBN result", err, zap.String("key", cacheKey))
+		}
+	}
+
+	totalDuration := time.Since(startTime)
+	Logger.Info("ISBN fetched", zap.String("isbn", isbn), zap.Duration("total_ms", totalDuration))
+	c.JSON(http.StatusOK, isbnResponse(isbn, edition, false, includeMetrics, totalDuration))
+}
+
+// isbnResponse is the body of a successful ISBN lookup. responseTime is left out unless
+// includeMetrics is set.
+func isbnResponse(isbn string, edition map[string]interface{}, cached bool, includeMetrics bool, totalDuration time.Duration) gin.H {
+	body := gin.H{
+		"isbn":   isbn,
+		"book":   bookResponse(mapEditionToBook(edition)),
+		"cached": cached,
+	}
+	if includeMetrics {
+		body["responseTime"] = fmt.Sprintf("%.2fms", totalDuration.Seconds()*1000)
+	}
+	return body
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+const hobbitEdition = `{
+	"key": "/books/OL1M",
+	"title": "The Hobbit",
+	"isbn_13": ["9780261103344"],
+	"publish_date": "1995",
+	"number_of_pages": 310,
+	"covers": [42]
+}`
+
+func TestISBNLookup(t *testing.T) {
+	tests := []struct {
+		name           string
+		isbn           string
+		withCache      bool
+		cached         bool
+		upstreamStatus int
+		wantStatus     int
+		wantCached     bool
+		wantCalls      int
+	}{
+		{name: "invalid ISBN", isbn: "12345", upstreamStatus: http.StatusOK, wantStatus: http.StatusBadRequest},
+		{name: "fetched without a cache", isbn: "978-0-261-10334-4", upstreamStatus: http.StatusOK, wantStatus: http.StatusOK, wantCalls: 1},
+		{name: "fetched and cached", isbn: "9780261103344", withCache: true, upstreamStatus: http.StatusOK, wantStatus: http.StatusOK, wantCalls: 1},
+		{name: "served from cache", isbn: "9780261103344", withCache: true, cached: true, upstreamStatus: http.StatusOK, wantStatus: http.StatusOK, wantCached: true},
+		{name: "unknown ISBN", isbn: "9780261103344", upstreamStatus: http.StatusNotFound, wantStatus: http.StatusNotFound, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			upstream := useUpstream(t, tt.upstreamStatus, hobbitEdition)
+			if tt.withCache {
+				c, _ := useCache(t)
+				if tt.cached {
+					if err := c.Set("isbn:9780261103344", map[string]interface{}{"title": "The Hobbit"}, 0); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			rec := serve(ISBNLookup, http.MethodGet, "/isbn/:isbn", "/isbn/"+tt.isbn, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if upstream.calls() != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", upstream.calls(), tt.wantCalls)
+			}
+			if tt.wantCalls > 0 && !strings.HasSuffix(upstream.urls[0], "/isbn/9780261103344.json") {
+				t.Errorf("upstream URL = %s, want the normalized ISBN", upstream.urls[0])
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, rec)
+			if body["cached"] != tt.wantCached {
+				t.Errorf("cached = %v, want %v", body["cached"], tt.wantCached)
+			}
+			book := body["book"].(map[string]interface{})
+			if book["title"] != "The Hobbit" {
+				t.Errorf("title = %v, want The Hobbit", book["title"])
+			}
+			if tt.withCache && !tt.cached {
+				if exists, _ := Cache.Exists("isbn:9780261103344"); !exists {
+					t.Error("fetched edition was not cached")
+				}
+			}
+		})
+	}
+}
+
+func TestISBNLookupFieldNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       map[string]string
+		wantTitle   string // key holding the title
+		wantMissing string
+	}{
+		{name: "defaults", wantTitle: "title"},
+		{name: "renamed", names: map[string]string{"title": "name"}, wantTitle: "name", wantMissing: "title"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				if tt.names != nil {
+					cfg.ResultFieldNames = tt.names
+				}
+			})
+			useUpstream(t, http.StatusOK, hobbitEdition)
+
+			rec := serve(ISBNLookup, http.MethodGet, "/isbn/:isbn", "/isbn/9780261103344", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			book := decodeBody(t, rec)["book"].(map[string]interface{})
+			if book[tt.wantTitle] != "The Hobbit" {
+				t.Errorf("%s = %v, want The Hobbit: %v", tt.wantTitle, book[tt.wantTitle], book)
+			}
+			if _, ok := book[tt.wantMissing]; tt.wantMissing != "" && ok {
+				t.Errorf("book has %q, want it renamed away", tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestISBNLookupBookKeyStyle(t *testing.T) {
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{style: app.BookKeyPath, want: "/books/OL1M"},
+		{style: app.BookKeyID, want: "OL1M"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.BookKeyStyle = tt.style })
+			useUpstream(t, http.StatusOK, hobbitEdition)
+
+			rec := serve(ISBNLookup, http.MethodGet, "/isbn/:isbn", "/isbn/9780261103344", "")
+			book := decodeBody(t, rec)["book"].(map[string]interface{})
+			if book["key"] != tt.want {
+				t.Errorf("key = %v, want %q", book["key"], tt.want)
+			}
+		})
+	}
+}
+
+func TestISBNLookupTrimsFields(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.ISBNUpstreamFields = []string{"key", "title"} })
+	useCache(t)
+	useUpstream(t, http.StatusOK, hobbitEdition)
+
+	rec := serve(ISBNLookup, http.MethodGet, "/isbn/:isbn", "/isbn/9780261103344", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+	}
+	var cached map[string]interface{}
+	if err := Cache.GetJSON("isbn:9780261103344", &cached); err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 2 || cached["key"] != "/books/OL1M" || cached["title"] != "The Hobbit" {
+		t.Errorf("cached edition = %v, want only key and title", cached)
+	}
+}
+
+func TestISBNLookupIncludeMetrics(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured bool // IncludeMetrics
+		param      string
+		cached     bool
+		wantStatus int
+		wantTime   bool
+	}{
+		{name: "default", configured: true, wantStatus: http.StatusOK, wantTime: true},
+		{name: "turned off by the client", configured: true, param: "false", wantStatus: http.StatusOK},
+		{name: "turned off by config", wantStatus: http.StatusOK},
+		{name: "client can't turn it on", param: "true", wantStatus: http.StatusOK},
+		{name: "kept on by the client", configured: true, param: "true", wantStatus: http.StatusOK, wantTime: true},
+		{name: "cache hit turned off", configured: true, param: "false", cached: true, wantStatus: http.StatusOK},
+		{name: "cache hit", configured: true, cached: true, wantStatus: http.StatusOK, wantTime: true},
+		{name: "invalid", configured: true, param: "yes", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.IncludeMetrics = tt.configured })
+			useUpstream(t, http.StatusOK, hobbitEdition)
+			if tt.cached {
+				c, _ := useCache(t)
+				if err := c.Set("isbn:9780261103344", map[string]interface{}{"title": "The Hobbit"}, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			target := "/isbn/9780261103344"
+			if tt.param != "" {
+				target += "?includeMetrics=" + tt.param
+			}
+
+			rec := serve(ISBNLookup, http.MethodGet, "/isbn/:isbn", target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, rec)
+			if body["cached"] != tt.cached {
+				t.Fatalf("cached = %v, want %v", body["cached"], tt.cached)
+			}
+			if _, present := body["responseTime"]; present != tt.wantTime {
+				t.Errorf("responseTime present = %v, want %v", present, tt.wantTime)
+			}
+		})
+	}
+}
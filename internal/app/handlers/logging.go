@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"go.uber.org/zap"
+)
+
+// searchSourceKey is the gin context key holding the source of a successful search response
+const searchSourceKey = "searchSource"
+
+// quietLogger is Logger with info and debug lines dropped, built by SetLogger
+var quietLogger *zap.Logger
+
+// stepLogger is the logger for the individual steps of a search (each variation tried, cache
+// hits and misses, upstream timings). In summary mode those are dropped in favour of the
+// single line written by logSearchSummary; warnings and errors are always kept.
+func stepLogger() *zap.Logger {
+	if quietLogger != nil && CurrentConfig().LogMode == app.LogModeSummary {
+		return quietLogger
+	}
+	return Logger
+}
+
+// logSearchSummary writes the one log line for a search request in summary mode
+func logSearchSummary(c *gin.Context, params SearchParams, startTime time.Time) {
+	source, _ := c.Get(searchSourceKey)
+	timings := timingsFor(c)
+	Logger.Info("Search",
+		zap.String("query", params.Query),
+		zap.String("normalizedQuery", params.NormalizedQuery),
+		zap.String("match", params.Match),
+		zap.Int("status", c.Writer.Status()),
+		zap.Any("source", source),
+		zap.String("numFound", c.Writer.Header().Get("X-Num-Found")),
+		zap.Duration("cache", timings.cache),
+		zap.Duration("fuzzy", timings.fuzzy),
+		zap.Duration("upstream", timings.upstream),
+		zap.Duration("total", time.Since(startTime)))
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// useObservedLogger installs a logger recording everything at debug level and above, and
+// restores the previous one when the test ends
+func useObservedLogger(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	previous := Logger
+	t.Cleanup(func() { SetLogger(previous) })
+	core, logs := observer.New(zapcore.DebugLevel)
+	SetLogger(zap.New(core))
+	return logs
+}
+
+func TestSearchLogMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		targets     []string // requested in order; only the last one's logs are checked
+		wantSummary bool
+		wantSource  string
+	}{
+		{name: "verbose miss", mode: app.LogModeVerbose, targets: []string{"/search?q=dune"}},
+		{name: "summary miss", mode: app.LogModeSummary, targets: []string{"/search?q=dune"}, wantSummary: true, wantSource: sourceUpstream},
+		{name: "summary hit", mode: app.LogModeSummary, targets: []string{"/search?q=dune", "/search?q=dune"}, wantSummary: true, wantSource: sourceL2Exact},
+		{name: "summary rejected request", mode: app.LogModeSummary, targets: []string{"/search"}, wantSummary: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.LogMode = tt.mode })
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			logs := useObservedLogger(t)
+
+			for _, target := range tt.targets {
+				logs.TakeAll()
+				serve(Search, http.MethodGet, "/search", target, "")
+				WaitBackground(time.Second)
+			}
+
+			info := logs.FilterLevelExact(zapcore.InfoLevel)
+			summaries := info.FilterMessage("Search").All()
+			if !tt.wantSummary {
+				if len(summaries) != 0 {
+					t.Errorf("%d summary lines in verbose mode, want none", len(summaries))
+				}
+				if info.Len() < 2 {
+					t.Errorf("%d info lines in verbose mode, want every step logged", info.Len())
+				}
+				return
+			}
+			if info.Len() != 1 || len(summaries) != 1 {
+				for _, entry := range info.All() {
+					t.Logf("info: %s", entry.Message)
+				}
+				t.Fatalf("%d info lines with %d summaries, want only the summary", info.Len(), len(summaries))
+			}
+			fields := summaries[0].ContextMap()
+			if tt.wantSource != "" && fields["source"] != tt.wantSource {
+				t.Errorf("summary source = %v, want %s", fields["source"], tt.wantSource)
+			}
+			for _, field := range []string{"query", "status", "total"} {
+				if _, ok := fields[field]; !ok {
+					t.Errorf("summary is missing %q: %v", field, fields)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchWithoutLogger(t *testing.T) {
+	tests := []struct {
+		name       string
+		install    func() // leaves the handlers without a configured logger
+		logMode    string
+		cached     bool
+		failing    bool // the upstream request fails
+		wantStatus int
+	}{
+		{name: "never set, upstream", install: func() { Logger, quietLogger = zap.NewNop(), nil }, wantStatus: http.StatusOK},
+		{name: "never set, cache hit", install: func() { Logger, quietLogger = zap.NewNop(), nil }, cached: true, wantStatus: http.StatusOK},
+		{name: "never set, upstream error", install: func() { Logger, quietLogger = zap.NewNop(), nil }, failing: true, wantStatus: http.StatusInternalServerError},
+		{name: "never set, summary mode", install: func() { Logger, quietLogger = zap.NewNop(), nil }, logMode: app.LogModeSummary, wantStatus: http.StatusOK},
+		{name: "set to nil", install: func() { SetLogger(nil) }, cached: true, wantStatus: http.StatusOK},
+		{name: "set to nil, summary mode", install: func() { SetLogger(nil) }, logMode: app.LogModeSummary, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous, previousQuiet := Logger, quietLogger
+			t.Cleanup(func() { Logger, quietLogger = previous, previousQuiet })
+			useConfig(t, func(cfg *app.Config) {
+				if tt.logMode != "" {
+					cfg.LogMode = tt.logMode
+				}
+			})
+			if tt.cached {
+				useCache(t)
+				cacheResults(t, "search:dune", upstreamBody("Dune"))
+			}
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			if tt.failing {
+				SetHTTPClient(&failingUpstream{})
+			}
+			tt.install()
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q=Dune", "")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics reports process-level counters for monitoring
+func Metrics(c *gin.Context) {
+	body := gin.H{
+		"inFlightRequests": inFlightRequests.Load(),
+		"shedRequests":     shedRequests.Load(),
+		"schemaViolations": schemaViolations.Load(),
+	}
+	if Cache != nil {
+		body["cacheWrites"] = cacheWriteSizes()
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// cacheWriteSizes summarizes the serialized size of values this instance has cached
+func cacheWriteSizes() gin.H {
+	writes, bytes := Cache.WriteSizes()
+	var average int64
+	if writes > 0 {
+		average = bytes / writes
+	}
+	return gin.H{
+		"count":             writes,
+		"totalBytes":        bytes,
+		"averageEntryBytes": average,
+	}
+}
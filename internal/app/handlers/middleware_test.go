@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockingRouter serves /work, which blocks until release is closed, and /health and
+// /items/:id/events, which don't, behind an InFlightLimiter of max exempting both
+func blockingRouter(max int64, release <-chan struct{}, started chan<- struct{}) *gin.Engine {
+	router := gin.New()
+	router.Use(InFlightLimiter(max, "/health", "/items/:id/events"))
+	router.GET("/work", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/items/:id/events", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func get(router *gin.Engine, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestInFlightLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int64
+		busy       int // requests held in flight
+		target     string
+		wantStatus int
+	}{
+		{name: "below the limit", max: 3, busy: 2, target: "/work", wantStatus: http.StatusOK},
+		{name: "at the limit", max: 2, busy: 2, target: "/work", wantStatus: http.StatusServiceUnavailable},
+		{name: "health checks exempt", max: 2, busy: 2, target: "/health", wantStatus: http.StatusOK},
+		{name: "exempt route patterns match with params", max: 2, busy: 2, target: "/items/7/events", wantStatus: http.StatusOK},
+		{name: "no limit", max: 0, busy: 5, target: "/work", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			release := make(chan struct{})
+			started := make(chan struct{}, tt.busy+1)
+			router := blockingRouter(tt.max, release, started)
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.busy; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					get(router, "/work")
+				}()
+			}
+			for i := 0; i < tt.busy; i++ {
+				<-started
+			}
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- get(router, tt.target) }()
+			var rec *httptest.ResponseRecorder
+			select {
+			case rec = <-done:
+			case <-started:
+				// The request was let through to the blocking handler
+				close(release)
+				rec = <-done
+			case <-time.After(2 * time.Second):
+				t.Fatal("request neither served nor shed")
+			}
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+			wg.Wait()
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusServiceUnavailable {
+				body := decodeBody(t, rec)
+				if body["code"] != "OVERLOADED" || body["retryable"] != true {
+					t.Errorf("shed body = %v, want code OVERLOADED and retryable", body)
+				}
+				if rec.Header().Get("Retry-After") == "" {
+					t.Error("shed response has no Retry-After header")
+				}
+			}
+			if got := inFlightRequests.Load(); got != 0 {
+				t.Errorf("in-flight count = %d after every request finished, want 0", got)
+			}
+		})
+	}
+}
+
+func TestResponseTimeHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    gin.HandlerFunc
+		wantStatus int
+	}{
+		{
+			name:       "json success",
+			handler:    func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "error response",
+			handler: func(c *gin.Context) {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "boom"})
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "status without a body",
+			handler:    func(c *gin.Context) { c.Status(http.StatusNoContent) },
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "plain text",
+			handler:    func(c *gin.Context) { c.String(http.StatusNotFound, "not found") },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "flushed stream",
+			handler: func(c *gin.Context) {
+				c.Status(http.StatusOK)
+				c.Writer.Flush()
+				c.Writer.WriteString("data: ready\n\n")
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const delay = 5 * time.Millisecond
+			router := gin.New()
+			router.Use(ResponseTimeHeader())
+			router.GET("/work", func(c *gin.Context) {
+				time.Sleep(delay)
+				tt.handler(c)
+			})
+
+			rec := get(router, "/work")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			header := rec.Result().Header.Get("X-Response-Time")
+			ms, err := strconv.ParseFloat(header, 64)
+			if err != nil {
+				t.Fatalf("X-Response-Time = %q, want milliseconds", header)
+			}
+			if ms < float64(delay.Milliseconds()) || ms > 2000 {
+				t.Errorf("X-Response-Time = %vms, want at least %v and well under 2s", ms, delay)
+			}
+		})
+	}
+}
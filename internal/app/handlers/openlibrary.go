@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"go.uber.org/zap"
+)
+
+// Errors returned by fetchOpenLibrary, one per stage of the upstream call
+var (
+	errUpstreamRequest  = errors.New("openlibrary request failed")
+	errUpstreamRead     = errors.New("failed to read openlibrary response")
+	errUpstreamParse    = errors.New("failed to parse openlibrary response")
+	errUpstreamNotFound = errors.New("openlibrary resource not found")
+
+	// errUpstreamNoResponse wraps errUpstreamRequest: the client returned neither a response
+	// nor an error, which only a broken Doer does
+	errUpstreamNoResponse = errors.New("http client returned no response")
+
+	// errUpstreamConnect wraps errUpstreamRequest: OpenLibrary couldn't be reached at all,
+	// as opposed to being slow to respond
+	errUpstreamConnect = errors.New("could not connect to openlibrary")
+
+	// errUpstreamTruncated also wraps errUpstreamRead or errUpstreamParse: the connection
+	// dropped mid-body, so the request is worth retrying
+	errUpstreamTruncated = errors.New("openlibrary response was truncated")
+)
+
+// Error codes returned to clients for upstream failures
+const (
+	codeUpstreamTruncated = "UPSTREAM_TRUNCATED"
+	codeUpstreamConnect   = "UPSTREAM_CONNECT_FAILED"
+	codeUpstreamTimeout   = "UPSTREAM_TIMEOUT"
+)
+
+// Doer is the subset of *http.Client used for upstream calls, so tests and alternative
+// transports can be injected with SetHTTPClient
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// NewUpstreamClient returns the HTTP client for OpenLibrary calls. Connecting is bounded by
+// dialTimeout; the rest of the call is bounded by each request's context.
+func NewUpstreamClient(dialTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = dialTimeout
+	return &http.Client{Transport: transport}
+}
+
+// isConnectError reports whether a failed request never reached OpenLibrary: the name
+// didn't resolve or the connection couldn't be opened
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// upstreamFieldPrefixes are OpenLibrary field searches that scan broad parts of the
+// catalog and legitimately take longer than plain title lookups
+var upstreamFieldPrefixes = []string{"subject:", "place:", "person:", "time:"}
+
+// upstreamTimeout picks the time budget for an upstream search. Plain lookups get the short
+// default; broad queries (field searches, match=any, or an explicit timeout=extended) get the
+// extended budget. Both are capped at UPSTREAM_MAX_TIMEOUT_SECONDS.
+func upstreamTimeout(rawQuery string, match string, extended bool) time.Duration {
+	cfg := CurrentConfig()
+	timeout := cfg.UpstreamTimeout
+
+	lowered := strings.ToLower(rawQuery)
+	for _, prefix := range upstreamFieldPrefixes {
+		if strings.Contains(lowered, prefix) {
+			extended = true
+			break
+		}
+	}
+	if extended || match == matchAny {
+		timeout = cfg.UpstreamExtendedTimeout
+	}
+
+	if hardMax := constants.UPSTREAM_MAX_TIMEOUT_SECONDS * time.Second; timeout > hardMax {
+		timeout = hardMax
+	}
+	return timeout
+}
+
+// upstreamResult is a decoded OpenLibrary response along with per-stage timings
+type upstreamResult struct {
+	Response      OpenLibraryResponse
+	StatusCode    int
+	APIDuration   time.Duration
+	ReadDuration  time.Duration
+	ParseDuration time.Duration
+}
+
+// Values accepted by the match parameter. The default joins terms with plain spaces and
+// leaves the operator up to OpenLibrary; all/any make AND/OR explicit.
+const (
+	matchDefault = ""
+	matchAll     = "all"
+	matchAny     = "any"
+)
+
+func validMatchMode(match string) bool {
+	return match == matchDefault || match == matchAll || match == matchAny
+}
+
+// toSearchQuery turns a normalized query into the "+"-joined form OpenLibrary expects,
+// inserting an explicit AND/OR between terms for match=all/any
+func toSearchQuery(normalizedQuery string, match string) string {
+	separator := "+"
+	switch match {
+	case matchAll:
+		separator = "+AND+"
+	case matchAny:
+		separator = "+OR+"
+	}
+	return strings.Join(strings.Split(normalizedQuery, " "), separator)
+}
+
+// cacheNamespace is the key prefix for a match mode, so AND and OR results are never
+// served for each other
+func cacheNamespace(match string) string {
+	if match == matchDefault {
+		return "search"
+	}
+	return "search:" + match
+}
+
+// phraseNamespace holds results of quoted phrase searches, which differ from the same
+// words searched loosely
+const phraseNamespace = "search:phrase"
+
+// toPhraseQuery turns a normalized query into a quoted phrase search
+func toPhraseQuery(normalizedQuery string) string {
+	return "%22" + toSearchQuery(normalizedQuery, matchDefault) + "%22"
+}
+
+// searchNamespaces lists the cache namespace of every match mode, and of phrase searches
+func searchNamespaces() []string {
+	return []string{
+		cacheNamespace(matchDefault),
+		cacheNamespace(matchAll),
+		cacheNamespace(matchAny),
+		phraseNamespace,
+	}
+}
+
+// ResultKeyPrefixes are the prefixes of cached search and ISBN results, the only keys a
+// flush replaces (see cache.SetGenerationalPrefixes)
+func ResultKeyPrefixes() []string {
+	return []string{"search:", "isbn:"}
+}
+
+// recentIndexKey is the recency index tracking cached queries within a namespace
+func recentIndexKey(namespace string) string {
+	return fmt.Sprintf("index:%s:recent", namespace)
+}
+
+// buildSearchURL builds the OpenLibrary search URL for an already "+"-joined query
+func buildSearchURL(searchQuery string, limit int) string {
+	searchURL := fmt.Sprintf("%s%s%s%s%d",
+		constants.OpenLibraryAPIURL,
+		constants.OpenLibrarySearchEndpoint,
+		searchQuery,
+		constants.QueryLimit,
+		limit)
+	if fields := CurrentConfig().SearchUpstreamFields; len(fields) > 0 {
+		searchURL += constants.FieldsParam + url.QueryEscape(strings.Join(fields, ","))
+	}
+	return searchURL
+}
+
+// CachePrefix is base, versioned by the configured upstream fields so results fetched with
+// different fields are never served for each other. With the default fields it is base
+// unchanged, keeping existing entries.
+func CachePrefix(base string, cfg app.Config) string {
+	if len(cfg.SearchUpstreamFields) == 0 && len(cfg.ISBNUpstreamFields) == 0 {
+		return base
+	}
+	fingerprint := "search=" + strings.Join(cfg.SearchUpstreamFields, ",") + ";isbn=" + strings.Join(cfg.ISBNUpstreamFields, ",")
+	sum := sha256.Sum256([]byte(fingerprint))
+	return base + "-" + hex.EncodeToString(sum[:4])
+}
+
+// trimFields keeps only fields of doc, or all of it when fields is empty
+func trimFields(doc map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return doc
+	}
+	trimmed := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, ok := doc[field]; ok {
+			trimmed[field] = value
+		}
+	}
+	return trimmed
+}
+
+// fetchOpenLibrary calls the OpenLibrary search API and decodes the response.
+// Errors wrap one of the errUpstream* sentinels so callers can tell the stages apart.
+// Transient failures are retried within the request's retry budget.
+func fetchOpenLibrary(ctx context.Context, searchURL string) (upstreamResult, error) {
+	var result upstreamResult
+	err := withRetries(ctx, "upstream", retryableUpstreamError, func() error {
+		var response OpenLibraryResponse
+		var err error
+		result, err = fetchUpstreamJSON(ctx, searchURL, &response)
+		result.Response = response
+		return err
+	})
+	if err == nil {
+		checkEcho(searchURL, result.Response)
+	}
+	return result, err
+}
+
+// checkEcho compares the query and offset OpenLibrary echoes back with what was requested.
+// A mismatch doesn't fail the search, but is logged since it likely means the URL was built
+// or encoded wrongly. We never send an offset, so any echoed offset must be 0.
+func checkEcho(searchURL string, response OpenLibraryResponse) {
+	parsed, err := url.Parse(searchURL)
+	if err != nil {
+		return
+	}
+	sentQuery := parsed.Query().Get("q")
+	if response.Query != "" && response.Query != sentQuery {
+		Logger.Warn("OpenLibrary echoed a different query than was sent",
+			zap.String("sent", sentQuery),
+			zap.String("echoed", response.Query),
+			zap.String("url", searchURL))
+	}
+	if response.Offset != nil && *response.Offset != 0 {
+		Logger.Warn("OpenLibrary echoed an unexpected offset",
+			zap.Int("echoed", *response.Offset),
+			zap.String("url", searchURL))
+	}
+}
+
+// fetchUpstreamJSON GETs an OpenLibrary URL and decodes the JSON body into v, recording
+// per-stage timings. A 404 returns errUpstreamNotFound without reading the body.
+// The request is bounded by ctx, which should carry the upstream timeout.
+func fetchUpstreamJSON(ctx context.Context, url string, v interface{}) (upstreamResult, error) {
+	var result upstreamResult
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errUpstreamRequest, err)
+	}
+
+	// Wait for an upstream slot, so bursts and warming can't flood OpenLibrary. A slot freed
+	// once too little budget is left is no use, and giving up then reports saturation before
+	// the request's own deadline does.
+	slotCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		slotCtx, cancel = context.WithDeadline(ctx, deadline.Add(-constants.UPSTREAM_MIN_REMAINING_MILLISECONDS*time.Millisecond))
+		defer cancel()
+	}
+	release, err := acquireUpstreamSlot(slotCtx)
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", errUpstreamSaturated, err)
+	}
+	defer release()
+
+	// Time the API call
+	apiStartTime := time.Now()
+	response, err := HTTPClient.Do(request)
+	result.APIDuration = time.Since(apiStartTime)
+
+	if err != nil {
+		Logger.Error("API call failed",
+			zap.Error(err),
+			zap.Duration("api_duration_ms", result.APIDuration))
+		if isConnectError(err) {
+			return result, fmt.Errorf("%w: %w: %w", errUpstreamRequest, errUpstreamConnect, err)
+		}
+		return result, fmt.Errorf("%w: %w", errUpstreamRequest, err)
+	}
+
+	if response == nil {
+		Logger.Error("API call returned no response", zap.Duration("api_duration_ms", result.APIDuration))
+		return result, fmt.Errorf("%w: %w", errUpstreamRequest, errUpstreamNoResponse)
+	}
+
+	stepLogger().Info("API response received",
+		zap.Int("statusCode", response.StatusCode),
+		zap.Duration("api_duration_ms", result.APIDuration))
+	defer response.Body.Close()
+	result.StatusCode = response.StatusCode
+
+	if response.StatusCode == http.StatusNotFound {
+		captureUpstream(ctx, url, response.StatusCode, nil)
+		return result, errUpstreamNotFound
+	}
+
+	readStartTime := time.Now()
+	body, err := io.ReadAll(response.Body)
+	result.ReadDuration = time.Since(readStartTime)
+
+	if err != nil {
+		Logger.Error("Error reading response body", zap.Error(err))
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return result, fmt.Errorf("%w: %w: %v", errUpstreamRead, errUpstreamTruncated, err)
+		}
+		return result, fmt.Errorf("%w: %v", errUpstreamRead, err)
+	}
+
+	captureUpstream(ctx, url, response.StatusCode, body)
+
+	Logger.Debug("Response body read",
+		zap.Int("body_size_bytes", len(body)),
+		zap.Duration("read_duration_ms", result.ReadDuration))
+
+	parseStartTime := time.Now()
+	err = json.Unmarshal(body, v)
+	result.ParseDuration = time.Since(parseStartTime)
+
+	if err != nil {
+		Logger.Error("Error unmarshalling response body", zap.Error(err))
+		if isTruncatedJSON(err, int64(len(body)), response.ContentLength) {
+			return result, fmt.Errorf("%w: %w: %v", errUpstreamParse, errUpstreamTruncated, err)
+		}
+		return result, fmt.Errorf("%w: %v", errUpstreamParse, err)
+	}
+
+	return result, nil
+}
+
+// isTruncatedJSON reports whether a JSON decode error comes from a body that ended early,
+// either short of its Content-Length or cut off in the middle of a value
+func isTruncatedJSON(err error, bodyLength int64, contentLength int64) bool {
+	if contentLength > 0 && bodyLength < contentLength {
+		return true
+	}
+	// "unexpected end of JSON input" is reported at the very end of the body
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) && syntaxErr.Offset >= bodyLength
+}
+
+// respondUpstreamError writes the error response for a failed upstream call. Calls that
+// never got an upstream slot are shed with a 503, truncated bodies get a 502 with a retry
+// hint and a missing response a plain 502; everything else gets status.
+func respondUpstreamError(c *gin.Context, err error, status int) {
+	if errors.Is(err, errUpstreamSaturated) {
+		respondShed(c, shedUpstreamUnavailable, "Too many OpenLibrary requests in flight, retry later")
+		return
+	}
+	if errors.Is(err, errUpstreamTruncated) {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":     "OpenLibrary response was cut off, retry the request",
+			"code":      codeUpstreamTruncated,
+			"retryable": true,
+		})
+		return
+	}
+	if errors.Is(err, errUpstreamConnect) {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": upstreamErrorMessage(err),
+			"code":  codeUpstreamConnect,
+		})
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{
+			"error": upstreamErrorMessage(err),
+			"code":  codeUpstreamTimeout,
+		})
+		return
+	}
+	if errors.Is(err, errUpstreamNoResponse) {
+		status = http.StatusBadGateway
+	}
+	c.JSON(status, gin.H{
+		"error": upstreamErrorMessage(err),
+	})
+}
+
+// upstreamErrorMessage maps a fetchOpenLibrary error to the message returned to clients
+func upstreamErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errUpstreamRead):
+		return "Failed to read response body"
+	case errors.Is(err, errUpstreamParse):
+		return "Failed to parse API response"
+	case errors.Is(err, errUpstreamNoResponse):
+		return "Upstream returned no response"
+	case errors.Is(err, errUpstreamConnect):
+		return "Could not connect to OpenLibrary"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "OpenLibrary did not respond in time"
+	default:
+		return "Failed to get search results"
+	}
+}
@@ -0,0 +1,574 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestToSearchQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		match string
+		want  string
+	}{
+		{name: "single word", query: "dune", match: matchDefault, want: "dune"},
+		{name: "default joins with spaces", query: "lord of the rings", match: matchDefault, want: "lord+of+the+rings"},
+		{name: "all joins with AND", query: "tolkien hobbit", match: matchAll, want: "tolkien+AND+hobbit"},
+		{name: "any joins with OR", query: "tolkien hobbit", match: matchAny, want: "tolkien+OR+hobbit"},
+		{name: "single word with all", query: "dune", match: matchAll, want: "dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toSearchQuery(tt.query, tt.match); got != tt.want {
+				t.Errorf("toSearchQuery(%q, %q) = %q, want %q", tt.query, tt.match, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchModes(t *testing.T) {
+	tests := []struct {
+		match         string
+		wantValid     bool
+		wantNamespace string
+	}{
+		{match: matchDefault, wantValid: true, wantNamespace: "search"},
+		{match: matchAll, wantValid: true, wantNamespace: "search:all"},
+		{match: matchAny, wantValid: true, wantNamespace: "search:any"},
+		{match: "some", wantValid: false},
+		{match: "ALL", wantValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.match, func(t *testing.T) {
+			if got := validMatchMode(tt.match); got != tt.wantValid {
+				t.Errorf("validMatchMode(%q) = %v, want %v", tt.match, got, tt.wantValid)
+			}
+			if !tt.wantValid {
+				return
+			}
+			if got := cacheNamespace(tt.match); got != tt.wantNamespace {
+				t.Errorf("cacheNamespace(%q) = %q, want %q", tt.match, got, tt.wantNamespace)
+			}
+		})
+	}
+}
+
+func TestSearchMatchModes(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantQuery  string // q sent upstream
+		wantKey    string // key the result is cached under
+	}{
+		{name: "default", target: "/search?q=tolkien+hobbit", wantStatus: http.StatusOK, wantQuery: "q=tolkien+hobbit", wantKey: "search:tolkien hobbit"},
+		{name: "all", target: "/search?q=tolkien+hobbit&match=all", wantStatus: http.StatusOK, wantQuery: "q=tolkien+AND+hobbit", wantKey: "search:all:tolkien hobbit"},
+		{name: "any", target: "/search?q=tolkien+hobbit&match=any", wantStatus: http.StatusOK, wantQuery: "q=tolkien+OR+hobbit", wantKey: "search:any:tolkien hobbit"},
+		{name: "invalid", target: "/search?q=tolkien+hobbit&match=some", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			c, _ := useCache(t)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("The Hobbit"))
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if upstream.calls() != 1 || !strings.Contains(upstream.urls[0], tt.wantQuery) {
+				t.Errorf("upstream URLs = %v, want one with %q", upstream.urls, tt.wantQuery)
+			}
+			if exists, err := c.Exists(tt.wantKey); err != nil || !exists {
+				t.Errorf("key %q cached = %v (%v), want true", tt.wantKey, exists, err)
+			}
+		})
+	}
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		extended time.Duration // configured extended budget
+		query    string
+		match    string
+		explicit bool // timeout=extended
+		want     time.Duration
+	}{
+		{name: "plain title lookup", query: "the hobbit", match: matchDefault, want: 5 * time.Second},
+		{name: "match all", query: "tolkien hobbit", match: matchAll, want: 5 * time.Second},
+		{name: "match any", query: "tolkien hobbit", match: matchAny, want: 15 * time.Second},
+		{name: "subject search", query: "subject:dragons", match: matchDefault, want: 15 * time.Second},
+		{name: "field search is case insensitive", query: "Place:Paris", match: matchDefault, want: 15 * time.Second},
+		{name: "explicitly extended", query: "the hobbit", match: matchDefault, explicit: true, want: 15 * time.Second},
+		{name: "capped at the hard max", extended: time.Minute, query: "subject:dragons", match: matchDefault, want: constants.UPSTREAM_MAX_TIMEOUT_SECONDS * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.UpstreamTimeout = 5 * time.Second
+				cfg.UpstreamExtendedTimeout = 15 * time.Second
+				if tt.extended != 0 {
+					cfg.UpstreamExtendedTimeout = tt.extended
+				}
+			})
+			if got := upstreamTimeout(tt.query, tt.match, tt.explicit); got != tt.want {
+				t.Errorf("upstreamTimeout(%q, %q, %v) = %v, want %v", tt.query, tt.match, tt.explicit, got, tt.want)
+			}
+		})
+	}
+}
+
+// deadlineUpstream answers like fakeUpstream and records how long each request had left
+type deadlineUpstream struct {
+	fakeUpstream
+	remaining []time.Duration
+}
+
+func (d *deadlineUpstream) Do(req *http.Request) (*http.Response, error) {
+	if deadline, ok := req.Context().Deadline(); ok {
+		d.remaining = append(d.remaining, time.Until(deadline))
+	}
+	return d.fakeUpstream.Do(req)
+}
+
+func TestSearchAppliesUpstreamTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantBudget time.Duration
+	}{
+		{name: "default budget", target: "/search?q=hobbit", wantStatus: http.StatusOK, wantBudget: 5 * time.Second},
+		{name: "extended budget", target: "/search?q=hobbit&timeout=extended", wantStatus: http.StatusOK, wantBudget: 15 * time.Second},
+		{name: "unknown timeout mode", target: "/search?q=hobbit&timeout=long", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.UpstreamTimeout = 5 * time.Second
+				cfg.UpstreamExtendedTimeout = 15 * time.Second
+			})
+			useCache(t)
+			upstream := &deadlineUpstream{fakeUpstream: fakeUpstream{status: http.StatusOK, body: upstreamBody("The Hobbit")}}
+			previous := HTTPClient
+			SetHTTPClient(upstream)
+			t.Cleanup(func() { SetHTTPClient(previous) })
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if len(upstream.remaining) != 1 {
+				t.Fatalf("%d upstream requests carried a deadline, want 1", len(upstream.remaining))
+			}
+			if got := upstream.remaining[0]; got > tt.wantBudget || got < tt.wantBudget-time.Second {
+				t.Errorf("upstream request had %v left, want about %v", got, tt.wantBudget)
+			}
+		})
+	}
+}
+
+func TestIsTruncatedJSON(t *testing.T) {
+	decodeErr := func(body string) error {
+		var v OpenLibraryResponse
+		return json.Unmarshal([]byte(body), &v)
+	}
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		want          bool
+	}{
+		{name: "cut off mid value", body: `{"numFound":1,"docs":[{"title":"Du`, contentLength: -1, want: true},
+		{name: "cut off between values", body: `{"numFound":1,`, contentLength: -1, want: true},
+		{name: "shorter than content length", body: `{"numFound":1}x`, contentLength: 100, want: true},
+		{name: "not JSON", body: `<html>Service Unavailable</html>`, contentLength: -1, want: false},
+		{name: "invalid in the middle", body: `{"numFound":1,,"docs":[]}`, contentLength: -1, want: false},
+		{name: "wrong type", body: `{"numFound":"many"}`, contentLength: -1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeErr(tt.body)
+			if err == nil {
+				t.Fatal("body decoded without an error")
+			}
+			if got := isTruncatedJSON(err, int64(len(tt.body)), tt.contentLength); got != tt.want {
+				t.Errorf("isTruncatedJSON(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+// serverUpstream sends every OpenLibrary request to server instead
+type serverUpstream struct {
+	server *httptest.Server
+}
+
+func (s serverUpstream) Do(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(s.server.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, target.Host
+	return s.server.Client().Do(req)
+}
+
+func TestSearchReportsTruncatedUpstreamBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantCode   interface{}
+	}{
+		{
+			name: "connection closed mid-body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "1000")
+				w.Write([]byte(`{"numFound":1,"docs":[{"title":"Du`))
+				w.(http.Flusher).Flush()
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+			},
+			wantStatus: http.StatusBadGateway,
+			wantCode:   codeUpstreamTruncated,
+		},
+		{
+			name: "body ends mid-value",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"numFound":1,"docs":[{"title":"Du`))
+			},
+			wantStatus: http.StatusBadGateway,
+			wantCode:   codeUpstreamTruncated,
+		},
+		{
+			name: "malformed body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`<html>Service Unavailable</html>`))
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			c, _ := useCache(t)
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			previous := HTTPClient
+			SetHTTPClient(serverUpstream{server: server})
+			t.Cleanup(func() { SetHTTPClient(previous) })
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q=Dune", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %v", body["code"], tt.wantCode)
+			}
+			if tt.wantCode != nil && (body["retryable"] != true || rec.Header().Get("Retry-After") == "") {
+				t.Errorf("truncated response = %v with Retry-After %q, want a retry hint", body, rec.Header().Get("Retry-After"))
+			}
+			if exists, _ := c.Exists("search:dune"); exists {
+				t.Error("partial upstream data was cached")
+			}
+		})
+	}
+}
+
+// nilUpstream is a broken client returning neither a response nor an error
+type nilUpstream struct{}
+
+func (nilUpstream) Do(req *http.Request) (*http.Response, error) {
+	return nil, nil
+}
+
+func TestNilUpstreamResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		route   string
+		target  string
+	}{
+		{name: "search", handler: Search, route: "/search", target: "/search?q=Dune"},
+		{name: "isbn lookup", handler: ISBNLookup, route: "/isbn/:isbn", target: "/isbn/9780441172719"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			previous := HTTPClient
+			SetHTTPClient(nilUpstream{})
+			t.Cleanup(func() { SetHTTPClient(previous) })
+
+			rec := serve(tt.handler, http.MethodGet, tt.route, tt.target, "")
+			if rec.Code != http.StatusBadGateway {
+				t.Fatalf("status code = %d, want 502: %s", rec.Code, rec.Body.String())
+			}
+			if body := decodeBody(t, rec); body["error"] != "Upstream returned no response" {
+				t.Errorf("error = %v, want the missing response reported", body["error"])
+			}
+		})
+	}
+}
+
+func TestCheckEcho(t *testing.T) {
+	const searchURL = "https://openlibrary.org/search.json?q=harry+potter&limit=20"
+	tests := []struct {
+		name     string
+		body     string
+		wantWarn string
+	}{
+		{name: "nothing echoed", body: `{"numFound":0,"docs":[]}`},
+		{name: "same query", body: `{"q":"harry potter","offset":null,"docs":[]}`},
+		{name: "different query", body: `{"q":"harry","docs":[]}`, wantWarn: "OpenLibrary echoed a different query than was sent"},
+		{name: "zero offset", body: `{"q":"harry potter","offset":0,"docs":[]}`},
+		{name: "unexpected offset", body: `{"q":"harry potter","offset":20,"docs":[]}`, wantWarn: "OpenLibrary echoed an unexpected offset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := useObservedLogger(t)
+			var response OpenLibraryResponse
+			if err := json.Unmarshal([]byte(tt.body), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			checkEcho(searchURL, response)
+			warnings := logs.FilterLevelExact(zapcore.WarnLevel).All()
+			if tt.wantWarn == "" {
+				if len(warnings) != 0 {
+					t.Errorf("warned %q, want no warning", warnings[0].Message)
+				}
+				return
+			}
+			if len(warnings) != 1 || warnings[0].Message != tt.wantWarn {
+				t.Fatalf("warnings = %v, want one %q", warnings, tt.wantWarn)
+			}
+			if got := warnings[0].ContextMap()["url"]; got != searchURL {
+				t.Errorf("warning url = %v, want %s", got, searchURL)
+			}
+		})
+	}
+}
+
+func TestSearchWarnsOnEchoMismatch(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	useUpstream(t, http.StatusOK, `{"numFound":1,"q":"something else","docs":[{"key":"/works/OL1W","title":"Dune"}]}`)
+	logs := useObservedLogger(t)
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want the search to succeed anyway: %s", rec.Code, rec.Body.String())
+	}
+	warnings := logs.FilterMessage("OpenLibrary echoed a different query than was sent").All()
+	if len(warnings) != 1 {
+		t.Fatalf("%d echo warnings, want 1", len(warnings))
+	}
+	fields := warnings[0].ContextMap()
+	if fields["sent"] != "dune" || fields["echoed"] != "something else" {
+		t.Errorf("warning fields = %v, want sent dune and echoed something else", fields)
+	}
+}
+
+// clientUpstream sends every OpenLibrary request to target through client
+type clientUpstream struct {
+	client *http.Client
+	target string
+}
+
+func (u clientUpstream) Do(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(u.target)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, target.Host
+	return u.client.Do(req)
+}
+
+func TestIsConnectError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "dial failure", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "wrapped dial failure", err: &url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: errors.New("i/o timeout")}}, want: true},
+		{name: "name not resolved", err: &net.DNSError{Err: "no such host", Name: "openlibrary.org"}, want: true},
+		{name: "read failure", err: &net.OpError{Op: "read", Err: errors.New("connection reset")}, want: false},
+		{name: "deadline", err: context.DeadlineExceeded, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectError(tt.err); got != tt.want {
+				t.Errorf("isConnectError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamConnectVersusResponseTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name        string
+		handler     gin.HandlerFunc
+		route       string
+		target      string
+		upstream    string
+		dialTimeout time.Duration
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "search slow to connect", handler: Search, route: "/search", target: "/search?q=dune", upstream: slow.URL, dialTimeout: time.Nanosecond, wantStatus: http.StatusBadGateway, wantCode: codeUpstreamConnect},
+		{name: "search connection refused", handler: Search, route: "/search", target: "/search?q=dune", upstream: closed.URL, dialTimeout: time.Second, wantStatus: http.StatusBadGateway, wantCode: codeUpstreamConnect},
+		{name: "isbn slow to connect", handler: ISBNLookup, route: "/isbn/:isbn", target: "/isbn/9780441172719", upstream: slow.URL, dialTimeout: time.Nanosecond, wantStatus: http.StatusBadGateway, wantCode: codeUpstreamConnect},
+		{name: "isbn slow to respond", handler: ISBNLookup, route: "/isbn/:isbn", target: "/isbn/9780441172719", upstream: slow.URL, dialTimeout: time.Second, wantStatus: http.StatusGatewayTimeout, wantCode: codeUpstreamTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.UpstreamTimeout = 300 * time.Millisecond
+				cfg.RetryBudgetAttempts = 0
+			})
+			previous := HTTPClient
+			SetHTTPClient(clientUpstream{client: NewUpstreamClient(tt.dialTimeout), target: tt.upstream})
+			t.Cleanup(func() { SetHTTPClient(previous) })
+
+			rec := serve(tt.handler, http.MethodGet, tt.route, tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if body := decodeBody(t, rec); body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestBuildSearchURLFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{name: "every field", want: "https://openlibrary.org/search.json?q=dune&limit=20"},
+		{name: "configured fields", fields: []string{"key", "title", "author_name"}, want: "https://openlibrary.org/search.json?q=dune&limit=20&fields=key%2Ctitle%2Cauthor_name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.SearchUpstreamFields = tt.fields })
+			if got := buildSearchURL("dune", 20); got != tt.want {
+				t.Errorf("buildSearchURL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachePrefix(t *testing.T) {
+	withFields := func(search []string, isbn []string) app.Config {
+		cfg := app.DefaultConfig()
+		cfg.SearchUpstreamFields = search
+		cfg.ISBNUpstreamFields = isbn
+		return cfg
+	}
+	base := CachePrefix("openlibrary", app.DefaultConfig())
+	if base != "openlibrary" {
+		t.Fatalf("CachePrefix with default fields = %q, want openlibrary unchanged", base)
+	}
+
+	prefixes := map[string]string{
+		"search fields":       CachePrefix("openlibrary", withFields([]string{"key", "title"}, nil)),
+		"other search fields": CachePrefix("openlibrary", withFields([]string{"key", "author_name"}, nil)),
+		"isbn fields":         CachePrefix("openlibrary", withFields(nil, []string{"key", "title"})),
+		"both":                CachePrefix("openlibrary", withFields([]string{"key", "title"}, []string{"key", "title"})),
+	}
+	seen := map[string]string{base: "default fields"}
+	for name, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "openlibrary-") {
+			t.Errorf("%s: prefix %q, want openlibrary versioned", name, prefix)
+		}
+		if other, ok := seen[prefix]; ok {
+			t.Errorf("%s and %s share prefix %q", name, other, prefix)
+		}
+		seen[prefix] = name
+	}
+	if again := CachePrefix("openlibrary", withFields([]string{"key", "title"}, nil)); again != prefixes["search fields"] {
+		t.Errorf("CachePrefix changed between calls: %q then %q", prefixes["search fields"], again)
+	}
+}
+
+func TestTrimFields(t *testing.T) {
+	doc := map[string]interface{}{"key": "/books/OL1M", "title": "The Hobbit", "covers": []interface{}{42}}
+	tests := []struct {
+		name   string
+		fields []string
+		want   map[string]interface{}
+	}{
+		{name: "every field", want: doc},
+		{name: "kept fields", fields: []string{"key", "title"}, want: map[string]interface{}{"key": "/books/OL1M", "title": "The Hobbit"}},
+		{name: "missing fields skipped", fields: []string{"key", "isbn_13"}, want: map[string]interface{}{"key": "/books/OL1M"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimFields(doc, tt.fields); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trimFields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchRequestsConfiguredFields(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.SearchUpstreamFields = []string{"key", "title"} })
+	useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+	}
+	if upstream.calls() != 1 || !strings.HasSuffix(upstream.urls[0], "&fields=key%2Ctitle") {
+		t.Errorf("upstream URLs = %v, want one asking for key and title", upstream.urls)
+	}
+}
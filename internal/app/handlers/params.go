@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// SearchParams is the validated form of a search request's query parameters
+type SearchParams struct {
+	Query            string   // q as sent by the client
+	NormalizedQuery  string   // q after normalizeQuery, the canonical cache key
+	Match            string   // matchDefault, matchAll or matchAny
+	Phrase           bool     // q was wrapped in quotes: search the exact phrase, never fuzzy match
+	AvailableOnline  bool     // only return works readable or borrowable online
+	FilterIncomplete bool     // drop docs missing any of the configured required fields
+	Sort             string   // sortRelevance or sortEditions
+	SortBy           string   // a key of sortableFields, or empty
+	SortOrder        string   // sortAsc or sortDesc, when SortBy is set
+	Fields           []string // OpenLibrary doc fields to return per result (all when empty)
+	Format           string   // app.ResultFormatRaw or app.ResultFormatNormalized
+	Facets           []string // keys of facetFields to count across the results
+	Limit            int      // results requested from OpenLibrary; 0 uses the configured default
+	ExtendedTimeout  bool     // timeout=extended
+	Debug            bool     // debug=true, honoured only when DebugResponseFields is on
+	IncludeMetrics   bool     // report responseTime and upstream metrics, IncludeMetrics unless overridden
+}
+
+// SearchQuery is the "+"-joined query sent to OpenLibrary
+func (p SearchParams) SearchQuery() string {
+	if p.Phrase {
+		return toPhraseQuery(p.NormalizedQuery)
+	}
+	return toSearchQuery(p.NormalizedQuery, p.Match)
+}
+
+// Namespace is the cache namespace results for these parameters are stored under
+func (p SearchParams) Namespace() string {
+	if p.Phrase {
+		return phraseNamespace
+	}
+	return cacheNamespace(p.Match)
+}
+
+// RawCacheKey is the key results are cached under when keying on the raw query. The
+// "raw:" segment keeps it apart from normalized keys, which can never contain a colon.
+func (p SearchParams) RawCacheKey() string {
+	return fmt.Sprintf("%s:raw:%s", p.Namespace(), p.Query)
+}
+
+// StoreKey is the canonical key results for these parameters are stored under, in Redis and
+// in the stale fallback: ParamsCacheKey when the request changes the upstream call,
+// RawCacheKey under the raw key strategy, otherwise the normalized query's key. Anything
+// filling or reading that entry directly must go through it.
+func (p SearchParams) StoreKey() string {
+	switch {
+	case p.changesUpstreamCall():
+		return p.ParamsCacheKey()
+	case CurrentConfig().CacheKeyStrategy == app.CacheKeyRaw:
+		return p.RawCacheKey()
+	}
+	return fmt.Sprintf("%s:%s", p.Namespace(), p.NormalizedQuery)
+}
+
+// Values accepted by the sort parameter. Relevance keeps OpenLibrary's order.
+const (
+	sortRelevance = ""
+	sortEditions  = "editions"
+)
+
+// Values accepted by the sortOrder parameter
+const (
+	sortAsc  = "asc"
+	sortDesc = "desc"
+)
+
+// QueryLimit is the number of results requested from OpenLibrary
+func (p SearchParams) QueryLimit() int {
+	if p.Limit > 0 {
+		return p.Limit
+	}
+	return CurrentConfig().DefaultQueryLimit
+}
+
+// UpstreamURL is the OpenLibrary URL these parameters are searched with
+func (p SearchParams) UpstreamURL() string {
+	return buildSearchURL(p.SearchQuery(), p.QueryLimit())
+}
+
+// changesUpstreamCall reports whether the request changes the upstream call beyond q and
+// match (e.g. a non-default limit). Its results can't be shared with the simple query's, so
+// they are cached under ParamsCacheKey alone; simple queries keep their human-readable keys
+// (and variations and fuzzy matching).
+func (p SearchParams) changesUpstreamCall() bool {
+	simple := SearchParams{NormalizedQuery: p.NormalizedQuery, Match: p.Match, Phrase: p.Phrase}
+	return p.UpstreamURL() != simple.UpstreamURL()
+}
+
+// usesURLKey reports whether results are keyed on the upstream URL: only when enabled and
+// the request changes the upstream call
+func (p SearchParams) usesURLKey() bool {
+	return CurrentConfig().CacheKeyURLHash && p.changesUpstreamCall()
+}
+
+// ParamsCacheKey is the key results are cached under when the request changes the upstream
+// call: URLCacheKey when enabled, otherwise the normalized query with the effective limit,
+// e.g. "search:limit=5:dune". Like "raw:", the segment can't collide with normalized keys.
+func (p SearchParams) ParamsCacheKey() string {
+	if p.usesURLKey() {
+		return p.URLCacheKey()
+	}
+	return fmt.Sprintf("%s:limit=%d:%s", p.Namespace(), p.QueryLimit(), p.NormalizedQuery)
+}
+
+// URLCacheKey is the key results are cached under when keyed on the upstream URL: a hash of
+// the canonicalized URL, so any parameter that reaches OpenLibrary yields a distinct entry
+func (p SearchParams) URLCacheKey() string {
+	sum := sha256.Sum256([]byte(canonicalURL(p.UpstreamURL())))
+	return fmt.Sprintf("%s:url:%s", p.Namespace(), hex.EncodeToString(sum[:16]))
+}
+
+// volatileURLParams never affect the results and are left out of URL cache keys
+var volatileURLParams = []string{"_", "cb"}
+
+// canonicalURL sorts the query parameters and drops volatile ones. Unparseable URLs are
+// returned unchanged.
+func canonicalURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	values := parsed.Query()
+	for _, name := range volatileURLParams {
+		values.Del(name)
+	}
+	parsed.RawQuery = values.Encode()
+	return parsed.String()
+}
+
+// quotePairs are the opening and closing quotes that mark a phrase query
+var quotePairs = [][2]string{{`"`, `"`}, {"“", "”"}}
+
+// unquote returns query without its surrounding quotes, reporting whether it had any
+func unquote(query string) (string, bool) {
+	trimmed := strings.TrimSpace(query)
+	for _, pair := range quotePairs {
+		if len(trimmed) > len(pair[0])+len(pair[1]) && strings.HasPrefix(trimmed, pair[0]) && strings.HasSuffix(trimmed, pair[1]) {
+			return trimmed[len(pair[0]) : len(trimmed)-len(pair[1])], true
+		}
+	}
+	return query, false
+}
+
+// fieldNameReg matches an OpenLibrary doc field name, e.g. publish_place
+var fieldNameReg = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// maxProjectedFields bounds the fields parameter
+const maxProjectedFields = 50
+
+// paramError is a client error in the search parameters, reported as a 400
+type paramError struct {
+	message string
+	unknown []string // unrecognized parameter names, in strict mode
+}
+
+func (e *paramError) Error() string {
+	return e.message
+}
+
+// body is the JSON error response for the parameter error
+func (e *paramError) body() gin.H {
+	body := gin.H{"error": e.message}
+	if len(e.unknown) > 0 {
+		body["unknownParams"] = e.unknown
+	}
+	return body
+}
+
+// knownSearchParams is the allowlist of query parameters /api/v1/search understands.
+// Keep it in sync when adding parameters, or strict mode will reject them.
+var knownSearchParams = map[string]bool{
+	"q":                true,
+	"match":            true,
+	"availableOnline":  true,
+	"filterIncomplete": true,
+	"sort":             true,
+	"sortBy":           true,
+	"sortOrder":        true,
+	"fields":           true,
+	"limit":            true,
+	"format":           true,
+	"facets":           true,
+	"timeout":          true,
+	"debug":            true,
+	"includeMetrics":   true,
+}
+
+// unknownParams lists the request's query parameters missing from known, sorted
+func unknownParams(c *gin.Context, known map[string]bool) []string {
+	unknown := []string{}
+	for name := range c.Request.URL.Query() {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// parseSearchParams reads and validates every search parameter. Errors are *paramError.
+func parseSearchParams(c *gin.Context) (SearchParams, error) {
+	var params SearchParams
+
+	// In strict mode unrecognized parameters are rejected so client typos don't go unnoticed
+	if CurrentConfig().StrictQueryParams {
+		if unknown := unknownParams(c, knownSearchParams); len(unknown) > 0 {
+			return params, &paramError{
+				message: "Unknown query parameters: " + strings.Join(unknown, ", "),
+				unknown: unknown,
+			}
+		}
+	}
+
+	params.Query = c.Query("q")
+	if params.Query == "" {
+		return params, &paramError{message: "Search query parameter 'q' is required"}
+	}
+	if phrase, ok := unquote(params.Query); ok && CurrentConfig().QuotedPhraseSearch {
+		params.Phrase = true
+		params.NormalizedQuery = normalizeQuery(phrase)
+	} else {
+		params.NormalizedQuery = normalizeQuery(params.Query)
+	}
+
+	params.Match = c.Query("match")
+	if !validMatchMode(params.Match) {
+		return params, &paramError{message: "Parameter 'match' must be 'all' or 'any'"}
+	}
+	if params.Phrase && params.Match != matchDefault {
+		return params, &paramError{message: "Parameter 'match' can't be combined with a quoted phrase"}
+	}
+
+	switch c.Query("availableOnline") {
+	case "", "false":
+	case "true":
+		params.AvailableOnline = true
+	default:
+		return params, &paramError{message: "Parameter 'availableOnline' must be 'true' or 'false'"}
+	}
+
+	switch c.Query("filterIncomplete") {
+	case "", "false":
+	case "true":
+		params.FilterIncomplete = true
+	default:
+		return params, &paramError{message: "Parameter 'filterIncomplete' must be 'true' or 'false'"}
+	}
+
+	params.Sort = c.Query("sort")
+	if params.Sort != sortRelevance && params.Sort != sortEditions {
+		return params, &paramError{message: "Parameter 'sort' must be 'editions'"}
+	}
+
+	params.SortBy = c.Query("sortBy")
+	params.SortOrder = c.Query("sortOrder")
+	if params.SortBy != "" {
+		if params.Sort != sortRelevance {
+			return params, &paramError{message: "Parameters 'sort' and 'sortBy' can't be combined"}
+		}
+		if _, ok := sortableFields[params.SortBy]; !ok {
+			return params, &paramError{message: "Parameter 'sortBy' must be one of: " + strings.Join(sortableFieldNames(), ", ")}
+		}
+		if params.SortOrder == "" {
+			params.SortOrder = sortAsc
+		}
+		if params.SortOrder != sortAsc && params.SortOrder != sortDesc {
+			return params, &paramError{message: "Parameter 'sortOrder' must be 'asc' or 'desc'"}
+		}
+	} else if params.SortOrder != "" {
+		return params, &paramError{message: "Parameter 'sortOrder' requires 'sortBy'"}
+	}
+
+	if raw := c.Query("fields"); raw != "" {
+		for _, field := range strings.Split(raw, ",") {
+			field = strings.TrimSpace(field)
+			if !fieldNameReg.MatchString(field) {
+				return params, &paramError{message: "Parameter 'fields' must be a comma-separated list of field names"}
+			}
+			params.Fields = append(params.Fields, field)
+		}
+		if len(params.Fields) > maxProjectedFields {
+			return params, &paramError{message: fmt.Sprintf("Parameter 'fields' accepts at most %d fields", maxProjectedFields)}
+		}
+	}
+
+	params.Format = c.DefaultQuery("format", CurrentConfig().ResultFormat)
+	if params.Format != app.ResultFormatRaw && params.Format != app.ResultFormatNormalized {
+		return params, &paramError{message: "Parameter 'format' must be 'raw' or 'normalized'"}
+	}
+	if params.Format == app.ResultFormatNormalized && len(params.Fields) > 0 {
+		return params, &paramError{message: "Parameter 'fields' only applies to format=raw"}
+	}
+
+	if raw := c.Query("facets"); raw != "" {
+		seen := map[string]bool{}
+		for _, name := range strings.Split(raw, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := facetFields[name]; !ok {
+				return params, &paramError{message: "Parameter 'facets' must be a comma-separated list of: " + strings.Join(facetNames(), ", ")}
+			}
+			if !seen[name] {
+				seen[name] = true
+				params.Facets = append(params.Facets, name)
+			}
+		}
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > constants.MAX_QUERY_LIMIT {
+			return params, &paramError{message: fmt.Sprintf("Parameter 'limit' must be between 1 and %d", constants.MAX_QUERY_LIMIT)}
+		}
+		params.Limit = limit
+	}
+
+	switch c.Query("timeout") {
+	case "":
+	case "extended":
+		params.ExtendedTimeout = true
+	default:
+		return params, &paramError{message: "Parameter 'timeout' must be 'extended'"}
+	}
+
+	switch c.Query("debug") {
+	case "", "false":
+	case "true":
+		params.Debug = CurrentConfig().DebugResponseFields
+	default:
+		return params, &paramError{message: "Parameter 'debug' must be 'true' or 'false'"}
+	}
+
+	includeMetrics, err := parseIncludeMetrics(c)
+	if err != nil {
+		return params, err
+	}
+	params.IncludeMetrics = includeMetrics
+
+	return params, nil
+}
+
+// parseIncludeMetrics reads the includeMetrics parameter, which can only turn metrics off:
+// true keeps the IncludeMetrics setting, so clients can't see timings the server hides.
+// Errors are *paramError.
+func parseIncludeMetrics(c *gin.Context) (bool, error) {
+	switch c.Query("includeMetrics") {
+	case "", "true":
+		return CurrentConfig().IncludeMetrics, nil
+	case "false":
+		return false, nil
+	}
+	return false, &paramError{message: "Parameter 'includeMetrics' must be 'true' or 'false'"}
+}
@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+// parseTarget runs parseSearchParams on a GET request for target
+func parseTarget(target string) (SearchParams, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return parseSearchParams(c)
+}
+
+// defaultParams are the parsed parameters for q alone under the default config
+func defaultParams(query string, normalized string) SearchParams {
+	return SearchParams{
+		Query:           query,
+		NormalizedQuery: normalized,
+		Format:          app.ResultFormatRaw,
+		IncludeMetrics:  true,
+	}
+}
+
+func TestParseSearchParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    func(p *SearchParams) // applied to defaultParams for the expected result
+		wantErr string
+	}{
+		{name: "query only", target: "/search?q=The+Hobbit"},
+		{name: "match all", target: "/search?q=The+Hobbit&match=all", want: func(p *SearchParams) { p.Match = matchAll }},
+		{name: "match any", target: "/search?q=The+Hobbit&match=any", want: func(p *SearchParams) { p.Match = matchAny }},
+		{name: "available online", target: "/search?q=The+Hobbit&availableOnline=true", want: func(p *SearchParams) { p.AvailableOnline = true }},
+		{name: "available online off", target: "/search?q=The+Hobbit&availableOnline=false"},
+		{name: "sort by editions", target: "/search?q=The+Hobbit&sort=editions", want: func(p *SearchParams) { p.Sort = sortEditions }},
+		{name: "fields", target: "/search?q=The+Hobbit&fields=title,+publisher,publish_place", want: func(p *SearchParams) { p.Fields = []string{"title", "publisher", "publish_place"} }},
+		{name: "sort by field defaults to ascending", target: "/search?q=The+Hobbit&sortBy=title", want: func(p *SearchParams) { p.SortBy, p.SortOrder = "title", sortAsc }},
+		{name: "sort by field descending", target: "/search?q=The+Hobbit&sortBy=first_publish_year&sortOrder=desc", want: func(p *SearchParams) { p.SortBy, p.SortOrder = "first_publish_year", sortDesc }},
+		{name: "limit", target: "/search?q=The+Hobbit&limit=50", want: func(p *SearchParams) { p.Limit = 50 }},
+		{name: "limit at the maximum", target: fmt.Sprintf("/search?q=The+Hobbit&limit=%d", constants.MAX_QUERY_LIMIT), want: func(p *SearchParams) { p.Limit = constants.MAX_QUERY_LIMIT }},
+		{name: "extended timeout", target: "/search?q=The+Hobbit&timeout=extended", want: func(p *SearchParams) { p.ExtendedTimeout = true }},
+		{
+			name:   "combined",
+			target: "/search?q=The+Hobbit&match=all&availableOnline=true&timeout=extended",
+			want: func(p *SearchParams) {
+				p.Match = matchAll
+				p.AvailableOnline = true
+				p.ExtendedTimeout = true
+			},
+		},
+		{name: "missing query", target: "/search", wantErr: "Search query parameter 'q' is required"},
+		{name: "empty query", target: "/search?q=", wantErr: "Search query parameter 'q' is required"},
+		{name: "invalid match", target: "/search?q=The+Hobbit&match=some", wantErr: "Parameter 'match' must be 'all' or 'any'"},
+		{name: "invalid available online", target: "/search?q=The+Hobbit&availableOnline=1", wantErr: "Parameter 'availableOnline' must be 'true' or 'false'"},
+		{name: "invalid sort", target: "/search?q=The+Hobbit&sort=year", wantErr: "Parameter 'sort' must be 'editions'"},
+		{name: "invalid field name", target: "/search?q=The+Hobbit&fields=title,Publisher", wantErr: "Parameter 'fields' must be a comma-separated list of field names"},
+		{name: "empty field name", target: "/search?q=The+Hobbit&fields=title,,publisher", wantErr: "Parameter 'fields' must be a comma-separated list of field names"},
+		{name: "too many fields", target: "/search?q=The+Hobbit&fields=" + strings.Repeat("title,", maxProjectedFields) + "key", wantErr: fmt.Sprintf("Parameter 'fields' accepts at most %d fields", maxProjectedFields)},
+		{name: "unsortable field", target: "/search?q=The+Hobbit&sortBy=publisher", wantErr: "Parameter 'sortBy' must be one of: edition_count, first_publish_year, title"},
+		{name: "invalid sort order", target: "/search?q=The+Hobbit&sortBy=title&sortOrder=up", wantErr: "Parameter 'sortOrder' must be 'asc' or 'desc'"},
+		{name: "sort order without field", target: "/search?q=The+Hobbit&sortOrder=asc", wantErr: "Parameter 'sortOrder' requires 'sortBy'"},
+		{name: "sort and sortBy combined", target: "/search?q=The+Hobbit&sort=editions&sortBy=title", wantErr: "Parameters 'sort' and 'sortBy' can't be combined"},
+		{name: "zero limit", target: "/search?q=The+Hobbit&limit=0", wantErr: fmt.Sprintf("Parameter 'limit' must be between 1 and %d", constants.MAX_QUERY_LIMIT)},
+		{name: "limit over the maximum", target: fmt.Sprintf("/search?q=The+Hobbit&limit=%d", constants.MAX_QUERY_LIMIT+1), wantErr: fmt.Sprintf("Parameter 'limit' must be between 1 and %d", constants.MAX_QUERY_LIMIT)},
+		{name: "non-numeric limit", target: "/search?q=The+Hobbit&limit=ten", wantErr: fmt.Sprintf("Parameter 'limit' must be between 1 and %d", constants.MAX_QUERY_LIMIT)},
+		{name: "debug ignored unless enabled", target: "/search?q=The+Hobbit&debug=true"},
+		{name: "invalid debug", target: "/search?q=The+Hobbit&debug=1", wantErr: "Parameter 'debug' must be 'true' or 'false'"},
+		{name: "normalized format", target: "/search?q=The+Hobbit&format=normalized", want: func(p *SearchParams) { p.Format = app.ResultFormatNormalized }},
+		{name: "raw format", target: "/search?q=The+Hobbit&format=raw"},
+		{name: "invalid format", target: "/search?q=The+Hobbit&format=books", wantErr: "Parameter 'format' must be 'raw' or 'normalized'"},
+		{name: "fields with normalized format", target: "/search?q=The+Hobbit&format=normalized&fields=title", wantErr: "Parameter 'fields' only applies to format=raw"},
+		{name: "metrics off", target: "/search?q=The+Hobbit&includeMetrics=false", want: func(p *SearchParams) { p.IncludeMetrics = false }},
+		{name: "metrics on", target: "/search?q=The+Hobbit&includeMetrics=true"},
+		{name: "invalid include metrics", target: "/search?q=The+Hobbit&includeMetrics=no", wantErr: "Parameter 'includeMetrics' must be 'true' or 'false'"},
+		{name: "facets", target: "/search?q=The+Hobbit&facets=language,+subject", want: func(p *SearchParams) { p.Facets = []string{"language", "subject"} }},
+		{name: "repeated facet", target: "/search?q=The+Hobbit&facets=subject,subject", want: func(p *SearchParams) { p.Facets = []string{"subject"} }},
+		{name: "unknown facet", target: "/search?q=The+Hobbit&facets=language,author", wantErr: "Parameter 'facets' must be a comma-separated list of: language, subject"},
+		{name: "invalid timeout", target: "/search?q=The+Hobbit&timeout=long", wantErr: "Parameter 'timeout' must be 'extended'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			got, err := parseTarget(tt.target)
+			if tt.wantErr != "" {
+				var paramErr *paramError
+				if !errors.As(err, &paramErr) || paramErr.message != tt.wantErr {
+					t.Fatalf("error = %v, want a paramError %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := defaultParams("The Hobbit", "the hobbit")
+			if tt.want != nil {
+				tt.want(&want)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parseSearchParams = %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSearchStrictQueryParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		strict      bool
+		target      string
+		wantStatus  int
+		wantUnknown []interface{}
+	}{
+		{name: "lenient ignores unknown", target: "/search?q=dune&lang=en", wantStatus: http.StatusOK},
+		{name: "strict rejects unknown", strict: true, target: "/search?q=dune&lang=en", wantStatus: http.StatusBadRequest, wantUnknown: []interface{}{"lang"}},
+		{name: "strict lists every unknown sorted", strict: true, target: "/search?q=dune&zz=1&Limit=5", wantStatus: http.StatusBadRequest, wantUnknown: []interface{}{"Limit", "zz"}},
+		{name: "strict accepts known", strict: true, target: "/search?q=dune&match=all&limit=5&availableOnline=false", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.StrictQueryParams = tt.strict })
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusBadRequest {
+				return
+			}
+			body := decodeBody(t, rec)
+			if !reflect.DeepEqual(body["unknownParams"], tt.wantUnknown) {
+				t.Errorf("unknownParams = %v, want %v", body["unknownParams"], tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestStoreKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		target   string
+		want     string
+	}{
+		{name: "normalized", strategy: app.CacheKeyNormalized, target: "/search?q=Dune!", want: "search:dune"},
+		{name: "both stores normalized first", strategy: app.CacheKeyBoth, target: "/search?q=Dune!", want: "search:dune"},
+		{name: "raw", strategy: app.CacheKeyRaw, target: "/search?q=Dune!", want: "search:raw:Dune!"},
+		{name: "raw in a match namespace", strategy: app.CacheKeyRaw, target: "/search?q=Dune&match=all", want: "search:all:raw:Dune"},
+		{name: "non-default limit", strategy: app.CacheKeyNormalized, target: "/search?q=Dune!&limit=5", want: "search:limit=5:dune"},
+		{name: "non-default limit under raw", strategy: app.CacheKeyRaw, target: "/search?q=Dune!&limit=5", want: "search:limit=5:dune"},
+		{name: "default limit given explicitly", strategy: app.CacheKeyNormalized, target: "/search?q=Dune!&limit=20", want: "search:dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.CacheKeyStrategy = tt.strategy })
+			params, err := parseTarget(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := params.StoreKey(); got != tt.want {
+				t.Errorf("StoreKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLCacheKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        string // request targets whose keys are compared
+		wantURLKeys bool   // whether both are keyed on the upstream URL
+		wantSame    bool
+	}{
+		{name: "simple queries keep readable keys", a: "/search?q=dune", b: "/search?q=emma", wantURLKeys: false},
+		{name: "default limit is a simple query", a: "/search?q=dune&limit=20", b: "/search?q=dune", wantURLKeys: false},
+		{name: "same limit", a: "/search?q=dune&limit=5", b: "/search?q=dune&limit=5", wantURLKeys: true, wantSame: true},
+		{name: "parameter order and casing", a: "/search?q=Dune&limit=5", b: "/search?limit=5&q=dune", wantURLKeys: true, wantSame: true},
+		{name: "different limits", a: "/search?q=dune&limit=5", b: "/search?q=dune&limit=10", wantURLKeys: true},
+		{name: "different queries", a: "/search?q=dune&limit=5", b: "/search?q=emma&limit=5", wantURLKeys: true},
+		{name: "different match modes", a: "/search?q=frank+herbert&limit=5", b: "/search?q=frank+herbert&limit=5&match=all", wantURLKeys: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.CacheKeyURLHash = true })
+			a, errA := parseTarget(tt.a)
+			b, errB := parseTarget(tt.b)
+			if errA != nil || errB != nil {
+				t.Fatal(errA, errB)
+			}
+			if a.usesURLKey() != tt.wantURLKeys || b.usesURLKey() != tt.wantURLKeys {
+				t.Fatalf("usesURLKey = %v, %v; want %v", a.usesURLKey(), b.usesURLKey(), tt.wantURLKeys)
+			}
+			if !tt.wantURLKeys {
+				return
+			}
+			if same := a.URLCacheKey() == b.URLCacheKey(); same != tt.wantSame {
+				t.Errorf("URLCacheKey %q vs %q: same = %v, want %v", a.URLCacheKey(), b.URLCacheKey(), same, tt.wantSame)
+			}
+			if !strings.HasPrefix(a.URLCacheKey(), a.Namespace()+":url:") {
+				t.Errorf("URLCacheKey = %q, want it under %s:url:", a.URLCacheKey(), a.Namespace())
+			}
+		})
+	}
+}
+
+func TestURLCacheKeyDisabled(t *testing.T) {
+	useConfig(t, nil)
+	params, err := parseTarget("/search?q=dune&limit=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.usesURLKey() {
+		t.Error("usesURLKey = true with CacheKeyURLHash off by default")
+	}
+	if !params.changesUpstreamCall() {
+		t.Error("changesUpstreamCall = false for a non-default limit")
+	}
+	if got, want := params.ParamsCacheKey(), "search:limit=5:dune"; got != want {
+		t.Errorf("ParamsCacheKey = %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "sorted parameters", url: "https://openlibrary.org/search.json?q=dune&limit=5", want: "https://openlibrary.org/search.json?limit=5&q=dune"},
+		{name: "volatile parameters dropped", url: "https://openlibrary.org/search.json?q=dune&_=123&cb=abc", want: "https://openlibrary.org/search.json?q=dune"},
+		{name: "unparseable", url: "://bad url", want: "://bad url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalURL(tt.url); got != tt.want {
+				t.Errorf("canonicalURL(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchCachesUnderURLKey(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.CacheKeyURLHash = true })
+	c, _ := useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+	for _, target := range []string{"/search?q=dune&limit=5", "/search?q=dune&limit=10", "/search?q=dune&limit=5"} {
+		if rec := serve(Search, http.MethodGet, "/search", target, ""); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status code = %d, want 200", target, rec.Code)
+		}
+	}
+	if got := upstream.calls(); got != 2 {
+		t.Errorf("upstream called %d times, want once per distinct limit", got)
+	}
+	keys, err := c.Scan("search:url:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("URL keys = %q, want one per distinct limit", keys)
+	}
+	if exists, _ := c.Exists("search:dune"); exists {
+		t.Error("a URL-keyed result was also stored under the readable key")
+	}
+}
+
+func TestSearchCachesEachLimit(t *testing.T) {
+	useConfig(t, nil)
+	c, _ := useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune", "Dune Messiah", "Children of Dune", "God Emperor of Dune", "Heretics of Dune"))
+
+	tests := []struct {
+		target     string
+		wantCached bool
+		wantLimit  float64
+	}{
+		{target: "/search?q=dune&limit=5", wantCached: false, wantLimit: 5},
+		{target: "/search?q=dune&limit=2", wantCached: false, wantLimit: 2},
+		{target: "/search?q=dune&limit=2", wantCached: true, wantLimit: 2},
+		{target: "/search?q=dune", wantCached: false, wantLimit: 20},
+	}
+	for _, tt := range tests {
+		rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status code = %d, want 200", tt.target, rec.Code)
+		}
+		body := decodeBody(t, rec)
+		if body["cached"] != tt.wantCached {
+			t.Errorf("%s: cached = %v, want %v", tt.target, body["cached"], tt.wantCached)
+		}
+		if body["limitApplied"] != tt.wantLimit {
+			t.Errorf("%s: limitApplied = %v, want %v", tt.target, body["limitApplied"], tt.wantLimit)
+		}
+	}
+	if got := upstream.calls(); got != 3 {
+		t.Errorf("upstream called %d times, want once per distinct limit", got)
+	}
+	for _, key := range []string{"search:limit=5:dune", "search:limit=2:dune", "search:dune"} {
+		if exists, _ := c.Exists(key); !exists {
+			t.Errorf("%s not cached", key)
+		}
+	}
+}
+
+func TestSearchLimitsDontShareLoads(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	upstream := useSlowUpstream(t, 50*time.Millisecond, upstreamBody("Dune"))
+
+	var wg sync.WaitGroup
+	for _, target := range []string{"/search?q=dune&limit=5", "/search?q=dune&limit=2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := serve(Search, http.MethodGet, "/search", target, ""); rec.Code != http.StatusOK {
+				t.Errorf("%s: status code = %d, want 200", target, rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+	if len(upstream.requests) != 2 {
+		t.Errorf("upstream URLs = %v, want one per limit", upstream.requests)
+	}
+}
+
+func TestSearchQueryLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultLimit int
+		target       string
+		wantLimit    int
+	}{
+		{name: "default", target: "/search?q=dune", wantLimit: constants.DEFAULT_QUERY_LIMIT},
+		{name: "configured default", defaultLimit: 40, target: "/search?q=dune", wantLimit: 40},
+		{name: "request limit", target: "/search?q=dune&limit=5", wantLimit: 5},
+		{name: "request limit overrides the configured default", defaultLimit: 40, target: "/search?q=dune&limit=5", wantLimit: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				if tt.defaultLimit > 0 {
+					cfg.DefaultQueryLimit = tt.defaultLimit
+				}
+			})
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			if upstream.calls() != 1 {
+				t.Fatalf("%d upstream calls, want 1", upstream.calls())
+			}
+			if want := fmt.Sprintf("&limit=%d", tt.wantLimit); !strings.HasSuffix(upstream.urls[0], want) {
+				t.Errorf("upstream URL = %s, want it to end in %s", upstream.urls[0], want)
+			}
+		})
+	}
+}
+
+func TestParseSearchParamsDebugEnabled(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.DebugResponseFields = true })
+	for target, want := range map[string]bool{
+		"/search?q=dune":             false,
+		"/search?q=dune&debug=false": false,
+		"/search?q=dune&debug=true":  true,
+	} {
+		params, err := parseTarget(target)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if params.Debug != want {
+			t.Errorf("%s: Debug = %v, want %v", target, params.Debug, want)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		want   string
+		wantOK bool
+	}{
+		{name: "straight quotes", query: `"the hobbit"`, want: "the hobbit", wantOK: true},
+		{name: "curly quotes", query: "\u201cthe hobbit\u201d", want: "the hobbit", wantOK: true},
+		{name: "surrounding spaces", query: `  "the hobbit" `, want: "the hobbit", wantOK: true},
+		{name: "not quoted", query: "the hobbit", want: "the hobbit"},
+		{name: "opening quote only", query: `"the hobbit`, want: `"the hobbit`},
+		{name: "quotes inside", query: `the "hobbit"`, want: `the "hobbit"`},
+		{name: "empty quotes", query: `""`, want: `""`},
+		{name: "mismatched quotes", query: "\u201cthe hobbit\"", want: "\u201cthe hobbit\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := unquote(tt.query)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("unquote(%q) = %q, %v; want %q, %v", tt.query, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseSearchParamsQuotedPhrase(t *testing.T) {
+	tests := []struct {
+		name           string
+		enabled        bool
+		target         string
+		wantPhrase     bool
+		wantNormalized string
+		wantErr        string
+	}{
+		{name: "disabled by default", target: "/search?q=%22The+Hobbit%22", wantNormalized: "the hobbit"},
+		{name: "quoted", enabled: true, target: "/search?q=%22The+Hobbit%22", wantPhrase: true, wantNormalized: "the hobbit"},
+		{name: "unquoted", enabled: true, target: "/search?q=The+Hobbit", wantNormalized: "the hobbit"},
+		{name: "match can't be combined", enabled: true, target: "/search?q=%22The+Hobbit%22&match=all", wantErr: "Parameter 'match' can't be combined with a quoted phrase"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				if tt.enabled {
+					cfg.QuotedPhraseSearch = true
+				}
+			})
+			params, err := parseTarget(tt.target)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params.Phrase != tt.wantPhrase || params.NormalizedQuery != tt.wantNormalized {
+				t.Errorf("Phrase, NormalizedQuery = %v, %q; want %v, %q", params.Phrase, params.NormalizedQuery, tt.wantPhrase, tt.wantNormalized)
+			}
+			if want := cacheNamespace(matchDefault); !tt.wantPhrase && params.Namespace() != want {
+				t.Errorf("Namespace = %s, want %s", params.Namespace(), want)
+			}
+			if tt.wantPhrase && params.Namespace() != phraseNamespace {
+				t.Errorf("Namespace = %s, want %s", params.Namespace(), phraseNamespace)
+			}
+		})
+	}
+}
+
+func TestSearchQuotedPhrase(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		wantFuzzy     bool
+		wantURLSuffix string
+	}{
+		{name: "phrase search skips fuzzy matching", enabled: true, wantURLSuffix: "q=%22the+hobbit%22"},
+		{name: "quotes ignored when disabled", wantFuzzy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.QuotedPhraseSearch = tt.enabled })
+			useCache(t)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("The Hobbit"))
+			cacheResults(t, "search:the hobit", upstreamBody("The Hobbit"))
+			indexQueries(t, "search", "the hobit")
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q=%22The+Hobbit%22", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if got := body["fuzzyMatch"] == true; got != tt.wantFuzzy {
+				t.Fatalf("fuzzyMatch = %v, want %v", body["fuzzyMatch"], tt.wantFuzzy)
+			}
+			if tt.wantFuzzy {
+				return
+			}
+			if upstream.calls() != 1 {
+				t.Fatalf("%d upstream calls, want 1", upstream.calls())
+			}
+			if !strings.Contains(upstream.urls[0], tt.wantURLSuffix) {
+				t.Errorf("upstream URL = %s, want a phrase search %s", upstream.urls[0], tt.wantURLSuffix)
+			}
+
+			// The phrase is cached under its own namespace, so repeating it is an exact hit
+			rec = serve(Search, http.MethodGet, "/search", "/search?q=%22the+hobbit%22", "")
+			if body := decodeBody(t, rec); body["source"] != sourceL2Exact {
+				t.Errorf("repeated phrase source = %v, want %s", body["source"], sourceL2Exact)
+			}
+			if upstream.calls() != 1 {
+				t.Errorf("%d upstream calls after repeating the phrase, want 1", upstream.calls())
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"go.uber.org/zap"
+)
+
+// Values of the source field, telling clients exactly where a search result came from
+const (
+	sourceL2Exact       = "l2-exact"
+	sourceL2Fuzzy       = "l2-fuzzy"
+	sourceL2Assembled   = "l2-assembled"
+	sourceUpstream      = "upstream"
+	sourceStaleFallback = "stale-fallback"
+)
+
+// unknownAge marks a cached result whose write time isn't recorded
+const unknownAge = time.Duration(-1)
+
+// searchResponse builds the fields shared by every search response. query echoes what the
+// client sent and normalizedQuery what was actually looked up and cached. age is how long
+// ago a cached result was stored and is reported as ageSeconds unless it is unknownAge.
+// Result filters from params are applied here so every path honours them. responseTime is
+// left out when params.IncludeMetrics is off.
+// Callers add path-specific fields before writing it.
+func searchResponse(params SearchParams, data OpenLibraryResponse, source string, age time.Duration, startTime time.Time) gin.H {
+	totalDuration := time.Since(startTime)
+	results := sortResults(params, filterResults(params, data.Docs))
+	body := gin.H{
+		"query":           params.Query,
+		"normalizedQuery": params.NormalizedQuery,
+		"numFound":        data.NumFound,
+		"results":         formatResults(params, results),
+		"cached":          source != sourceUpstream,
+		"source":          source,
+	}
+	if params.IncludeMetrics {
+		body["responseTime"] = fmt.Sprintf("%.2fms", totalDuration.Seconds()*1000)
+	}
+	// limitApplied is what was asked of OpenLibrary and actualReturned what came back (before
+	// result filters). limitCapped flags that more matches exist than were returned, e.g.
+	// when OpenLibrary caps the page size or the cached entry was fetched with a lower limit.
+	body["limitApplied"] = params.QueryLimit()
+	body["actualReturned"] = len(data.Docs)
+	if len(data.Docs) < params.QueryLimit() && data.NumFound > len(data.Docs) {
+		body["limitCapped"] = true
+	}
+	if len(params.Facets) > 0 {
+		body["facets"] = computeFacets(params.Facets, results)
+	}
+	if emptyButFound(data) {
+		body["note"] = fmt.Sprintf("OpenLibrary reported %d matches but returned none; retry later or refine the query", data.NumFound)
+	}
+	if params.AvailableOnline || params.FilterIncomplete {
+		body["numFiltered"] = len(data.Docs) - len(results)
+	}
+	if source != sourceUpstream && age != unknownAge {
+		body["ageSeconds"] = int64(age.Seconds())
+	}
+	if params.Debug {
+		addDebugFields(params, body)
+	}
+	return body
+}
+
+// writeSearchResponse sends a successful search response. X-Num-Found and X-Cached are set
+// on every response so HEAD requests, which get the same headers without a body, can check
+// whether a query has results without downloading them.
+func writeSearchResponse(c *gin.Context, body gin.H) {
+	c.Header("X-Num-Found", fmt.Sprint(body["numFound"]))
+	c.Header("X-Cached", fmt.Sprint(body["cached"]))
+	c.Set(searchSourceKey, body["source"])
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// filterResults post-filters docs by the request's result filters. numFound still reports
+// the upstream total.
+func filterResults(params SearchParams, docs []map[string]interface{}) []map[string]interface{} {
+	if !params.AvailableOnline && !params.FilterIncomplete {
+		return docs
+	}
+	required := CurrentConfig().RequiredResultFields
+	filtered := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		if params.AvailableOnline && !mapDocToBook(doc).AvailableOnline() {
+			continue
+		}
+		if params.FilterIncomplete && !hasFields(doc, required) {
+			continue
+		}
+		filtered = append(filtered, doc)
+	}
+	return filtered
+}
+
+// sortResults orders docs as requested by params. With sort=editions the works with the most
+// editions come first; docs without edition_count sort last, and ties keep upstream order.
+// sortBy sorts on a mapped Book field instead.
+func sortResults(params SearchParams, docs []map[string]interface{}) []map[string]interface{} {
+	if params.SortBy != "" {
+		return sortResultsBy(docs, sortableFields[params.SortBy], params.SortOrder == sortDesc)
+	}
+	if params.Sort != sortEditions {
+		return docs
+	}
+	sorted := make([]map[string]interface{}, len(docs))
+	copy(sorted, docs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return docInt(sorted[i], "edition_count") > docInt(sorted[j], "edition_count")
+	})
+	return sorted
+}
+
+// sortableField compares books on one field. Books where present is false have no value.
+type sortableField struct {
+	present func(b Book) bool
+	compare func(a, b Book) int
+}
+
+// sortableFields are the values accepted by the sortBy parameter, named after the
+// OpenLibrary doc fields they come from
+var sortableFields = map[string]sortableField{
+	"first_publish_year": {
+		present: func(b Book) bool { return b.FirstPublishYear != 0 },
+		compare: func(a, b Book) int { return cmp.Compare(a.FirstPublishYear, b.FirstPublishYear) },
+	},
+	"title": {
+		present: func(b Book) bool { return b.Title != "" },
+		compare: func(a, b Book) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+	},
+	"edition_count": {
+		present: func(b Book) bool { return b.EditionCount != 0 },
+		compare: func(a, b Book) int { return cmp.Compare(a.EditionCount, b.EditionCount) },
+	},
+}
+
+// sortableFieldNames lists the sortBy values, sorted
+func sortableFieldNames() []string {
+	names := make([]string, 0, len(sortableFields))
+	for name := range sortableFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortResultsBy stable-sorts a copy of docs on field. Docs missing the field go last in
+// either direction, and ties keep upstream order.
+func sortResultsBy(docs []map[string]interface{}, field sortableField, descending bool) []map[string]interface{} {
+	books := mapDocsToBooks(docs)
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := books[order[i]], books[order[j]]
+		aPresent, bPresent := field.present(a), field.present(b)
+		if !aPresent || !bPresent {
+			return aPresent && !bPresent
+		}
+		if descending {
+			return field.compare(a, b) > 0
+		}
+		return field.compare(a, b) < 0
+	})
+
+	sorted := make([]map[string]interface{}, len(docs))
+	for i, index := range order {
+		sorted[i] = docs[index]
+	}
+	return sorted
+}
+
+// projectResults trims each doc to the requested fields, always keeping the work key so
+// clients can follow up on a result. Docs missing a requested field simply omit it.
+func projectResults(params SearchParams, docs []map[string]interface{}) []map[string]interface{} {
+	if len(params.Fields) == 0 {
+		return docs
+	}
+	projected := make([]map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		trimmed := make(map[string]interface{}, len(params.Fields)+1)
+		if key, ok := doc["key"]; ok {
+			trimmed["key"] = key
+		}
+		for _, field := range params.Fields {
+			if value, ok := doc[field]; ok {
+				trimmed[field] = value
+			}
+		}
+		projected[i] = trimmed
+	}
+	return projected
+}
+
+// formatResults shapes the filtered, sorted docs for the response: raw docs (projected to
+// the requested fields) or normalized books. Either way the cache holds raw docs, so one
+// entry can serve both formats.
+func formatResults(params SearchParams, docs []map[string]interface{}) interface{} {
+	if params.Format != app.ResultFormatNormalized {
+		return renameResultFields(projectResults(params, docs))
+	}
+	books := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		books[i] = bookResponse(mapDocToBook(doc))
+	}
+	return books
+}
+
+// renameResultFields applies the configured ResultFieldNames to each doc. Docs are copied
+// so cached and shared maps are never modified.
+func renameResultFields(docs []map[string]interface{}) []map[string]interface{} {
+	names := CurrentConfig().ResultFieldNames
+	if len(names) == 0 {
+		return docs
+	}
+	renamed := make([]map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		renamed[i] = renameFields(doc, names)
+	}
+	return renamed
+}
+
+// renameFields returns a copy of fields with each key in names replaced by its new name.
+// Keys without a mapping keep theirs.
+func renameFields(fields map[string]interface{}, names map[string]string) map[string]interface{} {
+	renamed := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if newName, ok := names[key]; ok {
+			key = newName
+		}
+		renamed[key] = value
+	}
+	return renamed
+}
+
+// bookResponse is a Book as returned to clients, with the configured ResultFieldNames
+// applied to its JSON field names
+func bookResponse(book Book) interface{} {
+	names := CurrentConfig().ResultFieldNames
+	if len(names) == 0 {
+		return book
+	}
+	data, err := json.Marshal(book)
+	if err != nil {
+		return book
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return book
+	}
+	return renameFields(fields, names)
+}
+
+// cachedAge looks up how long ago a cached query was written using its recency index score
+func cachedAge(namespace string, cachedQuery string) time.Duration {
+	writtenAt, err := Cache.IndexTime(recentIndexKey(namespace), cachedQuery)
+	if err != nil {
+		Logger.Debug("No write time recorded for cached query",
+			zap.String("query", cachedQuery),
+			zap.Error(err))
+		return unknownAge
+	}
+	return time.Since(writtenAt)
+}
@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestSearchReportsSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		targets    []string // requested in order; the last response is checked
+		wantSource string
+		wantCached bool
+		wantAge    bool
+	}{
+		{
+			name:       "miss fetched upstream",
+			targets:    []string{"/search?q=Project+Hail+Mary"},
+			wantSource: sourceUpstream,
+		},
+		{
+			name:       "exact hit",
+			targets:    []string{"/search?q=Project+Hail+Mary", "/search?q=Project+Hail+Mary"},
+			wantSource: sourceL2Exact,
+			wantCached: true,
+			wantAge:    true,
+		},
+		{
+			name:       "fuzzy hit",
+			targets:    []string{"/search?q=Project+Hail+Mary", "/search?q=Projct+Hail+Mary"},
+			wantSource: sourceL2Fuzzy,
+			wantCached: true,
+			wantAge:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Project Hail Mary"))
+
+			rec := serve(Search, http.MethodGet, "/search", tt.targets[0], "")
+			for _, target := range tt.targets[1:] {
+				rec = serve(Search, http.MethodGet, "/search", target, "")
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if body["source"] != tt.wantSource {
+				t.Errorf("source = %v, want %s", body["source"], tt.wantSource)
+			}
+			if body["cached"] != tt.wantCached {
+				t.Errorf("cached = %v, want %v", body["cached"], tt.wantCached)
+			}
+			age, hasAge := body["ageSeconds"].(float64)
+			if hasAge != tt.wantAge {
+				t.Errorf("ageSeconds present = %v, want %v", hasAge, tt.wantAge)
+			}
+			if hasAge && age < 0 {
+				t.Errorf("ageSeconds = %v, want a non-negative age", age)
+			}
+			if got := rec.Header().Get("X-Cached"); got != fmt.Sprint(tt.wantCached) {
+				t.Errorf("X-Cached = %q, want %v", got, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestSortResultsBy(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"key": "/works/OL1W", "title": "dune messiah", "first_publish_year": float64(1969)},
+		{"key": "/works/OL2W", "title": "Children of Dune"},
+		{"key": "/works/OL3W", "first_publish_year": float64(1965)},
+		{"key": "/works/OL4W", "title": "Dune", "first_publish_year": float64(1965)},
+		{"key": "/works/OL5W", "title": "Chapterhouse: Dune", "first_publish_year": float64(1985)},
+	}
+	tests := []struct {
+		name   string
+		target string
+		want   []string // work keys in order
+	}{
+		{name: "year ascending, ties in upstream order, missing last", target: "/search?q=dune&sortBy=first_publish_year", want: []string{"/works/OL3W", "/works/OL4W", "/works/OL1W", "/works/OL5W", "/works/OL2W"}},
+		{name: "year descending, missing still last", target: "/search?q=dune&sortBy=first_publish_year&sortOrder=desc", want: []string{"/works/OL5W", "/works/OL1W", "/works/OL3W", "/works/OL4W", "/works/OL2W"}},
+		{name: "title ascending ignores case", target: "/search?q=dune&sortBy=title", want: []string{"/works/OL5W", "/works/OL2W", "/works/OL4W", "/works/OL1W", "/works/OL3W"}},
+		{name: "title descending", target: "/search?q=dune&sortBy=title&sortOrder=desc", want: []string{"/works/OL1W", "/works/OL4W", "/works/OL2W", "/works/OL5W", "/works/OL3W"}},
+		{name: "unsorted", target: "/search?q=dune", want: []string{"/works/OL1W", "/works/OL2W", "/works/OL3W", "/works/OL4W", "/works/OL5W"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			params, err := parseTarget(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, doc := range sortResults(params, docs) {
+				got = append(got, docString(doc, "key"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %q, want %q", got, tt.want)
+			}
+			if docString(docs[0], "key") != "/works/OL1W" {
+				t.Error("sorting reordered the docs it was given")
+			}
+		})
+	}
+}
+
+func TestSearchHead(t *testing.T) {
+	tests := []struct {
+		name         string
+		titles       []string // upstream results
+		warm         bool     // a GET caches the query first
+		wantNumFound string
+		wantCached   string
+	}{
+		{name: "miss", titles: []string{"Dune", "Dune Messiah"}, wantNumFound: "2", wantCached: "false"},
+		{name: "hit", titles: []string{"Dune", "Dune Messiah"}, warm: true, wantNumFound: "2", wantCached: "true"},
+		{name: "no results", wantNumFound: "0", wantCached: "false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody(tt.titles...))
+			if tt.warm {
+				serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+				WaitBackground(time.Second)
+			}
+
+			rec := serve(Search, http.MethodHead, "/search", "/search?q=dune", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d, want 200", rec.Code)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("HEAD response has a body: %s", rec.Body.String())
+			}
+			if got := rec.Header().Get("X-Num-Found"); got != tt.wantNumFound {
+				t.Errorf("X-Num-Found = %q, want %s", got, tt.wantNumFound)
+			}
+			if got := rec.Header().Get("X-Cached"); got != tt.wantCached {
+				t.Errorf("X-Cached = %q, want %s", got, tt.wantCached)
+			}
+			if got := upstream.calls(); got != 1 {
+				t.Errorf("%d upstream calls, want 1", got)
+			}
+		})
+	}
+}
+
+func TestSearchGetSetsHeadHeaders(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Dune", "Dune Messiah"))
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+	body := decodeBody(t, rec)
+	if got := rec.Header().Get("X-Num-Found"); got != fmt.Sprint(body["numFound"]) {
+		t.Errorf("X-Num-Found = %q, want the body's numFound %v", got, body["numFound"])
+	}
+}
+
+func TestSearchReportsNormalizedQuery(t *testing.T) {
+	tests := []struct {
+		name           string
+		targets        []string // requested in order; the last response is checked
+		wantQuery      string
+		wantNormalized string
+		wantSource     string
+	}{
+		{name: "miss", targets: []string{"/search?q=Dune!!"}, wantQuery: "Dune!!", wantNormalized: "dune", wantSource: sourceUpstream},
+		{name: "exact hit", targets: []string{"/search?q=dune", "/search?q=Dune!!"}, wantQuery: "Dune!!", wantNormalized: "dune", wantSource: sourceL2Exact},
+		{name: "reordered hit", targets: []string{"/search?q=hail+mary", "/search?q=Mary,+Hail"}, wantQuery: "Mary, Hail", wantNormalized: "mary hail", wantSource: sourceL2Exact},
+		{name: "fuzzy hit", targets: []string{"/search?q=Project+Hail+Mary", "/search?q=Projct+Hail+Mary!"}, wantQuery: "Projct Hail Mary!", wantNormalized: "projct hail mary", wantSource: sourceL2Fuzzy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Project Hail Mary"))
+
+			var rec *httptest.ResponseRecorder
+			for _, target := range tt.targets {
+				rec = serve(Search, http.MethodGet, "/search", target, "")
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if body["source"] != tt.wantSource {
+				t.Fatalf("source = %v, want %s", body["source"], tt.wantSource)
+			}
+			if body["query"] != tt.wantQuery {
+				t.Errorf("query = %v, want %q", body["query"], tt.wantQuery)
+			}
+			if body["normalizedQuery"] != tt.wantNormalized {
+				t.Errorf("normalizedQuery = %v, want %q", body["normalizedQuery"], tt.wantNormalized)
+			}
+		})
+	}
+}
+
+func TestSearchResultFieldNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       map[string]string
+		target      string
+		wantKeys    []string
+		wantMissing []string
+	}{
+		{name: "defaults", target: "/search?q=dune", wantKeys: []string{"title", "author_name", "key"}},
+		{name: "renamed", names: map[string]string{"title": "name", "author_name": "authors"}, target: "/search?q=dune", wantKeys: []string{"name", "authors", "key"}, wantMissing: []string{"title", "author_name"}},
+		{name: "renamed after projection", names: map[string]string{"title": "name"}, target: "/search?q=dune&fields=title", wantKeys: []string{"name"}, wantMissing: []string{"title", "author_name"}},
+		{name: "mapping for an absent field ignored", names: map[string]string{"subtitle": "tagline"}, target: "/search?q=dune", wantKeys: []string{"title"}, wantMissing: []string{"tagline"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				if tt.names != nil {
+					cfg.ResultFieldNames = tt.names
+				}
+			})
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			results := decodeBody(t, rec)["results"].([]interface{})
+			doc := results[0].(map[string]interface{})
+			for _, key := range tt.wantKeys {
+				if _, ok := doc[key]; !ok {
+					t.Errorf("result is missing %q: %v", key, doc)
+				}
+			}
+			for _, key := range tt.wantMissing {
+				if _, ok := doc[key]; ok {
+					t.Errorf("result has %q, want it renamed away: %v", key, doc)
+				}
+			}
+		})
+	}
+}
+
+func TestResultFieldNamesLeaveCacheUnchanged(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.ResultFieldNames = map[string]string{"title": "name"} })
+	useCache(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+	serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+
+	var cached OpenLibraryResponse
+	if err := Cache.GetJSON("search:dune", &cached); err != nil {
+		t.Fatal(err)
+	}
+	if cached.Docs[0]["title"] != "Dune" {
+		t.Errorf("cached doc = %v, want the upstream field names", cached.Docs[0])
+	}
+}
+
+func TestSearchResultFormats(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	upstream := useUpstream(t, http.StatusOK, `{"numFound":1,"docs":[{"key":"/works/OL27448W","title":"The Lord of the Rings","author_name":["J.R.R. Tolkien"],"first_publish_year":1954}]}`)
+
+	tests := []struct {
+		name   string
+		target string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "raw by default",
+			target: "/search?q=lord+of+the+rings",
+			want:   map[string]interface{}{"key": "/works/OL27448W", "title": "The Lord of the Rings", "author_name": []interface{}{"J.R.R. Tolkien"}, "first_publish_year": float64(1954)},
+		},
+		{
+			name:   "normalized from the same entry",
+			target: "/search?q=lord+of+the+rings&format=normalized",
+			want:   map[string]interface{}{"key": "/works/OL27448W", "title": "The Lord of the Rings", "authorNames": []interface{}{"J.R.R. Tolkien"}, "firstPublishYear": float64(1954)},
+		},
+		{
+			name:   "raw from the same entry",
+			target: "/search?q=lord+of+the+rings&format=raw",
+			want:   map[string]interface{}{"key": "/works/OL27448W", "title": "The Lord of the Rings", "author_name": []interface{}{"J.R.R. Tolkien"}, "first_publish_year": float64(1954)},
+		},
+	}
+
+	// Subtests share the cache: the first request fills it, the rest read it
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			doc := decodeBody(t, rec)["results"].([]interface{})[0].(map[string]interface{})
+			for field, want := range tt.want {
+				if !reflect.DeepEqual(doc[field], want) {
+					t.Errorf("%s = %v, want %v", field, doc[field], want)
+				}
+			}
+		})
+	}
+	if got := upstream.calls(); got != 1 {
+		t.Errorf("%d upstream calls, want both formats served from one cached entry", got)
+	}
+}
+
+func TestSearchDefaultResultFormat(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.ResultFormat = app.ResultFormatNormalized })
+	useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+	doc := decodeBody(t, rec)["results"].([]interface{})[0].(map[string]interface{})
+	if _, ok := doc["authorNames"]; !ok {
+		t.Errorf("result = %v, want a normalized book when that is the configured default", doc)
+	}
+}
+
+func TestSearchReportsLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		titles      []string // docs OpenLibrary returns
+		numFound    int
+		wantApplied float64
+		wantCapped  bool
+	}{
+		{name: "fewer matches than the limit", target: "/search?q=dune&limit=50", titles: []string{"Dune", "Dune Messiah", "Children of Dune"}, numFound: 3, wantApplied: 50},
+		{name: "upstream capped the page", target: "/search?q=dune&limit=50", titles: []string{"Dune", "Dune Messiah", "Children of Dune"}, numFound: 500, wantApplied: 50, wantCapped: true},
+		{name: "full page", target: "/search?q=dune&limit=2", titles: []string{"Dune", "Dune Messiah"}, numFound: 500, wantApplied: 2},
+		{name: "default limit", target: "/search?q=dune", titles: []string{"Dune"}, numFound: 1, wantApplied: constants.DEFAULT_QUERY_LIMIT},
+		{name: "no matches", target: "/search?q=dune&limit=10", numFound: 0, wantApplied: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			var response map[string]interface{}
+			if err := json.Unmarshal([]byte(upstreamBody(tt.titles...)), &response); err != nil {
+				t.Fatal(err)
+			}
+			response["numFound"] = tt.numFound
+			body, _ := json.Marshal(response)
+			useUpstream(t, http.StatusOK, string(body))
+
+			got := decodeBody(t, serve(Search, http.MethodGet, "/search", tt.target, ""))
+			if got["limitApplied"] != tt.wantApplied {
+				t.Errorf("limitApplied = %v, want %v", got["limitApplied"], tt.wantApplied)
+			}
+			if got["actualReturned"] != float64(len(tt.titles)) {
+				t.Errorf("actualReturned = %v, want %d", got["actualReturned"], len(tt.titles))
+			}
+			if capped, _ := got["limitCapped"].(bool); capped != tt.wantCapped {
+				t.Errorf("limitCapped = %v, want %v", got["limitCapped"], tt.wantCapped)
+			}
+		})
+	}
+}
+
+func TestSearchReportsLimitBeforeFilters(t *testing.T) {
+	useConfig(t, nil)
+	useUpstream(t, http.StatusOK, upstreamBody("Dune", "Dune Messiah"))
+
+	got := decodeBody(t, serve(Search, http.MethodGet, "/search", "/search?q=dune&availableOnline=true", ""))
+	if results := got["results"].([]interface{}); len(results) != 0 {
+		t.Fatalf("%d results, want the filter to drop every doc", len(results))
+	}
+	if got["actualReturned"] != float64(2) {
+		t.Errorf("actualReturned = %v, want the 2 docs OpenLibrary returned", got["actualReturned"])
+	}
+}
+
+func TestSearchIncludeMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		configured  bool // IncludeMetrics
+		param       string
+		cached      bool
+		wantMetrics bool
+	}{
+		{name: "default", configured: true, wantMetrics: true},
+		{name: "turned off by the client", configured: true, param: "false"},
+		{name: "turned off by config", configured: false},
+		{name: "client can't turn it on", configured: false, param: "true"},
+		{name: "kept on by the client", configured: true, param: "true", wantMetrics: true},
+		{name: "cache hit", configured: true, cached: true, wantMetrics: true},
+		{name: "cache hit turned off", configured: true, param: "false", cached: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.IncludeMetrics = tt.configured })
+			logs := useObservedLogger(t)
+			if tt.cached {
+				useCache(t)
+				cacheResults(t, "search:dune", upstreamBody("Dune"))
+			}
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			target := "/search?q=Dune"
+			if tt.param != "" {
+				target += "&includeMetrics=" + tt.param
+			}
+
+			rec := serve(Search, http.MethodGet, "/search", target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if _, present := body["responseTime"]; present != tt.wantMetrics {
+				t.Errorf("responseTime present = %v, want %v", present, tt.wantMetrics)
+			}
+			// Only upstream fetches carry the metrics breakdown
+			if _, present := body["metrics"]; present != (tt.wantMetrics && !tt.cached) {
+				t.Errorf("metrics present = %v, want %v", present, tt.wantMetrics && !tt.cached)
+			}
+			if !tt.cached && logs.FilterMessage("API search completed").FilterFieldKey("total_duration_ms").Len() != 1 {
+				t.Error("timings not logged server-side")
+			}
+		})
+	}
+}
+
+func TestSearchInvalidIncludeMetrics(t *testing.T) {
+	useConfig(t, nil)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=Dune&includeMetrics=no", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code = %d, want 400", rec.Code)
+	}
+	if upstream.calls() != 0 {
+		t.Errorf("%d upstream calls for an invalid request, want none", upstream.calls())
+	}
+}
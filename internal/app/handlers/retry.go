@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// retryBudget bounds the retries of one request across the cache and upstream, so
+// independent retry loops can't compound past the request's time budget. It is shared
+// through the request context and safe for concurrent use.
+type retryBudget struct {
+	mu        sync.Mutex
+	attempts  int           // retries left
+	remaining time.Duration // time left for backoff and retried calls
+}
+
+type retryBudgetCtxKey struct{}
+
+// withRetryBudget attaches a fresh budget from the current config to ctx
+func withRetryBudget(ctx context.Context) context.Context {
+	cfg := CurrentConfig()
+	return context.WithValue(ctx, retryBudgetCtxKey{}, &retryBudget{
+		attempts:  cfg.RetryBudgetAttempts,
+		remaining: cfg.RetryBudgetTime,
+	})
+}
+
+func retryBudgetFrom(ctx context.Context) *retryBudget {
+	budget, _ := ctx.Value(retryBudgetCtxKey{}).(*retryBudget)
+	return budget
+}
+
+// take claims one retry if the budget allows it
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.attempts <= 0 || b.remaining <= 0 {
+		return false
+	}
+	b.attempts--
+	return true
+}
+
+// spend charges d against the remaining time
+func (b *retryBudget) spend(d time.Duration) {
+	b.mu.Lock()
+	b.remaining -= d
+	b.mu.Unlock()
+}
+
+// backoff is the pause before a retry, never more than the time left
+func (b *retryBudget) backoff() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return min(retryBackoff, max(b.remaining, 0))
+}
+
+// retryBackoff is the pause before each retry
+const retryBackoff = 50 * time.Millisecond
+
+// withRetries calls fn, retrying while it fails with a retryable error and the request's
+// budget allows. Without a budget in ctx, fn is called once.
+func withRetries(ctx context.Context, operation string, retryable func(error) bool, fn func() error) error {
+	err := fn()
+	budget := retryBudgetFrom(ctx)
+	for budget != nil && err != nil && retryable(err) && ctx.Err() == nil && budget.take() {
+		start := time.Now()
+		select {
+		case <-time.After(budget.backoff()):
+		case <-ctx.Done():
+			budget.spend(time.Since(start))
+			return err
+		}
+		Logger.Info("Retrying", zap.String("operation", operation), zap.Error(err))
+		err = fn()
+		budget.spend(time.Since(start))
+	}
+	return err
+}
+
+// retryableCacheError reports whether a cache read failed in a way worth retrying: not a
+// miss, and not a value that can't be decoded
+func retryableCacheError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return !errors.Is(err, redis.Nil) && !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr)
+}
+
+// retryableUpstreamError reports whether an upstream call failed in a way worth retrying:
+// the request itself failed or the body was cut off. Missing resources and malformed
+// responses won't improve on a retry.
+func retryableUpstreamError(err error) bool {
+	if errors.Is(err, errUpstreamNoResponse) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return errors.Is(err, errUpstreamRequest) || errors.Is(err, errUpstreamTruncated)
+}
+
+// getCachedJSON reads a cached value, retrying transient errors (e.g. during a Redis
+// failover) within the request's budget. Retries of one read are further held to
+// CACHE_READ_RETRY_WINDOW_MILLISECONDS, passed down to Redis, so a cache outage never costs
+// more than that before the request moves on to upstream.
+func getCachedJSON(ctx context.Context, key string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, constants.CACHE_READ_RETRY_WINDOW_MILLISECONDS*time.Millisecond)
+	defer cancel()
+	return withRetries(ctx, "cache read", retryableCacheError, func() error {
+		return Cache.GetJSONContext(ctx, key, v)
+	})
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// failingUpstream fails every request before it gets a response
+type failingUpstream struct {
+	requests atomic.Int32
+}
+
+func (f *failingUpstream) Do(req *http.Request) (*http.Response, error) {
+	f.requests.Add(1)
+	return nil, errors.New("connection reset")
+}
+
+func TestWithRetries(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+	tests := []struct {
+		name      string
+		budget    bool
+		attempts  int
+		time      time.Duration
+		failures  []error // returned by successive calls, then nil
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "success", budget: true, attempts: 2, time: time.Second, wantCalls: 1},
+		{name: "no budget in the context", budget: false, failures: []error{errTransient}, wantCalls: 1, wantErr: errTransient},
+		{name: "retried until it succeeds", budget: true, attempts: 2, time: time.Second, failures: []error{errTransient}, wantCalls: 2},
+		{name: "attempts run out", budget: true, attempts: 2, time: time.Second, failures: []error{errTransient, errTransient, errTransient}, wantCalls: 3, wantErr: errTransient},
+		{name: "time runs out", budget: true, attempts: 2, time: 0, failures: []error{errTransient}, wantCalls: 1, wantErr: errTransient},
+		{name: "not retryable", budget: true, attempts: 2, time: time.Second, failures: []error{errPermanent}, wantCalls: 1, wantErr: errPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.RetryBudgetAttempts = tt.attempts
+				cfg.RetryBudgetTime = tt.time
+			})
+			ctx := context.Background()
+			if tt.budget {
+				ctx = withRetryBudget(ctx)
+			}
+
+			calls := 0
+			err := withRetries(ctx, "test", func(err error) bool { return errors.Is(err, errTransient) }, func() error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetSharedByCacheAndUpstream(t *testing.T) {
+	tests := []struct {
+		name          string
+		redisDown     bool // cache reads fail and retry first
+		wantUpstreams int32
+	}{
+		{name: "upstream retries with the whole budget", redisDown: false, wantUpstreams: 2},
+		{name: "cache retries leave none for upstream", redisDown: true, wantUpstreams: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.RetryBudgetAttempts = 1
+				cfg.RetryBudgetTime = 5 * time.Second
+			})
+			_, server := useCache(t)
+			upstream := &failingUpstream{}
+			previous := HTTPClient
+			SetHTTPClient(upstream)
+			t.Cleanup(func() { SetHTTPClient(previous) })
+			ctx := withRetryBudget(context.Background())
+
+			if tt.redisDown {
+				server.Close()
+				var response OpenLibraryResponse
+				if err := getCachedJSON(ctx, "search:dune", &response); err == nil {
+					t.Fatal("cache read succeeded with Redis down")
+				}
+			}
+			if _, err := fetchOpenLibrary(ctx, buildSearchURL("dune", 20)); !errors.Is(err, errUpstreamRequest) {
+				t.Fatalf("fetch error = %v, want errUpstreamRequest", err)
+			}
+			if got := upstream.requests.Load(); got != tt.wantUpstreams {
+				t.Errorf("%d upstream requests, want %d", got, tt.wantUpstreams)
+			}
+		})
+	}
+}
+
+// flakyReadHook fails reads of one key the way Redis does during a failover, until its
+// failures run out
+type flakyReadHook struct {
+	key      string
+	failures *atomic.Int32
+	reads    *atomic.Int32 // reads of key that reached the hook
+}
+
+func (flakyReadHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h flakyReadHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !h.matches(cmd) {
+			return next(ctx, cmd)
+		}
+		h.reads.Add(1)
+		if h.failures.Add(-1) >= 0 {
+			err := errors.New("connection reset by peer")
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (flakyReadHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// matches reports whether cmd reads the hook's key, plainly or through the envelope script
+func (h flakyReadHook) matches(cmd redis.Cmder) bool {
+	switch cmd.Name() {
+	case "get", "eval", "evalsha":
+	default:
+		return false
+	}
+	for _, arg := range cmd.Args()[1:] {
+		if s, ok := arg.(string); ok && strings.HasSuffix(s, ":"+h.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// useFlakyCache installs a cache laid out like useCache whose reads of key fail failures
+// times before reaching Redis
+func useFlakyCache(t *testing.T, key string, failures int32) flakyReadHook {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { client.Close() })
+	hook := flakyReadHook{key: key, failures: &atomic.Int32{}, reads: &atomic.Int32{}}
+	hook.failures.Store(failures)
+	client.AddHook(hook)
+
+	c := cache.NewCache(client, "test")
+	c.SetGenerationalPrefixes(ResultKeyPrefixes()...)
+	SetCache(c)
+	fuzzySnapshot = &queryIndexSnapshot{}
+	t.Cleanup(func() {
+		SetCache(nil)
+		fuzzySnapshot = &queryIndexSnapshot{}
+	})
+	return hook
+}
+
+func TestSearchRetriesCacheReads(t *testing.T) {
+	tests := []struct {
+		name          string
+		cached        string // key the results are cached under
+		target        string
+		failures      int32
+		wantReads     int32 // of the cached key, including the two GetOrSet makes before loading
+		wantUpstreams int
+	}{
+		{name: "no failures", cached: "dune", target: "/search?q=Dune", wantReads: 1},
+		{name: "fails once then hits", cached: "dune", target: "/search?q=Dune", failures: 1, wantReads: 2},
+		{name: "fuzzy match read fails once then hits", cached: "harry poter", target: "/search?q=harry+potter", failures: 1, wantReads: 2},
+		{name: "keeps failing past the budget", cached: "dune", target: "/search?q=Dune", failures: 100, wantReads: 5, wantUpstreams: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			hook := useFlakyCache(t, tt.cached, tt.failures)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			cacheResults(t, "search:"+tt.cached, upstreamBody("Cached"))
+			indexQueries(t, "search", tt.cached)
+
+			if rec := serve(Search, http.MethodGet, "/search", tt.target, ""); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			if got := hook.reads.Load(); got != tt.wantReads {
+				t.Errorf("%d reads of %s, want %d", got, tt.cached, tt.wantReads)
+			}
+			if got := upstream.calls(); got != tt.wantUpstreams {
+				t.Errorf("%d upstream calls, want %d", got, tt.wantUpstreams)
+			}
+		})
+	}
+}
+
+func TestCacheReadRetriesHeldToWindow(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) {
+		cfg.RetryBudgetAttempts = 100
+		cfg.RetryBudgetTime = 10 * time.Second
+	})
+	hook := useFlakyCache(t, "dune", 1000)
+	ctx := withRetryBudget(context.Background())
+
+	start := time.Now()
+	var response OpenLibraryResponse
+	if err := getCachedJSON(ctx, "search:dune", &response); err == nil {
+		t.Fatal("cache read succeeded while every read fails")
+	}
+	window := constants.CACHE_READ_RETRY_WINDOW_MILLISECONDS * time.Millisecond
+	if elapsed := time.Since(start); elapsed > window+100*time.Millisecond {
+		t.Errorf("read gave up after %v, want within the %v window", elapsed, window)
+	}
+	if got, most := hook.reads.Load(), int32(window/retryBackoff)+1; got < 2 || got > most {
+		t.Errorf("%d reads, want retries up to %d within the window", got, most)
+	}
+	if remaining := retryBudgetFrom(ctx).remaining; remaining < 9*time.Second {
+		t.Errorf("budget left %v, want the window to stop retries well before it ran out", remaining)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// zeroWidthRemover strips invisible characters that would otherwise split or pad
+// words without being visible in the query
+var zeroWidthRemover = strings.NewReplacer(
+	"\u200b", "", // zero width space
+	"\u200c", "", // zero width non-joiner
+	"\u200d", "", // zero width joiner
+	"\u2060", "", // word joiner
+	"\ufeff", "", // zero width no-break space / BOM
+	"\u00ad", "", // soft hyphen
+)
+
+// homoglyphs maps Cyrillic and Greek letters that render like Latin ones to their Latin look-alike
+var homoglyphs = map[rune]rune{
+	// Cyrillic
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'У': 'Y', 'І': 'I', 'Ј': 'J', 'Ѕ': 'S',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h',
+	// Greek
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K',
+	'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	'ο': 'o', 'ν': 'v',
+}
+
+// sanitizeQuery removes zero-width characters, turns every kind of Unicode whitespace into
+// a plain space and, when foldHomoglyphs is set, replaces look-alike letters from other
+// scripts with Latin ones. It runs before normalization so disguised queries land on the
+// same cache key as the clean form.
+func sanitizeQuery(query string, foldHomoglyphs bool) string {
+	query = zeroWidthRemover.Replace(query)
+	query = unifySpaces(query)
+	if !foldHomoglyphs {
+		return query
+	}
+	return strings.Map(func(r rune) rune {
+		if latin, ok := homoglyphs[r]; ok {
+			return latin
+		}
+		return r
+	}, query)
+}
+
+// unifySpaces replaces tabs, newlines, no-break spaces and the other Unicode spaces with
+// " ". Go's \s only matches ASCII whitespace, so without this a no-break space would be
+// stripped as a special character and glue the words on either side together.
+func unifySpaces(query string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, query)
+}
+
+// foldAccents strips combining marks, so "café" and "cafe" (or "Ångström" and "Angstrom")
+// normalize alike. Letters without a decomposition, such as "ø" or "ß", are left as they are.
+func foldAccents(query string) string {
+	decomposed := norm.NFD.String(query)
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, decomposed)
+	return norm.NFC.String(stripped)
+}
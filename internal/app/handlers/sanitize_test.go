@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestSanitizeQuery(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		foldHomoglyphs bool
+		want           string
+	}{
+		{name: "zero width space", query: "du\u200bne", want: "dune"},
+		{name: "joiners and word joiner", query: "d\u200cu\u200dn\u2060e", want: "dune"},
+		{name: "byte order mark", query: "\ufeffdune", want: "dune"},
+		{name: "soft hyphen", query: "hob\u00adbit", want: "hobbit"},
+		{name: "no-break space", query: "hail\u00a0mary", want: "hail mary"},
+		{name: "tabs and newlines", query: "hail\tmary\nproject", want: "hail mary project"},
+		{name: "ideographic space", query: "hail\u3000mary", want: "hail mary"},
+		{name: "homoglyphs kept when not folding", query: "Dun\u0435", want: "Dun\u0435"},
+		{name: "cyrillic folded", query: "Dun\u0435", foldHomoglyphs: true, want: "Dune"},
+		{name: "greek folded", query: "\u039fdyssey", foldHomoglyphs: true, want: "Odyssey"},
+		{name: "only look-alike letters folded", query: "\u0432\u043e\u0439\u043d\u0430", foldHomoglyphs: true, want: "\u0432o\u0439\u043da"},
+		{name: "plain ascii unchanged", query: "Dune", foldHomoglyphs: true, want: "Dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeQuery(tt.query, tt.foldHomoglyphs); got != tt.want {
+				t.Errorf("sanitizeQuery(%q, %v) = %q, want %q", tt.query, tt.foldHomoglyphs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeQueryDisguisedForms(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		foldHomoglyphs bool
+		want           string
+	}{
+		{name: "zero width space", query: "Hail\u200b Mary", want: "hail mary"},
+		{name: "no-break space keeps words apart", query: "Hail\u00a0Mary", want: "hail mary"},
+		{name: "homoglyph without folding", query: "Dun\u0435", want: "dun\u0435"},
+		{name: "homoglyph with folding", query: "Dun\u0435", foldHomoglyphs: true, want: "dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.FoldHomoglyphs = tt.foldHomoglyphs })
+			if got := normalizeQuery(tt.query); got != tt.want {
+				t.Errorf("normalizeQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFoldAccents(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "composed accent", query: "caf\u00e9", want: "cafe"},
+		{name: "decomposed accent", query: "cafe\u0301", want: "cafe"},
+		{name: "several accents", query: "\u00c5ngstr\u00f6m", want: "Angstrom"},
+		{name: "case kept", query: "\u00c9MILE", want: "EMILE"},
+		{name: "letters without a decomposition kept", query: "\u00f8 stra\u00dfe", want: "\u00f8 stra\u00dfe"},
+		{name: "accents stripped from other scripts", query: "\u0439", want: "\u0438"},
+		{name: "plain ascii unchanged", query: "dune", want: "dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := foldAccents(tt.query); got != tt.want {
+				t.Errorf("foldAccents(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeQueryFoldsCaseAndAccents(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        string
+		foldAccents bool
+		wantSame    bool
+	}{
+		{name: "case", a: "Dune", b: "dune", wantSame: true},
+		{name: "case with accents", a: "CAF\u00c9", b: "caf\u00e9", wantSame: true},
+		{name: "composed and decomposed", a: "caf\u00e9", b: "cafe\u0301", wantSame: true},
+		{name: "accents kept by default", a: "caf\u00e9", b: "cafe", wantSame: false},
+		{name: "accents folded", a: "caf\u00e9", b: "cafe", foldAccents: true, wantSame: true},
+		{name: "accents and case folded", a: "\u00c9mile Zola", b: "emile zola", foldAccents: true, wantSame: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.FoldAccents = tt.foldAccents })
+			a, b := normalizeQuery(tt.a), normalizeQuery(tt.b)
+			if same := a == b; same != tt.wantSame {
+				t.Errorf("normalizeQuery(%q) = %q, normalizeQuery(%q) = %q; same = %v, want %v", tt.a, a, tt.b, b, same, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestSearchSharesCacheAcrossAccents(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.FoldAccents = true })
+	useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Caf\u00e9 Society"))
+
+	for _, target := range []string{"/search?q=Caf%C3%A9", "/search?q=cafe", "/search?q=CAFE%CC%81"} {
+		rec := serve(Search, http.MethodGet, "/search", target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status code = %d, want 200", target, rec.Code)
+		}
+	}
+	if got := upstream.calls(); got != 1 {
+		t.Errorf("upstream called %d times, want the spellings to share one entry", got)
+	}
+}
+
+func TestNormalizeQueryCollapsesWhitespace(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "plain spaces", query: "lord of the rings"},
+		{name: "repeated spaces", query: "lord   of  the rings"},
+		{name: "no-break spaces", query: "lord\u00a0of\u00a0the\u00a0rings"},
+		{name: "tabs", query: "lord\tof\t\tthe rings"},
+		{name: "newlines", query: "lord\nof\r\nthe rings"},
+		{name: "mixed unicode spaces", query: "\u2003lord\u2009of\u202fthe\u3000rings\u00a0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			if got := normalizeQuery(tt.query); got != "lord of the rings" {
+				t.Errorf("normalizeQuery(%q) = %q, want %q", tt.query, got, "lord of the rings")
+			}
+		})
+	}
+}
+
+func TestSearchSharesCacheAcrossWhitespace(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("The Lord of the Rings"))
+
+	for _, target := range []string{"/search?q=lord+of+rings", "/search?q=lord%C2%A0of%C2%A0rings", "/search?q=lord%09of%0Arings"} {
+		rec := serve(Search, http.MethodGet, "/search", target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status code = %d, want 200", target, rec.Code)
+		}
+	}
+	if got := upstream.calls(); got != 1 {
+		t.Errorf("upstream called %d times, want the spacings to share one entry", got)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// schemaViolations counts upstream responses flagged by checkSchema, reported by /metrics
+var schemaViolations atomic.Int64
+
+// cacheableResponse runs the sanity checks an upstream search response must pass before it
+// is cached, reporting whether it may be
+func cacheableResponse(searchURL string, response OpenLibraryResponse) bool {
+	if emptyButFound(response) {
+		Logger.Warn("Upstream reported matches but returned no docs, not caching it",
+			zap.String("url", searchURL),
+			zap.Int("numFound", response.NumFound))
+		return false
+	}
+	return checkSchema(searchURL, response)
+}
+
+// emptyButFound reports whether OpenLibrary claims matches but sent no docs, e.g. because
+// the offset was past the end or the docs were filtered out. Caching that would hide
+// the results behind an empty answer.
+func emptyButFound(response OpenLibraryResponse) bool {
+	return response.NumFound > 0 && len(response.Docs) == 0
+}
+
+// checkSchema flags an upstream response where more than SchemaMaxMissingRatio of the docs
+// lack one of SchemaRequiredFields, which usually means OpenLibrary changed its schema. It
+// reports whether the response may be cached.
+func checkSchema(searchURL string, response OpenLibraryResponse) bool {
+	cfg := CurrentConfig()
+	if len(cfg.SchemaRequiredFields) == 0 || len(response.Docs) == 0 {
+		return true
+	}
+
+	missing := 0
+	missingByField := map[string]int{}
+	for _, doc := range response.Docs {
+		complete := true
+		for _, field := range cfg.SchemaRequiredFields {
+			if !hasFields(doc, []string{field}) {
+				missingByField[field]++
+				complete = false
+			}
+		}
+		if !complete {
+			missing++
+		}
+	}
+
+	ratio := float64(missing) / float64(len(response.Docs))
+	if ratio <= cfg.SchemaMaxMissingRatio {
+		return true
+	}
+
+	schemaViolations.Add(1)
+	fields := make([]string, 0, len(missingByField))
+	for field := range missingByField {
+		fields = append(fields, field)
+	}
+	Logger.Error("Upstream docs are missing required fields, OpenLibrary's schema may have changed",
+		zap.String("url", searchURL),
+		zap.Int("docs", len(response.Docs)),
+		zap.Int("incomplete", missing),
+		zap.Float64("ratio", ratio),
+		zap.String("missing_fields", strings.Join(fields, ",")),
+		zap.Bool("caching_refused", cfg.SchemaRefuseCache))
+	return !cfg.SchemaRefuseCache
+}
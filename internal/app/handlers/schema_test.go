@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		name          string
+		required      []string
+		maxMissing    float64
+		refuse        bool
+		docs          []string
+		wantCacheable bool
+		wantFlagged   bool
+	}{
+		{
+			name:          "compliant",
+			required:      []string{"key", "title"},
+			maxMissing:    0.5,
+			refuse:        true,
+			docs:          []string{`{"key":"/works/OL1W","title":"Dune"}`, `{"key":"/works/OL2W","title":"Emma"}`},
+			wantCacheable: true,
+		},
+		{
+			name:          "missing within the ratio",
+			required:      []string{"key", "title"},
+			maxMissing:    0.5,
+			refuse:        true,
+			docs:          []string{`{"key":"/works/OL1W","title":"Dune"}`, `{"key":"/works/OL2W"}`},
+			wantCacheable: true,
+		},
+		{
+			name:          "missing beyond the ratio, caching refused",
+			required:      []string{"key", "title"},
+			maxMissing:    0.5,
+			refuse:        true,
+			docs:          []string{`{"key":"/works/OL1W"}`, `{"name":"Emma"}`, `{"key":"/works/OL3W","title":"Ulysses"}`},
+			wantCacheable: false,
+			wantFlagged:   true,
+		},
+		{
+			name:          "missing beyond the ratio, still cached",
+			required:      []string{"key", "title"},
+			maxMissing:    0.5,
+			docs:          []string{`{"key":"/works/OL1W"}`, `{"name":"Emma"}`},
+			wantCacheable: true,
+			wantFlagged:   true,
+		},
+		{
+			name:          "nothing required",
+			maxMissing:    0,
+			refuse:        true,
+			docs:          []string{`{"name":"Emma"}`},
+			wantCacheable: true,
+		},
+		{
+			name:          "no docs",
+			required:      []string{"key", "title"},
+			refuse:        true,
+			wantCacheable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.SchemaRequiredFields = tt.required
+				cfg.SchemaMaxMissingRatio = tt.maxMissing
+				cfg.SchemaRefuseCache = tt.refuse
+			})
+			logs := useObservedLogger(t)
+			var response OpenLibraryResponse
+			for _, doc := range tt.docs {
+				response.Docs = append(response.Docs, decodeDoc(t, doc))
+			}
+			before := schemaViolations.Load()
+
+			if got := checkSchema("https://openlibrary.org/search.json?q=dune", response); got != tt.wantCacheable {
+				t.Errorf("checkSchema = %v, want %v", got, tt.wantCacheable)
+			}
+			wantViolations := int64(0)
+			if tt.wantFlagged {
+				wantViolations = 1
+			}
+			if got := schemaViolations.Load() - before; got != wantViolations {
+				t.Errorf("schemaViolations grew by %d, want %d", got, wantViolations)
+			}
+			if errorLogs := logs.FilterLevelExact(zapcore.ErrorLevel).Len(); (errorLogs > 0) != tt.wantFlagged {
+				t.Errorf("%d error logs, want flagged %v", errorLogs, tt.wantFlagged)
+			}
+		})
+	}
+}
+
+func TestSearchSchemaViolation(t *testing.T) {
+	body := `{"numFound":2,"docs":[{"name":"Dune"},{"name":"Dune Messiah"}]}`
+	tests := []struct {
+		name      string
+		refuse    bool
+		wantCalls int
+	}{
+		{name: "cached by default", refuse: false, wantCalls: 1},
+		{name: "not cached when refused", refuse: true, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.SchemaRefuseCache = tt.refuse })
+			useCache(t)
+			upstream := useUpstream(t, http.StatusOK, body)
+
+			for i := 0; i < 2; i++ {
+				rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("request %d: status code = %d: %s", i, rec.Code, rec.Body.String())
+				}
+				if results := decodeBody(t, rec)["results"].([]interface{}); len(results) != 2 {
+					t.Errorf("request %d: %d results, want the flagged response still served", i, len(results))
+				}
+			}
+			if got := upstream.calls(); got != tt.wantCalls {
+				t.Errorf("%d upstream calls, want %d", got, tt.wantCalls)
+			}
+
+			metrics := decodeBody(t, serve(Metrics, http.MethodGet, "/metrics", "/metrics", ""))
+			if metrics["schemaViolations"].(float64) < 1 {
+				t.Errorf("schemaViolations = %v, want the flagged response counted", metrics["schemaViolations"])
+			}
+		})
+	}
+}
+
+func TestEmptyButFound(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "matches with docs", body: upstreamBody("Dune"), want: false},
+		{name: "no matches", body: `{"numFound":0,"docs":[]}`, want: false},
+		{name: "matches without docs", body: `{"numFound":100,"docs":[]}`, want: true},
+		{name: "matches with docs missing", body: `{"numFound":100}`, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response OpenLibraryResponse
+			if err := json.Unmarshal([]byte(tt.body), &response); err != nil {
+				t.Fatal(err)
+			}
+			if got := emptyButFound(response); got != tt.want {
+				t.Errorf("emptyButFound = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchEmptyButFound(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantNote  bool
+		wantCalls int
+	}{
+		{name: "genuinely empty is cached", body: `{"numFound":0,"docs":[]}`, wantCalls: 1},
+		{name: "empty but found is not", body: `{"numFound":100,"docs":[]}`, wantNote: true, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			upstream := useUpstream(t, http.StatusOK, tt.body)
+
+			for i := 0; i < 2; i++ {
+				rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("request %d: status code = %d: %s", i, rec.Code, rec.Body.String())
+				}
+				note, ok := decodeBody(t, rec)["note"].(string)
+				if ok != tt.wantNote {
+					t.Errorf("request %d: note = %q, want one %v", i, note, tt.wantNote)
+				}
+				if ok && !strings.Contains(note, "100 matches") {
+					t.Errorf("note = %q, want the reported match count", note)
+				}
+			}
+			if got := upstream.calls(); got != tt.wantCalls {
+				t.Errorf("%d upstream calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -1,20 +1,27 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"github.com/agnivade/levenshtein"
+	"golang.org/x/text/unicode/norm"
 )
 
 // CacheMatch represents a fuzzy cache match result
@@ -23,71 +30,95 @@ type CacheMatch struct {
 	CachedQuery  string
 	Score        float64
 	Method       string
+	Distance     int // edit distance between the whole queries, for the levenshtein method only
 }
 
-// findSimilarCachedQueries finds similar queries in cache using fuzzy matching
-func findSimilarCachedQueries(query string, maxResults int) []CacheMatch {
-	if Cache == nil {
+// findSimilarCachedQueries finds similar queries in cache using fuzzy matching.
+// It returns at most maxResults matches, best first; a maxResults of zero or less
+// disables fuzzy matching and returns nil without touching the cache, as does a
+// keyspace past FuzzyDisableThreshold.
+func findSimilarCachedQueries(query string, namespace string, maxResults int) []CacheMatch {
+	if Cache == nil || maxResults <= 0 || !fuzzyAllowed(namespace) {
 		return nil
 	}
 
 	normalized := normalizeQuery(query)
 	queryWords := strings.Split(normalized, " ")
 	
-	// Get all search cache keys
-	pattern := "search:*"
-	allKeys, err := Cache.Keys(pattern)
+	// Only the most recently cached queries are candidates, newest first
+	recentQueries, err := recentQueriesFor(namespace)
 	if err != nil {
-		Logger.Warn("Failed to get cache keys for fuzzy matching", zap.Error(err))
+		Logger.Warn("Failed to get recent queries for fuzzy matching", zap.Error(err))
 		return nil
 	}
 	
 	matches := []CacheMatch{}
-	maxLevenshteinDistance := 3 // Maximum edit distance for whole query
+	comparisons := 0 // word-pair Levenshtein computations so far
+	budgetExhausted := false
+	cfg := CurrentConfig()
+	maxLevenshteinDistance := cfg.FuzzyMaxDistance // Maximum edit distance for whole query
 	
-	for _, key := range allKeys {
-		cachedQuery := strings.TrimPrefix(key, "search:")
+	for _, cachedQuery := range recentQueries {
+		key := fmt.Sprintf("%s:%s", namespace, cachedQuery)
 		
 		// Skip exact matches (handled elsewhere)
 		if cachedQuery == normalized {
 			continue
 		}
 		
-		// Method 1: Levenshtein distance for whole query
-		distance := levenshtein.ComputeDistance(normalized, cachedQuery)
-		if distance <= maxLevenshteinDistance {
-			score := 1.0 / float64(distance+1) // Lower distance = higher score
-			matches = append(matches, CacheMatch{
-				Key:         key,
-				CachedQuery: cachedQuery,
-				Score:       score,
-				Method:      "levenshtein",
-			})
-			continue
+		// Method 1: Levenshtein distance for whole query. The distance is at least the
+		// length difference, so the full computation is skipped when that already exceeds it.
+		if lengthGap(normalized, cachedQuery) <= maxLevenshteinDistance {
+			distance := levenshtein.ComputeDistance(normalized, cachedQuery)
+			if distance <= maxLevenshteinDistance {
+				score := 1.0 / float64(distance+1) // Lower distance = higher score
+				matches = append(matches, CacheMatch{
+					Key:         key,
+					CachedQuery: cachedQuery,
+					Score:       score,
+					Method:      "levenshtein",
+					Distance:    distance,
+				})
+				continue
+			}
 		}
 		
-		// Method 2: Word-by-word fuzzy matching
+		// Method 2: Word-by-word fuzzy matching, bounded by the per-request comparison budget
+		if comparisons >= constants.FUZZY_MAX_WORD_COMPARISONS {
+			budgetExhausted = true
+			continue
+		}
 		cachedWords := strings.Split(cachedQuery, " ")
 		matchingWords := 0
 		
-		for _, qWord := range queryWords {
+		// If most words match, consider it similar
+		maxLen := len(queryWords)
+		if len(cachedWords) > maxLen {
+			maxLen = len(cachedWords)
+		}
+		
+		for i, qWord := range queryWords {
+			// Stop once the remaining words can no longer reach the match ratio
+			if float64(matchingWords+len(queryWords)-i)/float64(maxLen) < cfg.FuzzyWordMatchRatio {
+				break
+			}
 			for _, cWord := range cachedWords {
+				// Edit distance is at least the length difference, so skip pairs that can't match
+				if lengthGap(qWord, cWord) > cfg.FuzzyWordDistance {
+					continue
+				}
+				comparisons++
 				wordDistance := levenshtein.ComputeDistance(qWord, cWord)
-				if wordDistance <= 2 {
+				if wordDistance <= cfg.FuzzyWordDistance {
 					matchingWords++
 					break
 				}
 			}
 		}
 		
-		// If most words match, consider it similar
-		maxLen := len(queryWords)
-		if len(cachedWords) > maxLen {
-			maxLen = len(cachedWords)
-		}
 		wordMatchRatio := float64(matchingWords) / float64(maxLen)
 		
-		if wordMatchRatio >= 0.6 { // 60% of words match
+		if wordMatchRatio >= cfg.FuzzyWordMatchRatio { // e.g. 60% of words match
 			matches = append(matches, CacheMatch{
 				Key:         key,
 				CachedQuery: cachedQuery,
@@ -97,12 +128,19 @@ func findSimilarCachedQueries(query string, maxResults int) []CacheMatch {
 		}
 	}
 	
+	if budgetExhausted {
+		Logger.Debug("Fuzzy word comparison budget exhausted",
+			zap.String("query", normalized),
+			zap.Int("comparisons", comparisons))
+	}
+	
 	// Sort by score (best matches first)
 	sort.Slice(matches, func(i, j int) bool {
 		return matches[i].Score > matches[j].Score
 	})
 	
-	// Return top N results
+	// Return top N results, leaving out entries too old to be worth serving
+	matches = freshMatches(matches, namespace, cfg, maxResults)
 	if len(matches) > maxResults {
 		matches = matches[:maxResults]
 	}
@@ -110,16 +148,167 @@ func findSimilarCachedQueries(query string, maxResults int) []CacheMatch {
 	return matches
 }
 
+// freshMatches drops matches written more than FuzzyMaxAge ago, going by their recency index
+// score, which is set when the result is written whatever its TTL or generation. Checking
+// stops once maxResults fresh matches are found, so at most a few scores are read per
+// request. Matches dropped from the index since the candidates were read are dropped too.
+func freshMatches(matches []CacheMatch, namespace string, cfg app.Config, maxResults int) []CacheMatch {
+	if cfg.FuzzyMaxAge <= 0 {
+		return matches
+	}
+	fresh := make([]CacheMatch, 0, maxResults)
+	for _, match := range matches {
+		if len(fresh) >= maxResults {
+			break
+		}
+		writtenAt, err := Cache.IndexTime(recentIndexKey(namespace), match.CachedQuery)
+		if err != nil {
+			Logger.Debug("Failed to read write time of fuzzy candidate", zap.String("key", match.Key), zap.Error(err))
+			continue
+		}
+		if age := time.Since(writtenAt); age > cfg.FuzzyMaxAge {
+			Logger.Debug("Skipping stale fuzzy candidate",
+				zap.String("key", match.Key),
+				zap.Duration("age", age))
+			continue
+		}
+		fresh = append(fresh, match)
+	}
+	return fresh
+}
+
+// FuzzyCandidate is a fuzzy match as reported to clients
+type FuzzyCandidate struct {
+	Query  string  `json:"query"`
+	Score  float64 `json:"score"`
+	Method string  `json:"method"`
+}
+
+// clientFuzzyCandidates reports at most FUZZY_CLIENT_MAX_CANDIDATES of the best matches,
+// however many were found internally, so responses stay small and don't reveal what else
+// is cached
+func clientFuzzyCandidates(matches []CacheMatch) []FuzzyCandidate {
+	if len(matches) > constants.FUZZY_CLIENT_MAX_CANDIDATES {
+		matches = matches[:constants.FUZZY_CLIENT_MAX_CANDIDATES]
+	}
+	candidates := make([]FuzzyCandidate, len(matches))
+	for i, match := range matches {
+		candidates[i] = FuzzyCandidate{
+			Query:  match.CachedQuery,
+			Score:  match.Score,
+			Method: match.Method,
+		}
+	}
+	return candidates
+}
+
+// lengthGap is the difference in rune length between a and b, a lower bound on their
+// Levenshtein distance
+func lengthGap(a string, b string) int {
+	gap := utf8.RuneCountInString(a) - utf8.RuneCountInString(b)
+	if gap < 0 {
+		return -gap
+	}
+	return gap
+}
+
+// recordRecentQuery adds a freshly cached query to the recency index used to
+// bound fuzzy matching, trimming entries that have expired or fallen out of range
+func recordRecentQuery(namespace string, normalizedQuery string) {
+	if err := Cache.AddToIndex(recentIndexKey(namespace), normalizedQuery, time.Now()); err != nil {
+		warnCacheWrite("Failed to update recent query index", err)
+		return
+	}
+	err := Cache.TrimIndex(recentIndexKey(namespace), CurrentConfig().CacheTTL, constants.CACHE_MAX_SIZE)
+	if err != nil {
+		warnCacheWrite("Failed to trim recent query index", err)
+	}
+}
+
+// warnCacheWrite logs a failed cache write. Writes refused because Redis is read-only are
+// only logged at debug level, since entering read-only mode is already reported once.
+func warnCacheWrite(message string, err error, fields ...zap.Field) {
+	fields = append(fields, zap.Error(err))
+	if errors.Is(err, cache.ErrReadOnly) || errors.Is(err, cache.ErrNotStored) {
+		Logger.Debug(message, fields...)
+		return
+	}
+	Logger.Warn(message, fields...)
+}
+
+// writeBackFuzzyHit caches a fuzzy hit under the key the client's query is stored under
+// (its StoreKey) for FuzzyWriteBackTTL, so repeating the same typo hits exactly. An existing
+// entry for the query is never overwritten. With envelopes enabled the entry is an envelope
+// for the client's query like any other search result. The entry isn't added to the recent
+// query index, so typos never become fuzzy candidates themselves.
+func writeBackFuzzyHit(params SearchParams, response OpenLibraryResponse) {
+	ttl := CurrentConfig().FuzzyWriteBackTTL
+	if ttl <= 0 {
+		return
+	}
+	key := params.StoreKey()
+	goBackground(func(context.Context) {
+		stored, err := setResultsNX(key, params.Query, response, ttl)
+		if err != nil {
+			warnCacheWrite("Failed to cache fuzzy hit under the client's query", err, zap.String("key", key))
+			return
+		}
+		if stored {
+			stepLogger().Debug("Cached fuzzy hit under the client's query", zap.String("key", key), zap.Duration("ttl", ttl))
+		}
+	})
+}
+
+// getOrSetResults is Cache.GetOrSet for search results, storing them in an envelope for the
+// client's query when envelopes are enabled
+func getOrSetResults(ctx context.Context, key string, query string, v interface{}, loader func(ctx context.Context) (interface{}, error)) (cache.Loaded, error) {
+	if Cache.Envelopes() {
+		return Cache.GetOrSetEnvelope(ctx, key, query, CurrentConfig().CacheTTL, v, loader)
+	}
+	return Cache.GetOrSet(ctx, key, CurrentConfig().CacheTTL, v, loader)
+}
+
+// setResults is Cache.Set for search results, storing them in an envelope for the client's
+// query when envelopes are enabled
+func setResults(key string, query string, value interface{}) error {
+	if Cache.Envelopes() {
+		return Cache.SetEnvelope(key, query, value, CurrentConfig().CacheTTL)
+	}
+	return Cache.Set(key, value, CurrentConfig().CacheTTL)
+}
+
+// setResultsNX is Cache.SetNX for search results, storing them in an envelope for the
+// client's query when envelopes are enabled
+func setResultsNX(key string, query string, value interface{}, ttl time.Duration) (bool, error) {
+	if Cache.Envelopes() {
+		return Cache.SetEnvelopeNX(key, query, value, ttl)
+	}
+	return Cache.SetNX(key, value, ttl)
+}
+
+var (
+	// specialCharsReg matches anything other than letters, numbers, underscores and spaces.
+	// Unicode classes are used so accented and non-Latin letters survive normalization.
+	specialCharsReg = regexp.MustCompile(`[^\p{L}\p{N}_\s]`)
+	spaceReg        = regexp.MustCompile(`\s+`)
+)
+
 // normalizeQuery cleans and normalizes the search query
 func normalizeQuery(query string) string {
+	cfg := CurrentConfig()
+	query = sanitizeQuery(query, cfg.FoldHomoglyphs)
+
+	// Canonically equivalent forms (e.g. NFD "cafe\u0301" vs NFC "caf\u00e9") must map to the same key
+	query = norm.NFC.String(query)
+	if cfg.FoldAccents {
+		query = foldAccents(query)
+	}
 	query = strings.ToLower(query)
 	query = strings.TrimSpace(query)
 	
 	// Remove special characters (keep only letters, numbers, and spaces)
-	reg := regexp.MustCompile(`[^\w\s]`)
-	query = reg.ReplaceAllString(query, "")
+	query = specialCharsReg.ReplaceAllString(query, "")
 
-	spaceReg := regexp.MustCompile(`\s+`)
 	query = spaceReg.ReplaceAllString(query, " ")
 	
 	return query
@@ -140,13 +329,8 @@ func generateCacheKeyVariations(query string) []string {
 	sort.Strings(sortedWords)
 	variations = append(variations, strings.Join(sortedWords, " "))
 	
-	// Filter words longer than 3 characters (remove small words)
-	longWords := []string{}
-	for _, word := range queryWords {
-		if len(word) > 3 {
-			longWords = append(longWords, word)
-		}
-	}
+	// Keep only long words (remove small words); skipped when every word is short
+	longWords := significantWords(queryWords)
 
 	if len(longWords) > 0 {
 		variations = append(variations, strings.Join(longWords, " "))
@@ -168,76 +352,108 @@ func generateCacheKeyVariations(query string) []string {
 	return result
 }
 
+// significantWords drops short words ("the", "of", ...) that say little about a query,
+// keeping those of at least LongWordMinLength characters. It returns an empty slice
+// when every word is short.
+func significantWords(words []string) []string {
+	minLength := CurrentConfig().LongWordMinLength
+	long := []string{}
+	for _, word := range words {
+		if utf8.RuneCountInString(word) >= minLength {
+			long = append(long, word)
+		}
+	}
+	return long
+}
+
 // checkCache attempts to retrieve cached results for a search query
 // Tries multiple cache key variations to handle typos and different orderings
-func checkCache(c *gin.Context, query string, searchQuery string, startTime time.Time) (bool, string) {
+func checkCache(c *gin.Context, params SearchParams, startTime time.Time) (bool, string) {
 	if Cache == nil {
 		return false, ""
 	}
+	query := params.Query
+	namespace := params.Namespace()
+	strategy := CurrentConfig().CacheKeyStrategy
+
+	// Results for requests that change the upstream call are only ever stored under their own key
+	if params.changesUpstreamCall() {
+		return exactCacheHit(c, params, params.ParamsCacheKey(), startTime), params.ParamsCacheKey()
+	}
+
+	// Exact raw-query key, when results are keyed on it
+	if strategy != app.CacheKeyNormalized {
+		rawKey := params.RawCacheKey()
+		if exactCacheHit(c, params, rawKey, startTime) {
+			return true, rawKey
+		}
+		if strategy == app.CacheKeyRaw {
+			return false, ""
+		}
+	}
 
 	// Generate all possible cache key variations
 	variations := generateCacheKeyVariations(query)
-	Logger.Info("Trying cache key variations", 
+	stepLogger().Info("Trying cache key variations", 
 		zap.Int("num_variations", len(variations)),
 		zap.Strings("variations", variations))
 	
 	cacheStartTime := time.Now()
 	var cachedResponse OpenLibraryResponse
 	
-	// Try each variation until we find a hit
-	for _, variation := range variations {
-		cacheKey := fmt.Sprintf("search:%s", variation)
-		err := Cache.GetJSON(cacheKey, &cachedResponse)
+	// Try the variations; when several hit, the earliest (the normalized query first) wins
+	if hit, response := lookupVariations(c.Request.Context(), namespace, variations); hit >= 0 {
+		// Cache HIT!
+		variation := variations[hit]
+		cacheKey := fmt.Sprintf("%s:%s", namespace, variation)
+		cacheDuration := time.Since(cacheStartTime)
+		totalDuration := time.Since(startTime)
+		debugTrace(c.Request.Context(), "variation hit: %s (tried %v)", cacheKey, variations)
 		
-		if err == nil {
-			// Cache HIT!
-			cacheDuration := time.Since(cacheStartTime)
-			totalDuration := time.Since(startTime)
-			
-			Logger.Info("Cache HIT",
-				zap.String("original_query", query),
-				zap.String("matched_variation", variation),
-				zap.String("cache_key", cacheKey),
-				zap.Duration("cache_lookup_ms", cacheDuration),
-				zap.Duration("total_ms", totalDuration),
-				zap.Int("num_results", len(cachedResponse.Docs)))
-			
-			c.JSON(http.StatusOK, gin.H{
-				"query":         query,
-				"numFound":      cachedResponse.NumFound,
-				"results":       cachedResponse.Docs,
-				"cached":        true,
-				"cacheKey":      variation,
-				"responseTime":  fmt.Sprintf("%.2fms", totalDuration.Seconds()*1000),
-			})
-			return true, cacheKey
-		} else if err != redis.Nil {
-			Logger.Warn("Cache error", 
-				zap.String("key", cacheKey),
-				zap.Error(err))
-		}
+		stepLogger().Info("Cache HIT",
+			zap.String("original_query", query),
+			zap.String("matched_variation", variation),
+			zap.String("cache_key", cacheKey),
+			zap.Duration("cache_lookup_ms", cacheDuration),
+			zap.Duration("total_ms", totalDuration),
+			zap.Int("num_results", len(response.Docs)))
+		
+		body := searchResponse(params, response, sourceL2Exact, cachedAge(namespace, variation), startTime)
+		body["cacheKey"] = variation
+		writeSearchResponse(c, body)
+		return true, cacheKey
 	}
 	
+	// Quoted phrases only ever hit on their own words, never fuzzy or assembled results
+	if params.Phrase {
+		debugTrace(c.Request.Context(), "variations missed: %v (phrase, no fuzzy)", variations)
+		return false, ""
+	}
+
 	// No exact match found, try fuzzy matching
-	Logger.Info("Trying fuzzy matching", zap.String("query", query))
-	fuzzyMatches := findSimilarCachedQueries(query, 5)
+	debugTrace(c.Request.Context(), "variations missed: %v", variations)
+	stepLogger().Info("Trying fuzzy matching", zap.String("query", query))
+	fuzzyStartTime := time.Now()
+	fuzzyMatches := findSimilarCachedQueries(query, namespace, constants.FUZZY_MAX_CANDIDATES)
+	timingsFor(c).fuzzy = time.Since(fuzzyStartTime)
 	
 	if len(fuzzyMatches) > 0 {
 		// Try the best fuzzy match
 		bestMatch := fuzzyMatches[0]
-		Logger.Info("Found fuzzy matches",
+		stepLogger().Info("Found fuzzy matches",
 			zap.Int("num_matches", len(fuzzyMatches)),
 			zap.String("best_match", bestMatch.CachedQuery),
 			zap.Float64("score", bestMatch.Score),
 			zap.String("method", bestMatch.Method))
 		
 		// Try to get the fuzzy match from cache
-		err := Cache.GetJSON(bestMatch.Key, &cachedResponse)
+		err := getCachedJSON(c.Request.Context(), bestMatch.Key, &cachedResponse)
 		if err == nil {
 			cacheDuration := time.Since(cacheStartTime)
 			totalDuration := time.Since(startTime)
 			
-			Logger.Info("Cache HIT (fuzzy match)",
+			debugTrace(c.Request.Context(), "fuzzy hit: %s (%s, score %.2f)", bestMatch.Key, bestMatch.Method, bestMatch.Score)
+			stepLogger().Info("Cache HIT (fuzzy match)",
 				zap.String("original_query", query),
 				zap.String("matched_query", bestMatch.CachedQuery),
 				zap.Float64("similarity_score", bestMatch.Score),
@@ -246,24 +462,51 @@ func checkCache(c *gin.Context, query string, searchQuery string, startTime time
 				zap.Duration("total_ms", totalDuration),
 				zap.Int("num_results", len(cachedResponse.Docs)))
 			
-			c.JSON(http.StatusOK, gin.H{
-				"query":           query,
-				"numFound":        cachedResponse.NumFound,
-				"results":         cachedResponse.Docs,
-				"cached":          true,
-				"fuzzyMatch":      true,
-				"matchedQuery":    bestMatch.CachedQuery,
-				"similarityScore": bestMatch.Score,
-				"responseTime":    fmt.Sprintf("%.2fms", totalDuration.Seconds()*1000),
-			})
+			body := searchResponse(params, cachedResponse, sourceL2Fuzzy, cachedAge(namespace, bestMatch.CachedQuery), startTime)
+			body["fuzzyMatch"] = true
+			body["matchedQuery"] = bestMatch.CachedQuery
+			body["similarityScore"] = bestMatch.Score
+			body["fuzzyCandidates"] = clientFuzzyCandidates(fuzzyMatches)
+			if CurrentConfig().FuzzyMatchDetails {
+				body["matchMethod"] = bestMatch.Method
+				if bestMatch.Method == "levenshtein" {
+					body["editDistance"] = bestMatch.Distance
+				}
+			}
+			writeSearchResponse(c, body)
+			if CurrentConfig().FuzzyWriteBack {
+				writeBackFuzzyHit(params, cachedResponse)
+			}
 			return true, bestMatch.Key
 		}
 	}
 	
+	// Opt-in: answer from cached single-word queries while the full query is fetched
+	if CurrentConfig().AssembledResults {
+		if assembled, words, ok := assembleFromWords(params); ok {
+			debugTrace(c.Request.Context(), "assembled from: %v", words)
+			stepLogger().Info("Cache HIT (assembled)",
+				zap.String("original_query", query),
+				zap.Strings("words", words),
+				zap.Int("num_results", len(assembled.Docs)))
+			
+			body := searchResponse(params, assembled, sourceL2Assembled, unknownAge, startTime)
+			body["assembled"] = true
+			body["assembledFrom"] = words
+			writeSearchResponse(c, body)
+			
+			goBackground(func(ctx context.Context) {
+				fetchInBackground(ctx, params)
+			})
+			return true, ""
+		}
+	}
+	
 	// Cache MISS on all variations (including fuzzy)
+	debugTrace(c.Request.Context(), "fuzzy missed: %d candidates matched", len(fuzzyMatches))
 	cacheDuration := time.Since(cacheStartTime)
-	Logger.Info("Cache MISS (all variations + fuzzy)",
-		zap.String("query", searchQuery),
+	stepLogger().Info("Cache MISS (all variations + fuzzy)",
+		zap.String("query", params.SearchQuery()),
 		zap.Int("variations_tried", len(variations)),
 		zap.Int("fuzzy_matches_found", len(fuzzyMatches)),
 		zap.Duration("total_lookup_ms", cacheDuration))
@@ -271,133 +514,304 @@ func checkCache(c *gin.Context, query string, searchQuery string, startTime time
 	return false, ""
 }
 
+// exactCacheHit serves the result cached under exactly key, without trying variations or
+// fuzzy matches. Returns false on a miss.
+func exactCacheHit(c *gin.Context, params SearchParams, key string, startTime time.Time) bool {
+	var response OpenLibraryResponse
+	err := getCachedJSON(c.Request.Context(), key, &response)
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			Logger.Warn("Cache error", 
+				zap.String("key", key),
+				zap.Error(err))
+		}
+		debugTrace(c.Request.Context(), "exact key miss: %s", key)
+		stepLogger().Info("Cache MISS (exact key)", zap.String("cache_key", key))
+		return false
+	}
+	
+	debugTrace(c.Request.Context(), "exact key hit: %s", key)
+	stepLogger().Info("Cache HIT (exact key)",
+		zap.String("original_query", params.Query),
+		zap.String("cache_key", key),
+		zap.Int("num_results", len(response.Docs)))
+	
+	body := searchResponse(params, response, sourceL2Exact, unknownAge, startTime)
+	body["cacheKey"] = params.Query
+	writeSearchResponse(c, body)
+	return true
+}
+
+// lookupVariations reads the cached result for each variation and returns the index of the
+// earliest one that hit, or -1. Reads are sequential, stopping at the first hit, unless
+// ConcurrentVariationReads is set, in which case they are issued in parallel (bounded by
+// VARIATION_READ_CONCURRENCY) and the earliest hit is still preferred.
+func lookupVariations(ctx context.Context, namespace string, variations []string) (int, OpenLibraryResponse) {
+	responses := make([]OpenLibraryResponse, len(variations))
+	hits := make([]bool, len(variations))
+
+	read := func(i int) {
+		cacheKey := fmt.Sprintf("%s:%s", namespace, variations[i])
+		err := getCachedJSON(ctx, cacheKey, &responses[i])
+		if err == nil {
+			hits[i] = true
+		} else if !errors.Is(err, redis.Nil) {
+			Logger.Warn("Cache error",
+				zap.String("key", cacheKey),
+				zap.Error(err))
+		}
+	}
+
+	if !CurrentConfig().ConcurrentVariationReads || len(variations) < 2 {
+		for i := range variations {
+			if read(i); hits[i] {
+				return i, responses[i]
+			}
+		}
+		return -1, OpenLibraryResponse{}
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, constants.VARIATION_READ_CONCURRENCY)
+	for i := range variations {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			read(i)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range variations {
+		if hits[i] {
+			return i, responses[i]
+		}
+	}
+	return -1, OpenLibraryResponse{}
+}
+
+// fallbackWorthy reports whether a query has been requested often enough (FallbackMinRequests,
+// per the stats counters) for its results to be persisted to the stale fallback. Without
+// stats, or when they can't be read, every result is.
+func fallbackWorthy(normalizedQuery string) bool {
+	minRequests := CurrentConfig().FallbackMinRequests
+	if minRequests <= 1 || Stats == nil || Cache == nil {
+		return true
+	}
+	key := statsQueryPrefix + analyticsKey(normalizedQuery)
+	count := Stats.Pending(key)
+	if stored, err := Cache.Get(key); err == nil {
+		n, _ := strconv.ParseInt(stored, 10, 64)
+		count += n
+	} else if !errors.Is(err, redis.Nil) {
+		return true
+	}
+	return count >= int64(minRequests)
+}
+
+// serveStaleFallback answers from the on-disk last known good store when both the
+// cache and upstream have failed. Returns false if there is nothing to serve.
+func serveStaleFallback(c *gin.Context, params SearchParams, fallbackKey string, startTime time.Time) bool {
+	if Fallback == nil {
+		return false
+	}
+
+	var staleResponse OpenLibraryResponse
+	if err := Fallback.LoadJSON(fallbackKey, &staleResponse); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			Logger.Warn("Failed to read stale fallback", zap.Error(err))
+		}
+		return false
+	}
+
+	Logger.Warn("Serving stale fallback",
+		zap.String("key", fallbackKey),
+		zap.Int("num_results", len(staleResponse.Docs)))
+
+	age := unknownAge
+	if savedAt, err := Fallback.SavedAt(fallbackKey); err == nil {
+		age = time.Since(savedAt)
+	}
+
+	body := searchResponse(params, staleResponse, sourceStaleFallback, age, startTime)
+	body["staleFallback"] = true
+	writeSearchResponse(c, body)
+	return true
+}
+
 func Search(c *gin.Context) {
 	startTime := time.Now() // Start overall timer
 
-	query := c.Query("q")
-	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Search query parameter 'q' is required",
-		})
+	// Cache and upstream retries for this request draw from one budget
+	c.Request = c.Request.WithContext(withRetryBudget(c.Request.Context()))
+
+	params, err := parseSearchParams(c)
+	if CurrentConfig().LogMode == app.LogModeSummary {
+		defer logSearchSummary(c, params, startTime)
+	}
+	if err != nil {
+		var paramErr *paramError
+		if errors.As(err, &paramErr) {
+			c.JSON(http.StatusBadRequest, paramErr.body())
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	normalizedQuery := normalizeQuery(query)
-	Logger.Info("Moses kang normalized query", zap.String("normalizedQuery", normalizedQuery))
-
-	queryWords := strings.Split(normalizedQuery, " ")
-	searchQuery := strings.Join(queryWords, "+")
+	query := params.Query
+	normalizedQuery := params.NormalizedQuery
+	stepLogger().Info("Moses kang normalized query", zap.String("normalizedQuery", normalizedQuery))
+	searchQuery := params.SearchQuery()
+	namespace := params.Namespace()
 
-	Logger.Info("Search request received", zap.String("query", searchQuery))
+	stepLogger().Info("Search request received", zap.String("query", searchQuery))
 
 	// Try to get from cache first (tries multiple variations)
-	cacheHit, _ := checkCache(c, query, searchQuery, startTime)
+	cacheHit, _ := checkCache(c, params, startTime)
+	timings := timingsFor(c)
+	timings.cache = time.Since(startTime) - timings.fuzzy
+	recordSearchStats(cacheHit, normalizedQuery)
 	if cacheHit {
 		return
 	}
 
-	Logger.Info("Cache Miss, Calling API", zap.String("query", searchQuery))
+	stepLogger().Info("Cache Miss, Calling API", zap.String("query", searchQuery))
 
-	searchURL := fmt.Sprintf("%s%s%s%s%s", 
-		constants.OpenLibraryAPIURL, 
-		constants.OpenLibrarySearchEndpoint, 
-		searchQuery, 
-		constants.QueryLimit, 
-		"3")
-	
-	// Time the API call
-	apiStartTime := time.Now()
-	response, err := http.Get(searchURL)
-	apiDuration := time.Since(apiStartTime)
-	
-	if err != nil {
-		Logger.Error("API call failed", 
-			zap.Error(err),
-			zap.Duration("api_duration_ms", apiDuration))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get search results",
-		})
+	// Canonical key the result is stored under, in Redis and in the stale fallback
+	strategy := CurrentConfig().CacheKeyStrategy
+	paramsKeyed := params.changesUpstreamCall()
+	cacheKey := params.StoreKey()
+
+	// The time budget covers the whole request, so a slow cache lookup leaves less for upstream
+	timeout := upstreamTimeout(query, params.Match, params.ExtendedTimeout)
+	deadline := startTime.Add(timeout)
+	if upstreamTooLate(deadline) {
+		if serveStaleFallback(c, params, cacheKey, startTime) {
+			return
+		}
+		respondDeadlineExceeded(c, timeout, startTime)
 		return
 	}
+	ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+	defer cancel()
 
-	Logger.Info("API response received", 
-		zap.Int("statusCode", response.StatusCode),
-		zap.Duration("api_duration_ms", apiDuration))
-	defer response.Body.Close()
+	searchURL := params.UpstreamURL()
+	fetch := func(ctx context.Context) (upstreamResult, error) {
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		return fetchOpenLibrary(ctx, searchURL)
+	}
+	// The load is shared with concurrent requests for the same key and runs detached from
+	// this request's cancellation, so it reports its timings through WithMeta rather than
+	// writing to this request's state
+	loadFromUpstream := func(ctx context.Context) (interface{}, error) {
+		result, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		value := cache.WithMeta(result.Response, result)
+		if !cacheableResponse(searchURL, result.Response) {
+			return cache.DontStore(value), nil
+		}
+		return value, nil
+	}
 
-	readStartTime := time.Now()
-	body, err := io.ReadAll(response.Body)
-	readDuration := time.Since(readStartTime)
-	
-	if err != nil {
-		Logger.Error("Error reading response body", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to read response body",
-		})
-		return
+	// Cache-aside on the canonical key. A hit here means a concurrent request filled it
+	// after checkCache missed.
+	var apiResponse OpenLibraryResponse
+	var result upstreamResult
+	loadedHit := false
+	storable := true // false when the response failed cacheableResponse and must not be cached
+	upstreamStartTime := time.Now()
+	if Cache != nil {
+		var loaded cache.Loaded
+		loaded, err = getOrSetResults(ctx, cacheKey, params.Query, &apiResponse, loadFromUpstream)
+		loadedHit = loaded.Hit
+		// Set whether this request ran the load or waited on another request's
+		result, _ = loaded.Meta.(upstreamResult)
+		// The load that failed cacheableResponse may have been another request's
+		storable = !errors.Is(err, cache.ErrNotStored)
+	} else {
+		result, err = fetch(ctx)
+		apiResponse = result.Response
+		storable = err == nil && cacheableResponse(searchURL, apiResponse)
+	}
+	if !loadedHit {
+		timings.upstream = time.Since(upstreamStartTime)
 	}
 
-	Logger.Debug("Response body read", 
-		zap.Int("body_size_bytes", len(body)),
-		zap.Duration("read_duration_ms", readDuration))
+	if err != nil && !errors.Is(err, cache.ErrSetFailed) {
+		if serveStaleFallback(c, params, cacheKey, startTime) {
+			return
+		}
+		// Failing to connect or to get an upstream slot is reported as such even if it used up the budget.
+		// The shared load runs to the same deadline, so its error can arrive before ctx reports it.
+		budgetSpent := errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded)
+		if budgetSpent && !errors.Is(err, errUpstreamConnect) && !errors.Is(err, errUpstreamSaturated) {
+			respondDeadlineExceeded(c, timeout, startTime)
+			return
+		}
+		respondUpstreamError(c, err, http.StatusInternalServerError)
+		return
+	}
 
-	parseStartTime := time.Now()
-	var apiResponse OpenLibraryResponse
-	err = json.Unmarshal(body, &apiResponse)
-	parseDuration := time.Since(parseStartTime)
-	
-	if err != nil {
-		Logger.Error("Error unmarshalling response body", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to parse API response",
-		})
+	if loadedHit {
+		stepLogger().Info("Cache HIT (filled concurrently)", zap.String("cache_key", cacheKey))
+		writeSearchResponse(c, searchResponse(params, apiResponse, sourceL2Exact, cachedAge(namespace, normalizedQuery), startTime))
 		return
 	}
 
+	apiDuration := result.APIDuration
+	parseDuration := result.ParseDuration
 	totalDuration := time.Since(startTime)
 	
-	Logger.Info("API search completed",
+	stepLogger().Info("API search completed",
 		zap.Int("numFound", apiResponse.NumFound),
 		zap.Int("numReturned", len(apiResponse.Docs)),
 		zap.Duration("parse_duration_ms", parseDuration),
 		zap.Duration("total_duration_ms", totalDuration))
 
-	// Store in cache ADJUST TIME TO HOLD CACHED DATA IN CONSTANTS FILE
 	if Cache != nil {
-		// Generate cache key for storing the result
-		cacheKey := fmt.Sprintf("search:%s", normalizedQuery)
-		
-		cacheWriteStart := time.Now()
-		err = Cache.Set(cacheKey, apiResponse, constants.CACHE_TTL_MINUTES*time.Minute)
-		cacheWriteDuration := time.Since(cacheWriteStart)
-		
 		if err != nil {
-			Logger.Warn("Failed to cache result", 
-				zap.Error(err),
-				zap.Duration("cache_write_duration_ms", cacheWriteDuration))
+			warnCacheWrite("Failed to cache result", err)
 		} else {
-			Logger.Info("Result cached successfully", 
-				zap.String("key", cacheKey),
-				zap.Duration("cache_write_duration_ms", cacheWriteDuration))
+			stepLogger().Info("Result cached successfully", zap.String("key", cacheKey))
+			// Fuzzy matching and cache stats work on normalized query keys only
+			if strategy != app.CacheKeyRaw && !paramsKeyed {
+				recordRecentQuery(namespace, normalizedQuery)
+			}
+		}
+		
+		// Keyed both ways: also store under the raw query so the exact spelling hits first next time
+		if rawKey := params.RawCacheKey(); strategy == app.CacheKeyBoth && !paramsKeyed && storable {
+			if err := setResults(rawKey, params.Query, apiResponse); err != nil {
+				warnCacheWrite("Failed to cache result under raw query", err)
+			}
+		}
+	}
+
+	if Fallback != nil && storable && fallbackWorthy(normalizedQuery) {
+		if err := Fallback.Save(cacheKey, apiResponse); err != nil {
+			Logger.Warn("Failed to persist stale fallback", zap.Error(err))
 		}
 	}
 
 	// Performance summary
-	Logger.Info("⚡ Performance Summary",
+	stepLogger().Info("⚡ Performance Summary",
 		zap.String("query", searchQuery),
 		zap.Duration("api_call_ms", apiDuration),
 		zap.Duration("total_request_ms", totalDuration),
 		zap.Float64("api_percentage", (apiDuration.Seconds()/totalDuration.Seconds())*100))
 
-	c.JSON(http.StatusOK, gin.H{
-		"query":        query,
-		"numFound":     apiResponse.NumFound,
-		"results":      apiResponse.Docs,
-		"cached":       false,
-		"responseTime": fmt.Sprintf("%.2fms", totalDuration.Seconds()*1000),
-		"metrics": gin.H{
-			"api_call_ms":    fmt.Sprintf("%.2f", apiDuration.Seconds()*1000),
-			"total_ms":       fmt.Sprintf("%.2f", totalDuration.Seconds()*1000),
-			"parse_ms":       fmt.Sprintf("%.2f", parseDuration.Seconds()*1000),
-		},
-	})
+	body := searchResponse(params, apiResponse, sourceUpstream, 0, startTime)
+	if params.IncludeMetrics {
+		body["metrics"] = gin.H{
+			"api_call_ms": fmt.Sprintf("%.2f", apiDuration.Seconds()*1000),
+			"total_ms":    fmt.Sprintf("%.2f", totalDuration.Seconds()*1000),
+			"parse_ms":    fmt.Sprintf("%.2f", parseDuration.Seconds()*1000),
+		}
+	}
+	writeSearchResponse(c, body)
 }
\ No newline at end of file
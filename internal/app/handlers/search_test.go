@@ -0,0 +1,1106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agnivade/levenshtein"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// indexQueries records queries in a namespace's recency index, oldest first
+func indexQueries(t testing.TB, namespace string, queries ...string) {
+	t.Helper()
+	start := time.Now().Add(-time.Duration(len(queries)) * time.Second)
+	for i, query := range queries {
+		if err := Cache.AddToIndex(recentIndexKey(namespace), query, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fillerQueries are n distinct queries unlike any test query
+func fillerQueries(n int) []string {
+	queries := make([]string, n)
+	for i := range queries {
+		queries[i] = fmt.Sprintf("zzqx%04d", i)
+	}
+	return queries
+}
+
+func TestFindSimilarCachedQueriesOnlyConsidersRecentQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		indexed []string // oldest first
+		want    string   // expected best match, "" for none
+	}{
+		{
+			name:    "recent query matches",
+			indexed: append(fillerQueries(10), "harry poter"),
+			want:    "harry poter",
+		},
+		{
+			name:    "query pushed out of the recency window is ignored",
+			indexed: append([]string{"harry poter"}, fillerQueries(constants.FUZZY_RECENT_WINDOW)...),
+			want:    "",
+		},
+		{
+			name:    "query at the edge of the window still matches",
+			indexed: append([]string{"harry poter"}, fillerQueries(constants.FUZZY_RECENT_WINDOW-1)...),
+			want:    "harry poter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			indexQueries(t, "search", tt.indexed...)
+
+			matches := findSimilarCachedQueries("harry potter", "search", 5)
+			got := ""
+			if len(matches) > 0 {
+				got = matches[0].CachedQuery
+			}
+			if got != tt.want {
+				t.Errorf("best match = %q, want %q (matches %+v)", got, tt.want, matches)
+			}
+		})
+	}
+}
+
+func TestFallbackWorthy(t *testing.T) {
+	tests := []struct {
+		name        string
+		minRequests int
+		stats       bool
+		stored      string // counter already flushed to Redis, "" for none
+		pending     int64  // increments not flushed yet
+		want        bool
+	}{
+		{name: "gate disabled", minRequests: 0, stats: true, want: true},
+		{name: "gate of one", minRequests: 1, stats: true, want: true},
+		{name: "no stats to go by", minRequests: 3, stats: false, want: true},
+		{name: "never requested", minRequests: 3, stats: true, want: false},
+		{name: "below the minimum", minRequests: 3, stats: true, stored: "1", pending: 1, want: false},
+		{name: "flushed counts reach it", minRequests: 3, stats: true, stored: "3", want: true},
+		{name: "pending counts reach it", minRequests: 3, stats: true, pending: 3, want: true},
+		{name: "flushed and pending together", minRequests: 3, stats: true, stored: "2", pending: 1, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.FallbackMinRequests = tt.minRequests
+			})
+			c, _ := useCache(t)
+			if tt.stats {
+				useStats(t)
+			}
+			key := statsQueryPrefix + analyticsKey("dune")
+			if tt.stored != "" {
+				if err := c.Set(key, tt.stored, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.pending > 0 {
+				Stats.Add(key, tt.pending)
+			}
+
+			if got := fallbackWorthy("dune"); got != tt.want {
+				t.Errorf("fallbackWorthy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchServesStaleFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		saved      bool
+		wantStatus int
+		wantStale  bool
+	}{
+		{name: "saved copy served", saved: true, wantStatus: http.StatusOK, wantStale: true},
+		{name: "nothing saved", saved: false, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useUpstream(t, http.StatusServiceUnavailable, `<html>Service Unavailable</html>`)
+			store, err := cache.NewDiskStore(t.TempDir(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetFallback(store)
+			t.Cleanup(func() { SetFallback(nil) })
+			if tt.saved {
+				var saved OpenLibraryResponse
+				if err := json.Unmarshal([]byte(upstreamBody("Dune")), &saved); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Save("search:dune", saved); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q=Dune", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if stale, _ := body["staleFallback"].(bool); stale != tt.wantStale {
+				t.Errorf("staleFallback = %v, want %v", stale, tt.wantStale)
+			}
+			if tt.wantStale && body["source"] != sourceStaleFallback {
+				t.Errorf("source = %v, want %s", body["source"], sourceStaleFallback)
+			}
+		})
+	}
+}
+
+func TestSearchPersistsOnlyPopularQueries(t *testing.T) {
+	tests := []struct {
+		name        string
+		minRequests int
+		requests    int
+		wantSaved   bool
+	}{
+		{name: "every result saved when the gate is off", minRequests: 1, requests: 1, wantSaved: true},
+		{name: "one-off query not saved", minRequests: 3, requests: 1, wantSaved: false},
+		{name: "popular query saved", minRequests: 3, requests: 3, wantSaved: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.FallbackMinRequests = tt.minRequests
+			})
+			c, _ := useCache(t)
+			useStats(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			store, err := cache.NewDiskStore(t.TempDir(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetFallback(store)
+			t.Cleanup(func() { SetFallback(nil) })
+
+			for i := 0; i < tt.requests; i++ {
+				// Each request misses the cache, like distinct instances would
+				if err := c.Delete("search:dune"); err != nil {
+					t.Fatal(err)
+				}
+				if rec := serve(Search, http.MethodGet, "/search", "/search?q=Dune", ""); rec.Code != http.StatusOK {
+					t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+				}
+			}
+
+			var saved OpenLibraryResponse
+			err = store.LoadJSON("search:dune", &saved)
+			if saved := err == nil; saved != tt.wantSaved {
+				t.Errorf("saved = %v (%v), want %v", saved, err, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestNormalizeQueryUnicodeForms(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "precomposed", query: "Caf\u00e9 Society", want: "caf\u00e9 society"},
+		{name: "decomposed", query: "Cafe\u0301 Society", want: "caf\u00e9 society"},
+		{name: "decomposed hangul", query: "\u1112\u1161\u11ab", want: "\ud55c"},
+		{name: "plain ascii unchanged", query: "Dune", want: "dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			if got := normalizeQuery(tt.query); got != tt.want {
+				t.Errorf("normalizeQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSharesCacheAcrossUnicodeForms(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Café Society"))
+
+	for _, target := range []string{"/search?q=Caf%C3%A9+Society", "/search?q=Cafe%CC%81+Society"} {
+		if rec := serve(Search, http.MethodGet, "/search", target, ""); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status code = %d: %s", target, rec.Code, rec.Body.String())
+		}
+	}
+	if upstream.calls() != 1 {
+		t.Errorf("upstream calls = %d, want 1: the second form should hit the first one's entry", upstream.calls())
+	}
+}
+
+// longQuery is a twelve-word query of five-letter words
+const longQuery = "alpha bravo charl delta echos foxtr golfs hotel india julie kilos limas"
+
+// longFillerQueries are n distinct twelve-word queries whose words are all too far from
+// longQuery's to match, so each costs the full word comparisons before giving up
+func longFillerQueries(n int) []string {
+	queries := make([]string, n)
+	for i := range queries {
+		words := make([]string, 12)
+		for j := range words {
+			words[j] = fmt.Sprintf("q%02d%02d", i%100, j)
+		}
+		queries[i] = strings.Join(words, " ") + fmt.Sprintf(" z%d", i)
+	}
+	return queries
+}
+
+func TestFindSimilarCachedQueriesWordComparisonBudget(t *testing.T) {
+	// Shares eight of longQuery's twelve words, too far apart for a whole-query match
+	wordMatch := "alpha bravo charl delta echos foxtr golfs hotel mmmmm nnnnn ooooo ppppp"
+	tests := []struct {
+		name    string
+		fillers int // newer than wordMatch, so compared first
+		want    string
+	}{
+		{name: "word match within budget", fillers: 10, want: wordMatch},
+		{name: "word match past the budget skipped", fillers: 150, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			indexQueries(t, "search", append([]string{wordMatch}, longFillerQueries(tt.fillers)...)...)
+
+			matches := findSimilarCachedQueries(longQuery, "search", constants.FUZZY_MAX_CANDIDATES)
+			got := ""
+			if len(matches) > 0 {
+				got = matches[0].CachedQuery
+			}
+			if got != tt.want {
+				t.Errorf("best match = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSimilarCachedQueriesWholeQueryMatchPastBudget(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	// The whole-query comparison isn't limited by the word comparison budget
+	typo := "alpha bravo charl delta echos foxtr golfs hotel india julie kilos limes"
+	indexQueries(t, "search", append([]string{typo}, longFillerQueries(150)...)...)
+
+	matches := findSimilarCachedQueries(longQuery, "search", constants.FUZZY_MAX_CANDIDATES)
+	if len(matches) == 0 || matches[0].CachedQuery != typo || matches[0].Method != "levenshtein" {
+		t.Errorf("matches = %+v, want a levenshtein match on %q", matches, typo)
+	}
+}
+
+// unboundedWordMatches is the word-by-word fuzzy match without the comparison budget, the
+// early break or the length prefilter, reporting how many word pairs it compared
+func unboundedWordMatches(query string, cached []string, cfg app.Config) (matches int, comparisons int) {
+	queryWords := strings.Split(query, " ")
+	for _, cachedQuery := range cached {
+		cachedWords := strings.Split(cachedQuery, " ")
+		matching := 0
+		for _, qWord := range queryWords {
+			for _, cWord := range cachedWords {
+				comparisons++
+				if levenshtein.ComputeDistance(qWord, cWord) <= cfg.FuzzyWordDistance {
+					matching++
+					break
+				}
+			}
+		}
+		if float64(matching)/float64(max(len(queryWords), len(cachedWords))) >= cfg.FuzzyWordMatchRatio {
+			matches++
+		}
+	}
+	return matches, comparisons
+}
+
+// BenchmarkFuzzyWordMatching compares the bounded word matching in findSimilarCachedQueries
+// with the unbounded nested loop it replaced, on a long query against a full recency window
+func BenchmarkFuzzyWordMatching(b *testing.B) {
+	cfg := useConfig(b, nil)
+	useCache(b)
+	cached := longFillerQueries(constants.FUZZY_RECENT_WINDOW)
+	indexQueries(b, "search", cached...)
+	// Serve candidates from memory so only the matching itself is measured
+	if err := fuzzySnapshot.refresh(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("bounded", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			findSimilarCachedQueries(longQuery, "search", constants.FUZZY_MAX_CANDIDATES)
+		}
+	})
+	b.Run("unbounded", func(b *testing.B) {
+		comparisons := 0
+		for i := 0; i < b.N; i++ {
+			_, comparisons = unboundedWordMatches(longQuery, cached, cfg)
+		}
+		b.ReportMetric(float64(comparisons), "comparisons/op")
+	})
+}
+
+func TestLengthGap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "dune", b: "dune", want: 0},
+		{a: "dune", b: "dunes", want: 1},
+		{a: "dunes", b: "dune", want: 1},
+		{a: "", b: "emma", want: 4},
+		{a: "café", b: "cafe", want: 0}, // runes, not bytes
+		{a: "東京", b: "tokyo", want: 3},
+	}
+
+	for _, tt := range tests {
+		if got := lengthGap(tt.a, tt.b); got != tt.want {
+			t.Errorf("lengthGap(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// prefilterCandidates are cached queries at a range of lengths and distances from "harry potter"
+var prefilterCandidates = []string{
+	"harry potter and the goblet of fire", "harry poter", "hary potter", "harry potters",
+	"harry", "potter harry", "harry potter 1", "harry potter 12", "harry potter 123",
+	"harry potter 1234", "barry trotter", "larry potter", "härry pötter", "dune",
+}
+
+// unfilteredLevenshteinMatches is the whole-query match computing every distance, as
+// findSimilarCachedQueries did before the length prefilter
+func unfilteredLevenshteinMatches(query string, cached []string, maxDistance int) []string {
+	var matches []string
+	for _, cachedQuery := range cached {
+		if cachedQuery != query && levenshtein.ComputeDistance(query, cachedQuery) <= maxDistance {
+			matches = append(matches, cachedQuery)
+		}
+	}
+	return matches
+}
+
+func TestLengthPrefilterKeepsLevenshteinMatches(t *testing.T) {
+	cfg := useConfig(t, nil)
+	useCache(t)
+	indexQueries(t, "search", prefilterCandidates...)
+
+	var got []string
+	for _, match := range findSimilarCachedQueries("harry potter", "search", len(prefilterCandidates)) {
+		if match.Method == "levenshtein" {
+			got = append(got, match.CachedQuery)
+		}
+	}
+	want := unfilteredLevenshteinMatches("harry potter", prefilterCandidates, cfg.FuzzyMaxDistance)
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("levenshtein matches = %q, want %q as without the prefilter", got, want)
+	}
+	// A length gap equal to the max distance must still be compared
+	if !slices.Contains(got, "harry potter 12") || slices.Contains(got, "harry potter 1234") {
+		t.Errorf("levenshtein matches = %q, want one at a gap of 3 and none at 5", got)
+	}
+}
+
+// BenchmarkWholeQueryLevenshtein measures the whole-query comparison over a recency window of
+// mostly longer queries, with and without the length prefilter
+func BenchmarkWholeQueryLevenshtein(b *testing.B) {
+	cached := longFillerQueries(constants.FUZZY_RECENT_WINDOW)
+	maxDistance := app.DefaultConfig().FuzzyMaxDistance
+
+	b.Run("prefiltered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, cachedQuery := range cached {
+				if lengthGap("harry potter", cachedQuery) <= maxDistance {
+					levenshtein.ComputeDistance("harry potter", cachedQuery)
+				}
+			}
+		}
+	})
+	b.Run("unfiltered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			unfilteredLevenshteinMatches("harry potter", cached, maxDistance)
+		}
+	})
+}
+
+func TestLookupVariationsPrefersEarliestHit(t *testing.T) {
+	variations := []string{"project hail mary", "hail mary project", "project", "hail", "mary"}
+	tests := []struct {
+		name      string
+		cached    []int // indexes of the variations that are cached
+		wantIndex int
+	}{
+		{name: "canonical and later variations hit", cached: []int{0, 1, 4}, wantIndex: 0},
+		{name: "later variations hit", cached: []int{4, 2, 3}, wantIndex: 2},
+		{name: "only the last hits", cached: []int{4}, wantIndex: 4},
+		{name: "nothing hits", wantIndex: -1},
+	}
+
+	for _, concurrent := range []bool{false, true} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s concurrent=%v", tt.name, concurrent), func(t *testing.T) {
+				useConfig(t, func(cfg *app.Config) { cfg.ConcurrentVariationReads = concurrent })
+				useCache(t)
+				for _, i := range tt.cached {
+					cacheResults(t, "search:"+variations[i], upstreamBody(variations[i]))
+				}
+
+				index, response := lookupVariations(context.Background(), "search", variations)
+				if index != tt.wantIndex {
+					t.Fatalf("hit index = %d, want %d", index, tt.wantIndex)
+				}
+				if index >= 0 && (len(response.Docs) != 1 || response.Docs[0]["title"] != variations[index]) {
+					t.Errorf("response = %+v, want the one cached under %q", response.Docs, variations[index])
+				}
+			})
+		}
+	}
+}
+
+func TestSearchPrefersCanonicalVariation(t *testing.T) {
+	for _, concurrent := range []bool{false, true} {
+		t.Run(fmt.Sprintf("concurrent=%v", concurrent), func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.ConcurrentVariationReads = concurrent })
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Upstream"))
+			cacheResults(t, "search:hail mary project", upstreamBody("Sorted"))
+			cacheResults(t, "search:project hail mary", upstreamBody("Canonical"))
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q=Project+Hail+Mary", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			if body["cacheKey"] != "project hail mary" {
+				t.Errorf("cacheKey = %v, want the canonical variation", body["cacheKey"])
+			}
+		})
+	}
+}
+
+func TestFindSimilarCachedQueriesMaxResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxResults  int
+		wantMatches int
+	}{
+		{name: "zero disables fuzzy matching", maxResults: 0, wantMatches: 0},
+		{name: "negative disables fuzzy matching", maxResults: -1, wantMatches: 0},
+		{name: "one", maxResults: 1, wantMatches: 1},
+		{name: "more than found", maxResults: 10, wantMatches: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			_, server := useCache(t)
+			indexQueries(t, "search", "harry poter", "hary potter", "harry potters")
+			commands := server.CommandCount()
+
+			matches := findSimilarCachedQueries("harry potter", "search", tt.maxResults)
+			if len(matches) != tt.wantMatches {
+				t.Errorf("%d matches, want %d: %+v", len(matches), tt.wantMatches, matches)
+			}
+			if tt.maxResults <= 0 && server.CommandCount() != commands {
+				t.Errorf("%d Redis commands sent with fuzzy matching disabled, want none", server.CommandCount()-commands)
+			}
+		})
+	}
+}
+
+func TestSearchCacheKeyStrategies(t *testing.T) {
+	tests := []struct {
+		name         string
+		strategy     string
+		targets      []string // requested in order
+		wantCalls    int      // upstream requests
+		wantKeys     []string
+		wantAbsent   []string
+		wantCacheKey interface{} // cacheKey reported by the last response
+		wantIndexed  bool        // the normalized query was added to the recency index
+	}{
+		{
+			name:         "normalized spellings share an entry",
+			strategy:     app.CacheKeyNormalized,
+			targets:      []string{"/search?q=The+Hobbit", "/search?q=the+hobbit"},
+			wantCalls:    1,
+			wantKeys:     []string{"search:the hobbit"},
+			wantAbsent:   []string{"search:raw:The Hobbit"},
+			wantCacheKey: "the hobbit",
+			wantIndexed:  true,
+		},
+		{
+			name:        "raw spellings miss each other",
+			strategy:    app.CacheKeyRaw,
+			targets:     []string{"/search?q=The+Hobbit", "/search?q=the+hobbit"},
+			wantCalls:   2,
+			wantKeys:    []string{"search:raw:The Hobbit", "search:raw:the hobbit"},
+			wantAbsent:  []string{"search:the hobbit"},
+			wantIndexed: false,
+		},
+		{
+			name:         "raw exact repeat hits",
+			strategy:     app.CacheKeyRaw,
+			targets:      []string{"/search?q=The+Hobbit", "/search?q=The+Hobbit"},
+			wantCalls:    1,
+			wantKeys:     []string{"search:raw:The Hobbit"},
+			wantCacheKey: "The Hobbit",
+		},
+		{
+			name:         "both falls back to the normalized entry",
+			strategy:     app.CacheKeyBoth,
+			targets:      []string{"/search?q=The+Hobbit", "/search?q=the+hobbit"},
+			wantCalls:    1,
+			wantKeys:     []string{"search:the hobbit", "search:raw:The Hobbit"},
+			wantCacheKey: "the hobbit",
+			wantIndexed:  true,
+		},
+		{
+			name:         "both prefers the raw entry",
+			strategy:     app.CacheKeyBoth,
+			targets:      []string{"/search?q=The+Hobbit", "/search?q=The+Hobbit"},
+			wantCalls:    1,
+			wantKeys:     []string{"search:the hobbit", "search:raw:The Hobbit"},
+			wantCacheKey: "The Hobbit",
+			wantIndexed:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.CacheKeyStrategy = tt.strategy })
+			c, _ := useCache(t)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("The Hobbit"))
+
+			var body map[string]interface{}
+			for _, target := range tt.targets {
+				rec := serve(Search, http.MethodGet, "/search", target, "")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s: status code = %d: %s", target, rec.Code, rec.Body.String())
+				}
+				body = decodeBody(t, rec)
+			}
+			if upstream.calls() != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", upstream.calls(), tt.wantCalls)
+			}
+			for _, key := range tt.wantKeys {
+				if exists, _ := c.Exists(key); !exists {
+					t.Errorf("key %q not cached", key)
+				}
+			}
+			for _, key := range tt.wantAbsent {
+				if exists, _ := c.Exists(key); exists {
+					t.Errorf("key %q cached, want it absent", key)
+				}
+			}
+			if body["cacheKey"] != tt.wantCacheKey {
+				t.Errorf("cacheKey = %v, want %v", body["cacheKey"], tt.wantCacheKey)
+			}
+			recent, err := c.RecentFromIndex(recentIndexKey("search"), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if indexed := slices.Contains(recent, "the hobbit"); indexed != tt.wantIndexed {
+				t.Errorf("indexed = %v, want %v", indexed, tt.wantIndexed)
+			}
+		})
+	}
+}
+
+func TestClientFuzzyCandidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		matches int
+		want    int
+	}{
+		{name: "none", matches: 0, want: 0},
+		{name: "under the cap", matches: constants.FUZZY_CLIENT_MAX_CANDIDATES - 1, want: constants.FUZZY_CLIENT_MAX_CANDIDATES - 1},
+		{name: "at the cap", matches: constants.FUZZY_CLIENT_MAX_CANDIDATES, want: constants.FUZZY_CLIENT_MAX_CANDIDATES},
+		{name: "over the cap", matches: constants.FUZZY_MAX_CANDIDATES, want: constants.FUZZY_CLIENT_MAX_CANDIDATES},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := make([]CacheMatch, tt.matches)
+			for i := range matches {
+				matches[i] = CacheMatch{Key: fmt.Sprintf("search:q%d", i), CachedQuery: fmt.Sprintf("q%d", i), Score: 1 / float64(i+1), Method: "levenshtein"}
+			}
+
+			got := clientFuzzyCandidates(matches)
+			if len(got) != tt.want {
+				t.Fatalf("%d candidates, want %d", len(got), tt.want)
+			}
+			for i, candidate := range got {
+				want := FuzzyCandidate{Query: matches[i].CachedQuery, Score: matches[i].Score, Method: matches[i].Method}
+				if candidate != want {
+					t.Errorf("candidate %d = %+v, want %+v", i, candidate, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchCapsReportedFuzzyCandidates(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Harry Potter"))
+	similar := []string{"harry poter", "hary potter", "harry potters", "harry pottr", "harri potter"}
+	for _, query := range similar {
+		cacheResults(t, "search:"+query, upstreamBody("Harry Potter"))
+	}
+	indexQueries(t, "search", similar...)
+	if got := len(findSimilarCachedQueries("harry potter", "search", constants.FUZZY_MAX_CANDIDATES)); got <= constants.FUZZY_CLIENT_MAX_CANDIDATES {
+		t.Fatalf("only %d internal matches, want more than the client cap", got)
+	}
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=harry+potter", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	body := decodeBody(t, rec)
+	if body["fuzzyMatch"] != true {
+		t.Fatalf("fuzzyMatch = %v, want a fuzzy hit", body["fuzzyMatch"])
+	}
+	candidates, _ := body["fuzzyCandidates"].([]interface{})
+	if len(candidates) != constants.FUZZY_CLIENT_MAX_CANDIDATES {
+		t.Fatalf("%d fuzzy candidates reported, want %d", len(candidates), constants.FUZZY_CLIENT_MAX_CANDIDATES)
+	}
+	best := candidates[0].(map[string]interface{})
+	if best["query"] != body["matchedQuery"] {
+		t.Errorf("first candidate = %v, want the matched query %v", best["query"], body["matchedQuery"])
+	}
+}
+
+func TestGenerateCacheKeyVariationsLongWords(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		minLength int
+		want      []string
+	}{
+		{name: "mixed lengths", query: "the lord of the rings", minLength: 4, want: []string{"the lord of the rings", "lord of rings the the", "lord rings", "thelordoftherings"}},
+		{name: "lower threshold keeps more words", query: "the lord of the rings", minLength: 3, want: []string{"the lord of the rings", "lord of rings the the", "the lord the rings", "thelordoftherings"}},
+		{name: "higher threshold keeps fewer words", query: "the lord of the rings", minLength: 5, want: []string{"the lord of the rings", "lord of rings the the", "rings", "thelordoftherings"}},
+		{name: "all short words skip the variation", query: "it is up to me", minLength: 4, want: []string{"it is up to me", "is it me to up", "itisuptome"}},
+		{name: "every word long is not repeated", query: "project hail mary", minLength: 4, want: []string{"project hail mary", "hail mary project", "projecthailmary"}},
+		{name: "threshold counts characters not bytes", query: "émile zola", minLength: 5, want: []string{"émile zola", "zola émile", "émile", "émilezola"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.LongWordMinLength = tt.minLength })
+			if got := generateCacheKeyVariations(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generateCacheKeyVariations(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSimilarCachedQueriesMaxAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxAge  time.Duration
+		written map[string]time.Duration // how long ago each query was written; absent queries are cached but not indexed
+		ttl     time.Duration            // TTL the entries are stored with
+		flushed bool                     // a flush started after the writes
+		want    []string
+	}{
+		{
+			name:    "no limit keeps old entries",
+			written: map[string]time.Duration{"harry poter": 59 * time.Minute, "hary potter": 10 * time.Minute},
+			ttl:     time.Hour,
+			want:    []string{"harry poter", "hary potter"},
+		},
+		{
+			name:    "old entries skipped",
+			maxAge:  10 * time.Minute,
+			written: map[string]time.Duration{"harry poter": 59 * time.Minute, "hary potter": 5 * time.Minute},
+			ttl:     time.Hour,
+			want:    []string{"hary potter"},
+		},
+		{
+			name:    "entries stored with a shorter TTL",
+			maxAge:  10 * time.Minute,
+			written: map[string]time.Duration{"harry poter": time.Minute},
+			ttl:     5 * time.Minute,
+			want:    []string{"harry poter"},
+		},
+		{
+			name:    "entries stored with a longer TTL",
+			maxAge:  10 * time.Minute,
+			written: map[string]time.Duration{"harry poter": 30 * time.Minute},
+			ttl:     2 * time.Hour,
+			want:    nil,
+		},
+		{
+			name:    "entries in the previous generation",
+			maxAge:  10 * time.Minute,
+			written: map[string]time.Duration{"harry poter": time.Minute, "hary potter": 30 * time.Minute},
+			ttl:     time.Hour,
+			flushed: true,
+			want:    []string{"harry poter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.CacheTTL = time.Hour
+				cfg.FuzzyMaxAge = tt.maxAge
+			})
+			c, _ := useCache(t)
+			for query, ago := range tt.written {
+				if err := Cache.Set("search:"+query, OpenLibraryResponse{}, tt.ttl); err != nil {
+					t.Fatal(err)
+				}
+				if err := Cache.AddToIndex(recentIndexKey("search"), query, time.Now().Add(-ago)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.flushed {
+				if _, err := c.BeginGeneration(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			for _, match := range findSimilarCachedQueries("harry potter", "search", 5) {
+				got = append(got, match.CachedQuery)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchServesOnlyFreshFuzzyMatch(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) {
+		cfg.CacheTTL = time.Hour
+		cfg.FuzzyMaxAge = 10 * time.Minute
+	})
+	useCache(t)
+	useUpstream(t, http.StatusOK, upstreamBody("Fresh"))
+	// The old entry is the closer match, so it would win if its age were ignored
+	old := `{"numFound":1,"docs":[{"key":"/works/OL1W","title":"Old"}]}`
+	fresh := `{"numFound":1,"docs":[{"key":"/works/OL2W","title":"Fresh"}]}`
+	for query, entry := range map[string]struct {
+		body    string
+		written time.Duration
+	}{
+		"harry poter":    {old, 55 * time.Minute},
+		"harry pottterr": {fresh, 2 * time.Minute},
+	} {
+		var response OpenLibraryResponse
+		if err := json.Unmarshal([]byte(entry.body), &response); err != nil {
+			t.Fatal(err)
+		}
+		if err := Cache.Set("search:"+query, response, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := Cache.AddToIndex(recentIndexKey("search"), query, time.Now().Add(-entry.written)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=harry+potter", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	body := decodeBody(t, rec)
+	if body["fuzzyMatch"] != true || body["matchedQuery"] != "harry pottterr" {
+		t.Errorf("fuzzyMatch = %v, matchedQuery = %v; want a fuzzy hit on the fresh entry", body["fuzzyMatch"], body["matchedQuery"])
+	}
+}
+
+func TestSearchFuzzyMatchDetails(t *testing.T) {
+	tests := []struct {
+		name         string
+		details      bool
+		cached       string
+		query        string
+		wantMethod   interface{}
+		wantDistance interface{}
+	}{
+		{name: "levenshtein", details: true, cached: "harry poter", query: "harry+potter", wantMethod: "levenshtein", wantDistance: float64(1)},
+		{name: "word match", details: true, cached: "lord of the rings fellowship", query: "lord+of+the+rings+two+towers", wantMethod: "word-match"},
+		{name: "disabled", cached: "harry poter", query: "harry+potter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.FuzzyMatchDetails = tt.details })
+			useCache(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Fetched"))
+			cacheResults(t, "search:"+tt.cached, upstreamBody("Cached"))
+			indexQueries(t, "search", tt.cached)
+
+			rec := serve(Search, http.MethodGet, "/search", "/search?q="+tt.query, "")
+			body := decodeBody(t, rec)
+			if body["fuzzyMatch"] != true {
+				t.Fatalf("fuzzyMatch = %v, want a fuzzy hit: %s", body["fuzzyMatch"], rec.Body.String())
+			}
+			if body["matchMethod"] != tt.wantMethod {
+				t.Errorf("matchMethod = %v, want %v", body["matchMethod"], tt.wantMethod)
+			}
+			if body["editDistance"] != tt.wantDistance {
+				t.Errorf("editDistance = %v, want %v", body["editDistance"], tt.wantDistance)
+			}
+		})
+	}
+}
+
+func TestSearchCachesInEnvelopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		keys     []string // cache keys expected to hold envelopes
+		wantHits []int64  // per key, counting the test's own read
+	}{
+		{name: "normalized key", strategy: app.CacheKeyNormalized, keys: []string{"search:the hobbit"}, wantHits: []int64{2}},
+		{name: "both keys", strategy: app.CacheKeyBoth, keys: []string{"search:the hobbit", "search:raw:The Hobbit!"}, wantHits: []int64{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.CacheKeyStrategy = tt.strategy
+				cfg.CacheKeyURLHash = false
+			})
+			c, _ := useCache(t)
+			c.SetEnvelopes(true)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("The Hobbit"))
+
+			for i := 0; i < 2; i++ {
+				rec := serve(Search, http.MethodGet, "/search", "/search?q=The+Hobbit%21", "")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("request %d: status code = %d: %s", i, rec.Code, rec.Body.String())
+				}
+			}
+			WaitBackground(time.Second)
+			if got := upstream.calls(); got != 1 {
+				t.Errorf("%d upstream calls, want the second request served from the envelope", got)
+			}
+
+			for i, key := range tt.keys {
+				var response OpenLibraryResponse
+				meta, err := Cache.GetEnvelope(key, &response)
+				if err != nil {
+					t.Fatalf("%s: %v", key, err)
+				}
+				if meta.OriginalQuery != "The Hobbit!" || meta.CreatedAt.IsZero() {
+					t.Errorf("%s envelope = %+v, want the client's query and a write time", key, meta)
+				}
+				if meta.HitCount != tt.wantHits[i] {
+					t.Errorf("%s hit count = %d, want %d", key, meta.HitCount, tt.wantHits[i])
+				}
+				if len(response.Docs) != 1 {
+					t.Errorf("%s payload has %d docs, want 1", key, len(response.Docs))
+				}
+			}
+		})
+	}
+}
+
+func TestSearchFuzzyWriteBack(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		ttl       time.Duration
+		envelopes bool
+		wantEntry bool
+	}{
+		{name: "off", ttl: time.Minute},
+		{name: "on", enabled: true, ttl: time.Minute, wantEntry: true},
+		{name: "on without a TTL", enabled: true},
+		{name: "on with envelopes", enabled: true, ttl: time.Minute, envelopes: true, wantEntry: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) {
+				cfg.FuzzyWriteBack = tt.enabled
+				cfg.FuzzyWriteBackTTL = tt.ttl
+			})
+			useRootContext(t)
+			c, _ := useCache(t)
+			c.SetEnvelopes(tt.envelopes)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Harry Potter"))
+			cacheResults(t, "search:harry poter", upstreamBody("Harry Potter"))
+			indexQueries(t, "search", "harry poter")
+
+			body := decodeBody(t, serve(Search, http.MethodGet, "/search", "/search?q=Harry+Potter", ""))
+			if body["source"] != sourceL2Fuzzy {
+				t.Fatalf("source = %v, want a fuzzy hit", body["source"])
+			}
+			WaitBackground(time.Second)
+
+			found, err := Cache.Exists("search:harry potter")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.wantEntry {
+				t.Fatalf("entry under the client's query = %v, want %v", found, tt.wantEntry)
+			}
+			if !tt.wantEntry {
+				return
+			}
+			if ttl, err := Cache.GetTTL("search:harry potter"); err != nil || ttl <= 0 || ttl > tt.ttl {
+				t.Errorf("TTL = %v, %v; want up to %v", ttl, err, tt.ttl)
+			}
+			if tt.envelopes {
+				var got OpenLibraryResponse
+				meta, err := Cache.GetEnvelope("search:harry potter", &got)
+				if err != nil || meta.CreatedAt.IsZero() || meta.OriginalQuery != "Harry Potter" {
+					t.Errorf("envelope = %+v, %v; want one for the client's query", meta, err)
+				}
+			}
+			if queries, _ := Cache.RecentFromIndex(recentIndexKey("search"), 10); !reflect.DeepEqual(queries, []string{"harry poter"}) {
+				t.Errorf("recent queries = %v, want the typo kept out of the index", queries)
+			}
+
+			body = decodeBody(t, serve(Search, http.MethodGet, "/search", "/search?q=Harry+Potter", ""))
+			if body["source"] != sourceL2Exact || upstream.calls() != 0 {
+				t.Errorf("repeat source = %v with %d upstream calls, want an exact hit", body["source"], upstream.calls())
+			}
+		})
+	}
+}
+
+func TestFuzzyWriteBackKeepsExistingEntry(t *testing.T) {
+	for _, envelopes := range []bool{false, true} {
+		t.Run(fmt.Sprintf("envelopes=%v", envelopes), func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.FuzzyWriteBackTTL = time.Minute })
+			useRootContext(t)
+			c, _ := useCache(t)
+			var exact, fuzzy OpenLibraryResponse
+			if err := json.Unmarshal([]byte(upstreamBody("Exact")), &exact); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(upstreamBody("Fuzzy")), &fuzzy); err != nil {
+				t.Fatal(err)
+			}
+			c.SetEnvelopes(envelopes)
+			if err := setResults("search:harry potter", "Harry Potter", exact); err != nil {
+				t.Fatal(err)
+			}
+			params, err := parseTarget("/search?q=Harry+Potter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			writeBackFuzzyHit(params, fuzzy)
+			WaitBackground(time.Second)
+
+			var got OpenLibraryResponse
+			if err := Cache.GetJSON("search:harry potter", &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Docs) != 1 || got.Docs[0]["title"] != "Exact" {
+				t.Errorf("docs = %v, want the existing exact entry kept", got.Docs)
+			}
+			if ttl, _ := Cache.GetTTL("search:harry potter"); ttl <= time.Minute {
+				t.Errorf("TTL = %v, want the existing entry's", ttl)
+			}
+		})
+	}
+}
+
+// setCounter is a redis hook counting the SET commands a client sends
+type setCounter struct {
+	sets atomic.Int64
+}
+
+func (h *setCounter) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *setCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if strings.EqualFold(cmd.Name(), "set") {
+			h.sets.Add(1)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *setCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if strings.EqualFold(cmd.Name(), "set") {
+				h.sets.Add(1)
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestSearchWritesEachKeyOnce(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategy   string
+		target     string
+		wantWrites int64
+		wantKeys   []string
+	}{
+		{name: "single word", strategy: app.CacheKeyNormalized, target: "/search?q=Dune", wantWrites: 1, wantKeys: []string{"search:dune"}},
+		{name: "overlapping variations", strategy: app.CacheKeyNormalized, target: "/search?q=Dune+dune+DUNE", wantWrites: 1, wantKeys: []string{"search:dune dune dune"}},
+		{name: "reordered words", strategy: app.CacheKeyNormalized, target: "/search?q=Herbert+Dune+dune", wantWrites: 1, wantKeys: []string{"search:herbert dune dune"}},
+		{name: "raw key", strategy: app.CacheKeyRaw, target: "/search?q=Dune+dune", wantWrites: 1, wantKeys: []string{"search:raw:Dune dune"}},
+		{name: "both keys", strategy: app.CacheKeyBoth, target: "/search?q=Dune", wantWrites: 2, wantKeys: []string{"search:dune", "search:raw:Dune"}},
+		{name: "overlapping variations keyed both ways", strategy: app.CacheKeyBoth, target: "/search?q=Dune+dune+DUNE", wantWrites: 2, wantKeys: []string{"search:dune dune dune", "search:raw:Dune dune DUNE"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.CacheKeyStrategy = tt.strategy })
+			_, server := useCache(t)
+			counter := &setCounter{}
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			client.AddHook(counter)
+			t.Cleanup(func() { client.Close() })
+			SetCache(cache.NewCache(client, "test"))
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			if upstream.calls() != 1 {
+				t.Errorf("upstream calls = %d, want 1", upstream.calls())
+			}
+			if got := counter.sets.Load(); got != tt.wantWrites {
+				t.Errorf("cache writes = %d, want %d", got, tt.wantWrites)
+			}
+			for _, key := range tt.wantKeys {
+				if !server.Exists("test:" + key) {
+					t.Errorf("key %q not cached", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Outcomes of a self-test stage
+const (
+	stagePass    = "pass"
+	stageFail    = "fail"
+	stageSkipped = "skipped"
+)
+
+// StageResult is the outcome of one self-test stage
+type StageResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"durationMs"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// runStage times fn and records its outcome
+func runStage(name string, fn func() error) StageResult {
+	start := time.Now()
+	err := fn()
+	result := StageResult{
+		Name:       name,
+		Status:     stagePass,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = stageFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// SelfTest runs a canned query through the whole pipeline (cache read, upstream call,
+// cache write, cache read-back) and reports each stage, for post-deploy smoke tests.
+// Cache stages are skipped when Redis is disabled. Responds 503 if any stage fails.
+func SelfTest(c *gin.Context) {
+	startTime := time.Now()
+	key := fmt.Sprintf("selftest:%d", startTime.UnixNano())
+	stages := []StageResult{}
+
+	skipped := func(name string) StageResult {
+		return StageResult{Name: name, Status: stageSkipped}
+	}
+
+	if Cache != nil {
+		stages = append(stages, runStage("cacheRead", func() error {
+			var probe OpenLibraryResponse
+			if err := Cache.GetJSON(key, &probe); err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			return nil
+		}))
+	} else {
+		stages = append(stages, skipped("cacheRead"))
+	}
+
+	var upstream OpenLibraryResponse
+	stages = append(stages, runStage("upstream", func() error {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), CurrentConfig().UpstreamTimeout)
+		defer cancel()
+		normalized := normalizeQuery(constants.SELFTEST_QUERY)
+		result, err := fetchOpenLibrary(ctx, buildSearchURL(toSearchQuery(normalized, matchDefault), CurrentConfig().DefaultQueryLimit))
+		if err != nil {
+			return err
+		}
+		if result.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %d", result.StatusCode)
+		}
+		upstream = result.Response
+		return nil
+	}))
+	upstreamOK := stages[len(stages)-1].Status == stagePass
+
+	if Cache != nil && upstreamOK {
+		stages = append(stages, runStage("cacheWrite", func() error {
+			return Cache.Set(key, upstream, constants.SELFTEST_TTL_SECONDS*time.Second)
+		}))
+		stages = append(stages, runStage("cacheReadBack", func() error {
+			var readBack OpenLibraryResponse
+			if err := Cache.GetJSON(key, &readBack); err != nil {
+				return err
+			}
+			if readBack.NumFound != upstream.NumFound || len(readBack.Docs) != len(upstream.Docs) {
+				return errors.New("read-back result does not match what was written")
+			}
+			return nil
+		}))
+		if err := Cache.Delete(key); err != nil {
+			Logger.Warn("Failed to delete self-test key", zap.String("key", key), zap.Error(err))
+		}
+	} else {
+		stages = append(stages, skipped("cacheWrite"), skipped("cacheReadBack"))
+	}
+
+	status := stagePass
+	httpStatus := http.StatusOK
+	for _, stage := range stages {
+		if stage.Status == stageFail {
+			status = stageFail
+			httpStatus = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	Logger.Info("Self-test completed", zap.String("status", status))
+	c.JSON(httpStatus, gin.H{
+		"status":       status,
+		"query":        constants.SELFTEST_QUERY,
+		"stages":       stages,
+		"responseTime": fmt.Sprintf("%.2fms", time.Since(startTime).Seconds()*1000),
+	})
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		name           string
+		cache          bool
+		redisDown      bool
+		upstreamStatus int
+		wantStatus     int
+		wantStages     []string // status of cacheRead, upstream, cacheWrite and cacheReadBack
+	}{
+		{
+			name:           "everything passes",
+			cache:          true,
+			upstreamStatus: http.StatusOK,
+			wantStatus:     http.StatusOK,
+			wantStages:     []string{stagePass, stagePass, stagePass, stagePass},
+		},
+		{
+			name:           "cache disabled",
+			upstreamStatus: http.StatusOK,
+			wantStatus:     http.StatusOK,
+			wantStages:     []string{stageSkipped, stagePass, stageSkipped, stageSkipped},
+		},
+		{
+			name:           "upstream failing",
+			cache:          true,
+			upstreamStatus: http.StatusServiceUnavailable,
+			wantStatus:     http.StatusServiceUnavailable,
+			wantStages:     []string{stagePass, stageFail, stageSkipped, stageSkipped},
+		},
+		{
+			name:           "redis down",
+			cache:          true,
+			redisDown:      true,
+			upstreamStatus: http.StatusOK,
+			wantStatus:     http.StatusServiceUnavailable,
+			wantStages:     []string{stageFail, stagePass, stageFail, stageFail},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			upstream := useUpstream(t, tt.upstreamStatus, upstreamBody("Dune"))
+			if tt.cache {
+				_, server := useCache(t)
+				if tt.redisDown {
+					server.Close()
+				}
+				defer func() {
+					if !tt.redisDown && len(server.Keys()) != 0 {
+						t.Errorf("keys left behind by the self-test: %v", server.Keys())
+					}
+				}()
+			}
+
+			rec := serve(SelfTest, http.MethodGet, "/selftest", "/selftest", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			body := decodeBody(t, rec)
+			var names, statuses []string
+			for _, stage := range body["stages"].([]interface{}) {
+				stage := stage.(map[string]interface{})
+				names = append(names, stage["name"].(string))
+				statuses = append(statuses, stage["status"].(string))
+				if stage["status"] == stageFail && stage["error"] == nil {
+					t.Errorf("failed stage %v has no error", stage["name"])
+				}
+			}
+			if want := []string{"cacheRead", "upstream", "cacheWrite", "cacheReadBack"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("stages = %v, want %v", names, want)
+			}
+			if !reflect.DeepEqual(statuses, tt.wantStages) {
+				t.Errorf("stage statuses = %v, want %v", statuses, tt.wantStages)
+			}
+			if upstream.calls() != 1 {
+				t.Errorf("upstream calls = %d, want 1", upstream.calls())
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shedCause is why a request was turned away to protect the service or OpenLibrary. The code
+// tells clients which limit they hit; every cause is retryable after Retry-After.
+type shedCause struct {
+	code   string
+	status int
+}
+
+var (
+	// shedOverloaded: the service is serving MaxInFlightRequests already
+	shedOverloaded = shedCause{code: "OVERLOADED", status: http.StatusServiceUnavailable}
+	// shedUpstreamUnavailable: no upstream slot freed up within the request's budget
+	shedUpstreamUnavailable = shedCause{code: "UPSTREAM_UNAVAILABLE", status: http.StatusServiceUnavailable}
+)
+
+// errUpstreamSaturated is returned by fetchUpstreamJSON when every upstream slot stayed busy
+// until the request's context was done
+var errUpstreamSaturated = errors.New("no upstream slot available")
+
+// respondShed aborts the request with the cause's status and code and a Retry-After of the
+// configured ShedRetryAfter, so every shedding mechanism looks the same to clients
+func respondShed(c *gin.Context, cause shedCause, message string) {
+	retryAfter := int(math.Ceil(CurrentConfig().ShedRetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(cause.status, gin.H{
+		"error":             message,
+		"code":              cause.code,
+		"retryable":         true,
+		"retryAfterSeconds": retryAfter,
+	})
+}
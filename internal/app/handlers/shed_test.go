@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestRespondShed(t *testing.T) {
+	tests := []struct {
+		name           string
+		cause          shedCause
+		retryAfter     time.Duration
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter string
+	}{
+		{name: "overloaded", cause: shedOverloaded, retryAfter: time.Second, wantStatus: http.StatusServiceUnavailable, wantCode: "OVERLOADED", wantRetryAfter: "1"},
+		{name: "upstream unavailable", cause: shedUpstreamUnavailable, retryAfter: 5 * time.Second, wantStatus: http.StatusServiceUnavailable, wantCode: "UPSTREAM_UNAVAILABLE", wantRetryAfter: "5"},
+		{name: "rounded up to whole seconds", cause: shedOverloaded, retryAfter: 2500 * time.Millisecond, wantStatus: http.StatusServiceUnavailable, wantCode: "OVERLOADED", wantRetryAfter: "3"},
+		{name: "at least a second", cause: shedOverloaded, retryAfter: 0, wantStatus: http.StatusServiceUnavailable, wantCode: "OVERLOADED", wantRetryAfter: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.ShedRetryAfter = tt.retryAfter })
+			rec := serve(func(c *gin.Context) {
+				respondShed(c, tt.cause, "busy")
+			}, http.MethodGet, "/work", "/work", "")
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			body := decodeBody(t, rec)
+			if body["code"] != tt.wantCode || body["retryable"] != true || body["error"] != "busy" {
+				t.Errorf("body = %v, want code %s, retryable and the message", body, tt.wantCode)
+			}
+			if seconds, _ := body["retryAfterSeconds"].(float64); strconv.Itoa(int(seconds)) != tt.wantRetryAfter {
+				t.Errorf("retryAfterSeconds = %v, want it to match Retry-After %s", body["retryAfterSeconds"], tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestSearchShedWhenUpstreamSaturated(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) {
+		cfg.UpstreamTimeout = 300 * time.Millisecond
+		cfg.RetryBudgetAttempts = 0
+		cfg.ShedRetryAfter = 2 * time.Second
+	})
+	useCache(t)
+	upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+	for i := 0; i < constants.UPSTREAM_MAX_CONCURRENCY; i++ {
+		release, err := acquireUpstreamSlot(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(release)
+	}
+
+	rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status code = %d, want 503: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if body := decodeBody(t, rec); body["code"] != "UPSTREAM_UNAVAILABLE" || body["retryable"] != true {
+		t.Errorf("body = %v, want code UPSTREAM_UNAVAILABLE and retryable", body)
+	}
+	if got := upstream.calls(); got != 0 {
+		t.Errorf("%d upstream calls without a free slot, want 0", got)
+	}
+}
+
+func TestInFlightLimiterUsesShedRetryAfter(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.ShedRetryAfter = 4 * time.Second })
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	router := blockingRouter(1, release, started)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		get(router, "/work")
+	}()
+	<-started
+	rec := get(router, "/work")
+	close(release)
+	<-done
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status code = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "4" {
+		t.Errorf("Retry-After = %q, want 4", got)
+	}
+	if body := decodeBody(t, rec); body["retryAfterSeconds"] != float64(4) {
+		t.Errorf("retryAfterSeconds = %v, want 4", body["retryAfterSeconds"])
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Redis keys for cache statistics. Per-query counters live under statsQueryPrefix, and in
+// hashed analytics mode the optional hash -> query mapping under statsQueryMapPrefix.
+const (
+	statsHitsKey        = "stats:hits"
+	statsMissesKey      = "stats:misses"
+	statsQueryPrefix    = "stats:query:"
+	statsQueryMapPrefix = "stats:querymap:"
+)
+
+// recordSearchStats queues hit/miss and per-query counters on the batched Stats counter
+func recordSearchStats(hit bool, normalizedQuery string) {
+	if Stats == nil {
+		return
+	}
+	if hit {
+		Stats.Add(statsHitsKey, 1)
+	} else {
+		Stats.Add(statsMissesKey, 1)
+	}
+	Stats.Add(statsQueryPrefix+analyticsKey(normalizedQuery), 1)
+}
+
+// trimQueryCounters keeps the max most-requested per-query counters and deletes the rest,
+// so analytics can't grow to dominate Redis. Returns how many counters were removed.
+func trimQueryCounters(max int) (int, error) {
+	keys, err := Cache.Scan(statsQueryPrefix + "*")
+	if err != nil {
+		return 0, err
+	}
+	if len(keys) <= max {
+		return 0, nil
+	}
+
+	values, _, err := Cache.GetMany(keys)
+	if err != nil {
+		return 0, err
+	}
+	counts := make(map[string]int64, len(keys))
+	for i, key := range keys {
+		counts[key], _ = strconv.ParseInt(values[i], 10, 64)
+	}
+
+	// Least requested first
+	sort.Slice(keys, func(i, j int) bool {
+		return counts[keys[i]] < counts[keys[j]]
+	})
+	excess := keys[:len(keys)-max]
+	doomed := excess
+	if CurrentConfig().AnalyticsMode == app.AnalyticsModeHashed {
+		doomed = append([]string{}, excess...)
+		for _, key := range excess {
+			doomed = append(doomed, statsQueryMapPrefix+strings.TrimPrefix(key, statsQueryPrefix))
+		}
+	}
+	if err := Cache.DeleteMany(doomed); err != nil {
+		return 0, err
+	}
+	return len(excess), nil
+}
+
+// StartAnalyticsSweeper trims per-query counters beyond the configured cap every interval.
+// Call the returned function to stop it.
+func StartAnalyticsSweeper(interval time.Duration) func() {
+	return runEvery(interval, func() {
+		removed, err := trimQueryCounters(CurrentConfig().AnalyticsMaxQueries)
+		if err != nil {
+			Logger.Warn("Failed to trim query counters", zap.Error(err))
+			return
+		}
+		if removed > 0 {
+			Logger.Info("Trimmed query counters", zap.Int("removed", removed))
+		}
+	})
+}
+
+// cachedQueryCount is the number of search results currently cached, counted from the recency
+// indexes so auxiliary keys (stats, analytics, indexes) are never included
+func cachedQueryCount() (int64, error) {
+	var total int64
+	for _, namespace := range searchNamespaces() {
+		size, err := Cache.IndexSize(recentIndexKey(namespace))
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// readStat returns the flushed value of a counter plus anything still queued in memory
+func readStat(key string) (int64, error) {
+	var total int64
+	value, err := Cache.Get(key)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+	if err == nil {
+		total, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if Stats != nil {
+		total += Stats.Pending(key)
+	}
+	return total, nil
+}
+
+// CacheStats reports cache hit/miss counts
+func CacheStats(c *gin.Context) {
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+
+	hits, err := readStat(statsHitsKey)
+	if err != nil {
+		Logger.Warn("Failed to read cache stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read cache stats",
+		})
+		return
+	}
+	misses, err := readStat(statsMissesKey)
+	if err != nil {
+		Logger.Warn("Failed to read cache stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read cache stats",
+		})
+		return
+	}
+
+	cachedQueries, err := cachedQueryCount()
+	if err != nil {
+		Logger.Warn("Failed to read cache stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read cache stats",
+		})
+		return
+	}
+
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hits":          hits,
+		"misses":        misses,
+		"hitRate":       hitRate,
+		"cachedQueries": cachedQueries,
+		"maxCacheSize":  constants.CACHE_MAX_SIZE,
+		"cacheWrites":   cacheWriteSizes(),
+	})
+}
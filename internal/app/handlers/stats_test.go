@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+)
+
+// useStats installs a batched stats counter on the current cache and removes it when the
+// test ends
+func useStats(t *testing.T) *cache.Counter {
+	t.Helper()
+	counter := cache.NewCounter(Cache)
+	SetStats(counter)
+	t.Cleanup(func() { SetStats(nil) })
+	return counter
+}
+
+func TestSearchQueuesStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		cached     bool
+		wantHits   int64
+		wantMisses int64
+	}{
+		{name: "miss", cached: false, wantMisses: 1},
+		{name: "hit", cached: true, wantHits: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			stats := useStats(t)
+			useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+			if tt.cached {
+				cacheResults(t, "search:dune", upstreamBody("Dune"))
+			}
+
+			if rec := serve(Search, http.MethodGet, "/search", "/search?q=dune", ""); rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := stats.Pending(statsHitsKey); got != tt.wantHits {
+				t.Errorf("hits = %d, want %d", got, tt.wantHits)
+			}
+			if got := stats.Pending(statsMissesKey); got != tt.wantMisses {
+				t.Errorf("misses = %d, want %d", got, tt.wantMisses)
+			}
+			if got := stats.Pending(statsQueryPrefix + analyticsKey("dune")); got != 1 {
+				t.Errorf("per-query count = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestTrimQueryCounters(t *testing.T) {
+	tests := []struct {
+		name        string
+		counts      map[string]string
+		max         int
+		wantRemoved int
+		wantKept    []string
+	}{
+		{
+			name:        "under the cap",
+			counts:      map[string]string{"dune": "5", "emma": "1"},
+			max:         3,
+			wantRemoved: 0,
+			wantKept:    []string{"dune", "emma"},
+		},
+		{
+			name:        "least requested removed",
+			counts:      map[string]string{"dune": "5", "emma": "1", "ulysses": "3"},
+			max:         2,
+			wantRemoved: 1,
+			wantKept:    []string{"dune", "ulysses"},
+		},
+		{
+			name:        "cap of zero removes everything",
+			counts:      map[string]string{"dune": "5"},
+			max:         0,
+			wantRemoved: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			_, server := useCache(t)
+			for query, count := range tt.counts {
+				server.Set("test:"+statsQueryPrefix+query, count)
+			}
+			server.Set("test:"+statsHitsKey, "9")
+
+			removed, err := trimQueryCounters(tt.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("removed = %d, want %d", removed, tt.wantRemoved)
+			}
+			for _, query := range tt.wantKept {
+				if !server.Exists("test:" + statsQueryPrefix + query) {
+					t.Errorf("counter for %q was removed", query)
+				}
+			}
+			if kept := len(tt.counts) - removed; kept != len(tt.wantKept) {
+				t.Errorf("%d counters kept, want %d", kept, len(tt.wantKept))
+			}
+			if !server.Exists("test:" + statsHitsKey) {
+				t.Error("the hits counter was trimmed with the per-query counters")
+			}
+		})
+	}
+}
+
+func TestSetStatsExpiresQueryCounters(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.AnalyticsQueryTTL = 2 * time.Hour })
+	_, server := useCache(t)
+	stats := useStats(t)
+	recordSearchStats(true, "dune")
+
+	if err := stats.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.TTL("test:" + statsQueryPrefix + "dune"); got != 2*time.Hour {
+		t.Errorf("per-query counter TTL = %v, want 2h", got)
+	}
+	if got := server.TTL("test:" + statsHitsKey); got != 0 {
+		t.Errorf("hits counter TTL = %v, want none", got)
+	}
+}
+
+func TestCacheStatsCountsOnlyCachedQueries(t *testing.T) {
+	useConfig(t, nil)
+	_, server := useCache(t)
+	useStats(t)
+	indexQueries(t, "search", "dune", "emma")
+	indexQueries(t, "search:all", "tolkien hobbit")
+	cacheResults(t, "search:dune", upstreamBody("Dune"))
+	server.Set("test:"+statsQueryPrefix+"dune", "4")
+	server.Set("test:"+statsHitsKey, "3")
+	server.Set("test:"+statsMissesKey, "1")
+
+	rec := serve(CacheStats, http.MethodGet, "/stats", "/stats", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body.String())
+	}
+	body := decodeBody(t, rec)
+	if body["cachedQueries"] != float64(3) {
+		t.Errorf("cachedQueries = %v, want 3", body["cachedQueries"])
+	}
+	if body["hitRate"] != 0.75 {
+		t.Errorf("hitRate = %v, want 0.75", body["hitRate"])
+	}
+}
+
+func TestAnalyticsSweeperTrimsOnInterval(t *testing.T) {
+	useConfig(t, func(cfg *app.Config) { cfg.AnalyticsMaxQueries = 1 })
+	_, server := useCache(t)
+	server.Set("test:"+statsQueryPrefix+"dune", "5")
+	server.Set("test:"+statsQueryPrefix+"emma", "1")
+
+	stop := StartAnalyticsSweeper(10 * time.Millisecond)
+	defer stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if !server.Exists("test:" + statsQueryPrefix + "emma") {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("the least requested counter was never trimmed")
+}
+
+func TestMetricsReportCacheWriteSizes(t *testing.T) {
+	useConfig(t, nil)
+	c, _ := useCache(t)
+	if err := c.Set("search:dune", "12345678", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("search:emma", "1234", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, handler := range []gin.HandlerFunc{Metrics, CacheStats} {
+		rec := serve(handler, http.MethodGet, "/metrics", "/metrics", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status code = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		want := map[string]interface{}{"count": float64(2), "totalBytes": float64(12), "averageEntryBytes": float64(6)}
+		if got := decodeBody(t, rec)["cacheWrites"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("cacheWrites = %v, want %v", got, want)
+		}
+	}
+}
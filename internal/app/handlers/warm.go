@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/cache"
+	"go.uber.org/zap"
+)
+
+// upstreamSlots bounds the OpenLibrary calls in flight from this process. Live searches and
+// warming share it; warming holds at most WARM_CONCURRENCY slots so it can't starve live traffic.
+var upstreamSlots = make(chan struct{}, constants.UPSTREAM_MAX_CONCURRENCY)
+
+// acquireUpstreamSlot waits for a free upstream slot or until ctx is done. Call release when
+// the upstream call has finished.
+func acquireUpstreamSlot(ctx context.Context) (release func(), err error) {
+	select {
+	case upstreamSlots <- struct{}{}:
+		return func() { <-upstreamSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Outcomes of warming one query
+const (
+	warmCached        = "cached"         // fetched from upstream and stored
+	warmAlreadyCached = "already-cached" // nothing to do, or a live request filled it first
+	warmFailed        = "failed"
+)
+
+// WarmResult is the outcome of warming one query
+type WarmResult struct {
+	Query  string `json:"query"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// warmRequest is the body of POST /api/v1/cache/warm
+type warmRequest struct {
+	Queries []string `json:"queries"`
+	Match   string   `json:"match"`
+}
+
+// WarmCache fills the cache for a list of queries ahead of traffic. Each query goes through
+// fillCache, so a live search for the same query shares its upstream call instead of racing
+// it, and at most WARM_CONCURRENCY queries are fetched at once. With async=true it starts a
+// warm job instead and returns its ID right away; see warmjobs.go.
+func WarmCache(c *gin.Context) {
+	if Cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Cache is not enabled",
+		})
+		return
+	}
+
+	var request warmRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Body must be JSON with a 'queries' array",
+		})
+		return
+	}
+	if len(request.Queries) == 0 || len(request.Queries) > constants.WARM_MAX_QUERIES {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Provide between 1 and %d queries", constants.WARM_MAX_QUERIES),
+			"max":   constants.WARM_MAX_QUERIES,
+		})
+		return
+	}
+	if !validMatchMode(request.Match) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Field 'match' must be 'all' or 'any'",
+		})
+		return
+	}
+
+	switch c.Query("async") {
+	case "", "false":
+	case "true":
+		job, err := startWarmJob(request.Queries, request.Match)
+		if err != nil {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Too many warm jobs running, retry later",
+			})
+			return
+		}
+		Logger.Info("Warm job started", zap.String("id", job.id), zap.Int("queries", len(request.Queries)))
+		c.JSON(http.StatusAccepted, gin.H{
+			"id":      job.id,
+			"queries": len(request.Queries),
+		})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Parameter 'async' must be 'true' or 'false'",
+		})
+		return
+	}
+
+	// Up to WARM_MAX_QUERIES fetches, WARM_CONCURRENCY at a time, can outlast the server's
+	// WriteTimeout; the upstream timeouts bound the request instead
+	clearWriteDeadline(c)
+	results := warmQueries(c.Request.Context(), request.Queries, request.Match, nil)
+
+	warmed := 0
+	for _, result := range results {
+		if result.Status == warmCached {
+			warmed++
+		}
+	}
+	Logger.Info("Cache warm finished", zap.Int("queries", len(results)), zap.Int("warmed", warmed))
+	c.JSON(http.StatusOK, gin.H{
+		"warmed":  warmed,
+		"results": results,
+	})
+}
+
+// clearWriteDeadline lifts the server's WriteTimeout for a response that legitimately takes
+// longer, such as a synchronous warm or an event stream
+func clearWriteDeadline(c *gin.Context) {
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		Logger.Warn("Failed to clear the write deadline, the response may be cut off", zap.Error(err))
+	}
+}
+
+// warmQueries warms each query with up to WARM_CONCURRENCY workers, returning results in
+// the order the queries were given. progress, if not nil, is told about every status change
+// by query index, from any goroutine. Once ctx is done the queries not yet started are
+// reported as cancelled.
+func warmQueries(ctx context.Context, queries []string, match string, progress func(i int, result WarmResult)) []WarmResult {
+	results := make([]WarmResult, len(queries))
+	report := func(i int, result WarmResult) {
+		results[i] = result
+		if progress != nil {
+			progress(i, result)
+		}
+	}
+	for i, query := range queries {
+		report(i, WarmResult{Query: query, Status: warmQueued})
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, constants.WARM_CONCURRENCY)
+	for i, query := range queries {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			for j := i; j < len(queries); j++ {
+				report(j, WarmResult{Query: queries[j], Status: warmCancelled})
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, query string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			report(i, WarmResult{Query: query, Status: warmFetching})
+			report(i, warmQuery(ctx, query, match))
+		}(i, query)
+	}
+	wg.Wait()
+	return results
+}
+
+// warmQuery fills the cache for one query within the usual upstream timeout
+func warmQuery(ctx context.Context, query string, match string) WarmResult {
+	result := WarmResult{Query: query}
+	params := SearchParams{Query: query, NormalizedQuery: normalizeQuery(query), Match: match}
+	if params.NormalizedQuery == "" {
+		result.Status = warmFailed
+		result.Error = "query is empty after normalization"
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout(query, match, false))
+	defer cancel()
+
+	hit, err := fillCache(ctx, params)
+	switch {
+	case errors.Is(err, context.Canceled):
+		result.Status = warmCancelled
+	case err != nil && !errors.Is(err, cache.ErrSetFailed):
+		Logger.Warn("Failed to warm query", zap.String("query", query), zap.Error(err))
+		result.Status = warmFailed
+		result.Error = upstreamErrorMessage(err)
+	case err != nil:
+		result.Status = warmFailed
+		result.Error = "Failed to store result"
+	case hit:
+		result.Status = warmAlreadyCached
+	default:
+		result.Status = warmCached
+	}
+	return result
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"github.com/moseskang00/custom_search_component_service/internal/app"
+)
+
+func TestWarmCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		noCache     bool
+		cached      []string // queries cached before warming
+		broken      bool     // upstream returns no response
+		body        string
+		wantStatus  int
+		wantWarmed  float64
+		wantResults []WarmResult
+	}{
+		{name: "cache disabled", noCache: true, body: `{"queries":["dune"]}`, wantStatus: http.StatusServiceUnavailable},
+		{name: "not JSON", body: `queries=dune`, wantStatus: http.StatusBadRequest},
+		{name: "no queries", body: `{"queries":[]}`, wantStatus: http.StatusBadRequest},
+		{name: "too many queries", body: `{"queries":[` + strings.Repeat(`"dune",`, constants.WARM_MAX_QUERIES) + `"emma"]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid match", body: `{"queries":["dune"],"match":"some"}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "queries warmed",
+			body:       `{"queries":["Dune","Emma"]}`,
+			wantStatus: http.StatusOK,
+			wantWarmed: 2,
+			wantResults: []WarmResult{
+				{Query: "Dune", Status: warmCached},
+				{Query: "Emma", Status: warmCached},
+			},
+		},
+		{
+			name:       "already cached and empty queries",
+			cached:     []string{"dune"},
+			body:       `{"queries":["Dune","!!!","Emma"]}`,
+			wantStatus: http.StatusOK,
+			wantWarmed: 1,
+			wantResults: []WarmResult{
+				{Query: "Dune", Status: warmAlreadyCached},
+				{Query: "!!!", Status: warmFailed, Error: "query is empty after normalization"},
+				{Query: "Emma", Status: warmCached},
+			},
+		},
+		{
+			name:       "upstream failure",
+			broken:     true,
+			body:       `{"queries":["Dune"]}`,
+			wantStatus: http.StatusOK,
+			wantWarmed: 0,
+			wantResults: []WarmResult{
+				{Query: "Dune", Status: warmFailed, Error: "Upstream returned no response"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			if !tt.noCache {
+				useCache(t)
+			}
+			for _, query := range tt.cached {
+				cacheResults(t, "search:"+query, upstreamBody("Cached"))
+			}
+			useUpstream(t, http.StatusOK, upstreamBody("Fetched"))
+			if tt.broken {
+				SetHTTPClient(nilUpstream{})
+			}
+
+			rec := serve(WarmCache, http.MethodPost, "/cache/warm", "/cache/warm", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Warmed  float64      `json:"warmed"`
+				Results []WarmResult `json:"results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Warmed != tt.wantWarmed {
+				t.Errorf("warmed = %v, want %v", body.Warmed, tt.wantWarmed)
+			}
+			if !reflect.DeepEqual(body.Results, tt.wantResults) {
+				t.Errorf("results = %+v, want %+v", body.Results, tt.wantResults)
+			}
+			for _, result := range body.Results {
+				if result.Status == warmCached && !cachedKey(t, "search:"+normalizeQuery(result.Query)) {
+					t.Errorf("%q reported cached but isn't", result.Query)
+				}
+			}
+		})
+	}
+}
+
+// cachedKey reports whether key holds a cached response
+func cachedKey(t *testing.T, key string) bool {
+	t.Helper()
+	var response OpenLibraryResponse
+	return Cache.GetJSON(key, &response) == nil
+}
+
+func TestWarmSharesFetchesWithLiveTraffic(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	upstream := useSlowUpstream(t, 100*time.Millisecond, upstreamBody("Dune"))
+	queries := []string{"dune", "emma", "ulysses", "beloved"}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		body, _ := json.Marshal(warmRequest{Queries: queries})
+		if rec := serve(WarmCache, http.MethodPost, "/cache/warm", "/cache/warm", string(body)); rec.Code != http.StatusOK {
+			t.Errorf("warm status code = %d: %s", rec.Code, rec.Body.String())
+		}
+	}()
+	for _, query := range queries {
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func(query string) {
+				defer wg.Done()
+				if rec := serve(Search, http.MethodGet, "/search", "/search?q="+query, ""); rec.Code != http.StatusOK {
+					t.Errorf("search %q status code = %d: %s", query, rec.Code, rec.Body.String())
+				}
+			}(query)
+		}
+	}
+	wg.Wait()
+	WaitBackground(time.Second)
+
+	if got := len(upstream.requests); got != len(queries) {
+		t.Errorf("%d distinct upstream URLs, want %d", got, len(queries))
+	}
+	if got := upstream.duplicates(); got != 0 {
+		t.Errorf("%d duplicate upstream calls for overlapping warm and live requests, want none", got)
+	}
+}
+
+func TestWarmThenSearchHits(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		target   string
+		wantKey  string // key the warmed results are stored under
+	}{
+		{name: "normalized", strategy: app.CacheKeyNormalized, target: "/search?q=Dune", wantKey: "search:dune"},
+		{name: "raw", strategy: app.CacheKeyRaw, target: "/search?q=Dune", wantKey: "search:raw:Dune"},
+		{name: "both", strategy: app.CacheKeyBoth, target: "/search?q=Dune", wantKey: "search:dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, func(cfg *app.Config) { cfg.CacheKeyStrategy = tt.strategy })
+			useCache(t)
+			upstream := useUpstream(t, http.StatusOK, upstreamBody("Dune"))
+
+			if rec := serve(WarmCache, http.MethodPost, "/cache/warm", "/cache/warm", `{"queries":["Dune"]}`); rec.Code != http.StatusOK {
+				t.Fatalf("warm status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			if !cachedKey(t, tt.wantKey) {
+				t.Errorf("%s not cached after warming", tt.wantKey)
+			}
+
+			rec := serve(Search, http.MethodGet, "/search", tt.target, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("search status code = %d: %s", rec.Code, rec.Body.String())
+			}
+			if body := decodeBody(t, rec); body["cached"] != true {
+				t.Errorf("cached = %v, want the warmed entry served", body["cached"])
+			}
+			if got := upstream.calls(); got != 1 {
+				t.Errorf("%d upstream calls, want only the warm fetch", got)
+			}
+		})
+	}
+}
+
+func TestWarmConcurrencyBound(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	upstream := useSlowUpstream(t, 20*time.Millisecond, upstreamBody("Dune"))
+
+	results := warmQueries(context.Background(), fillerQueries(constants.WARM_CONCURRENCY*3), "", nil)
+	for _, result := range results {
+		if result.Status != warmCached {
+			t.Errorf("%q = %s (%s), want cached", result.Query, result.Status, result.Error)
+		}
+	}
+	if got := upstream.peak.Load(); got > constants.WARM_CONCURRENCY {
+		t.Errorf("%d upstream calls in flight while warming, want at most %d", got, constants.WARM_CONCURRENCY)
+	}
+}
+
+func TestAcquireUpstreamSlot(t *testing.T) {
+	var releases []func()
+	t.Cleanup(func() {
+		for _, release := range releases {
+			release()
+		}
+	})
+	for i := 0; i < constants.UPSTREAM_MAX_CONCURRENCY; i++ {
+		release, err := acquireUpstreamSlot(context.Background())
+		if err != nil {
+			t.Fatalf("slot %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireUpstreamSlot(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire with every slot taken = %v, want the context's error", err)
+	}
+
+	releases[0]()
+	releases = releases[1:]
+	release, err := acquireUpstreamSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquire after a release: %v", err)
+	}
+	releases = append(releases, release)
+}
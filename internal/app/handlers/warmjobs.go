@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+	"go.uber.org/zap"
+)
+
+// Statuses reported while a query is waiting or being warmed, and when its job was
+// cancelled first
+const (
+	warmQueued    = "queued"
+	warmFetching  = "fetching"
+	warmCancelled = "cancelled"
+)
+
+// WarmEvent is one status change of a query in a warm job
+type WarmEvent struct {
+	Index int `json:"index"`
+	WarmResult
+}
+
+// warmJob is a warm started with async=true. Its progress is kept in memory so it can be
+// polled or streamed, and it can be cancelled by ID until it finishes.
+type warmJob struct {
+	id        string
+	match     string
+	startedAt time.Time
+	cancel    context.CancelFunc
+
+	mu       sync.Mutex
+	results  []WarmResult
+	events   []WarmEvent   // every status change in order, replayed to each stream
+	finished time.Time     // zero while running
+	changed  chan struct{} // closed and replaced on every update
+}
+
+// Warm jobs by ID. Finished jobs are kept for WARM_JOB_RETENTION_SECONDS.
+var (
+	warmJobsMu sync.Mutex
+	warmJobs   = map[string]*warmJob{}
+)
+
+// update records a status change for query i and wakes any streams
+func (j *warmJob) update(i int, result WarmResult) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.results[i] = result
+	j.events = append(j.events, WarmEvent{Index: i, WarmResult: result})
+	j.notify()
+}
+
+// finish marks the job finished and wakes any streams
+func (j *warmJob) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finished = time.Now()
+	j.notify()
+}
+
+// notify wakes everyone waiting on changed. Callers hold mu.
+func (j *warmJob) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// running reports whether the job hasn't finished yet
+func (j *warmJob) running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finished.IsZero()
+}
+
+// eventsSince returns the events after the first n, whether the job has finished, and a
+// channel closed on the next update
+func (j *warmJob) eventsSince(n int) ([]WarmEvent, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	events := make([]WarmEvent, len(j.events)-n)
+	copy(events, j.events[n:])
+	return events, !j.finished.IsZero(), j.changed
+}
+
+// body reports the job's state, counting queries per status
+func (j *warmJob) body() gin.H {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	counts := map[string]int{}
+	for _, result := range j.results {
+		counts[result.Status]++
+	}
+	results := make([]WarmResult, len(j.results))
+	copy(results, j.results)
+	body := gin.H{
+		"id":        j.id,
+		"match":     j.match,
+		"startedAt": j.startedAt,
+		"done":      !j.finished.IsZero(),
+		"counts":    counts,
+		"results":   results,
+	}
+	if !j.finished.IsZero() {
+		body["finishedAt"] = j.finished
+	}
+	return body
+}
+
+// startWarmJob registers a job for queries and warms them in the background. It fails when
+// WARM_MAX_RUNNING_JOBS are already running.
+func startWarmJob(queries []string, match string) (*warmJob, error) {
+	warmJobsMu.Lock()
+	defer warmJobsMu.Unlock()
+
+	running := 0
+	for id, job := range warmJobs {
+		if job.running() {
+			running++
+		} else if time.Since(job.finished) > constants.WARM_JOB_RETENTION_SECONDS*time.Second {
+			delete(warmJobs, id)
+		}
+	}
+	if running >= constants.WARM_MAX_RUNNING_JOBS {
+		return nil, fmt.Errorf("%d warm jobs are already running", running)
+	}
+
+	ctx, cancel := context.WithCancel(rootCtx)
+	job := &warmJob{
+		id:        fmt.Sprintf("%x%04x", time.Now().UnixNano(), Rand.IntN(1<<16)),
+		match:     match,
+		startedAt: time.Now(),
+		cancel:    cancel,
+		results:   make([]WarmResult, len(queries)),
+		changed:   make(chan struct{}),
+	}
+	warmJobs[job.id] = job
+
+	goBackground(func(context.Context) {
+		defer cancel()
+		results := warmQueries(ctx, queries, match, job.update)
+		job.finish()
+		Logger.Info("Warm job finished", zap.String("id", job.id), zap.Int("queries", len(results)), zap.Bool("cancelled", ctx.Err() != nil))
+	})
+	return job, nil
+}
+
+// warmJobFromParam looks up the job named by the id path parameter, responding 404 when
+// there is none
+func warmJobFromParam(c *gin.Context) (*warmJob, bool) {
+	warmJobsMu.Lock()
+	job, ok := warmJobs[c.Param("id")]
+	warmJobsMu.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Warm job not found",
+		})
+	}
+	return job, ok
+}
+
+// WarmJobStatus returns the current state of a warm job
+func WarmJobStatus(c *gin.Context) {
+	job, ok := warmJobFromParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job.body())
+}
+
+// WarmJobEvents streams a warm job's progress as server-sent events: a "progress" event per
+// status change, starting from the first, and a final "done" event with the job's state
+func WarmJobEvents(c *gin.Context) {
+	job, ok := warmJobFromParam(c)
+	if !ok {
+		return
+	}
+
+	// Streams stay open until the job finishes, well past the server's WriteTimeout
+	clearWriteDeadline(c)
+	sent := 0
+	c.Stream(func(w io.Writer) bool {
+		events, done, changed := job.eventsSince(sent)
+		for _, event := range events {
+			c.SSEvent("progress", event)
+		}
+		sent += len(events)
+		if done {
+			c.SSEvent("done", job.body())
+			return false
+		}
+
+		select {
+		case <-changed:
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// CancelWarmJob stops a running warm job. Every query not done yet is reported as cancelled
+// right away. Fetches already started still finish in the background, since live searches
+// for the same query may be waiting on them.
+func CancelWarmJob(c *gin.Context) {
+	job, ok := warmJobFromParam(c)
+	if !ok {
+		return
+	}
+	if !job.running() {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Warm job already finished",
+		})
+		return
+	}
+	job.cancel()
+	Logger.Info("Warm job cancelled", zap.String("id", job.id))
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":        job.id,
+		"cancelled": true,
+	})
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moseskang00/custom_search_component_service/common/constants"
+)
+
+// useWarmJobs serves the warm job routes the way main registers them, behind middleware and
+// under a server with writeTimeout (0 for none). When the test ends it lets running jobs
+// finish and forgets them.
+func useWarmJobs(t *testing.T, writeTimeout time.Duration, middleware ...gin.HandlerFunc) *httptest.Server {
+	t.Helper()
+	useRootContext(t)
+	t.Cleanup(func() {
+		if !WaitBackground(2 * time.Second) {
+			t.Error("warm jobs still running")
+		}
+		warmJobsMu.Lock()
+		defer warmJobsMu.Unlock()
+		clear(warmJobs)
+	})
+
+	router := gin.New()
+	router.Use(middleware...)
+	router.POST("/cache/warm", WarmCache)
+	router.GET("/cache/warm/:id", WarmJobStatus)
+	router.GET("/cache/warm/:id/events", WarmJobEvents)
+	router.DELETE("/cache/warm/:id", CancelWarmJob)
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = writeTimeout
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// startJob starts an asynchronous warm of body and returns its ID
+func startJob(t *testing.T, server *httptest.Server, body string) string {
+	t.Helper()
+	resp, err := http.Post(server.URL+"/cache/warm?async=true", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var started struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted || started.ID == "" {
+		t.Fatalf("start = %d with id %q, want 202 and an id", resp.StatusCode, started.ID)
+	}
+	return started.ID
+}
+
+// sseEvent is one server-sent event
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents reads a job's event stream until the server closes it
+func readEvents(t *testing.T, server *httptest.Server, id string) []sseEvent {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/cache/warm/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/event-stream") {
+		t.Errorf("Content-Type = %q, want an event stream", got)
+	}
+
+	var events []sseEvent
+	var event sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event.name = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			event.data = strings.TrimPrefix(line, "data:")
+		case line == "" && event.name != "":
+			events = append(events, event)
+			event = sseEvent{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("stream cut off after %d events: %v", len(events), err)
+	}
+	return events
+}
+
+func TestWarmCacheAsync(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		running    int // slow jobs already running
+		wantStatus int
+	}{
+		{name: "started", target: "/cache/warm?async=true", wantStatus: http.StatusAccepted},
+		{name: "synchronous", target: "/cache/warm?async=false", wantStatus: http.StatusOK},
+		{name: "invalid async", target: "/cache/warm?async=yes", wantStatus: http.StatusBadRequest},
+		{name: "too many jobs", target: "/cache/warm?async=true", running: constants.WARM_MAX_RUNNING_JOBS, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, nil)
+			useCache(t)
+			useSlowUpstream(t, 200*time.Millisecond, upstreamBody("Dune"))
+			server := useWarmJobs(t, 0)
+			for i := 0; i < tt.running; i++ {
+				startJob(t, server, `{"queries":["emma"]}`)
+			}
+
+			resp, err := http.Post(server.URL+tt.target, "application/json", strings.NewReader(`{"queries":["dune"]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status code = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestWarmJobEvents(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	cacheResults(t, "search:emma", upstreamBody("Emma"))
+	useSlowUpstream(t, 100*time.Millisecond, upstreamBody("Dune"))
+	// The stream outlives the write timeout; it must clear its write deadline to finish
+	server := useWarmJobs(t, 50*time.Millisecond)
+
+	id := startJob(t, server, `{"queries":["Dune","Emma","!!!"]}`)
+	events := readEvents(t, server, id)
+	if len(events) == 0 || events[len(events)-1].name != "done" {
+		t.Fatalf("events = %v, want progress ending with done", events)
+	}
+
+	// Each query goes queued, fetching, then to its outcome, in that order
+	statuses := map[int][]string{}
+	for _, event := range events[:len(events)-1] {
+		if event.name != "progress" {
+			t.Fatalf("event %q before done, want progress", event.name)
+		}
+		var progress WarmEvent
+		if err := json.Unmarshal([]byte(event.data), &progress); err != nil {
+			t.Fatal(err)
+		}
+		statuses[progress.Index] = append(statuses[progress.Index], progress.Status)
+	}
+	want := map[int][]string{
+		0: {warmQueued, warmFetching, warmCached},
+		1: {warmQueued, warmFetching, warmAlreadyCached},
+		2: {warmQueued, warmFetching, warmFailed},
+	}
+	for i, wantStatuses := range want {
+		if strings.Join(statuses[i], ",") != strings.Join(wantStatuses, ",") {
+			t.Errorf("query %d went %v, want %v", i, statuses[i], wantStatuses)
+		}
+	}
+
+	var done struct {
+		Done   bool           `json:"done"`
+		Counts map[string]int `json:"counts"`
+	}
+	if err := json.Unmarshal([]byte(events[len(events)-1].data), &done); err != nil {
+		t.Fatal(err)
+	}
+	if !done.Done || done.Counts[warmCached] != 1 || done.Counts[warmAlreadyCached] != 1 || done.Counts[warmFailed] != 1 {
+		t.Errorf("done = %+v, want one cached, one already cached and one failed", done)
+	}
+
+	// A stream opened after the job finished replays everything
+	if replay := readEvents(t, server, id); len(replay) != len(events) {
+		t.Errorf("replay has %d events, want %d", len(replay), len(events))
+	}
+}
+
+func TestWarmJobEventsResponseTime(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	cacheResults(t, "search:emma", upstreamBody("Emma"))
+	useSlowUpstream(t, 100*time.Millisecond, upstreamBody("Dune"))
+	// Behind the response time middleware, as main serves it, the stream must still be able
+	// to clear its write deadline
+	server := useWarmJobs(t, 50*time.Millisecond, ResponseTimeHeader())
+
+	id := startJob(t, server, `{"queries":["Dune","Emma","!!!"]}`)
+	resp, err := http.Get(server.URL + "/cache/warm/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// Stamped on the stream's first write, before the job is done
+	header := resp.Header.Get("X-Response-Time")
+	if _, err := strconv.ParseFloat(header, 64); err != nil {
+		t.Errorf("X-Response-Time = %q, want milliseconds", header)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream cut off: %v", err)
+	}
+	if !strings.Contains(string(body), "event:done") {
+		t.Errorf("stream = %q, want it to end with a done event", body)
+	}
+}
+
+func TestCancelWarmJob(t *testing.T) {
+	useConfig(t, nil)
+	useCache(t)
+	useSlowUpstream(t, 200*time.Millisecond, upstreamBody("Dune"))
+	server := useWarmJobs(t, 0)
+
+	request := func(method string, path string) (int, map[string]interface{}) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body
+	}
+
+	if status, _ := request(http.MethodDelete, "/cache/warm/missing"); status != http.StatusNotFound {
+		t.Errorf("cancelling an unknown job = %d, want 404", status)
+	}
+	if status, _ := request(http.MethodGet, "/cache/warm/missing"); status != http.StatusNotFound {
+		t.Errorf("status of an unknown job = %d, want 404", status)
+	}
+
+	id := startJob(t, server, `{"queries":["dune","emma","ulysses","beloved"]}`)
+	if status, body := request(http.MethodDelete, "/cache/warm/"+id); status != http.StatusAccepted || body["cancelled"] != true {
+		t.Fatalf("cancel = %d %v, want 202 and cancelled", status, body)
+	}
+	events := readEvents(t, server, id)
+	if events[len(events)-1].name != "done" {
+		t.Fatalf("last event = %q, want done", events[len(events)-1].name)
+	}
+
+	status, body := request(http.MethodGet, "/cache/warm/"+id)
+	if status != http.StatusOK || body["done"] != true {
+		t.Fatalf("status = %d %v, want a finished job", status, body)
+	}
+	counts := body["counts"].(map[string]interface{})
+	if counts[warmCancelled].(float64) < float64(4-constants.WARM_CONCURRENCY) {
+		t.Errorf("counts = %v, want the queries not yet started cancelled", counts)
+	}
+	if status, _ := request(http.MethodDelete, "/cache/warm/"+id); status != http.StatusConflict {
+		t.Errorf("cancelling a finished job = %d, want 409", status)
+	}
+
+	// Fetches already started finish after the job, so wait for them before the test's
+	// upstream and cache go away
+	deadline := time.Now().Add(2 * time.Second)
+	for !cachedKey(t, "search:dune") || !cachedKey(t, "search:emma") {
+		if time.Now().After(deadline) {
+			t.Fatal("fetches started before the cancel never finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -3,86 +3,694 @@ package cache
 import (
 	"fmt"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
+// ErrSetFailed is returned by GetOrSet when a loaded value could not be stored. The value is
+// still decoded into the destination, so callers can serve it and just log the error.
+var ErrSetFailed = errors.New("failed to store loaded value")
+
+// ErrNotStored wraps ErrSetFailed when the loader asked for its value not to be cached
+// with DontStore
+var ErrNotStored = errors.New("loaded value was not stored")
+
+// dontStore marks a loaded value GetOrSet should return without caching it
+type dontStore struct {
+	value interface{}
+}
+
+// DontStore wraps a value returned by a GetOrSet loader so it is returned to every waiting
+// caller but not cached. GetOrSet then reports ErrNotStored.
+func DontStore(value interface{}) interface{} {
+	return dontStore{value: value}
+}
+
+// withMeta attaches meta to a loaded value
+type withMeta struct {
+	value interface{}
+	meta  interface{}
+}
+
+// WithMeta wraps a value returned by a GetOrSet loader so meta, e.g. how long fetching the
+// value took, is reported in Loaded.Meta to every caller that waited on the load. meta is
+// never stored. It combines with DontStore in either order.
+func WithMeta(value interface{}, meta interface{}) interface{} {
+	return withMeta{value: value, meta: meta}
+}
+
+// Loaded describes where the value decoded by GetOrSet came from
+type Loaded struct {
+	Hit  bool        // read from the cache rather than loaded
+	Meta interface{} // attached by the loader with WithMeta; nil on a hit
+}
+
+// ErrCommandDisabled is returned by FlushAll and Keys in safe mode
+var ErrCommandDisabled = errors.New("command disabled in safe mode")
+
+// ErrReadOnly is returned by writes while Redis rejects them as a read-only replica.
+// Reads keep working, so callers can carry on without caching new results.
+var ErrReadOnly = errors.New("redis is read-only")
+
+// readOnlyRecheck is how long writes are skipped after a READONLY error before one is tried
+// again, in case the replica was promoted or the configuration fixed
+const readOnlyRecheck = 30 * time.Second
+
+// flushBatchSize is how many keys FlushNamespace scans and deletes per round trip
+const flushBatchSize = 500
+
 type Cache struct {
 	redisClient *redis.Client
 	ctx         context.Context
 	prefix      string
+	loads       singleflight.Group
+	safeMode    bool
+
+	// Keys longer than maxKeyLength (0 for no limit) are stored under a hash; onLongKey is
+	// told each time that happens
+	maxKeyLength int
+	onLongKey    func(key string)
+
+	// Set while Redis answers writes with READONLY; readOnlySince is in Unix nanoseconds
+	readOnly      atomic.Bool
+	readOnlySince atomic.Int64
+	onReadOnly    func(readOnly bool, err error)
+
+	// Generation keys are written under, and the one reads fall back to during a flush.
+	// Only keys starting with one of generational are kept per generation.
+	generation   atomic.Int64
+	previous     atomic.Int64
+	generational []string
+	flushTimeout time.Duration
+
+	// Whether reads unwrap envelopes written by SetEnvelope
+	envelopes bool
+
+	// Serialized size of the values written by this process, for sizing the cache
+	writes       atomic.Int64
+	bytesWritten atomic.Int64
 }
 
 func NewCache(client *redis.Client, prefix string) *Cache {
-	return &Cache{
+	c := &Cache{
 		redisClient: client,
 		ctx: context.Background(),
 		prefix: prefix,
+		flushTimeout: defaultFlushTimeout,
 	}
+	c.previous.Store(noGeneration)
+	return c
 }
 
-func (c *Cache) Set(key string, value interface{}, ttl time.Duration) error {
-	var data interface{} = value
+// SetSafeMode disables commands that are destructive or blocking on a shared Redis (FlushAll,
+// Keys). Use FlushNamespace and Scan instead, which only touch this cache's keys.
+func (c *Cache) SetSafeMode(enabled bool) {
+	c.safeMode = enabled
+}
 
-	switch v := value.(type) {
-	case string:
-		data = v
-	default:
-		jsonData, err := json.Marshal(value)
-		if err != nil {
-			return fmt.Errorf("failed to marshal value to JSON: %w", err)
+// SetReadOnlyHandler registers fn to be called when the cache enters read-only mode (with
+// the READONLY error) and when a write succeeds again. fn may be nil.
+func (c *Cache) SetReadOnlyHandler(fn func(readOnly bool, err error)) {
+	c.onReadOnly = fn
+}
+
+// ReadOnly reports whether Redis last rejected a write as a read-only replica
+func (c *Cache) ReadOnly() bool {
+	return c.readOnly.Load()
+}
+
+// write runs a Redis write, tracking read-only mode. While read-only, writes fail fast with
+// ErrReadOnly instead of reaching Redis, except for one attempt every readOnlyRecheck.
+func (c *Cache) write(op func() error) error {
+	if c.readOnly.Load() {
+		since := time.Unix(0, c.readOnlySince.Load())
+		if time.Since(since) < readOnlyRecheck {
+			return ErrReadOnly
 		}
-		data = jsonData
+		c.readOnlySince.Store(time.Now().UnixNano())
+	}
+
+	err := op()
+	switch {
+	case err == nil:
+		if c.readOnly.Swap(false) && c.onReadOnly != nil {
+			c.onReadOnly(false, nil)
+		}
+	case redis.IsReadOnlyError(err):
+		c.readOnlySince.Store(time.Now().UnixNano())
+		if !c.readOnly.Swap(true) && c.onReadOnly != nil {
+			c.onReadOnly(true, err)
+		}
+		return fmt.Errorf("%w: %v", ErrReadOnly, err)
+	}
+	return err
+}
+
+// SetMaxKeyLength caps the length of keys sent to Redis. Longer keys are replaced by
+// "hashed:" and their SHA-256, the same way on reads and writes, so they still round-trip.
+// 0 disables the cap.
+func (c *Cache) SetMaxKeyLength(n int) {
+	c.maxKeyLength = n
+}
+
+// SetLongKeyHandler registers fn to be called with each key that was too long and got
+// hashed. fn may be nil.
+func (c *Cache) SetLongKeyHandler(fn func(key string)) {
+	c.onLongKey = fn
+}
+
+// key namespaces key under the current generation's prefix, or the plain prefix for keys
+// outside generations
+func (c *Cache) key(key string) string {
+	if !c.isGenerational(key) {
+		return c.keyIn(c.prefix, key)
+	}
+	return c.keyIn(c.generationPrefix(c.generation.Load()), key)
+}
+
+// keyIn namespaces key under prefix. An empty prefix leaves the key as it is rather than
+// producing a leading colon. Keys over the maximum length are hashed.
+func (c *Cache) keyIn(prefix string, key string) string {
+	fullKey := key
+	if prefix != "" {
+		fullKey = prefix + ":" + key
+	}
+	if c.maxKeyLength <= 0 || len(fullKey) <= c.maxKeyLength {
+		return fullKey
+	}
+
+	if c.onLongKey != nil {
+		c.onLongKey(key)
+	}
+	sum := sha256.Sum256([]byte(key))
+	hashed := "hashed:" + hex.EncodeToString(sum[:])
+	if prefix == "" {
+		return hashed
+	}
+	return prefix + ":" + hashed
+}
+
+// stripKey removes prefix, as added by keyIn
+func stripKey(prefix string, fullKey string) string {
+	if prefix == "" {
+		return fullKey
+	}
+	return strings.TrimPrefix(fullKey, prefix+":")
+}
+
+func (c *Cache) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+
+	fullKey := c.key(key)
+	err = c.write(func() error {
+		return c.redisClient.Set(c.ctx, fullKey, data, ttl).Err()
+	})
+	if err != nil {
+		return err
+	}
+	c.recordWrite(len(data))
+	return nil
+}
+
+// SetNX is Set, but only when key doesn't exist yet. It reports whether the value was
+// stored; an existing entry is left untouched.
+func (c *Cache) SetNX(key string, value interface{}, ttl time.Duration) (bool, error) {
+	data, err := encodeValue(value)
+	if err != nil {
+		return false, err
+	}
+
+	fullKey := c.key(key)
+	var stored bool
+	err = c.write(func() error {
+		var err error
+		stored, err = c.redisClient.SetNX(c.ctx, fullKey, data, ttl).Result()
+		return err
+	})
+	if err != nil || !stored {
+		return false, err
+	}
+	c.recordWrite(len(data))
+	return true, nil
+}
+
+// encodeValue is how values are written to Redis: strings as they are, anything else as JSON
+func encodeValue(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
 	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal value to JSON: %w", err)
+	}
+	return string(jsonData), nil
+}
 
-	fullKey := fmt.Sprintf("%s:%s", c.prefix, key)
-	return c.redisClient.Set(c.ctx, fullKey, data, ttl).Err()
+func (c *Cache) recordWrite(size int) {
+	c.writes.Add(1)
+	c.bytesWritten.Add(int64(size))
+}
+
+// WriteSizes reports how many values this process has written through Set and GetOrSet
+// and their total serialized size in bytes
+func (c *Cache) WriteSizes() (writes int64, bytes int64) {
+	return c.writes.Load(), c.bytesWritten.Load()
 }
 
 func (c *Cache) Get(key string) (string, error) {
-	fullKey := fmt.Sprintf("%s:%s", c.prefix, key)
+	fullKey := c.key(key)
 	return c.redisClient.Get(c.ctx, fullKey).Result()
 }
 
+// GetJSON decodes the value at key into v, unwrapping envelopes when they are enabled
 func (c *Cache) GetJSON(key string, v interface{}) error {
-	fullKey := fmt.Sprintf("%s:%s", c.prefix, key)
-	jsonData, err := c.redisClient.Get(c.ctx, fullKey).Result()
+	return c.GetJSONContext(c.ctx, key, v)
+}
+
+// GetJSONContext is GetJSON with the Redis round trips bound to ctx, so a deadline on ctx
+// cuts a slow read short instead of waiting for the client's read timeout
+func (c *Cache) GetJSONContext(ctx context.Context, key string, v interface{}) error {
+	jsonData, err := c.readThrough(ctx, key)
 	if err != nil {
 		return fmt.Errorf("failed to get value from Redis: %w", err)
 	}
-	return json.Unmarshal([]byte(jsonData), v)
+	return json.Unmarshal(jsonData, v)
+}
+
+// GetOrSet decodes the cached value for key into v. On a miss it calls loader, stores the
+// result for ttl and decodes that into v instead. Concurrent callers missing the same key
+// share one loader call, and a caller arriving just after a load finished reads what it
+// stored instead of loading again. The cache read is bound to ctx; Redis read errors are treated as a
+// miss so a cache outage never blocks loading.
+//
+// The shared loader runs on a context detached from the cancellation of the caller that
+// started it, so one caller giving up (or its client disconnecting) never fails the others;
+// loaders must bound their own run time. Each caller waits for the load only until its own
+// ctx is done, then returns ctx.Err().
+func (c *Cache) GetOrSet(ctx context.Context, key string, ttl time.Duration, v interface{}, loader func(ctx context.Context) (interface{}, error)) (Loaded, error) {
+	return c.getOrSet(ctx, key, v, loader, func(fullKey string, data []byte) error {
+		return c.redisClient.Set(c.ctx, fullKey, data, ttl).Err()
+	})
+}
+
+// getOrSet implements GetOrSet and GetOrSetEnvelope, storing loaded values with store
+func (c *Cache) getOrSet(ctx context.Context, key string, v interface{}, loader func(ctx context.Context) (interface{}, error), store func(fullKey string, data []byte) error) (Loaded, error) {
+	if data, err := c.readThrough(ctx, key); err == nil {
+		return Loaded{Hit: true}, json.Unmarshal(data, v)
+	}
+	fullKey := c.key(key)
+
+	type loaded struct {
+		data   []byte
+		meta   interface{}
+		hit    bool
+		setErr error
+	}
+	loadCtx := context.WithoutCancel(ctx)
+	results := c.loads.DoChan(fullKey, func() (interface{}, error) {
+		// A load that finished between the read above and joining here has already stored
+		// the value, so read again rather than loading it twice
+		if data, err := c.readThrough(loadCtx, key); err == nil {
+			return loaded{data: data, hit: true}, nil
+		}
+		value, err := loader(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+		var meta interface{}
+		skip := false
+		for unwrapped := false; !unwrapped; {
+			switch wrapped := value.(type) {
+			case dontStore:
+				value, skip = wrapped.value, true
+			case withMeta:
+				value, meta = wrapped.value, wrapped.meta
+			default:
+				unwrapped = true
+			}
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value to JSON: %w", err)
+		}
+		if skip {
+			return loaded{data: data, meta: meta, setErr: ErrNotStored}, nil
+		}
+		setErr := c.write(func() error {
+			return store(fullKey, data)
+		})
+		if setErr == nil {
+			c.recordWrite(len(data))
+		}
+		return loaded{
+			data:   data,
+			meta:   meta,
+			setErr: setErr,
+		}, nil
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return Loaded{}, ctx.Err()
+	}
+	if result.Err != nil {
+		return Loaded{}, result.Err
+	}
+
+	value := result.Val.(loaded)
+	if err := json.Unmarshal(value.data, v); err != nil {
+		return Loaded{}, err
+	}
+	if value.hit {
+		return Loaded{Hit: true}, nil
+	}
+	if value.setErr != nil {
+		return Loaded{Meta: value.meta}, fmt.Errorf("%w: %w", ErrSetFailed, value.setErr)
+	}
+	return Loaded{Meta: value.meta}, nil
 }
 
+// Delete removes key. During a flush its copy in the previous generation goes too, so reads
+// don't fall back to it.
 func (c *Cache) Delete(key string) error {
-	fullKey := fmt.Sprintf("%s:%s", c.prefix, key)
-	return c.redisClient.Del(c.ctx, fullKey).Err()
+	return c.DeleteMany([]string{key})
 }
 
 func (c *Cache) Exists(key string) (bool, error) {
-    fullKey := fmt.Sprintf("%s:%s", c.prefix, key)
+    fullKey := c.key(key)
     result, err := c.redisClient.Exists(c.ctx, fullKey).Result()
     return result > 0, err
 }
 
 func (c *Cache) Increment(key string) (int64, error) {
-    fullKey := fmt.Sprintf("%s:%s", c.prefix, key)
+    fullKey := c.key(key)
     return c.redisClient.Incr(c.ctx, fullKey).Result()
 }
 
+// WriteBatch applies several increments and sets of plain string values in one pipelined
+// round trip. Keys with an entry in ttls expire after it, refreshed in the same pipeline;
+// values and ttls may be nil.
+func (c *Cache) WriteBatch(deltas map[string]int64, values map[string]string, ttls map[string]time.Duration) error {
+	pipe := c.redisClient.Pipeline()
+	for key, n := range deltas {
+		fullKey := c.key(key)
+		pipe.IncrBy(c.ctx, fullKey, n)
+		if ttl, ok := ttls[key]; ok && ttl > 0 {
+			pipe.Expire(c.ctx, fullKey, ttl)
+		}
+	}
+	for key, value := range values {
+		ttl := ttls[key]
+		if ttl < 0 {
+			ttl = 0
+		}
+		pipe.Set(c.ctx, c.key(key), value, ttl)
+	}
+	return c.write(func() error {
+		_, err := pipe.Exec(c.ctx)
+		return err
+	})
+}
+
+// GetMany reads several keys in one round trip. Missing keys come back as empty strings
+// with found set to false at the same index.
+func (c *Cache) GetMany(keys []string) (values []string, found []bool, err error) {
+	if len(keys) == 0 {
+		return nil, nil, nil
+	}
+	fullKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = c.key(key)
+	}
+
+	raw, err := c.redisClient.MGet(c.ctx, fullKeys...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	values = make([]string, len(raw))
+	found = make([]bool, len(raw))
+	for i, item := range raw {
+		if str, ok := item.(string); ok {
+			values[i] = str
+			found[i] = true
+		}
+	}
+	if c.envelopes {
+		if err := c.getManyEnvelopes(fullKeys, values, found); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.previous.Load() != noGeneration {
+		if err := c.getManyPrevious(keys, values, found); err != nil {
+			return nil, nil, err
+		}
+	}
+	return values, found, nil
+}
+
+// getManyPrevious fills in the keys the current generation is missing from the previous
+// one, while a flush is in progress
+func (c *Cache) getManyPrevious(keys []string, values []string, found []bool) error {
+	missing := []int{}
+	for i := range keys {
+		if !found[i] {
+			missing = append(missing, i)
+		}
+	}
+	for _, i := range missing {
+		data, err := c.readPrevious(c.ctx, keys[i])
+		if err == nil {
+			values[i] = string(data)
+			found[i] = true
+		} else if !errors.Is(err, redis.Nil) && !errors.Is(err, errNoPrevious) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getManyEnvelopes fills in the keys MGET didn't find, which may be envelopes, in one
+// more round trip
+func (c *Cache) getManyEnvelopes(fullKeys []string, values []string, found []bool) error {
+	pipe := c.redisClient.Pipeline()
+	cmds := make(map[int]*redis.Cmd)
+	for i, fullKey := range fullKeys {
+		if !found[i] {
+			cmds[i] = readScript.Eval(c.ctx, pipe, []string{fullKey})
+		}
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(c.ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	for i, cmd := range cmds {
+		reply, err := cmd.Slice()
+		if err != nil {
+			continue
+		}
+		if data, _, err := parseReadReply(reply); err == nil {
+			values[i] = string(data)
+			found[i] = true
+		}
+	}
+	return nil
+}
+
+// DeleteMany removes several keys in one round trip
+func (c *Cache) DeleteMany(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	fullKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fullKeys = append(fullKeys, c.key(key))
+		if previousKey := c.previousKey(key); previousKey != "" {
+			fullKeys = append(fullKeys, previousKey)
+		}
+	}
+	return c.write(func() error {
+		return c.redisClient.Del(c.ctx, fullKeys...).Err()
+	})
+}
+
 func (c *Cache) GetTTL(key string) (time.Duration, error) {
-    fullKey := fmt.Sprintf("%s:%s", c.prefix, key)
+    fullKey := c.key(key)
     return c.redisClient.TTL(c.ctx, fullKey).Result()
 }
 
+// AddToIndex records member in a sorted set scored by t so the most recently
+// written members can be fetched without scanning the keyspace
+func (c *Cache) AddToIndex(index string, member string, t time.Time) error {
+	fullKey := c.key(index)
+	return c.write(func() error {
+		return c.redisClient.ZAdd(c.ctx, fullKey, redis.Z{
+			Score:  float64(t.Unix()),
+			Member: member,
+		}).Err()
+	})
+}
+
+// TrimIndex drops index members written more than maxAge ago and keeps at most
+// maxSize of the newest ones
+func (c *Cache) TrimIndex(index string, maxAge time.Duration, maxSize int64) error {
+	fullKey := c.key(index)
+	cutoff := strconv.FormatInt(time.Now().Add(-maxAge).Unix(), 10)
+
+	pipe := c.redisClient.TxPipeline()
+	pipe.ZRemRangeByScore(c.ctx, fullKey, "-inf", "("+cutoff)
+	pipe.ZRemRangeByRank(c.ctx, fullKey, 0, -(maxSize + 1))
+	return c.write(func() error {
+		_, err := pipe.Exec(c.ctx)
+		return err
+	})
+}
+
+// RemoveFromIndex drops members from a recency index
+func (c *Cache) RemoveFromIndex(index string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return c.write(func() error {
+		return c.redisClient.ZRem(c.ctx, c.key(index), values...).Err()
+	})
+}
+
+// RecentFromIndex returns up to n index members, newest first
+func (c *Cache) RecentFromIndex(index string, n int64) ([]string, error) {
+	fullKey := c.key(index)
+	return c.redisClient.ZRevRange(c.ctx, fullKey, 0, n-1).Result()
+}
+
+// IndexTime returns when member was last recorded in the index
+func (c *Cache) IndexTime(index string, member string) (time.Time, error) {
+	fullKey := c.key(index)
+	score, err := c.redisClient.ZScore(c.ctx, fullKey, member).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(score), 0), nil
+}
+
+// IndexSize returns the number of members in the index
+func (c *Cache) IndexSize(index string) (int64, error) {
+	fullKey := c.key(index)
+	return c.redisClient.ZCard(c.ctx, fullKey).Result()
+}
+
+// Scan returns every key matching pattern, iterating with SCAN so Redis isn't blocked the
+// way it is by KEYS. The cache prefix is stripped from the returned keys. Patterns over
+// generational keys match in the current generation and, during a flush, the previous one.
+func (c *Cache) Scan(pattern string) ([]string, error) {
+	prefixes := []string{c.prefix}
+	if c.isGenerational(pattern) {
+		prefixes = []string{c.generationPrefix(c.generation.Load())}
+		if previous := c.previous.Load(); previous != noGeneration {
+			prefixes = append(prefixes, c.generationPrefix(previous))
+		}
+	}
+
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, prefix := range prefixes {
+		iter := c.redisClient.Scan(c.ctx, 0, c.keyIn(prefix, pattern), 100).Iterator()
+		for iter.Next(c.ctx) {
+			key := stripKey(prefix, iter.Val())
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
 // Keys gets all keys matching pattern --> might be useful for later..
+// Disabled in safe mode, since KEYS blocks Redis; use Scan.
 func (c *Cache) Keys(pattern string) ([]string, error) {
-    fullPattern := fmt.Sprintf("%s:%s", c.prefix, pattern)
+    if c.safeMode {
+        return nil, fmt.Errorf("KEYS: %w", ErrCommandDisabled)
+    }
+    fullPattern := c.key(pattern)
     return c.redisClient.Keys(c.ctx, fullPattern).Result()
 }
 
+// Ping checks that Redis answers within ctx
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.redisClient.Ping(ctx).Err()
+}
+
 // FlushAll clears all cache
+// Disabled in safe mode, since it wipes every database on the server; use FlushNamespace.
 func (c *Cache) FlushAll() error {
+    if c.safeMode {
+        return fmt.Errorf("FLUSHALL: %w", ErrCommandDisabled)
+    }
     return c.redisClient.FlushAll(c.ctx).Err()
-}
\ No newline at end of file
+}
+
+// FlushNamespace deletes every key under the cache prefix in every generation (or, with an
+// empty prefix, every key in the database), scanning and deleting in batches so Redis is never blocked.
+// Returns how many keys were deleted.
+func (c *Cache) FlushNamespace() (int64, error) {
+	if c.prefix == "" {
+		return c.deleteMatching("*")
+	}
+	deleted, err := c.deleteMatching(c.prefix + ":*")
+	if err != nil {
+		return deleted, err
+	}
+	generations, err := c.deleteMatching(c.prefix + "@g*:*")
+	return deleted + generations, err
+}
+
+// deleteMatching deletes every key matching pattern in batches of flushBatchSize
+func (c *Cache) deleteMatching(pattern string) (int64, error) {
+	var deleted int64
+	batch := make([]string, 0, flushBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := c.redisClient.Del(c.ctx, batch...).Result()
+		deleted += n
+		batch = batch[:0]
+		return err
+	}
+
+	iter := c.redisClient.Scan(c.ctx, 0, pattern, flushBatchSize).Iterator()
+	for iter.Next(c.ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == flushBatchSize {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	return deleted, flush()
+}
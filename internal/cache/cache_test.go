@@ -0,0 +1,873 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestCache returns a cache under prefix backed by a fresh in-memory Redis
+func newTestCache(t *testing.T, prefix string) (*Cache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1, DialerRetries: 1})
+	t.Cleanup(func() { client.Close() })
+	return NewCache(client, prefix), server
+}
+
+func TestRecencyIndex(t *testing.T) {
+	now := time.Now()
+	type entry struct {
+		member string
+		age    time.Duration
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+		maxAge  time.Duration
+		maxSize int64
+		n       int64
+		want    []string
+	}{
+		{
+			name:    "newest first",
+			entries: []entry{{"dune", 3 * time.Minute}, {"emma", time.Minute}, {"ulysses", 2 * time.Minute}},
+			maxAge:  time.Hour,
+			maxSize: 10,
+			n:       10,
+			want:    []string{"emma", "ulysses", "dune"},
+		},
+		{
+			name:    "limited to n",
+			entries: []entry{{"dune", 3 * time.Minute}, {"emma", time.Minute}, {"ulysses", 2 * time.Minute}},
+			maxAge:  time.Hour,
+			maxSize: 10,
+			n:       2,
+			want:    []string{"emma", "ulysses"},
+		},
+		{
+			name:    "expired members trimmed",
+			entries: []entry{{"dune", 2 * time.Hour}, {"emma", time.Minute}},
+			maxAge:  time.Hour,
+			maxSize: 10,
+			n:       10,
+			want:    []string{"emma"},
+		},
+		{
+			name:    "oldest beyond max size trimmed",
+			entries: []entry{{"dune", 3 * time.Minute}, {"emma", time.Minute}, {"ulysses", 2 * time.Minute}},
+			maxAge:  time.Hour,
+			maxSize: 2,
+			n:       10,
+			want:    []string{"emma", "ulysses"},
+		},
+		{
+			name:    "rewriting a member moves it to the front",
+			entries: []entry{{"dune", 3 * time.Minute}, {"emma", 2 * time.Minute}, {"dune", time.Minute}},
+			maxAge:  time.Hour,
+			maxSize: 10,
+			n:       10,
+			want:    []string{"dune", "emma"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCache(t, "test")
+			for _, e := range tt.entries {
+				if err := c.AddToIndex("index:recent", e.member, now.Add(-e.age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.TrimIndex("index:recent", tt.maxAge, tt.maxSize); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := c.RecentFromIndex("index:recent", tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RecentFromIndex = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexTime(t *testing.T) {
+	c, _ := newTestCache(t, "test")
+	writtenAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := c.AddToIndex("index:recent", "dune", writtenAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.IndexTime("index:recent", "dune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(writtenAt) {
+		t.Errorf("IndexTime = %v, want %v", got, writtenAt)
+	}
+	if _, err := c.IndexTime("index:recent", "emma"); err != redis.Nil {
+		t.Errorf("IndexTime of an unindexed member: err = %v, want redis.Nil", err)
+	}
+}
+
+func TestGetManyAndDeleteMany(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	server.Set("test:stats:query:dune", "3")
+	server.Set("test:stats:query:emma", "1")
+
+	values, found, err := c.GetMany([]string{"stats:query:dune", "stats:query:ulysses", "stats:query:emma"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"3", "", "1"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("GetMany values = %q, want %q", values, want)
+	}
+	if want := []bool{true, false, true}; !reflect.DeepEqual(found, want) {
+		t.Errorf("GetMany found = %v, want %v", found, want)
+	}
+
+	if err := c.DeleteMany([]string{"stats:query:dune", "stats:query:ulysses"}); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists("test:stats:query:dune") || !server.Exists("test:stats:query:emma") {
+		t.Errorf("keys left after DeleteMany = %v, want only stats:query:emma", server.Keys())
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	loadErr := errors.New("upstream down")
+	tests := []struct {
+		name       string
+		cached     string // JSON already stored under the key, if any
+		loadValue  interface{}
+		loadErr    error
+		want       map[string]string
+		wantHit    bool
+		wantLoads  int
+		wantStored string
+		wantErr    error
+	}{
+		{
+			name:       "hit",
+			cached:     `{"title":"Dune"}`,
+			loadValue:  map[string]string{"title": "Emma"},
+			want:       map[string]string{"title": "Dune"},
+			wantHit:    true,
+			wantStored: `{"title":"Dune"}`,
+		},
+		{
+			name:       "miss then load",
+			loadValue:  map[string]string{"title": "Emma"},
+			want:       map[string]string{"title": "Emma"},
+			wantLoads:  1,
+			wantStored: `{"title":"Emma"}`,
+		},
+		{
+			name:      "loader error",
+			loadErr:   loadErr,
+			wantLoads: 1,
+			wantErr:   loadErr,
+		},
+		{
+			name:      "loaded but not stored",
+			loadValue: DontStore(map[string]string{"title": "Emma"}),
+			want:      map[string]string{"title": "Emma"},
+			wantLoads: 1,
+			wantErr:   ErrNotStored,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			if tt.cached != "" {
+				server.Set("test:search:dune", tt.cached)
+			}
+			loads := 0
+			var got map[string]string
+			loaded, err := c.GetOrSet(context.Background(), "search:dune", time.Hour, &got, func(ctx context.Context) (interface{}, error) {
+				loads++
+				return tt.loadValue, tt.loadErr
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetOrSet error = %v, want %v", err, tt.wantErr)
+			}
+			if loaded.Hit != tt.wantHit || loads != tt.wantLoads {
+				t.Errorf("hit = %v after %d loads, want %v after %d", loaded.Hit, loads, tt.wantHit, tt.wantLoads)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("value = %v, want %v", got, tt.want)
+			}
+			stored, _ := server.Get("test:search:dune")
+			if stored != tt.wantStored {
+				t.Errorf("stored = %q, want %q", stored, tt.wantStored)
+			}
+			if tt.wantLoads > 0 && tt.wantStored != "" && server.TTL("test:search:dune") != time.Hour {
+				t.Errorf("TTL = %v, want 1h", server.TTL("test:search:dune"))
+			}
+		})
+	}
+}
+
+func TestGetOrSetSharesConcurrentLoads(t *testing.T) {
+	c, _ := newTestCache(t, "test")
+	const callers = 10
+	var loads atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		loads.Add(1)
+		<-release
+		return map[string]string{"title": "Dune"}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got map[string]string
+			if _, err := c.GetOrSet(context.Background(), "search:dune", time.Hour, &got, loader); err != nil {
+				errs <- err
+				return
+			}
+			if got["title"] != "Dune" {
+				errs <- fmt.Errorf("value = %v, want Dune", got)
+			}
+		}()
+	}
+	// Let every caller miss and join the load before it finishes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := loads.Load(); got != 1 {
+		t.Errorf("loader called %d times for %d concurrent callers, want 1", got, callers)
+	}
+}
+
+// staleReadHook answers the first misses GETs with redis.Nil, the way a read that ran just
+// before another caller's write sees the key
+type staleReadHook struct {
+	misses *atomic.Int32
+}
+
+func (staleReadHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h staleReadHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "get" && h.misses.Add(-1) >= 0 {
+			cmd.SetErr(redis.Nil)
+			return redis.Nil
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (staleReadHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestGetOrSetRereadsBeforeLoading(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    bool // another caller's load stored the value after the first read
+		wantHit   bool
+		wantLoads int32
+		want      string
+	}{
+		{name: "stored meanwhile", stored: true, wantHit: true, want: "Dune"},
+		{name: "still missing", wantLoads: 1, want: "Emma"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1, DialerRetries: 1})
+			t.Cleanup(func() { client.Close() })
+			hook := staleReadHook{misses: &atomic.Int32{}}
+			hook.misses.Store(1)
+			client.AddHook(hook)
+			c := NewCache(client, "test")
+			if tt.stored {
+				server.Set("test:search:dune", `{"title":"Dune"}`)
+			}
+
+			var loads atomic.Int32
+			var got map[string]string
+			loaded, err := c.GetOrSet(context.Background(), "search:dune", time.Hour, &got, func(ctx context.Context) (interface{}, error) {
+				loads.Add(1)
+				return map[string]string{"title": "Emma"}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Hit != tt.wantHit || loads.Load() != tt.wantLoads || got["title"] != tt.want {
+				t.Errorf("Hit = %v with %d loads and value %v; want %v with %d loads and %s", loaded.Hit, loads.Load(), got, tt.wantHit, tt.wantLoads, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOrSetDetachesLoadFromCaller(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var loadCtxErr error
+	loader := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		loadCtxErr = ctx.Err()
+		return map[string]string{"title": "Dune"}, nil
+	}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		var got map[string]string
+		_, err := c.GetOrSet(leaderCtx, "search:dune", time.Hour, &got, loader)
+		leaderErr <- err
+	}()
+	<-started
+
+	followerDone := make(chan error, 1)
+	var follower map[string]string
+	go func() {
+		_, err := c.GetOrSet(context.Background(), "search:dune", time.Hour, &follower, loader)
+		followerDone <- err
+	}()
+
+	cancel()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-followerDone; err != nil {
+		t.Fatalf("follower error = %v, want nil", err)
+	}
+	if follower["title"] != "Dune" {
+		t.Errorf("follower value = %v, want Dune", follower)
+	}
+	if loadCtxErr != nil {
+		t.Errorf("loader context error = %v, want it unaffected by the leader giving up", loadCtxErr)
+	}
+	if !server.Exists("test:search:dune") {
+		t.Error("the shared load was not stored after the leader gave up")
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	server.Set("test:stats:hits", "2")
+
+	err := c.WriteBatch(
+		map[string]int64{"stats:hits": 3, "stats:query:dune": 1},
+		map[string]string{"stats:querymap:aaaa": "dune"},
+		map[string]time.Duration{"stats:query:dune": time.Hour, "stats:querymap:aaaa": 2 * time.Hour},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"stats:hits": "5", "stats:query:dune": "1", "stats:querymap:aaaa": "dune"}
+	for key, value := range want {
+		if got, _ := server.Get("test:" + key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	wantTTLs := map[string]time.Duration{"stats:hits": 0, "stats:query:dune": time.Hour, "stats:querymap:aaaa": 2 * time.Hour}
+	for key, ttl := range wantTTLs {
+		if got := server.TTL("test:" + key); got != ttl {
+			t.Errorf("TTL of %s = %v, want %v", key, got, ttl)
+		}
+	}
+}
+
+func TestEmptyPrefixKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		wantKey string // key as stored in Redis
+	}{
+		{name: "prefixed", prefix: "test", wantKey: "test:search:dune"},
+		{name: "empty prefix", prefix: "", wantKey: "search:dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, tt.prefix)
+			if err := c.Set("search:dune", map[string]string{"title": "Dune"}, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			if keys := server.Keys(); !reflect.DeepEqual(keys, []string{tt.wantKey}) {
+				t.Errorf("stored keys = %q, want [%s]", keys, tt.wantKey)
+			}
+
+			var got map[string]string
+			if err := c.GetJSON("search:dune", &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["title"] != "Dune" {
+				t.Errorf("GetJSON = %v, want Dune", got)
+			}
+			scanned, err := c.Scan("search:*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(scanned, []string{"search:dune"}) {
+				t.Errorf("Scan = %q, want [search:dune]", scanned)
+			}
+			if err := c.Delete("search:dune"); err != nil {
+				t.Fatal(err)
+			}
+			if exists, err := c.Exists("search:dune"); err != nil || exists {
+				t.Errorf("Exists after Delete = %v (%v), want false", exists, err)
+			}
+		})
+	}
+}
+
+func TestSafeMode(t *testing.T) {
+	tests := []struct {
+		name         string
+		safeMode     bool
+		wantDisabled bool
+	}{
+		{name: "off", safeMode: false, wantDisabled: false},
+		{name: "on", safeMode: true, wantDisabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			c.SetSafeMode(tt.safeMode)
+			server.Set("test:search:dune", "{}")
+			server.Set("other:search:dune", "{}")
+
+			_, keysErr := c.Keys("search:*")
+			flushErr := c.FlushAll()
+			for name, err := range map[string]error{"Keys": keysErr, "FlushAll": flushErr} {
+				if disabled := errors.Is(err, ErrCommandDisabled); disabled != tt.wantDisabled {
+					t.Errorf("%s error = %v, want disabled %v", name, err, tt.wantDisabled)
+				}
+			}
+			if !tt.wantDisabled {
+				return
+			}
+			if !server.Exists("other:search:dune") {
+				t.Fatal("FlushAll touched Redis in safe mode")
+			}
+
+			scanned, err := c.Scan("search:*")
+			if err != nil || !reflect.DeepEqual(scanned, []string{"search:dune"}) {
+				t.Errorf("Scan = %q (%v), want [search:dune]", scanned, err)
+			}
+			deleted, err := c.FlushNamespace()
+			if err != nil || deleted != 1 {
+				t.Errorf("FlushNamespace deleted %d (%v), want 1", deleted, err)
+			}
+			if keys := server.Keys(); !reflect.DeepEqual(keys, []string{"other:search:dune"}) {
+				t.Errorf("keys left after FlushNamespace = %q, want only the other namespace", keys)
+			}
+		})
+	}
+}
+
+func TestWriteSizes(t *testing.T) {
+	value := map[string]string{"title": "Dune"}
+	encoded := `{"title":"Dune"}`
+	tests := []struct {
+		name       string
+		write      func(c *Cache) error
+		wantWrites int64
+		wantBytes  int64
+	}{
+		{
+			name:       "string stored as is",
+			write:      func(c *Cache) error { return c.Set("search:dune", "raw value", time.Hour) },
+			wantWrites: 1,
+			wantBytes:  int64(len("raw value")),
+		},
+		{
+			name:       "value serialized to JSON",
+			write:      func(c *Cache) error { return c.Set("search:dune", value, time.Hour) },
+			wantWrites: 1,
+			wantBytes:  int64(len(encoded)),
+		},
+		{
+			name: "loaded through GetOrSet",
+			write: func(c *Cache) error {
+				var got map[string]string
+				_, err := c.GetOrSet(context.Background(), "search:dune", time.Hour, &got, func(ctx context.Context) (interface{}, error) {
+					return value, nil
+				})
+				return err
+			},
+			wantWrites: 1,
+			wantBytes:  int64(len(encoded)),
+		},
+		{
+			name: "SetNX onto an existing key",
+			write: func(c *Cache) error {
+				if err := c.Set("search:dune", value, time.Hour); err != nil {
+					return err
+				}
+				_, err := c.SetNX("search:dune", "ignored", time.Hour)
+				return err
+			},
+			wantWrites: 1,
+			wantBytes:  int64(len(encoded)),
+		},
+		{
+			name: "several writes add up",
+			write: func(c *Cache) error {
+				if err := c.Set("search:dune", value, time.Hour); err != nil {
+					return err
+				}
+				return c.Set("search:emma", "emma", time.Hour)
+			},
+			wantWrites: 2,
+			wantBytes:  int64(len(encoded) + len("emma")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			if err := tt.write(c); err != nil {
+				t.Fatal(err)
+			}
+			writes, bytes := c.WriteSizes()
+			if writes != tt.wantWrites || bytes != tt.wantBytes {
+				t.Errorf("WriteSizes = %d writes, %d bytes; want %d, %d", writes, bytes, tt.wantWrites, tt.wantBytes)
+			}
+			if stored, _ := server.Get("test:search:dune"); tt.wantWrites == 1 && int64(len(stored)) != bytes {
+				t.Errorf("recorded %d bytes, want the %d stored", bytes, len(stored))
+			}
+		})
+	}
+}
+
+func TestWriteSizesSkipFailedWrites(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	server.Close()
+	if err := c.Set("search:dune", "raw value", time.Hour); err == nil {
+		t.Fatal("Set succeeded with Redis down, want an error")
+	}
+	if writes, bytes := c.WriteSizes(); writes != 0 || bytes != 0 {
+		t.Errorf("WriteSizes = %d writes, %d bytes after a failed write; want none", writes, bytes)
+	}
+}
+
+// replicaHook makes Redis reject writes the way a read-only replica does while readOnly is set
+type replicaHook struct {
+	readOnly *atomic.Bool
+	sets     *atomic.Int32 // SETs that reached the hook
+}
+
+func (replicaHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h replicaHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() != "set" {
+			return next(ctx, cmd)
+		}
+		h.sets.Add(1)
+		if h.readOnly.Load() {
+			err := errors.New("READONLY You can't write against a read only replica.")
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (replicaHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name         string
+		readOnly     bool // Redis rejects writes
+		writes       int
+		recheck      bool // the recheck window has passed before the last write
+		recovered    bool // Redis accepts writes again before the last write
+		wantErr      bool
+		wantReadOnly bool
+		wantSets     int32
+		wantCalls    []bool
+	}{
+		{name: "writable", writes: 2, wantSets: 2},
+		{
+			name: "first rejection enters read-only mode", readOnly: true, writes: 1,
+			wantErr: true, wantReadOnly: true, wantSets: 1, wantCalls: []bool{true},
+		},
+		{
+			name: "later writes fail fast", readOnly: true, writes: 3,
+			wantErr: true, wantReadOnly: true, wantSets: 1, wantCalls: []bool{true},
+		},
+		{
+			name: "recheck while still read-only", readOnly: true, writes: 3, recheck: true,
+			wantErr: true, wantReadOnly: true, wantSets: 2, wantCalls: []bool{true},
+		},
+		{
+			name: "recheck after recovery", readOnly: true, writes: 3, recheck: true, recovered: true,
+			wantSets: 2, wantCalls: []bool{true, false},
+		},
+		{
+			name: "no recheck before the window passes", readOnly: true, writes: 3, recovered: true,
+			wantErr: true, wantReadOnly: true, wantSets: 1, wantCalls: []bool{true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+			t.Cleanup(func() { client.Close() })
+			hook := replicaHook{readOnly: &atomic.Bool{}, sets: &atomic.Int32{}}
+			hook.readOnly.Store(tt.readOnly)
+			client.AddHook(hook)
+			c := NewCache(client, "test")
+			var calls []bool
+			c.SetReadOnlyHandler(func(readOnly bool, err error) {
+				if readOnly && err == nil {
+					t.Error("entering read-only mode reported without the error")
+				}
+				calls = append(calls, readOnly)
+			})
+
+			var err error
+			for i := 0; i < tt.writes; i++ {
+				if i == tt.writes-1 {
+					if tt.recheck {
+						c.readOnlySince.Store(time.Now().Add(-readOnlyRecheck).UnixNano())
+					}
+					if tt.recovered {
+						hook.readOnly.Store(false)
+					}
+				}
+				err = c.Set("search:dune", "x", time.Minute)
+			}
+
+			if gotErr := errors.Is(err, ErrReadOnly); gotErr != tt.wantErr {
+				t.Errorf("last Set error = %v, want ErrReadOnly %v", err, tt.wantErr)
+			}
+			if got := c.ReadOnly(); got != tt.wantReadOnly {
+				t.Errorf("ReadOnly = %v, want %v", got, tt.wantReadOnly)
+			}
+			if got := hook.sets.Load(); got != tt.wantSets {
+				t.Errorf("%d SETs reached Redis, want %d", got, tt.wantSets)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("handler calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if !tt.wantErr && !server.Exists("test:search:dune") {
+				t.Error("value not written once Redis accepted writes")
+			}
+		})
+	}
+}
+
+func TestMaxKeyLength(t *testing.T) {
+	long := "search:" + strings.Repeat("lord of the rings ", 20)
+	hashed := hashedKey(long)
+	tests := []struct {
+		name      string
+		prefix    string
+		max       int
+		key       string
+		wantKey   string // the key as stored in Redis
+		wantCalls int
+	}{
+		{name: "short key kept", prefix: "test", max: 64, key: "search:dune", wantKey: "test:search:dune"},
+		{name: "long key hashed", prefix: "test", max: 64, key: long, wantKey: "test:" + hashed, wantCalls: 1},
+		{name: "prefix counts toward the limit", prefix: "test", max: len("search:dune"), key: "search:dune", wantKey: "test:" + hashedKey("search:dune"), wantCalls: 1},
+		{name: "empty prefix", max: 64, key: long, wantKey: hashed, wantCalls: 1},
+		{name: "no limit", prefix: "test", key: long, wantKey: "test:" + long},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, tt.prefix)
+			c.SetMaxKeyLength(tt.max)
+			var longKeys []string
+			c.SetLongKeyHandler(func(key string) { longKeys = append(longKeys, key) })
+
+			if err := c.Set(tt.key, map[string]string{"title": "Dune"}, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if keys := server.Keys(); !reflect.DeepEqual(keys, []string{tt.wantKey}) {
+				t.Fatalf("stored keys = %v, want [%s]", keys, tt.wantKey)
+			}
+			if len(longKeys) != tt.wantCalls {
+				t.Errorf("long key handler called %d times, want %d", len(longKeys), tt.wantCalls)
+			}
+			if tt.wantCalls > 0 && longKeys[0] != tt.key {
+				t.Errorf("long key handler got %q, want the unprefixed key", longKeys[0])
+			}
+
+			var got map[string]string
+			if err := c.GetJSON(tt.key, &got); err != nil || got["title"] != "Dune" {
+				t.Errorf("GetJSON = %v, %v; want the value round-tripped", got, err)
+			}
+			if found, err := c.Exists(tt.key); err != nil || !found {
+				t.Errorf("Exists = %v, %v; want true", found, err)
+			}
+			if err := c.Delete(tt.key); err != nil {
+				t.Fatal(err)
+			}
+			if keys := server.Keys(); len(keys) != 0 {
+				t.Errorf("keys after Delete = %v, want none", keys)
+			}
+		})
+	}
+}
+
+// hashedKey is the name keyIn gives a key over the maximum length
+func hashedKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "hashed:" + hex.EncodeToString(sum[:])
+}
+
+// silentRedis accepts connections and never answers, the way a Redis mid-failover can hang
+func silentRedis(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestGetJSONContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		silent  bool // Redis never answers
+		stored  bool
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+		timeout bool // the read fails with a network timeout at the deadline
+	}{
+		{name: "live context", stored: true, ctx: func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }},
+		{name: "missing key", ctx: func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, wantErr: redis.Nil},
+		{name: "context already done", stored: true, ctx: func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, wantErr: context.Canceled},
+		{name: "deadline cuts a hanging read short", silent: true, ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, timeout: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCache(t, "test")
+			if tt.silent {
+				client := redis.NewClient(&redis.Options{Addr: silentRedis(t), MaxRetries: -1, ReadTimeout: 5 * time.Second, ContextTimeoutEnabled: true})
+				t.Cleanup(func() { client.Close() })
+				c = NewCache(client, "test")
+			}
+			if tt.stored {
+				if err := c.Set("search:dune", []string{"Dune"}, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			var got []string
+			start := time.Now()
+			err := c.GetJSONContext(ctx, "search:dune", &got)
+			var netErr net.Error
+			if tt.timeout {
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					t.Fatalf("error = %v, want a timeout", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("read took %v, want it bound to the context", elapsed)
+			}
+			if err == nil && !reflect.DeepEqual(got, []string{"Dune"}) {
+				t.Errorf("value = %v, want [Dune]", got)
+			}
+		})
+	}
+}
+
+func TestSetNX(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   string // already stored under the key
+		value      interface{}
+		wantStored bool
+		want       string
+		wantTTL    time.Duration
+	}{
+		{name: "new key", value: []string{"Dune"}, wantStored: true, want: `["Dune"]`, wantTTL: time.Minute},
+		{name: "string stored raw", value: "plain", wantStored: true, want: "plain", wantTTL: time.Minute},
+		{name: "existing key left untouched", existing: `["Emma"]`, value: []string{"Dune"}, want: `["Emma"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			if tt.existing != "" {
+				server.Set("test:search:dune", tt.existing)
+			}
+
+			stored, err := c.SetNX("search:dune", tt.value, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != tt.wantStored {
+				t.Errorf("stored = %v, want %v", stored, tt.wantStored)
+			}
+			if got, _ := server.Get("test:search:dune"); got != tt.want {
+				t.Errorf("value = %q, want %q", got, tt.want)
+			}
+			if got := server.TTL("test:search:dune"); got != tt.wantTTL {
+				t.Errorf("TTL = %v, want %v", got, tt.wantTTL)
+			}
+			wantWrites, wantBytes := int64(0), int64(0)
+			if tt.wantStored {
+				wantWrites, wantBytes = 1, int64(len(tt.want))
+			}
+			if writes, bytes := c.WriteSizes(); writes != wantWrites || bytes != wantBytes {
+				t.Errorf("WriteSizes = %d, %d; want %d, %d", writes, bytes, wantWrites, wantBytes)
+			}
+		})
+	}
+}
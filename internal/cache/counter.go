@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFlushFailures is how many flushes in a row may fail before the queued batch is dropped,
+// so an unreachable Redis can't make it grow without bound
+const maxFlushFailures = 5
+
+// Counter batches increments in memory and flushes them to Redis periodically in a single
+// pipeline, instead of issuing one INCR per request. Plain values that only need to reach
+// Redis eventually (e.g. lookup tables kept alongside counters) can ride along with Put.
+type Counter struct {
+	cache    *Cache
+	mu       sync.Mutex
+	pending  map[string]int64
+	values   map[string]string        // latest value Put per key, written on flush
+	ttls     map[string]time.Duration // expiry applied on flush, by key prefix
+	failures int                      // flushes failed in a row
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewCounter(c *Cache) *Counter {
+	return &Counter{
+		cache:   c,
+		pending: make(map[string]int64),
+		values:  make(map[string]string),
+		ttls:    make(map[string]time.Duration),
+	}
+}
+
+// SetTTL makes every flushed key starting with prefix expire ttl after its last update,
+// so counters for keys that stop being incremented age out on their own
+func (b *Counter) SetTTL(prefix string, ttl time.Duration) {
+	b.mu.Lock()
+	b.ttls[prefix] = ttl
+	b.mu.Unlock()
+}
+
+// Add queues n to be added to key on the next flush
+func (b *Counter) Add(key string, n int64) {
+	b.mu.Lock()
+	b.pending[key] += n
+	b.mu.Unlock()
+}
+
+// Put queues value to be written to key on the next flush. Repeated puts of one key within
+// a flush interval cost a single write.
+func (b *Counter) Put(key string, value string) {
+	b.mu.Lock()
+	b.values[key] = value
+	b.mu.Unlock()
+}
+
+// Pending returns the amount queued for key that has not been flushed yet
+func (b *Counter) Pending(key string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending[key]
+}
+
+// Flush writes all queued increments and values to Redis. On failure they are queued again
+// (a value Put since is kept over the failed one) and retried on the next flush, until
+// maxFlushFailures flushes in a row have failed; then the batch is dropped.
+func (b *Counter) Flush() error {
+	b.mu.Lock()
+	if len(b.pending) == 0 && len(b.values) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	batch, values := b.pending, b.values
+	b.pending = make(map[string]int64)
+	b.values = make(map[string]string)
+	ttls := make(map[string]time.Duration)
+	for prefix, ttl := range b.ttls {
+		for key := range batch {
+			if strings.HasPrefix(key, prefix) {
+				ttls[key] = ttl
+			}
+		}
+		for key := range values {
+			if strings.HasPrefix(key, prefix) {
+				ttls[key] = ttl
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	if err := b.cache.WriteBatch(batch, values, ttls); err != nil {
+		b.mu.Lock()
+		b.failures++
+		if b.failures >= maxFlushFailures {
+			b.failures = 0
+			b.mu.Unlock()
+			return fmt.Errorf("dropped %d keys after %d failed flushes: %w", len(batch)+len(values), maxFlushFailures, err)
+		}
+		for key, n := range batch {
+			b.pending[key] += n
+		}
+		for key, value := range values {
+			if _, newer := b.values[key]; !newer {
+				b.values[key] = value
+			}
+		}
+		b.mu.Unlock()
+		return err
+	}
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+	return nil
+}
+
+// Start flushes every interval in the background until Stop is called. A non-positive
+// interval starts nothing; queued writes then only reach Redis through Flush and Stop.
+// onError is called with any flush error and may be nil.
+func (b *Counter) Start(interval time.Duration, onError func(error)) {
+	if interval <= 0 {
+		return
+	}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := b.Flush(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background flusher and flushes whatever is still queued
+func (b *Counter) Stop() error {
+	if b.stop != nil {
+		close(b.stop)
+		<-b.done
+		b.stop = nil
+	}
+	return b.Flush()
+}
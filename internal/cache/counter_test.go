@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterFlush(t *testing.T) {
+	type add struct {
+		key string
+		n   int64
+	}
+	tests := []struct {
+		name    string
+		initial map[string]string // values already in Redis
+		adds    []add
+		want    map[string]string
+	}{
+		{
+			name: "increments summed per key",
+			adds: []add{{"stats:hits", 1}, {"stats:hits", 2}, {"stats:misses", 1}},
+			want: map[string]string{"stats:hits": "3", "stats:misses": "1"},
+		},
+		{
+			name:    "added to existing counters",
+			initial: map[string]string{"stats:hits": "10"},
+			adds:    []add{{"stats:hits", 5}},
+			want:    map[string]string{"stats:hits": "15"},
+		},
+		{
+			name: "nothing queued",
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			for key, value := range tt.initial {
+				server.Set("test:"+key, value)
+			}
+			counter := NewCounter(c)
+			for _, a := range tt.adds {
+				counter.Add(a.key, a.n)
+			}
+
+			if err := counter.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if got, _ := server.Get("test:" + key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+				if pending := counter.Pending(key); pending != 0 {
+					t.Errorf("Pending(%s) = %d after a flush, want 0", key, pending)
+				}
+			}
+		})
+	}
+}
+
+func TestCounterPending(t *testing.T) {
+	c, _ := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.Add("stats:hits", 2)
+	counter.Add("stats:hits", 3)
+
+	if got := counter.Pending("stats:hits"); got != 5 {
+		t.Errorf("Pending = %d, want 5", got)
+	}
+	if got := counter.Pending("stats:misses"); got != 0 {
+		t.Errorf("Pending of an untouched key = %d, want 0", got)
+	}
+}
+
+func TestCounterRequeuesFailedFlush(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.Add("stats:hits", 2)
+
+	server.Close()
+	if err := counter.Flush(); err == nil {
+		t.Fatal("Flush succeeded with Redis down, want an error")
+	}
+	if got := counter.Pending("stats:hits"); got != 2 {
+		t.Fatalf("Pending after a failed flush = %d, want 2", got)
+	}
+
+	counter.Add("stats:hits", 1)
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.Get("test:stats:hits"); got != "3" {
+		t.Errorf("stats:hits = %q, want 3", got)
+	}
+}
+
+func TestCounterStopFlushesRemaining(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.Start(time.Hour, nil)
+	counter.Add("stats:hits", 4)
+
+	if err := counter.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.Get("test:stats:hits"); got != "4" {
+		t.Errorf("stats:hits = %q, want 4", got)
+	}
+}
+
+func TestCounterStartFlushesOnInterval(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.Start(10*time.Millisecond, nil)
+	defer counter.Stop()
+	counter.Add("stats:hits", 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if got, _ := server.Get("test:stats:hits"); got == "1" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("stats:hits was never flushed")
+}
+
+func TestCounterDropsBatchAfterRepeatedFailures(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.Add("stats:hits", 2)
+	counter.Put("stats:last", "dune")
+
+	server.Close()
+	for i := 1; i < maxFlushFailures; i++ {
+		if err := counter.Flush(); err == nil {
+			t.Fatal("Flush succeeded with Redis down, want an error")
+		}
+		if got := counter.Pending("stats:hits"); got != 2 {
+			t.Fatalf("Pending after %d failed flushes = %d, want the batch kept", i, got)
+		}
+	}
+	if err := counter.Flush(); err == nil || !strings.Contains(err.Error(), "dropped 2 keys") {
+		t.Fatalf("last failed Flush = %v, want the batch dropped", err)
+	}
+	if got := counter.Pending("stats:hits"); got != 0 {
+		t.Errorf("Pending after the batch was dropped = %d, want 0", got)
+	}
+
+	// The failure count starts over
+	counter.Add("stats:hits", 1)
+	if err := counter.Flush(); err == nil || strings.Contains(err.Error(), "dropped") {
+		t.Fatalf("first Flush of a new batch = %v, want it queued again", err)
+	}
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.Get("test:stats:hits"); got != "1" {
+		t.Errorf("stats:hits = %q, want only the increment after the drop", got)
+	}
+}
+
+func TestCounterStartWithoutInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		c, server := newTestCache(t, "test")
+		counter := NewCounter(c)
+		counter.Start(interval, nil)
+		counter.Add("stats:hits", 1)
+
+		if err := counter.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := server.Get("test:stats:hits"); got != "1" {
+			t.Errorf("interval %v: stats:hits = %q after Stop, want 1", interval, got)
+		}
+	}
+}
+
+func TestCounterSetTTL(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.SetTTL("stats:query:", time.Hour)
+	counter.Add("stats:query:dune", 1)
+	counter.Add("stats:hits", 1)
+
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.TTL("test:stats:query:dune"); got != time.Hour {
+		t.Errorf("TTL of a matching key = %v, want 1h", got)
+	}
+	if got := server.TTL("test:stats:hits"); got != 0 {
+		t.Errorf("TTL of another key = %v, want none", got)
+	}
+}
+
+func TestCounterPut(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.SetTTL("stats:querymap:", time.Hour)
+	counter.Put("stats:querymap:aaaa", "dune")
+	counter.Put("stats:querymap:aaaa", "emma")
+	counter.Put("stats:label", "kept")
+
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.Get("test:stats:querymap:aaaa"); got != "emma" {
+		t.Errorf("stats:querymap:aaaa = %q, want the last value put", got)
+	}
+	if got := server.TTL("test:stats:querymap:aaaa"); got != time.Hour {
+		t.Errorf("TTL of a matching key = %v, want 1h", got)
+	}
+	if got := server.TTL("test:stats:label"); got != 0 {
+		t.Errorf("TTL of another key = %v, want none", got)
+	}
+}
+
+func TestCounterKeepsNewerPutOverFailedFlush(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	counter := NewCounter(c)
+	counter.Put("stats:querymap:aaaa", "dune")
+
+	server.Close()
+	if err := counter.Flush(); err == nil {
+		t.Fatal("Flush succeeded with Redis down, want an error")
+	}
+	counter.Put("stats:querymap:aaaa", "emma")
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := counter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.Get("test:stats:querymap:aaaa"); got != "emma" {
+		t.Errorf("stats:querymap:aaaa = %q, want the value put after the failed flush", got)
+	}
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskStore keeps a bounded set of JSON values on disk so they survive Redis outages
+// and restarts. Once maxEntries is exceeded by a tenth the least recently saved files are
+// removed, so the directory is listed once per batch of new entries rather than every save.
+type DiskStore struct {
+	dir        string
+	maxEntries int
+	mu         sync.Mutex
+
+	// Files known to be in dir, counted at startup and on each save. Guarded by mu.
+	entries map[string]struct{}
+}
+
+func NewDiskStore(dir string, maxEntries int) (*DiskStore, error) {
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("disk store max entries must be positive, got %d", maxEntries)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create disk store directory: %w", err)
+	}
+	d := &DiskStore{
+		dir:        dir,
+		maxEntries: maxEntries,
+	}
+	existing, err := d.list()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disk store directory: %w", err)
+	}
+	d.entries = make(map[string]struct{}, len(existing))
+	for _, entry := range existing {
+		d.entries[entry] = struct{}{}
+	}
+	return d, nil
+}
+
+// Save writes value as JSON under key, replacing any previous value
+func (d *DiskStore) Save(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value to JSON: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Write to a temp file first so readers never see a half-written entry
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	path := d.path(key)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store entry: %w", err)
+	}
+
+	d.entries[path] = struct{}{}
+	if len(d.entries) <= d.maxEntries+d.pruneSlack() {
+		return nil
+	}
+	return d.prune()
+}
+
+// LoadJSON reads the value stored under key into v. A missing entry returns an
+// error satisfying errors.Is(err, os.ErrNotExist).
+func (d *DiskStore) LoadJSON(key string, v interface{}) error {
+	d.mu.Lock()
+	data, err := os.ReadFile(d.path(key))
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SavedAt returns when the value under key was last saved
+func (d *DiskStore) SavedAt(key string) (time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info, err := os.Stat(d.path(key))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// path maps a key to a file name that is safe regardless of the characters in the key
+func (d *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// list returns the entry files in dir
+func (d *DiskStore) list() ([]string, error) {
+	return filepath.Glob(filepath.Join(d.dir, "*.json"))
+}
+
+// pruneSlack is how far past maxEntries the store may grow before it is pruned
+func (d *DiskStore) pruneSlack() int {
+	return max(d.maxEntries/10, 1)
+}
+
+// prune removes the oldest entries beyond maxEntries and recounts the rest. Callers must
+// hold d.mu.
+func (d *DiskStore) prune() error {
+	entries, err := d.list()
+	if err != nil {
+		return err
+	}
+	d.entries = make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		d.entries[entry] = struct{}{}
+	}
+	if len(entries) <= d.maxEntries {
+		return nil
+	}
+
+	modTimes := make(map[string]int64, len(entries))
+	for _, entry := range entries {
+		info, err := os.Stat(entry)
+		if err != nil {
+			continue
+		}
+		modTimes[entry] = info.ModTime().UnixNano()
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return modTimes[entries[i]] < modTimes[entries[j]]
+	})
+
+	for _, entry := range entries[:len(entries)-d.maxEntries] {
+		if err := os.Remove(entry); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to prune disk store: %w", err)
+		}
+		delete(d.entries, entry)
+	}
+	return nil
+}
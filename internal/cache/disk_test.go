@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveAged saves keys in order, dating each one an hour after the previous so pruning
+// order doesn't depend on timer resolution
+func saveAged(t *testing.T, d *DiskStore, keys ...string) {
+	t.Helper()
+	start := time.Now().Add(-time.Duration(len(keys)+1) * time.Hour)
+	for i, key := range keys {
+		if err := d.Save(key, map[string]string{"key": key}); err != nil {
+			t.Fatal(err)
+		}
+		modTime := start.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(d.path(key), modTime, modTime); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func keysNamed(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("search:query%02d", i)
+	}
+	return keys
+}
+
+func countEntries(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestDiskStoreRoundTrip(t *testing.T) {
+	d, err := NewDiskStore(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Save("search:dune", map[string]int{"numFound": 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]int
+	if err := d.LoadJSON("search:dune", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["numFound"] != 3 {
+		t.Errorf("numFound = %d, want 3", got["numFound"])
+	}
+	if savedAt, err := d.SavedAt("search:dune"); err != nil || time.Since(savedAt) > time.Minute {
+		t.Errorf("SavedAt = %v, %v; want about now", savedAt, err)
+	}
+	if err := d.LoadJSON("search:emma", &got); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadJSON of a missing key = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestDiskStorePrunesInBatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		saves      int
+		want       int
+	}{
+		{name: "under the limit", maxEntries: 10, saves: 10, want: 10},
+		{name: "within the slack", maxEntries: 10, saves: 11, want: 11},
+		{name: "past the slack", maxEntries: 10, saves: 12, want: 10},
+		{name: "larger store", maxEntries: 50, saves: 55, want: 55},
+		{name: "larger store past the slack", maxEntries: 50, saves: 56, want: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d, err := NewDiskStore(dir, tt.maxEntries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := keysNamed(tt.saves)
+			saveAged(t, d, keys...)
+
+			if got := countEntries(t, dir); got != tt.want {
+				t.Errorf("entries = %d, want %d", got, tt.want)
+			}
+			// The most recently saved entry always survives a prune
+			var v map[string]string
+			if err := d.LoadJSON(keys[len(keys)-1], &v); err != nil {
+				t.Errorf("newest entry missing: %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskStorePrunesOldestFirst(t *testing.T) {
+	d, err := NewDiskStore(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := keysNamed(12)
+	saveAged(t, d, keys...)
+
+	for i, key := range keys {
+		var v map[string]string
+		err := d.LoadJSON(key, &v)
+		if pruned := i < 2; pruned != errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: LoadJSON error = %v, want pruned %v", key, err, pruned)
+		}
+	}
+}
+
+func TestDiskStoreCountsExistingEntries(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewDiskStore(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveAged(t, first, keysNamed(11)...)
+
+	// A restarted store knows about the files already on disk
+	second, err := NewDiskStore(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Save("search:another", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := countEntries(t, dir); got != 10 {
+		t.Errorf("entries = %d, want 10", got)
+	}
+}
+
+func TestNewDiskStoreRejectsNonPositiveMax(t *testing.T) {
+	for _, max := range []int{0, -1} {
+		if _, err := NewDiskStore(t.TempDir(), max); err == nil {
+			t.Errorf("NewDiskStore(max %d) succeeded, want an error", max)
+		}
+	}
+}
+
+func TestDiskStoreSavedAt(t *testing.T) {
+	d, err := NewDiskStore(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SavedAt("search:dune"); err == nil {
+		t.Error("SavedAt of an unsaved key succeeded, want an error")
+	}
+
+	saveAged(t, d, "search:dune", "search:emma")
+	dune, err := d.SavedAt("search:dune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emma, err := d.SavedAt("search:emma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := emma.Sub(dune); got != time.Hour {
+		t.Errorf("emma saved %v after dune, want 1h", got)
+	}
+}
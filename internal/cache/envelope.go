@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Hash fields of an envelope
+const (
+	envelopePayload       = "payload"
+	envelopeCreatedAt     = "createdAt" // Unix milliseconds
+	envelopeHitCount      = "hitCount"
+	envelopeOriginalQuery = "originalQuery"
+)
+
+// Envelope is the metadata stored with a value written by SetEnvelope. Envelopes are Redis
+// hashes, so the hit count is incremented in place without rewriting the payload.
+type Envelope struct {
+	CreatedAt     time.Time // zero for plain values
+	HitCount      int64     // reads so far, including the one that returned it
+	OriginalQuery string    // the query as the client sent it
+}
+
+// readScript reads a plain value as {"string", value} or an envelope as {"hash", payload,
+// createdAt, hitCount, originalQuery}, counting the read as a hit. The increment goes through
+// pcall so reads keep working on a read-only replica.
+var readScript = redis.NewScript(`
+local kind = redis.call('TYPE', KEYS[1]).ok
+if kind == 'string' then
+	return {kind, redis.call('GET', KEYS[1])}
+end
+if kind == 'hash' then
+	redis.pcall('HINCRBY', KEYS[1], 'hitCount', 1)
+	local fields = redis.call('HMGET', KEYS[1], 'payload', 'createdAt', 'hitCount', 'originalQuery')
+	return {kind, fields[1], fields[2], fields[3], fields[4]}
+end
+return false
+`)
+
+// storeEnvelopeNXScript writes an envelope {payload, createdAt, hitCount, originalQuery} at
+// KEYS[1] with a TTL of ARGV[4] milliseconds (0 none), unless the key already holds
+// anything. It returns 1 when the envelope was written.
+var storeEnvelopeNXScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'payload', ARGV[1], 'createdAt', ARGV[2], 'hitCount', 0, 'originalQuery', ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[4])
+end
+return 1
+`)
+
+// SetEnvelopes makes reads unwrap envelopes. Turn it on before writing any with SetEnvelope;
+// while it's off, reads see envelopes as missing (or as errors, for GetJSON).
+func (c *Cache) SetEnvelopes(enabled bool) {
+	c.envelopes = enabled
+}
+
+// Envelopes reports whether reads unwrap envelopes
+func (c *Cache) Envelopes() bool {
+	return c.envelopes
+}
+
+// SetEnvelope stores value as JSON in an envelope recording when it was written and the
+// query it was written for, replacing whatever was stored at key
+func (c *Cache) SetEnvelope(key string, originalQuery string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value to JSON: %w", err)
+	}
+	fullKey := c.key(key)
+	err = c.write(func() error {
+		return c.storeEnvelope(fullKey, originalQuery, data, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	c.recordWrite(len(data))
+	return nil
+}
+
+// SetEnvelopeNX is SetEnvelope that only writes if key holds nothing yet, plain value or
+// envelope. It reports whether the envelope was written.
+func (c *Cache) SetEnvelopeNX(key string, originalQuery string, value interface{}, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal value to JSON: %w", err)
+	}
+	fullKey := c.key(key)
+	var stored bool
+	err = c.write(func() error {
+		n, err := storeEnvelopeNXScript.Run(c.ctx, c.redisClient, []string{fullKey},
+			data, time.Now().UnixMilli(), originalQuery, ttl.Milliseconds()).Int()
+		stored = n == 1
+		return err
+	})
+	if err != nil || !stored {
+		return false, err
+	}
+	c.recordWrite(len(data))
+	return true, nil
+}
+
+// GetEnvelope decodes the value at key into v and returns its envelope, counting the read as
+// a hit. Plain values written by Set decode the same way with a zero Envelope.
+func (c *Cache) GetEnvelope(key string, v interface{}) (Envelope, error) {
+	data, meta, err := c.readEnvelope(c.ctx, c.key(key))
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to get value from Redis: %w", err)
+	}
+	return meta, json.Unmarshal(data, v)
+}
+
+// GetOrSetEnvelope is GetOrSet storing a loaded value in an envelope for originalQuery
+func (c *Cache) GetOrSetEnvelope(ctx context.Context, key string, originalQuery string, ttl time.Duration, v interface{}, loader func(ctx context.Context) (interface{}, error)) (Loaded, error) {
+	return c.getOrSet(ctx, key, v, loader, func(fullKey string, data []byte) error {
+		return c.storeEnvelope(fullKey, originalQuery, data, ttl)
+	})
+}
+
+// storeEnvelope replaces fullKey with a fresh envelope around data in one transaction.
+// Callers run it through write.
+func (c *Cache) storeEnvelope(fullKey string, originalQuery string, data []byte, ttl time.Duration) error {
+	pipe := c.redisClient.TxPipeline()
+	pipe.Del(c.ctx, fullKey)
+	pipe.HSet(c.ctx, fullKey,
+		envelopePayload, data,
+		envelopeCreatedAt, time.Now().UnixMilli(),
+		envelopeHitCount, 0,
+		envelopeOriginalQuery, originalQuery)
+	if ttl > 0 {
+		pipe.Expire(c.ctx, fullKey, ttl)
+	}
+	_, err := pipe.Exec(c.ctx)
+	return err
+}
+
+// read returns the payload stored at fullKey: a plain GET unless envelopes are enabled
+func (c *Cache) read(ctx context.Context, fullKey string) ([]byte, error) {
+	if !c.envelopes {
+		return c.redisClient.Get(ctx, fullKey).Bytes()
+	}
+	data, _, err := c.readEnvelope(ctx, fullKey)
+	return data, err
+}
+
+// readEnvelope reads a plain value or an envelope at fullKey. Missing keys return redis.Nil.
+func (c *Cache) readEnvelope(ctx context.Context, fullKey string) ([]byte, Envelope, error) {
+	reply, err := readScript.Run(ctx, c.redisClient, []string{fullKey}).Slice()
+	if err != nil {
+		return nil, Envelope{}, err
+	}
+	return parseReadReply(reply)
+}
+
+// parseReadReply decodes a readScript reply
+func parseReadReply(reply []interface{}) ([]byte, Envelope, error) {
+	var meta Envelope
+	if len(reply) < 2 {
+		return nil, meta, fmt.Errorf("unexpected read reply with %d elements", len(reply))
+	}
+	payload, ok := reply[1].(string)
+	if !ok {
+		// An envelope without a payload can only be a partial write; treat it as missing
+		return nil, meta, redis.Nil
+	}
+	if len(reply) == 5 {
+		if ms, err := strconv.ParseInt(replyString(reply[2]), 10, 64); err == nil {
+			meta.CreatedAt = time.UnixMilli(ms)
+		}
+		meta.HitCount, _ = strconv.ParseInt(replyString(reply[3]), 10, 64)
+		meta.OriginalQuery = replyString(reply[4])
+	}
+	return []byte(payload), meta, nil
+}
+
+// replyString returns a reply element as a string, or "" for nil
+func replyString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
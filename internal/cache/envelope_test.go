@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		envelope  bool // written with SetEnvelope rather than Set
+		reads     int
+		wantQuery string
+		wantHits  int64
+	}{
+		{name: "first read counts", envelope: true, reads: 1, wantQuery: "Dune", wantHits: 1},
+		{name: "every read counts", envelope: true, reads: 3, wantQuery: "Dune", wantHits: 3},
+		{name: "plain value", envelope: false, reads: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCache(t, "test")
+			c.SetEnvelopes(true)
+			value := map[string]interface{}{"title": "Dune"}
+			before := time.Now().Truncate(time.Millisecond)
+			var err error
+			if tt.envelope {
+				err = c.SetEnvelope("search:dune", "Dune", value, time.Hour)
+			} else {
+				err = c.Set("search:dune", value, time.Hour)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var meta Envelope
+			for i := 0; i < tt.reads; i++ {
+				got := map[string]interface{}{}
+				if meta, err = c.GetEnvelope("search:dune", &got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, value) {
+					t.Fatalf("read %d = %v, want %v", i, got, value)
+				}
+			}
+			if meta.HitCount != tt.wantHits || meta.OriginalQuery != tt.wantQuery {
+				t.Errorf("envelope = %+v, want %d hits for %q", meta, tt.wantHits, tt.wantQuery)
+			}
+			if tt.envelope && (meta.CreatedAt.Before(before) || meta.CreatedAt.After(time.Now())) {
+				t.Errorf("CreatedAt = %v, want the time of the write", meta.CreatedAt)
+			}
+			if !tt.envelope && !meta.CreatedAt.IsZero() {
+				t.Errorf("CreatedAt of a plain value = %v, want zero", meta.CreatedAt)
+			}
+
+			got := map[string]interface{}{}
+			if err := c.GetJSON("search:dune", &got); err != nil || !reflect.DeepEqual(got, value) {
+				t.Errorf("GetJSON = %v, %v; want %v", got, err, value)
+			}
+		})
+	}
+}
+
+func TestSetEnvelopeReplacesAndExpires(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	c.SetEnvelopes(true)
+	if err := c.Set("search:dune", "plain", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetEnvelope("search:dune", "Dune", "wrapped", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.TTL("test:search:dune"); got != time.Hour {
+		t.Errorf("TTL = %v, want 1h", got)
+	}
+
+	var got string
+	meta, err := c.GetEnvelope("search:dune", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "wrapped" || meta.HitCount != 1 {
+		t.Errorf("GetEnvelope = %q, %+v; want the envelope with a fresh hit count", got, meta)
+	}
+
+	// Rewriting resets the hit count
+	if err := c.SetEnvelope("search:dune", "dune", "rewrapped", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := c.GetEnvelope("search:dune", &got); got != "rewrapped" || meta.HitCount != 1 || meta.OriginalQuery != "dune" {
+		t.Errorf("GetEnvelope after a rewrite = %q, %+v", got, meta)
+	}
+}
+
+func TestSetEnvelopeNX(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  func(c *Cache) error // writes whatever key already holds, if anything
+		wantStore bool
+	}{
+		{name: "missing key", wantStore: true},
+		{name: "plain value", existing: func(c *Cache) error { return c.Set("search:dune", []string{"kept"}, 0) }},
+		{name: "envelope", existing: func(c *Cache) error { return c.SetEnvelope("search:dune", "dune", []string{"kept"}, 0) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			c.SetEnvelopes(true)
+			if tt.existing != nil {
+				if err := tt.existing(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stored, err := c.SetEnvelopeNX("search:dune", "Dune", []string{"wrapped"}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != tt.wantStore {
+				t.Fatalf("stored = %v, want %v", stored, tt.wantStore)
+			}
+
+			var got []string
+			meta, err := c.GetEnvelope("search:dune", &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantStore {
+				if !reflect.DeepEqual(got, []string{"kept"}) || server.TTL("test:search:dune") != 0 {
+					t.Errorf("GetEnvelope = %q with TTL %v, want the existing value untouched", got, server.TTL("test:search:dune"))
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, []string{"wrapped"}) || meta.OriginalQuery != "Dune" || meta.HitCount != 1 || meta.CreatedAt.IsZero() {
+				t.Errorf("GetEnvelope = %q, %+v; want a fresh envelope for Dune", got, meta)
+			}
+			if got := server.TTL("test:search:dune"); got != time.Hour {
+				t.Errorf("TTL = %v, want 1h", got)
+			}
+			if writes, _ := c.WriteSizes(); writes != 1 {
+				t.Errorf("recorded writes = %d, want 1", writes)
+			}
+		})
+	}
+}
+
+func TestEnvelopesDisabled(t *testing.T) {
+	c, _ := newTestCache(t, "test")
+	if err := c.SetEnvelope("search:dune", "Dune", "wrapped", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := c.GetJSON("search:dune", &got); err == nil {
+		t.Errorf("GetJSON of an envelope with envelopes off = %q, want an error", got)
+	}
+	if _, found, err := c.GetMany([]string{"search:dune"}); err != nil || found[0] {
+		t.Errorf("GetMany found an envelope with envelopes off: %v, %v", found, err)
+	}
+}
+
+func TestGetOrSetEnvelope(t *testing.T) {
+	c, _ := newTestCache(t, "test")
+	c.SetEnvelopes(true)
+	loads := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return "loaded", nil
+	}
+
+	for i, wantHit := range []bool{false, true, true} {
+		var got string
+		loaded, err := c.GetOrSetEnvelope(context.Background(), "search:dune", "Dune", time.Hour, &got, loader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "loaded" || loaded.Hit != wantHit {
+			t.Errorf("call %d = %q, hit %v; want loaded, hit %v", i, got, loaded.Hit, wantHit)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("%d loads, want 1", loads)
+	}
+
+	var got string
+	meta, err := c.GetEnvelope("search:dune", &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.OriginalQuery != "Dune" || meta.HitCount != 3 {
+		t.Errorf("envelope = %+v, want Dune with the two hits and this read", meta)
+	}
+}
+
+func TestGetManyEnvelopes(t *testing.T) {
+	c, _ := newTestCache(t, "test")
+	c.SetEnvelopes(true)
+	if err := c.Set("search:dune", "plain", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetEnvelope("search:emma", "Emma", "wrapped", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	values, found, err := c.GetMany([]string{"search:dune", "search:emma", "search:missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, true, false}; !reflect.DeepEqual(found, want) {
+		t.Fatalf("found = %v, want %v", found, want)
+	}
+	if values[0] != "plain" || values[1] != `"wrapped"` {
+		t.Errorf("values = %q, want the plain value and the envelope's payload", values)
+	}
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrFlushInProgress is returned by BeginGeneration while the previous generation is still
+// being replaced
+var ErrFlushInProgress = errors.New("a cache flush is already in progress")
+
+// errNoPrevious is returned by readPrevious when no flush is in progress
+var errNoPrevious = errors.New("no previous generation")
+
+// noGeneration marks that no previous generation is readable
+const noGeneration = -1
+
+// defaultFlushTimeout is how long a flush may take before it is considered abandoned, unless
+// set with SetFlushTimeout
+const defaultFlushTimeout = 30 * time.Minute
+
+// Fields of the generation record
+const (
+	generationCurrent  = "current"
+	generationPrevious = "previous"
+	generationStarted  = "started" // Unix seconds the flush began at
+)
+
+// Generational keys (see SetGenerationalPrefixes) are written under the current generation.
+// Generation 0 uses the plain prefix, so caches that were never flushed keep their keys;
+// later ones use "prefix@gN", which never matches the plain prefix's "prefix:*" pattern.
+// During a flush, reads that miss the current generation fall back to the previous one
+// until EndGeneration deletes it. Every other key always uses the plain prefix and
+// outlives flushes.
+
+// SetGenerationalPrefixes limits generations to keys starting with one of prefixes, such as
+// cached results, leaving indexes and counters out of flushes. With no prefixes every key
+// is generational.
+func (c *Cache) SetGenerationalPrefixes(prefixes ...string) {
+	c.generational = prefixes
+}
+
+// SetFlushTimeout sets how long a flush may take. A previous generation left behind for
+// longer (by a flush that was interrupted or failed to delete it) is deleted by the next
+// SyncGeneration or BeginGeneration, so it can't block flushes forever.
+func (c *Cache) SetFlushTimeout(d time.Duration) {
+	c.flushTimeout = d
+}
+
+// FlushTimeout is how long a flush may take before it is considered abandoned
+func (c *Cache) FlushTimeout() time.Duration {
+	return c.flushTimeout
+}
+
+// isGenerational reports whether key is kept per generation
+func (c *Cache) isGenerational(key string) bool {
+	if len(c.generational) == 0 {
+		return true
+	}
+	for _, prefix := range c.generational {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// generationKey is the Redis hash recording the current and previous generation, shared by
+// every instance using this prefix
+func (c *Cache) generationKey() string {
+	return c.prefix + "@generation"
+}
+
+// generationPrefix is the key prefix of generation gen
+func (c *Cache) generationPrefix(gen int64) string {
+	if gen == 0 {
+		return c.prefix
+	}
+	return c.prefix + "@g" + strconv.FormatInt(gen, 10)
+}
+
+// Generation reports the generation keys are written under and the previous one reads fall
+// back to, which is -1 unless a flush is in progress
+func (c *Cache) Generation() (current int64, previous int64) {
+	return c.generation.Load(), c.previous.Load()
+}
+
+// BeginGeneration starts a two-phase flush: new writes go to a fresh, empty generation while
+// reads keep falling back to the current one, which stays intact until EndGeneration.
+// Returns the new generation. A previous generation abandoned for longer than the flush
+// timeout is deleted first.
+func (c *Cache) BeginGeneration() (int64, error) {
+	if c.previous.Load() != noGeneration {
+		if err := c.SyncGeneration(); err != nil {
+			return 0, err
+		}
+		if c.previous.Load() != noGeneration {
+			return 0, ErrFlushInProgress
+		}
+	}
+	old := c.generation.Load()
+
+	var next *redis.IntCmd
+	err := c.write(func() error {
+		pipe := c.redisClient.TxPipeline()
+		next = pipe.HIncrBy(c.ctx, c.generationKey(), generationCurrent, 1)
+		pipe.HSet(c.ctx, c.generationKey(), generationPrevious, old, generationStarted, time.Now().Unix())
+		_, err := pipe.Exec(c.ctx)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start a new cache generation: %w", err)
+	}
+
+	c.previous.Store(old)
+	c.generation.Store(next.Val())
+	return next.Val(), nil
+}
+
+// EndGeneration finishes a two-phase flush by deleting every generational key of the
+// previous generation, after which reads only see the current one. Returns how many keys
+// were deleted.
+func (c *Cache) EndGeneration() (int64, error) {
+	previous := c.previous.Load()
+	if previous == noGeneration {
+		return 0, nil
+	}
+	deleted, err := c.deleteGeneration(previous)
+	if err != nil {
+		return deleted, err
+	}
+	err = c.write(func() error {
+		return c.redisClient.HDel(c.ctx, c.generationKey(), generationPrevious, generationStarted).Err()
+	})
+	if err != nil {
+		return deleted, err
+	}
+	c.previous.Store(noGeneration)
+	return deleted, nil
+}
+
+// deleteGeneration deletes the generational keys of generation gen. Generation 0 shares the
+// plain prefix with keys outside generations, so only the generational prefixes are
+// deleted there, along with hashed long keys, which can't be told apart.
+func (c *Cache) deleteGeneration(gen int64) (int64, error) {
+	if gen != 0 || len(c.generational) == 0 {
+		return c.deleteMatching(c.keyIn(c.generationPrefix(gen), "*"))
+	}
+	var deleted int64
+	for _, pattern := range append([]string{"hashed:"}, c.generational...) {
+		n, err := c.deleteMatching(c.keyIn(c.prefix, pattern+"*"))
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// SyncGeneration loads the generation record, so instances pick up flushes started
+// elsewhere. A missing record means generation 0. A flush that started longer than the
+// flush timeout ago was abandoned; its previous generation is deleted.
+func (c *Cache) SyncGeneration() error {
+	values, err := c.redisClient.HMGet(c.ctx, c.generationKey(), generationCurrent, generationPrevious, generationStarted).Result()
+	if err != nil {
+		return err
+	}
+	current, previous := int64(0), int64(noGeneration)
+	if s, ok := values[0].(string); ok {
+		if current, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("invalid current generation %q: %w", s, err)
+		}
+	}
+	if s, ok := values[1].(string); ok {
+		if previous, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("invalid previous generation %q: %w", s, err)
+		}
+	}
+	c.generation.Store(current)
+	c.previous.Store(previous)
+
+	if previous == noGeneration {
+		return nil
+	}
+	// Records without a start time were left by a flush that predates it
+	var started int64
+	if s, ok := values[2].(string); ok {
+		if started, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("invalid flush start %q: %w", s, err)
+		}
+	}
+	if time.Since(time.Unix(started, 0)) < c.flushTimeout {
+		return nil
+	}
+	if _, err := c.EndGeneration(); err != nil {
+		return fmt.Errorf("failed to delete abandoned generation %d: %w", previous, err)
+	}
+	return nil
+}
+
+// previousKey is key under the previous generation, or "" when no flush is in progress or
+// key isn't generational
+func (c *Cache) previousKey(key string) string {
+	previous := c.previous.Load()
+	if previous == noGeneration || !c.isGenerational(key) {
+		return ""
+	}
+	return c.keyIn(c.generationPrefix(previous), key)
+}
+
+// readThrough reads key from the current generation, falling back to the previous one
+// while a flush is in progress
+func (c *Cache) readThrough(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.read(ctx, c.key(key))
+	if errors.Is(err, redis.Nil) {
+		if previous, prevErr := c.readPrevious(ctx, key); !errors.Is(prevErr, errNoPrevious) {
+			return previous, prevErr
+		}
+	}
+	return data, err
+}
+
+// readPrevious reads key from the previous generation, or returns errNoPrevious when no
+// flush is in progress
+func (c *Cache) readPrevious(ctx context.Context, key string) ([]byte, error) {
+	previousKey := c.previousKey(key)
+	if previousKey == "" {
+		return nil, errNoPrevious
+	}
+	return c.read(ctx, previousKey)
+}
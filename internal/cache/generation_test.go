@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGenerationalCache returns a test cache whose "search:" keys are kept per generation
+func newGenerationalCache(t *testing.T) *Cache {
+	t.Helper()
+	c, _ := newTestCache(t, "test")
+	c.SetGenerationalPrefixes("search:")
+	return c
+}
+
+func TestGenerations(t *testing.T) {
+	tests := []struct {
+		name      string
+		end       bool     // run EndGeneration after the writes during the flush
+		written   []string // keys written during the flush
+		wantFound map[string]bool
+	}{
+		{
+			name:      "old results readable during a flush",
+			wantFound: map[string]bool{"search:dune": true, "search:emma": true, "stats:hits": true},
+		},
+		{
+			name:      "old results gone after it",
+			end:       true,
+			wantFound: map[string]bool{"search:dune": false, "search:emma": false, "stats:hits": true},
+		},
+		{
+			name:      "refilled results survive it",
+			end:       true,
+			written:   []string{"search:dune"},
+			wantFound: map[string]bool{"search:dune": true, "search:emma": false, "stats:hits": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newGenerationalCache(t)
+			for _, key := range []string{"search:dune", "search:emma", "stats:hits"} {
+				if err := c.Set(key, []string{"old"}, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := c.BeginGeneration(); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range tt.written {
+				if err := c.Set(key, []string{"new"}, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.end {
+				if _, err := c.EndGeneration(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for key, want := range tt.wantFound {
+				var got []string
+				loaded, err := c.GetOrSet(context.Background(), key, 0, &got, func(ctx context.Context) (interface{}, error) {
+					return []string{"loaded"}, nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if loaded.Hit != want {
+					t.Errorf("%s hit = %v, want %v", key, loaded.Hit, want)
+				}
+				for _, written := range tt.written {
+					if key == written && !reflect.DeepEqual(got, []string{"new"}) {
+						t.Errorf("%s = %v, want the value written during the flush", key, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBeginGeneration(t *testing.T) {
+	c := newGenerationalCache(t)
+	if current, previous := c.Generation(); current != 0 || previous != noGeneration {
+		t.Fatalf("Generation = %d, %d; want 0 and none", current, previous)
+	}
+
+	gen, err := c.BeginGeneration()
+	if err != nil || gen != 1 {
+		t.Fatalf("BeginGeneration = %d, %v; want 1", gen, err)
+	}
+	if _, err := c.BeginGeneration(); !errors.Is(err, ErrFlushInProgress) {
+		t.Fatalf("second BeginGeneration = %v, want ErrFlushInProgress", err)
+	}
+	if current, previous := c.Generation(); current != 1 || previous != 0 {
+		t.Errorf("Generation during the flush = %d, %d; want 1 and 0", current, previous)
+	}
+
+	if _, err := c.EndGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if gen, err := c.BeginGeneration(); err != nil || gen != 2 {
+		t.Errorf("BeginGeneration after the flush = %d, %v; want 2", gen, err)
+	}
+}
+
+func TestEndGenerationKeepsOtherKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		flushes int // earlier flushes, so the one tested starts from that generation
+	}{
+		{name: "from generation 0", flushes: 0},
+		{name: "from a later generation", flushes: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			c.SetGenerationalPrefixes("search:")
+			c.SetMaxKeyLength(40)
+			for i := 0; i < tt.flushes; i++ {
+				if _, err := c.BeginGeneration(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := c.EndGeneration(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			long := "search:" + strings.Repeat("a", 40)
+			for _, key := range []string{"search:dune", long, "stats:hits", "index:search:recent"} {
+				if err := c.Set(key, "old", 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := c.BeginGeneration(); err != nil {
+				t.Fatal(err)
+			}
+			deleted, err := c.EndGeneration()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != 2 {
+				t.Errorf("deleted %d keys, want the two results", deleted)
+			}
+			for _, key := range []string{"test:stats:hits", "test:index:search:recent"} {
+				if !server.Exists(key) {
+					t.Errorf("%s deleted by the flush", key)
+				}
+			}
+		})
+	}
+}
+
+func TestScanAndDeleteDuringFlush(t *testing.T) {
+	c := newGenerationalCache(t)
+	for _, key := range []string{"search:dune", "search:emma"} {
+		if err := c.Set(key, "old", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.BeginGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"search:dune", "search:ulysses"} {
+		if err := c.Set(key, "new", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := c.Scan("search:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if want := []string{"search:dune", "search:emma", "search:ulysses"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Scan = %v, want each key once across both generations", keys)
+	}
+
+	if err := c.Delete("search:dune"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := c.Exists("search:dune"); exists {
+		t.Error("search:dune exists after Delete")
+	}
+	if data, err := c.readThrough(context.Background(), "search:dune"); err == nil {
+		t.Errorf("search:dune = %s after Delete, want it gone from the previous generation too", data)
+	}
+	if exists, _ := c.Exists("search:ulysses"); !exists {
+		t.Error("Delete removed another key")
+	}
+}
+
+func TestGetManyDuringFlush(t *testing.T) {
+	c := newGenerationalCache(t)
+	for key, value := range map[string]string{"search:dune": "old", "search:emma": "old"} {
+		if err := c.Set(key, value, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.BeginGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("search:dune", "new", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	values, found, err := c.GetMany([]string{"search:dune", "search:emma", "search:missing", "stats:missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"new", "old", "", ""}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %q, want %q", values, want)
+	}
+	if want := []bool{true, true, false, false}; !reflect.DeepEqual(found, want) {
+		t.Errorf("found = %v, want %v", found, want)
+	}
+}
+
+func TestSyncGeneration(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	c.SetGenerationalPrefixes("search:")
+	other := NewCache(c.redisClient, "test")
+	other.SetGenerationalPrefixes("search:")
+	if err := c.Set("search:dune", "old", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.BeginGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SyncGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if current, previous := other.Generation(); current != 1 || previous != 0 {
+		t.Fatalf("synced Generation = %d, %d; want 1 and 0", current, previous)
+	}
+	if err := other.Set("search:emma", "new", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("test@g1:search:emma") {
+		t.Error("write on the synced instance missed the new generation")
+	}
+
+	if _, err := c.EndGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SyncGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if _, previous := other.Generation(); previous != noGeneration {
+		t.Errorf("previous generation after the flush = %d, want none", previous)
+	}
+}
+
+func TestSyncGenerationEndsAbandonedFlush(t *testing.T) {
+	tests := []struct {
+		name      string
+		started   time.Duration // how long ago the flush started
+		noStart   bool          // the record has no start time
+		wantEnded bool
+	}{
+		{name: "recent flush", started: time.Second, wantEnded: false},
+		{name: "flush past the timeout", started: 2 * time.Minute, wantEnded: true},
+		{name: "flush without a start time", noStart: true, wantEnded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestCache(t, "test")
+			c.SetGenerationalPrefixes("search:")
+			c.SetFlushTimeout(time.Minute)
+			if err := c.Set("search:dune", "old", 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.BeginGeneration(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.noStart {
+				server.HDel("test@generation", generationStarted)
+			} else {
+				server.HSet("test@generation", generationStarted, strconv.FormatInt(time.Now().Add(-tt.started).Unix(), 10))
+			}
+
+			if err := c.SyncGeneration(); err != nil {
+				t.Fatal(err)
+			}
+			_, previous := c.Generation()
+			if ended := previous == noGeneration; ended != tt.wantEnded {
+				t.Fatalf("previous generation = %d, want ended = %v", previous, tt.wantEnded)
+			}
+			if server.Exists("test:search:dune") == tt.wantEnded {
+				t.Errorf("old generation's search:dune exists = %v, want %v", !tt.wantEnded, !tt.wantEnded)
+			}
+			_, err := c.BeginGeneration()
+			if gotBusy := errors.Is(err, ErrFlushInProgress); gotBusy == tt.wantEnded {
+				t.Errorf("BeginGeneration = %v, want it refused only while the flush is live", err)
+			}
+		})
+	}
+}
+
+func TestBeginGenerationAfterFlushEndedElsewhere(t *testing.T) {
+	c, _ := newTestCache(t, "test")
+	other := NewCache(c.redisClient, "test")
+	if _, err := c.BeginGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SyncGeneration(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.EndGeneration(); err != nil {
+		t.Fatal(err)
+	}
+
+	// other hasn't synced since, but finds the flush over
+	if gen, err := other.BeginGeneration(); err != nil || gen != 2 {
+		t.Errorf("BeginGeneration = %d, %v; want 2", gen, err)
+	}
+}
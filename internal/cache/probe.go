@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// probeTTL bounds how long the probe key can outlive a crashed probe
+const probeTTL = 10 * time.Second
+
+// Probe checks that the commands the cache relies on work and looks for server settings
+// that would quietly break it. It returns a warning for each problem found rather than
+// failing, since a server that only partly fits our assumptions is still usable. The probe
+// key lives under the cache prefix, so ACLs scoped to it don't fail the probe.
+func (c *Cache) Probe(ctx context.Context) []string {
+	client := c.redisClient
+	warnings := []string{}
+	key := c.key(fmt.Sprintf("capability-probe:%d", time.Now().UnixNano()))
+	defer client.Del(ctx, key)
+
+	if err := client.Incr(ctx, key).Err(); err != nil {
+		if redis.IsReadOnlyError(err) {
+			return append(warnings, "server is read-only (replica?), nothing will be cached")
+		}
+		warnings = append(warnings, fmt.Sprintf("INCR failed, cache stats won't be recorded: %v", err))
+	}
+	if err := client.Expire(ctx, key, probeTTL).Err(); err != nil {
+		warnings = append(warnings, fmt.Sprintf("EXPIRE failed, cached entries may never expire: %v", err))
+	}
+	if err := client.Scan(ctx, 0, key, 100).Err(); err != nil {
+		warnings = append(warnings, fmt.Sprintf("SCAN failed, pattern deletes and namespace flushes won't work: %v", err))
+	}
+
+	return append(warnings, evictionWarnings(ctx, client)...)
+}
+
+// evictionWarnings checks maxmemory-policy against how keys are stored: cached results
+// expire, but the recency indexes and stats counters have no TTL
+func evictionWarnings(ctx context.Context, client *redis.Client) []string {
+	config, err := client.ConfigGet(ctx, "maxmemory-policy").Result()
+	if err != nil {
+		// Managed services often disable CONFIG; not knowing the policy isn't a problem in itself
+		return []string{fmt.Sprintf("could not read maxmemory-policy, eviction behavior unknown: %v", err)}
+	}
+
+	switch policy := config["maxmemory-policy"]; policy {
+	case "":
+		return nil
+	case "noeviction":
+		return []string{"maxmemory-policy is noeviction: once memory is full every cache write fails until entries expire"}
+	case "allkeys-lru", "allkeys-lfu", "allkeys-random":
+		return []string{fmt.Sprintf("maxmemory-policy is %s: the recency indexes and stats counters can be evicted, breaking fuzzy matching and stats; a volatile-* policy only evicts expiring cache entries", policy)}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// probeHook stands in for server behavior miniredis lacks: commands named in fail return
+// their error, and CONFIG GET reports policy (or fails when policy is nil). It records the
+// key of every INCR.
+type probeHook struct {
+	fail   map[string]error
+	policy *string
+	keys   *[]string
+}
+
+func (probeHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h probeHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "incr" {
+			*h.keys = append(*h.keys, fmt.Sprint(cmd.Args()[1]))
+		}
+		if err, ok := h.fail[cmd.Name()]; ok {
+			cmd.SetErr(err)
+			return err
+		}
+		if cmd.Name() == "config" && h.policy != nil {
+			cmd.(*redis.MapStringStringCmd).SetVal(map[string]string{"maxmemory-policy": *h.policy})
+			return nil
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (probeHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestProbe(t *testing.T) {
+	policy := func(p string) *string { return &p }
+	tests := []struct {
+		name   string
+		fail   map[string]error
+		policy *string
+		want   []string // substrings, one per expected warning in order
+	}{
+		{name: "config unavailable", want: []string{"could not read maxmemory-policy"}},
+		{name: "volatile policy", policy: policy("volatile-lru"), want: []string{}},
+		{name: "no policy reported", policy: policy(""), want: []string{}},
+		{name: "noeviction", policy: policy("noeviction"), want: []string{"maxmemory-policy is noeviction"}},
+		{name: "allkeys eviction", policy: policy("allkeys-lru"), want: []string{"maxmemory-policy is allkeys-lru"}},
+		{
+			name:   "read-only server",
+			fail:   map[string]error{"incr": errors.New("READONLY You can't write against a read only replica.")},
+			policy: policy("volatile-lru"),
+			want:   []string{"server is read-only"},
+		},
+		{
+			name:   "commands failing",
+			fail:   map[string]error{"incr": errors.New("NOPERM"), "expire": errors.New("NOPERM"), "scan": errors.New("NOPERM")},
+			policy: policy("volatile-lru"),
+			want:   []string{"INCR failed", "EXPIRE failed", "SCAN failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+			t.Cleanup(func() { client.Close() })
+			var keys []string
+			client.AddHook(probeHook{fail: tt.fail, policy: tt.policy, keys: &keys})
+			c := NewCache(client, "test")
+
+			warnings := c.Probe(context.Background())
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("warning %d = %q, want it to mention %q", i, warnings[i], want)
+				}
+			}
+			if len(keys) != 1 || !strings.HasPrefix(keys[0], "test:capability-probe:") {
+				t.Errorf("probe keys = %v, want one under the cache prefix", keys)
+			}
+			if got := server.Keys(); len(got) != 0 {
+				t.Errorf("keys left after the probe = %v, want the probe key deleted", got)
+			}
+		})
+	}
+}
+
+func TestProbeAgainstMiniredis(t *testing.T) {
+	c, server := newTestCache(t, "test")
+	server.Set("test:search:dune", "cached")
+
+	warnings := c.Probe(context.Background())
+	// miniredis supports INCR, EXPIRE and SCAN but not CONFIG, so only the policy is unknown
+	for _, warning := range warnings {
+		if !strings.Contains(warning, "maxmemory-policy") {
+			t.Errorf("unexpected warning %q", warning)
+		}
+	}
+	if got := server.Keys(); !reflect.DeepEqual(got, []string{"test:search:dune"}) {
+		t.Errorf("keys after the probe = %v, want only the existing entry", got)
+	}
+}
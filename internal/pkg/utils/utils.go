@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetEnv gets an environment variable with a default fallback
+func GetEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvBool parses a boolean environment variable, using the default when unset or invalid
+func GetEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvInt parses an integer environment variable, using the default when unset or invalid
+func GetEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvFloat parses a float environment variable, using the default when unset or invalid
+func GetEnvFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetEnvList splits a comma-separated environment variable into trimmed, non-empty values,
+// using the default when unset
+func GetEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// GetEnvMap parses a comma-separated list of key=value pairs (e.g. "a=b,c=d"), skipping
+// malformed entries, and uses the default when unset
+func GetEnvMap(key string, defaultValue map[string]string) map[string]string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	values := map[string]string{}
+	for _, item := range strings.Split(value, ",") {
+		name, mapped, ok := strings.Cut(item, "=")
+		name, mapped = strings.TrimSpace(name), strings.TrimSpace(mapped)
+		if ok && name != "" && mapped != "" {
+			values[name] = mapped
+		}
+	}
+	return values
+}
+
+// GetEnvDuration parses a duration environment variable (e.g. "3s"), using the default when unset or invalid
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// NormalizeISBN strips hyphens and spaces from an ISBN and validates its ISBN-10 or
+// ISBN-13 checksum. It returns the bare ISBN (uppercase X for an ISBN-10 check digit of 10).
+func NormalizeISBN(raw string) (string, bool) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(raw))
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, r := range isbn {
+			var digit int
+			switch {
+			case r >= '0' && r <= '9':
+				digit = int(r - '0')
+			case r == 'X' && i == 9:
+				digit = 10
+			default:
+				return "", false
+			}
+			sum += digit * (10 - i)
+		}
+		return isbn, sum%11 == 0
+	case 13:
+		sum := 0
+		for i, r := range isbn {
+			if r < '0' || r > '9' {
+				return "", false
+			}
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += int(r-'0') * weight
+		}
+		return isbn, sum%10 == 0
+	default:
+		return "", false
+	}
+}
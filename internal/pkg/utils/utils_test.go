@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{name: "ISBN-13", raw: "9780261103344", want: "9780261103344", wantOK: true},
+		{name: "ISBN-13 with hyphens", raw: "978-0-261-10334-4", want: "9780261103344", wantOK: true},
+		{name: "ISBN-10", raw: "0261103342", want: "0261103342", wantOK: true},
+		{name: "ISBN-10 with spaces", raw: "0 261 10334 2", want: "0261103342", wantOK: true},
+		{name: "ISBN-10 with X check digit", raw: "080442957x", want: "080442957X", wantOK: true},
+		{name: "bad ISBN-13 checksum", raw: "9780261103345", wantOK: false},
+		{name: "bad ISBN-10 checksum", raw: "0261103343", wantOK: false},
+		{name: "X before the check digit", raw: "08044295X7", wantOK: false},
+		{name: "letters in ISBN-13", raw: "978026110334A", wantOK: false},
+		{name: "wrong length", raw: "12345", wantOK: false},
+		{name: "empty", raw: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NormalizeISBN(tt.raw)
+			if ok != tt.wantOK {
+				t.Fatalf("NormalizeISBN(%q) ok = %v, want %v", tt.raw, ok, tt.wantOK)
+			}
+			if ok && got != tt.want {
+				t.Errorf("NormalizeISBN(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnvFloat(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  float64
+	}{
+		{name: "unset", value: "", want: 0.6},
+		{name: "valid", value: "0.75", want: 0.75},
+		{name: "invalid", value: "most", want: 0.6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_FLOAT", tt.value)
+			if got := GetEnvFloat("TEST_FLOAT", 0.6); got != tt.want {
+				t.Errorf("GetEnvFloat = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnvList(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{name: "unset", value: "", want: []string{"*"}},
+		{name: "single", value: "https://a.example", want: []string{"https://a.example"}},
+		{name: "trimmed", value: " https://a.example , https://b.example ", want: []string{"https://a.example", "https://b.example"}},
+		{name: "empty items dropped", value: "https://a.example,,", want: []string{"https://a.example"}},
+		{name: "only separators", value: ",", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_LIST", tt.value)
+			if got := GetEnvList("TEST_LIST", []string{"*"}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetEnvList = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnvMap(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+	}{
+		{name: "unset", value: "", want: map[string]string{"default": "kept"}},
+		{name: "single", value: "title=name", want: map[string]string{"title": "name"}},
+		{name: "trimmed", value: " title = name , author_name=authors ", want: map[string]string{"title": "name", "author_name": "authors"}},
+		{name: "malformed entries skipped", value: "title=name,author_name,=x,key=", want: map[string]string{"title": "name"}},
+		{name: "only separators", value: ",", want: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_MAP", tt.value)
+			if got := GetEnvMap("TEST_MAP", map[string]string{"default": "kept"}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetEnvMap = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -25,23 +27,109 @@ type Config struct {
 	ReadTimeout time.Duration
 	WriteTimeout time.Duration
 	DialTimeout time.Duration
+	// ConnectTimeout is an alias for DialTimeout, used only when DialTimeout is unset
 	ConnectTimeout time.Duration
 }
 
-func NewClient(config Config) (*Client, error) {
-	address := fmt.Sprintf("%s:%s", config.Host, config.Port)
+// Defaults applied when a timeout is left at zero, matching configs/redis.yaml
+const (
+	defaultDialTimeout  = 5 * time.Second
+	defaultReadTimeout  = 3 * time.Second
+	defaultWriteTimeout = 3 * time.Second
+)
 
-	options := &redis.Options{
-		Addr: address,
-		Password: config.Password,
-		DB: config.DB,
-		PoolSize: config.PoolSize,
-		MinIdleConns: config.MinIdleConns,
-		MaxRetries: config.MaxRetries,
-		DialTimeout: config.DialTimeout,
-		ReadTimeout: config.ReadTimeout,
-		WriteTimeout: config.WriteTimeout,
+// maxDB is the highest database index available on a default Redis server (databases 16)
+const maxDB = 15
+
+// validate rejects settings that go-redis would otherwise accept and fail on obscurely at connect time
+func (c Config) validate() error {
+	if c.DB < 0 || c.DB > maxDB {
+		return fmt.Errorf("invalid redis config: DB must be between 0 and %d, got %d", maxDB, c.DB)
+	}
+	if c.PoolSize < 0 {
+		return fmt.Errorf("invalid redis config: PoolSize must not be negative, got %d", c.PoolSize)
+	}
+	if c.MinIdleConns < 0 {
+		return fmt.Errorf("invalid redis config: MinIdleConns must not be negative, got %d", c.MinIdleConns)
+	}
+	if c.PoolSize > 0 && c.MinIdleConns > c.PoolSize {
+		return fmt.Errorf("invalid redis config: MinIdleConns (%d) must not exceed PoolSize (%d)", c.MinIdleConns, c.PoolSize)
+	}
+	if c.Host == "" {
+		return fmt.Errorf("invalid redis config: Host is required")
 	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid redis config: Port must be a number between 1 and 65535, got %q", c.Port)
+	}
+	// go-redis treats -1 as "never retry"; anything lower is a typo
+	if c.MaxRetries < -1 {
+		return fmt.Errorf("invalid redis config: MaxRetries must be -1 (no retries) or more, got %d", c.MaxRetries)
+	}
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"DialTimeout", c.DialTimeout},
+		{"ConnectTimeout", c.ConnectTimeout},
+		{"ReadTimeout", c.ReadTimeout},
+		{"WriteTimeout", c.WriteTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			return fmt.Errorf("invalid redis config: %s must not be negative, got %s", timeout.name, timeout.value)
+		}
+	}
+	// ConnectTimeout only stands in for DialTimeout; two different values are ambiguous
+	if c.DialTimeout > 0 && c.ConnectTimeout > 0 && c.DialTimeout != c.ConnectTimeout {
+		return fmt.Errorf("invalid redis config: DialTimeout (%s) and ConnectTimeout (%s) conflict, set only one", c.DialTimeout, c.ConnectTimeout)
+	}
+	return nil
+}
+
+// withDefaults fills zero timeouts with our own defaults rather than relying on go-redis's
+func (c Config) withDefaults() Config {
+	if c.DialTimeout == 0 {
+		c.DialTimeout = c.ConnectTimeout
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = defaultDialTimeout
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = defaultReadTimeout
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = defaultWriteTimeout
+	}
+	return c
+}
+
+// options maps every Config field onto go-redis options. Call it on a validated Config with
+// defaults applied; ConnectTimeout has been folded into DialTimeout by then.
+func (c Config) options() *redis.Options {
+	return &redis.Options{
+		Addr:         net.JoinHostPort(c.Host, c.Port),
+		Password:     c.Password,
+		DB:           c.DB,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		MaxRetries:   c.MaxRetries,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		// Let a deadline on the command's context cut a read short before ReadTimeout
+		ContextTimeoutEnabled: true,
+	}
+}
+
+func NewClient(config Config) (*Client, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	config = config.withDefaults()
+	log.Printf("Redis timeouts: dial=%s read=%s write=%s", config.DialTimeout, config.ReadTimeout, config.WriteTimeout)
+
+	options := config.options()
+	address := options.Addr
     
     client := redis.NewClient(options)
     ctx := context.Background()
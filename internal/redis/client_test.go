@@ -0,0 +1,204 @@
+package redis
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// validConfig is a config that passes validation, for tests to change one field of
+func validConfig() Config {
+	return Config{
+		Host: "localhost",
+		Port: "6379",
+	}
+}
+
+func TestConfigValidateDBAndPool(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(c *Config)
+		wantErr string // substring of the error, "" for none
+	}{
+		{name: "defaults", edit: func(c *Config) {}},
+		{name: "highest DB", edit: func(c *Config) { c.DB = maxDB }},
+		{name: "negative DB", edit: func(c *Config) { c.DB = -1 }, wantErr: "DB must be between"},
+		{name: "DB past the last database", edit: func(c *Config) { c.DB = maxDB + 1 }, wantErr: "DB must be between"},
+		{name: "negative pool size", edit: func(c *Config) { c.PoolSize = -1 }, wantErr: "PoolSize must not be negative"},
+		{name: "negative idle connections", edit: func(c *Config) { c.MinIdleConns = -1 }, wantErr: "MinIdleConns must not be negative"},
+		{name: "more idle connections than the pool", edit: func(c *Config) { c.PoolSize, c.MinIdleConns = 5, 10 }, wantErr: "must not exceed PoolSize"},
+		{name: "idle connections filling the pool", edit: func(c *Config) { c.PoolSize, c.MinIdleConns = 5, 5 }},
+		{name: "idle connections with the default pool", edit: func(c *Config) { c.MinIdleConns = 10 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			tt.edit(&config)
+			checkValidateError(t, config.validate(), tt.wantErr)
+		})
+	}
+}
+
+// checkValidateError fails unless err matches wantErr, a substring of the expected error or
+// "" for none
+func checkValidateError(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Errorf("validate() = %v, want no error", err)
+	case wantErr != "" && err == nil:
+		t.Errorf("validate() = nil, want an error containing %q", wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Errorf("validate() = %v, want an error containing %q", err, wantErr)
+	}
+}
+
+func TestNewClientRejectsInvalidConfig(t *testing.T) {
+	config := validConfig()
+	config.DB = -1
+	if _, err := NewClient(config); err == nil {
+		t.Fatal("NewClient succeeded with an invalid DB, want an error before connecting")
+	}
+}
+
+func TestConfigWithDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		wantDial  time.Duration
+		wantRead  time.Duration
+		wantWrite time.Duration
+	}{
+		{
+			name:      "all timeouts unset",
+			config:    Config{},
+			wantDial:  defaultDialTimeout,
+			wantRead:  defaultReadTimeout,
+			wantWrite: defaultWriteTimeout,
+		},
+		{
+			name:      "explicit timeouts kept",
+			config:    Config{DialTimeout: time.Second, ReadTimeout: 2 * time.Second, WriteTimeout: 4 * time.Second},
+			wantDial:  time.Second,
+			wantRead:  2 * time.Second,
+			wantWrite: 4 * time.Second,
+		},
+		{
+			name:      "connect timeout stands in for dial timeout",
+			config:    Config{ConnectTimeout: 7 * time.Second},
+			wantDial:  7 * time.Second,
+			wantRead:  defaultReadTimeout,
+			wantWrite: defaultWriteTimeout,
+		},
+		{
+			name:      "dial timeout wins over connect timeout",
+			config:    Config{DialTimeout: time.Second, ConnectTimeout: 7 * time.Second},
+			wantDial:  time.Second,
+			wantRead:  defaultReadTimeout,
+			wantWrite: defaultWriteTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.withDefaults()
+			if got.DialTimeout != tt.wantDial || got.ReadTimeout != tt.wantRead || got.WriteTimeout != tt.wantWrite {
+				t.Errorf("timeouts = dial %s, read %s, write %s; want dial %s, read %s, write %s",
+					got.DialTimeout, got.ReadTimeout, got.WriteTimeout, tt.wantDial, tt.wantRead, tt.wantWrite)
+			}
+		})
+	}
+}
+
+func TestConfigValidateFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(c *Config)
+		wantErr string
+	}{
+		{name: "missing host", edit: func(c *Config) { c.Host = "" }, wantErr: "Host is required"},
+		{name: "port not a number", edit: func(c *Config) { c.Port = "redis" }, wantErr: "Port must be a number"},
+		{name: "port zero", edit: func(c *Config) { c.Port = "0" }, wantErr: "Port must be a number"},
+		{name: "port too high", edit: func(c *Config) { c.Port = "65536" }, wantErr: "Port must be a number"},
+		{name: "no retries", edit: func(c *Config) { c.MaxRetries = -1 }},
+		{name: "retries below -1", edit: func(c *Config) { c.MaxRetries = -2 }, wantErr: "MaxRetries must be -1"},
+		{name: "negative dial timeout", edit: func(c *Config) { c.DialTimeout = -time.Second }, wantErr: "DialTimeout must not be negative"},
+		{name: "negative connect timeout", edit: func(c *Config) { c.ConnectTimeout = -time.Second }, wantErr: "ConnectTimeout must not be negative"},
+		{name: "negative read timeout", edit: func(c *Config) { c.ReadTimeout = -time.Second }, wantErr: "ReadTimeout must not be negative"},
+		{name: "negative write timeout", edit: func(c *Config) { c.WriteTimeout = -time.Second }, wantErr: "WriteTimeout must not be negative"},
+		{name: "conflicting dial and connect timeouts", edit: func(c *Config) { c.DialTimeout, c.ConnectTimeout = time.Second, 2*time.Second }, wantErr: "conflict"},
+		{name: "matching dial and connect timeouts", edit: func(c *Config) { c.DialTimeout, c.ConnectTimeout = time.Second, time.Second }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			tt.edit(&config)
+			checkValidateError(t, config.validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(c *Config)
+		check func(o *redis.Options) bool
+	}{
+		{name: "host and port", edit: func(c *Config) { c.Host, c.Port = "cache.internal", "6380" }, check: func(o *redis.Options) bool { return o.Addr == "cache.internal:6380" }},
+		{name: "IPv6 host", edit: func(c *Config) { c.Host = "::1" }, check: func(o *redis.Options) bool { return o.Addr == "[::1]:6379" }},
+		{name: "password", edit: func(c *Config) { c.Password = "secret" }, check: func(o *redis.Options) bool { return o.Password == "secret" }},
+		{name: "db", edit: func(c *Config) { c.DB = 3 }, check: func(o *redis.Options) bool { return o.DB == 3 }},
+		{name: "pool size", edit: func(c *Config) { c.PoolSize = 20 }, check: func(o *redis.Options) bool { return o.PoolSize == 20 }},
+		{name: "idle connections", edit: func(c *Config) { c.MinIdleConns = 4 }, check: func(o *redis.Options) bool { return o.MinIdleConns == 4 }},
+		{name: "max retries", edit: func(c *Config) { c.MaxRetries = 5 }, check: func(o *redis.Options) bool { return o.MaxRetries == 5 }},
+		{name: "dial timeout", edit: func(c *Config) { c.DialTimeout = 3 * time.Second }, check: func(o *redis.Options) bool { return o.DialTimeout == 3*time.Second }},
+		{name: "connect timeout", edit: func(c *Config) { c.ConnectTimeout = 6 * time.Second }, check: func(o *redis.Options) bool { return o.DialTimeout == 6*time.Second }},
+		{name: "read timeout", edit: func(c *Config) { c.ReadTimeout = 2 * time.Second }, check: func(o *redis.Options) bool { return o.ReadTimeout == 2*time.Second }},
+		{name: "write timeout", edit: func(c *Config) { c.WriteTimeout = 4 * time.Second }, check: func(o *redis.Options) bool { return o.WriteTimeout == 4*time.Second }},
+		{name: "context deadlines", edit: func(c *Config) {}, check: func(o *redis.Options) bool { return o.ContextTimeoutEnabled }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			tt.edit(&config)
+			if err := config.validate(); err != nil {
+				t.Fatal(err)
+			}
+			if options := config.withDefaults().options(); !tt.check(options) {
+				t.Errorf("options = %+v, missing the %s", options, tt.name)
+			}
+		})
+	}
+}
+
+func TestNewClientAppliesConfig(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	host, port, err := net.SplitHostPort(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewClient(Config{Host: host, Port: port, Password: "wrong"}); err == nil {
+		t.Fatal("NewClient succeeded with the wrong password, want the password sent")
+	}
+
+	client, err := NewClient(Config{Host: host, Port: port, Password: "secret", DB: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.GetClient().Set(client.GetContext(), "dune", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := server.DB(3).Get("dune"); err != nil || got != "1" {
+		t.Errorf("DB 3 dune = %q, %v; want the write to land in the configured DB", got, err)
+	}
+}